@@ -16,10 +16,14 @@ package core
 
 import (
 	"log"
+	"notifications/core/model"
 	"notifications/driven/core"
 	"notifications/driven/mailer"
+	"sync/atomic"
+	"time"
 
 	"github.com/rokwire/logging-library-go/v2/logs"
+	"golang.org/x/sync/syncmap"
 )
 
 type storageListener struct {
@@ -51,14 +55,23 @@ type Application struct {
 	Admin    Admin    // expose to the drivers adapters
 	BBs      BBs      // expose to the drivers adapters
 	logger   *logs.Logger
-
-	storage  Storage
-	firebase Firebase
-	mailer   Mailer
-	core     Core
-	airship  Airship
-
-	queueLogic queueLogic
+	config   *model.Config
+
+	storage   Storage
+	firebase  Firebase
+	mailer    Mailer
+	core      Core
+	airship   Airship
+	apns      APNs
+	sms       SMS
+	webhook   Webhook
+	moderator Moderator //nil if the moderation is disabled
+
+	queueLogic         queueLogic
+	retentionLogic     retentionLogic
+	tokensCleanupLogic tokensCleanupLogic
+
+	topicsReach *syncmap.Map //cached topics reach counts
 }
 
 // Start starts the core part of the application
@@ -68,16 +81,39 @@ func (app *Application) Start() {
 	app.storage.RegisterStorageListener(&storageListener)
 
 	app.queueLogic.start()
+	app.queueLogic.startRetries()
+	app.queueLogic.startCallbacks()
+	app.retentionLogic.start()
+	app.tokensCleanupLogic.start()
 }
 
 // NewApplication creates new Application
-func NewApplication(version string, build string, storage Storage, firebase Firebase, mailer *mailer.Adapter, logger *logs.Logger, core *core.Adapter, airship Airship) *Application {
+func NewApplication(version string, build string, storage Storage, firebase Firebase, mailer *mailer.Adapter, logger *logs.Logger, core *core.Adapter, airship Airship, apns APNs, sms SMS, webhook Webhook, moderator Moderator, config *model.Config) *Application {
+
+	retryBaseSeconds := config.PushRetryBaseSeconds
+	if retryBaseSeconds <= 0 {
+		retryBaseSeconds = defaultPushRetryBaseSeconds
+	}
+	retryMaxAttempts := config.PushRetryMaxAttempts
+	if retryMaxAttempts <= 0 {
+		retryMaxAttempts = defaultPushRetryMaxAttempts
+	}
+
+	sendConcurrency := config.FirebaseSendConcurrency
+	if sendConcurrency <= 0 {
+		sendConcurrency = defaultSendConcurrency
+	}
 
 	timerDone := make(chan bool)
-	queueLogic := queueLogic{logger: logger, storage: storage, firebase: firebase, timerDone: timerDone, airship: airship}
+	queueLogic := queueLogic{logger: logger, storage: storage, firebase: firebase, timerDone: timerDone, airship: airship, apns: apns, sms: sms, mailer: mailer, core: core, webhook: webhook,
+		tokenFailuresLimit: config.TokenFailuresLimit, retryBase: time.Duration(retryBaseSeconds) * time.Second, retryMaxAttempts: retryMaxAttempts,
+		sendSlots: make(chan struct{}, sendConcurrency)}
+	retentionLogic := retentionLogic{logger: logger, storage: storage, retentionDays: config.MessagesRetentionDays, ttlDays: config.MessageTTLDays}
+	tokensCleanupLogic := tokensCleanupLogic{logger: logger, storage: storage, staleDays: config.TokenStaleDays, prunedTokens: &atomic.Int64{}}
 
 	application := Application{version: version, build: build, storage: storage, firebase: firebase,
-		mailer: mailer, logger: logger, core: core, queueLogic: queueLogic, airship: airship}
+		mailer: mailer, logger: logger, core: core, queueLogic: queueLogic, retentionLogic: retentionLogic, tokensCleanupLogic: tokensCleanupLogic, airship: airship, apns: apns, sms: sms, webhook: webhook, moderator: moderator, config: config,
+		topicsReach: &syncmap.Map{}}
 
 	//add the drivers ports/interfaces
 	application.Services = &servicesImpl{app: &application}
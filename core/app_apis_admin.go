@@ -14,15 +14,28 @@
 
 package core
 
-import "notifications/core/model"
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"notifications/core/model"
+	"notifications/driven/storage"
+	"time"
 
-func (app *Application) adminGetMessagesStats(orgID string, appID string, adminAccountID string, source string, offset *int64, limit *int64, order *string) (map[int][]interface{}, error) {
+	"github.com/google/uuid"
+	"github.com/rokwire/logging-library-go/v2/errors"
+	"github.com/rokwire/logging-library-go/v2/logs"
+	"github.com/rokwire/logging-library-go/v2/logutils"
+)
+
+func (app *Application) adminGetMessagesStats(ctx context.Context, orgID string, appID string, adminAccountID string, source string, sourceApp *string, includeDeleted bool, offset *int64, limit *int64, order *string, orderBy *string) (map[int][]interface{}, error) {
 	//1. find the messages
 	var senderAccountID *string
 	if source == "me" {
 		senderAccountID = &adminAccountID
 	}
-	messages, err := app.storage.FindMessagesByParams(orgID, appID, "administrative", senderAccountID, offset, limit, order)
+	messages, err := app.storage.FindMessagesByParams(ctx, orgID, appID, "administrative", senderAccountID, sourceApp, includeDeleted, offset, limit, order, orderBy)
 	if err != nil {
 		return nil, err
 	}
@@ -36,7 +49,7 @@ func (app *Application) adminGetMessagesStats(orgID string, appID string, adminA
 	for i, message := range messages {
 		messagesIDs[i] = message.ID
 	}
-	allMessagesRecipients, err := app.storage.FindMessagesRecipientsByMessages(messagesIDs)
+	allMessagesRecipients, err := app.storage.FindMessagesRecipientsByMessages(orgID, appID, messagesIDs)
 	if err != nil {
 		return nil, err
 	}
@@ -57,3 +70,806 @@ func (app *Application) adminGetMessagesStats(orgID string, appID string, adminA
 	}
 	return result, nil
 }
+
+func (app *Application) adminGetTopics(orgID string, appID string, offset *int64, limit *int64, sortBy *string, order *string) ([]model.Topic, int64, error) {
+	return app.sharedGetTopics(orgID, appID, offset, limit, sortBy, order, true)
+}
+
+func (app *Application) adminGetTopicSubscribers(orgID string, appID string, name string, offset *int64, limit *int64) ([]string, int64, error) {
+	topic, err := app.storage.GetTopicByName(orgID, appID, name)
+	if err != nil {
+		return nil, 0, errors.WrapErrorAction(logutils.ActionFind, "topic", &logutils.FieldArgs{"name": name}, err)
+	}
+	if topic == nil {
+		return nil, 0, errors.WrapErrorData(logutils.StatusMissing, "topic", &logutils.FieldArgs{"name": name}, model.ErrNotFound)
+	}
+
+	total, err := app.storage.CountUsersByTopic(orgID, appID, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	userIDs, err := app.storage.FindTopicSubscribersIDs(orgID, appID, name, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	return userIDs, total, nil
+}
+
+func (app *Application) adminGetTopicStats(orgID string, appID string, name string, startDate *int64, endDate *int64) (*model.TopicStats, error) {
+	topic, err := app.storage.GetTopicByName(orgID, appID, name)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "topic", &logutils.FieldArgs{"name": name}, err)
+	}
+	if topic == nil {
+		return nil, errors.WrapErrorData(logutils.StatusMissing, "topic", &logutils.FieldArgs{"name": name}, model.ErrNotFound)
+	}
+	if startDate != nil && endDate != nil && *startDate > *endDate {
+		return nil, errors.WrapErrorData(logutils.StatusInvalid, "date range", &logutils.FieldArgs{"start_date": *startDate, "end_date": *endDate}, model.ErrValidation)
+	}
+
+	return app.storage.GetTopicStats(orgID, appID, topic.Name, startDate, endDate)
+}
+
+func (app *Application) adminRenameTopic(l *logs.Log, orgID string, appID string, name string, newName string) (*model.Topic, error) {
+	if len(newName) == 0 || newName == name {
+		return nil, errors.WrapErrorData(logutils.StatusInvalid, "new name", &logutils.FieldArgs{"name": newName}, model.ErrValidation)
+	}
+	err := app.validateTopicName(newName)
+	if err != nil {
+		return nil, err
+	}
+
+	//1. find the topic
+	topic, err := app.storage.GetTopicByName(orgID, appID, name)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "topic", &logutils.FieldArgs{"name": name}, err)
+	}
+	if topic == nil {
+		return nil, errors.WrapErrorData(logutils.StatusMissing, "topic", &logutils.FieldArgs{"name": name}, model.ErrNotFound)
+	}
+
+	//2. check that the new name is not already in use
+	existing, err := app.storage.GetTopicByName(orgID, appID, newName)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "topic", &logutils.FieldArgs{"name": newName}, err)
+	}
+	if existing != nil {
+		return nil, errors.WrapErrorData(logutils.StatusFound, "topic", &logutils.FieldArgs{"name": newName}, model.ErrConflict)
+	}
+
+	//3. find the subscribed users before migrating them - we need their tokens for firebase
+	users, err := app.storage.GetUsersByTopicsWithContext(context.Background(), orgID, appID, []string{name})
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "user", &logutils.FieldArgs{"topic": name}, err)
+	}
+
+	//4. migrate the data in transaction
+	renamedTopic := *topic
+	renamedTopic.ID = uuid.NewString()
+	renamedTopic.Name = newName
+	renamedTopic.Aliases = append(append([]string{}, topic.Aliases...), name)
+	transaction := func(context storage.TransactionContext) error {
+		err := app.storage.InsertTopicWithContext(context, renamedTopic)
+		if err != nil {
+			return err
+		}
+		err = app.storage.DeleteTopicWithContext(context, orgID, appID, name)
+		if err != nil {
+			return err
+		}
+		err = app.storage.RenameUsersTopicWithContext(context, orgID, appID, name, newName)
+		if err != nil {
+			return err
+		}
+		return app.storage.RenameMessagesTopicWithContext(context, orgID, appID, name, newName)
+	}
+	err = app.storage.PerformTransaction(transaction, 10000) //10 seconds timeout
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionUpdate, "topic", &logutils.FieldArgs{"name": name}, err)
+	}
+
+	//5. re-subscribe the tokens in firebase, a failure for a single token must not stop the others
+	tasks := []func() error{}
+	for _, user := range users {
+		for _, token := range user.DeviceTokens {
+			if token.TokenType == model.TokenTypeAirship || token.TokenType == model.TokenTypeAPNs {
+				continue //not firebase tokens
+			}
+			userID := user.UserID
+			deviceToken := token.Token
+			tasks = append(tasks, func() error {
+				unsubscribeErr := app.firebase.UnsubscribeToTopic(orgID, appID, deviceToken, name)
+				err := app.firebase.SubscribeToTopic(orgID, appID, deviceToken, newName)
+				if err != nil {
+					return fmt.Errorf("error subscribing token for user %s to topic %s - %w", userID, newName, err)
+				}
+				if unsubscribeErr != nil {
+					return fmt.Errorf("error unsubscribing token for user %s from topic %s - %w", userID, name, unsubscribeErr)
+				}
+				return nil
+			})
+		}
+	}
+	app.sharedRunResync(l, "topic "+name+" resync", tasks)
+
+	return &renamedTopic, nil
+}
+
+// topicRecentMessagesDays is the number of days in which the topic messages prevent the topic deletion unless it is forced
+const topicRecentMessagesDays = 7
+
+func (app *Application) adminDeleteTopic(l *logs.Log, orgID string, appID string, name string, force bool) (*model.TopicDeletion, error) {
+	//1. find the topic
+	topic, err := app.storage.GetTopicByName(orgID, appID, name)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "topic", &logutils.FieldArgs{"name": name}, err)
+	}
+	if topic == nil {
+		return nil, errors.WrapErrorData(logutils.StatusMissing, "topic", &logutils.FieldArgs{"name": name}, model.ErrNotFound)
+	}
+
+	//2. the topic must not have recent messages unless forced
+	if !force {
+		recentCount, err := app.storage.CountMessagesByTopic(orgID, appID, name, time.Now().AddDate(0, 0, -topicRecentMessagesDays))
+		if err != nil {
+			return nil, err
+		}
+		if recentCount > 0 {
+			return nil, fmt.Errorf("%w: %s has %d messages in the last %d days", model.ErrTopicHasRecentMessages, name, recentCount, topicRecentMessagesDays)
+		}
+	}
+
+	//3. find the subscribed users before removing the topic - we need their tokens for firebase
+	users, err := app.storage.GetUsersByTopicsWithContext(context.Background(), orgID, appID, []string{name})
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "user", &logutils.FieldArgs{"topic": name}, err)
+	}
+
+	//4. remove the topic and the users subscriptions in transaction
+	var affectedUsers int64
+	transaction := func(context storage.TransactionContext) error {
+		err := app.storage.DeleteTopicWithContext(context, orgID, appID, name)
+		if err != nil {
+			return err
+		}
+		affectedUsers, err = app.storage.RemoveUsersTopicWithContext(context, orgID, appID, name)
+		return err
+	}
+	err = app.storage.PerformTransaction(transaction, 10000) //10 seconds timeout
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionDelete, "topic", &logutils.FieldArgs{"name": name}, err)
+	}
+
+	//5. unsubscribe the tokens in firebase, a failure for a single token must not stop the others
+	tasks := []func() error{}
+	for _, user := range users {
+		for _, token := range user.DeviceTokens {
+			if token.TokenType == model.TokenTypeAirship || token.TokenType == model.TokenTypeAPNs {
+				continue //not firebase tokens
+			}
+			userID := user.UserID
+			deviceToken := token.Token
+			tasks = append(tasks, func() error {
+				err := app.firebase.UnsubscribeToTopic(orgID, appID, deviceToken, name)
+				if err != nil {
+					return fmt.Errorf("error unsubscribing token for user %s from topic %s - %w", userID, name, err)
+				}
+				return nil
+			})
+		}
+	}
+	app.sharedRunResync(l, "topic "+name+" deletion", tasks)
+
+	return &model.TopicDeletion{Topic: name, AffectedUsers: affectedUsers}, nil
+}
+
+func (app *Application) adminGetMessageStatus(orgID string, appID string, messageID string) (*model.MessageStatus, error) {
+	message, err := app.storage.GetMessage(orgID, appID, messageID)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "message", &logutils.FieldArgs{"id": messageID}, err)
+	}
+	if message == nil {
+		return nil, errors.WrapErrorData(logutils.StatusMissing, "message", &logutils.FieldArgs{"id": messageID}, model.ErrNotFound)
+	}
+
+	//the recipients which are still in the queue, a broadcast has no recipients and it is being added to the queue until it has ended
+	var pendingCount int64
+	if message.Broadcast && message.DateBroadcastEnded == nil {
+		pendingCount = message.BroadcastPendingCount()
+	} else {
+		pendingCount, err = app.storage.CountQueueDataForMessage(messageID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	status := model.NewMessageStatus(*message, pendingCount)
+
+	//the recipients for which the delivery has failed
+	failedRecipients, err := app.storage.FindFailedMessageRecipients(orgID, appID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	status.FailedRecipients = make([]model.FailedRecipient, len(failedRecipients))
+	for i, recipient := range failedRecipients {
+		reason := model.DeliveryErrorInternal
+		if recipient.DeliveryErrorCode != nil {
+			reason = *recipient.DeliveryErrorCode
+		}
+		status.FailedRecipients[i] = model.FailedRecipient{UserID: recipient.UserID, Reason: reason}
+	}
+	return &status, nil
+}
+
+func (app *Application) adminGetMessageDeliveries(orgID string, appID string, messageID string, offset *int64, limit *int64) ([]model.DeliveryLog, int64, error) {
+	//the message is not required as the log is kept after the message is deleted
+	return app.storage.FindDeliveryLogs(orgID, appID, messageID, offset, limit)
+}
+
+func (app *Application) adminGetMessage(orgID string, appID string, messageID string, includeDeleted bool) (*model.Message, error) {
+	message, err := app.storage.GetMessage(orgID, appID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message == nil || (message.Deleted && !includeDeleted) {
+		return nil, errors.WrapErrorData(logutils.StatusMissing, "message", &logutils.FieldArgs{"id": messageID}, model.ErrNotFound)
+	}
+
+	//give the recipients with their delivery status
+	recipients, err := app.storage.FindMessagesRecipientsByMessages(orgID, appID, []string{messageID})
+	if err != nil {
+		return nil, err
+	}
+	message.Recipients = recipients
+	message.SendMetrics = model.NewMessageSendMetrics(*message)
+	return message, nil
+}
+
+func (app *Application) adminGetTopicsAudience(orgID string, appID string, topics []string, mode string) (*model.TopicsAudience, error) {
+	if mode != model.TopicsAudienceModeUnion && mode != model.TopicsAudienceModeIntersection {
+		return nil, errors.WrapErrorData(logutils.StatusInvalid, "mode", &logutils.FieldArgs{"mode": mode}, model.ErrValidation)
+	}
+
+	count, err := app.storage.CountUsersByTopics(orgID, appID, topics, mode == model.TopicsAudienceModeIntersection)
+	if err != nil {
+		return nil, err
+	}
+	return &model.TopicsAudience{Topics: topics, Mode: mode, Count: count}, nil
+}
+
+func (app *Application) adminPreviewMessageFor(im model.InputMessage, recipient model.MessageRecipient) (*model.MessagePreview, error) {
+	if len(recipient.UserID) == 0 {
+		return nil, errors.WrapErrorData(logutils.StatusMissing, "recipient", &logutils.FieldArgs{"user_id": recipient.UserID}, model.ErrValidation)
+	}
+
+	//the draft does not have an id until it is created
+	messageID := uuid.NewString()
+	if im.ID != nil {
+		messageID = *im.ID
+	}
+	data := app.sharedMessageData(im.Data, messageID)
+
+	//localize and render the body as it is done on creating the message
+	var locale *string
+	if len(im.LocalizedSubjects) > 0 || len(im.LocalizedBodies) > 0 {
+		usersLocales, err := app.sharedFindUsersLocales(im.OrgID, im.AppID, []model.MessageRecipient{recipient})
+		if err != nil {
+			return nil, err
+		}
+		locale = usersLocales[recipient.UserID]
+	}
+	subject := model.LocalizedValue(im.LocalizedSubjects, locale, im.Subject)
+	body := model.LocalizedValue(im.LocalizedBodies, locale, im.Body)
+	if sharedHasPlaceholders(body) {
+		renderedBody, err := sharedRenderBody(body, data, recipient)
+		if err != nil {
+			return nil, err
+		}
+		body = renderedBody
+	}
+	return &model.MessagePreview{UserID: recipient.UserID, Subject: subject, Body: body, Data: data}, nil
+}
+
+func (app *Application) adminApproveMessage(orgID string, appID string, messageID string, approver model.CoreAccountRef) (*model.Message, error) {
+	message, err := app.storage.GetMessage(orgID, appID, messageID)
+	if err != nil || message == nil {
+		return message, err
+	}
+	if message.Deleted {
+		return nil, fmt.Errorf("%w: %s", model.ErrMessageDeleted, messageID)
+	}
+	if !message.IsPendingApproval() {
+		return nil, fmt.Errorf("%w: %s", model.ErrMessageNotPendingApproval, messageID)
+	}
+	//a second admin must approve it
+	if message.IsSender(approver.UserID) {
+		return nil, fmt.Errorf("%w: %s", model.ErrMessageSelfApproval, messageID)
+	}
+
+	//the queue items were not created as the message was pending approval
+	recipients, err := app.storage.FindMessagesRecipientsByMessages(orgID, appID, []string{messageID})
+	if err != nil {
+		return nil, err
+	}
+	queueItems, err := app.sharedCreateQueueItems(*message, recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	transaction := func(context storage.TransactionContext) error {
+		approved, err := app.storage.ApproveMessageWithContext(context, orgID, appID, messageID, approver, now)
+		if err != nil {
+			return err
+		}
+		if !approved {
+			return fmt.Errorf("%w: %s", model.ErrMessageNotPendingApproval, messageID) //approved meanwhile by another admin
+		}
+
+		err = app.storage.ReleaseMessageRecipientsWithContext(context, messageID)
+		if err != nil {
+			return err
+		}
+
+		if len(queueItems) > 0 {
+			err = app.storage.InsertQueueDataItemsWithContext(context, queueItems)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err = app.storage.PerformTransaction(transaction, 10000) //10 seconds timeout
+	if err != nil {
+		return nil, err
+	}
+
+	//notify the queue that new items are added
+	if len(queueItems) > 0 {
+		go app.queueLogic.onQueuePush()
+	}
+
+	approvalStatus := model.ApprovalStatusApproved
+	message.ApprovalStatus = &approvalStatus
+	message.ApprovedBy = &approver
+	message.DateApproved = &now
+	message.DateUpdated = &now
+	return message, nil
+}
+
+// defaultMessageResendWindowHours is used when the message resend window is not configured
+const defaultMessageResendWindowHours = 72
+
+func (app *Application) adminResendMessage(orgID string, appID string, messageID string, onlyFailed bool, force bool) (*model.Message, error) {
+	message, err := app.storage.GetMessage(orgID, appID, messageID)
+	if err != nil || message == nil {
+		return message, err
+	}
+	if message.Deleted {
+		return nil, fmt.Errorf("%w: %s", model.ErrMessageDeleted, messageID)
+	}
+	if message.Recalled {
+		return nil, fmt.Errorf("%w: %s", model.ErrMessageRecalled, messageID)
+	}
+	now := time.Now().UTC()
+	if message.IsPendingApproval() || message.Time.After(now) {
+		return nil, fmt.Errorf("%w: %s", model.ErrMessageNotSent, messageID)
+	}
+	if message.ExpiresAt != nil && !now.Before(*message.ExpiresAt) {
+		return nil, fmt.Errorf("%w: %s", model.ErrMessageExpired, messageID)
+	}
+	windowHours := app.config.MessageResendWindowHours
+	if windowHours <= 0 {
+		windowHours = defaultMessageResendWindowHours
+	}
+	if !force && now.Sub(message.Time) > time.Duration(windowHours)*time.Hour {
+		return nil, fmt.Errorf("%w: %s was sent more than %d hours ago", model.ErrMessageResendWindowPassed, messageID, windowHours)
+	}
+
+	//the pushes of the first send must have ended
+	pendingCount, err := app.storage.CountQueueDataForMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	retriesCount, err := app.storage.CountPushRetriesForMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if pendingCount > 0 || retriesCount > 0 {
+		return nil, fmt.Errorf("%w: %s is still being sent", model.ErrMessageNotSent, messageID)
+	}
+
+	var recipients []model.MessageRecipient
+	if onlyFailed {
+		recipients, err = app.storage.FindFailedMessageRecipients(orgID, appID, messageID)
+	} else {
+		recipients, err = app.storage.FindMessagesRecipientsByMessages(orgID, appID, []string{messageID})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//the device tokens are found when the queue sends the items, the quiet hours are applied from now
+	resent := *message
+	resent.Time = now
+	queueItems, err := app.sharedCreateQueueItems(resent, recipients)
+	if err != nil {
+		return nil, err
+	}
+	if len(queueItems) == 0 {
+		return message, nil
+	}
+
+	queuedRecipients := map[string]bool{}
+	for _, queueItem := range queueItems {
+		queuedRecipients[queueItem.MessageRecipientID] = true
+	}
+	resentRecipients := []model.MessageRecipient{}
+	for _, recipient := range recipients {
+		if queuedRecipients[recipient.ID] {
+			resentRecipients = append(resentRecipients, recipient)
+		}
+	}
+
+	transaction := func(context storage.TransactionContext) error {
+		//the results of the first send are replaced by the results of the resend
+		err := app.storage.IncrementMessageDeliverySummaryWithContext(context, orgID, appID, messageID, model.NewResendSummaryDelta(resentRecipients))
+		if err != nil {
+			return err
+		}
+		return app.storage.InsertQueueDataItemsWithContext(context, queueItems)
+	}
+	err = app.storage.PerformTransaction(transaction, 10000) //10 seconds timeout
+	if err != nil {
+		return nil, err
+	}
+
+	//notify the queue that new items are added
+	go app.queueLogic.onQueuePush()
+
+	app.logger.Infof("message %s resent to %d recipients", messageID, len(queueItems))
+	return message, nil
+}
+
+func (app *Application) adminDeleteMessage(orgID string, appID string, messageID string) error {
+	message, err := app.storage.GetMessage(orgID, appID, messageID)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionFind, "message", &logutils.FieldArgs{"id": messageID}, err)
+	}
+	if message == nil {
+		return errors.WrapErrorData(logutils.StatusMissing, "message", &logutils.FieldArgs{"id": messageID}, model.ErrNotFound)
+	}
+	if message.Deleted {
+		return fmt.Errorf("%w: %s", model.ErrMessageDeleted, messageID)
+	}
+
+	//the message and its recipients are kept for the audit history, they are hidden until the message is restored
+	transaction := func(context storage.TransactionContext) error {
+		deleted, err := app.storage.SoftDeleteMessageWithContext(context, orgID, appID, messageID, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("%w: %s", model.ErrMessageDeleted, messageID) //deleted meanwhile by another admin
+		}
+		return app.storage.UpdateMessageRecipientsDeletedWithContext(context, messageID, true)
+	}
+	return app.storage.PerformTransaction(transaction, 10000) //10 seconds timeout
+}
+
+func (app *Application) adminRestoreMessage(orgID string, appID string, messageID string) (*model.Message, error) {
+	message, err := app.storage.GetMessage(orgID, appID, messageID)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "message", &logutils.FieldArgs{"id": messageID}, err)
+	}
+	if message == nil {
+		return nil, errors.WrapErrorData(logutils.StatusMissing, "message", &logutils.FieldArgs{"id": messageID}, model.ErrNotFound)
+	}
+	if !message.Deleted {
+		return nil, fmt.Errorf("%w: %s", model.ErrMessageNotDeleted, messageID)
+	}
+
+	now := time.Now().UTC()
+	transaction := func(context storage.TransactionContext) error {
+		restored, err := app.storage.RestoreMessageWithContext(context, orgID, appID, messageID, now)
+		if err != nil {
+			return err
+		}
+		if !restored {
+			return fmt.Errorf("%w: %s", model.ErrMessageNotDeleted, messageID) //restored meanwhile by another admin
+		}
+		return app.storage.UpdateMessageRecipientsDeletedWithContext(context, messageID, false)
+	}
+	err = app.storage.PerformTransaction(transaction, 10000) //10 seconds timeout
+	if err != nil {
+		return nil, err
+	}
+
+	message.Deleted = false
+	message.DateDeleted = nil
+	message.DateUpdated = &now
+	return message, nil
+}
+
+func (app *Application) adminPurgeMessage(orgID string, appID string, messageID string) error {
+	message, err := app.storage.GetMessage(orgID, appID, messageID)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionFind, "message", &logutils.FieldArgs{"id": messageID}, err)
+	}
+	if message == nil {
+		return errors.WrapErrorData(logutils.StatusMissing, "message", &logutils.FieldArgs{"id": messageID}, model.ErrNotFound)
+	}
+
+	//the message is removed permanently with its recipients and the pushes which have not been sent yet
+	transaction := func(context storage.TransactionContext) error {
+		err := app.storage.DeleteMessagesWithContext(context, orgID, appID, []string{messageID})
+		if err != nil {
+			return err
+		}
+		err = app.storage.DeleteMessagesRecipientsForMessagesWithContext(context, []string{messageID})
+		if err != nil {
+			return err
+		}
+		return app.storage.DeleteQueueDataForMessagesWithContext(context, []string{messageID})
+	}
+	return app.storage.PerformTransaction(transaction, 10000) //10 seconds timeout
+}
+
+// recallDataKey is the data key of the silent push with which the clients are asked to remove the notification of a recalled message
+const recallDataKey = "recalled"
+
+func (app *Application) adminRecallMessage(orgID string, appID string, messageID string) (*model.Message, error) {
+	message, err := app.storage.GetMessage(orgID, appID, messageID)
+	if err != nil || message == nil {
+		return message, err
+	}
+	if message.Deleted {
+		return nil, fmt.Errorf("%w: %s", model.ErrMessageDeleted, messageID)
+	}
+	if message.Recalled {
+		return nil, fmt.Errorf("%w: %s", model.ErrMessageRecalled, messageID)
+	}
+
+	recipients, err := app.storage.FindMessagesRecipientsByMessages(orgID, appID, []string{messageID})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	transaction := func(context storage.TransactionContext) error {
+		recalled, err := app.storage.RecallMessageWithContext(context, orgID, appID, messageID, now)
+		if err != nil {
+			return err
+		}
+		if !recalled {
+			return fmt.Errorf("%w: %s", model.ErrMessageRecalled, messageID) //recalled meanwhile by another admin
+		}
+
+		err = app.storage.RecallMessageRecipientsWithContext(context, messageID)
+		if err != nil {
+			return err
+		}
+
+		//the pushes which have not been sent yet are not sent at all
+		return app.storage.DeleteQueueDataForMessagesWithContext(context, []string{messageID})
+	}
+	err = app.storage.PerformTransaction(transaction, 10000) //10 seconds timeout
+	if err != nil {
+		return nil, err
+	}
+
+	go app.adminSendRecall(orgID, appID, messageID, recipients) //new thread
+
+	message.Recalled = true
+	message.DateRecalled = &now
+	message.DateUpdated = &now
+	return message, nil
+}
+
+// adminSendRecall sends a silent data only push to the Firebase tokens of the recipients so that the clients remove the notification
+func (app *Application) adminSendRecall(orgID string, appID string, messageID string, recipients []model.MessageRecipient) {
+	usersIDs := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		usersIDs[i] = recipient.UserID
+	}
+	users, err := app.storage.FindUsersByIDs(orgID, appID, usersIDs)
+	if err != nil {
+		app.logger.Errorf("error finding the recipients of the recalled message %s - %s", messageID, err)
+		return
+	}
+
+	tokens := []string{}
+	for _, user := range users {
+		for _, deviceToken := range user.DeviceTokens {
+			if deviceToken.TokenType == model.TokenTypeAirship || deviceToken.TokenType == model.TokenTypeAPNs {
+				continue //the silent pushes are sent through Firebase only
+			}
+			tokens = append(tokens, deviceToken.Token)
+		}
+	}
+
+	data := map[string]string{recallDataKey: messageID}
+	for start := 0; start < len(tokens); start += firebaseMulticastSize {
+		end := start + firebaseMulticastSize
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+		response, err := app.firebase.SendNotificationToTokens(orgID, appID, tokens[start:end], "", "", "", nil, 0, "", "", "", data)
+		if err != nil {
+			app.logger.Errorf("error sending the recall of message %s - %s", messageID, err)
+			continue
+		}
+		app.logger.Infof("message %s recall sent to %d tokens - %d succeeded, %d failed", messageID, end-start, response.SuccessCount, response.FailureCount)
+	}
+}
+
+// broadcastUsersPageSize is the number of users which a broadcast loads and sends at once
+const broadcastUsersPageSize = 1000
+
+func (app *Application) adminPreviewBroadcast(orgID string, appID string, subject string, body string) (*model.BroadcastPreview, error) {
+	count, err := app.storage.CountUsersWithTokens(orgID, appID)
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Now().UTC().Add(model.BroadcastConfirmationTTL).Truncate(time.Second)
+	token := model.NewBroadcastConfirmationToken(app.broadcastConfirmationKey(), orgID, appID, subject, body, count, expires)
+	return &model.BroadcastPreview{AudienceCount: count, ConfirmationToken: token, ConfirmationExpires: expires}, nil
+}
+
+func (app *Application) adminBroadcastMessage(im model.InputMessage, confirmationToken string) (*model.Message, error) {
+	//the broadcast must have been previewed with a dry run
+	confirmedCount, err := model.ValidateBroadcastConfirmationToken(app.broadcastConfirmationKey(), confirmationToken, im.OrgID, im.AppID, im.Subject, im.Body, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := app.storage.CountUsersWithTokens(im.OrgID, im.AppID)
+	if err != nil {
+		return nil, err
+	}
+	if count > confirmedCount {
+		return nil, fmt.Errorf("%w: the audience has grown from %d to %d users, run the dry run again", model.ErrInvalidBroadcastConfirmation, confirmedCount, count)
+	}
+
+	id := uuid.NewString()
+	im.ID = &id
+	im.Data = app.sharedMessageData(im.Data, id)
+	message, err := app.storage.CreateMessageWithContext(context.Background(), model.NewBroadcastMessage(im, int(count), time.Now().UTC()))
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionCreate, "message", &logutils.FieldArgs{"broadcast": true}, err)
+	}
+
+	go app.adminSendBroadcast(*message) //new thread
+
+	return message, nil
+}
+
+// broadcastConfirmationKey gives the key which signs the broadcast confirmation tokens. It is derived from the internal API key
+// so that all the service instances accept the tokens of each other.
+func (app *Application) broadcastConfirmationKey() []byte {
+	mac := hmac.New(sha256.New, []byte(app.config.InternalAPIKey))
+	mac.Write([]byte("broadcast confirmation"))
+	return mac.Sum(nil)
+}
+
+// adminSendBroadcast adds the broadcast to the queue for the users page by page, so it is sent like the other messages -
+// the users paused notifications and quiet hours, their devices settings and the invalid tokens and the retries are handled by the queue.
+// The broadcast has no recipients, its queue items are not bound to any.
+func (app *Application) adminSendBroadcast(message model.Message) {
+	total := 0
+	if message.CalculatedRecipientsCount != nil {
+		total = *message.CalculatedRecipientsCount
+	}
+
+	queued := 0
+	afterUserID := ""
+	for {
+		users, err := app.storage.FindUsersWithTokens(message.OrgID, message.AppID, afterUserID, broadcastUsersPageSize)
+		if err != nil {
+			app.logger.Errorf("error finding the users of the broadcast %s - %s", message.ID, err)
+			break
+		}
+		if len(users) == 0 {
+			break
+		}
+
+		recipients := make([]model.MessageRecipient, len(users))
+		for i, user := range users {
+			recipients[i] = model.MessageRecipient{OrgID: message.OrgID, AppID: message.AppID, UserID: user.UserID, MessageID: message.ID}
+		}
+		queueItems, err := app.sharedCreateQueueItems(message, recipients)
+		if err != nil {
+			app.logger.Errorf("error creating the queue items of the broadcast %s - %s", message.ID, err)
+			break
+		}
+		err = app.storage.InsertQueueDataItemsWithContext(context.Background(), queueItems)
+		if err != nil {
+			app.logger.Errorf("error inserting the queue items of the broadcast %s - %s", message.ID, err)
+			break
+		}
+		go app.queueLogic.onQueuePush()
+
+		queued += len(queueItems)
+		app.logger.Infof("broadcast %s progress - %d/%d users queued", message.ID, queued, total)
+
+		if len(users) < broadcastUsersPageSize {
+			break
+		}
+		afterUserID = users[len(users)-1].UserID
+	}
+
+	//from now on the queue gives the pending users
+	err := app.storage.EndBroadcast(message.OrgID, message.AppID, message.ID, time.Now().UTC())
+	if err != nil {
+		app.logger.Errorf("error ending the broadcast %s - %s", message.ID, err)
+	}
+}
+
+// defaultBulkMessagesLimit is used when the bulk messages limit is not configured
+const defaultBulkMessagesLimit = 500
+
+func (app *Application) adminCreateMessagesBulk(ctx context.Context, inputMessages []model.InputMessage) ([]model.BulkMessageResult, error) {
+	if len(inputMessages) == 0 {
+		return nil, errors.New("no data")
+	}
+	limit := app.config.BulkMessagesLimit
+	if limit <= 0 {
+		limit = defaultBulkMessagesLimit
+	}
+	if len(inputMessages) > limit {
+		return nil, fmt.Errorf("%w: %d is more than %d", model.ErrTooManyMessages, len(inputMessages), limit)
+	}
+	return app.sharedCreateMessagesPartially(ctx, inputMessages)
+}
+
+func (app *Application) adminGetDeadTokens(orgID string, appID string, minFailures int) ([]model.DeadDeviceToken, error) {
+	if minFailures < 1 {
+		return nil, errors.WrapErrorData(logutils.StatusInvalid, "min failures", &logutils.FieldArgs{"min_failures": minFailures}, model.ErrValidation)
+	}
+	return app.storage.FindDeadDeviceTokens(orgID, appID, minFailures)
+}
+
+func (app *Application) adminPurgeDeadTokens(l *logs.Log, orgID string, appID string, minFailures int) ([]model.DeadDeviceToken, error) {
+	deadTokens, err := app.adminGetDeadTokens(orgID, appID, minFailures)
+	if err != nil {
+		return nil, err
+	}
+	if len(deadTokens) == 0 {
+		return deadTokens, nil
+	}
+
+	//a token which failed again meanwhile is removed too
+	usersCount, err := app.storage.RemoveDeadDeviceTokens(orgID, appID, minFailures)
+	if err != nil {
+		return nil, err
+	}
+	l.Infof("purged %d dead device tokens of %d users for %s/%s", len(deadTokens), usersCount, orgID, appID)
+	return deadTokens, nil
+}
+
+func (app *Application) adminGetUser(orgID string, appID string, userID string) (*model.User, error) {
+	return app.storage.FindUserByID(orgID, appID, userID)
+}
+
+// adminRemoveUserToken removes a stale device token of the user. It gives false if the user does not have the token.
+func (app *Application) adminRemoveUserToken(l *logs.Log, orgID string, appID string, userID string, token string) (bool, error) {
+	user, err := app.storage.FindUserByID(orgID, appID, userID)
+	if err != nil {
+		return false, err
+	}
+	if user == nil || !user.HasToken(token) {
+		return false, nil
+	}
+
+	err = app.storage.RemoveDeviceToken(orgID, appID, userID, token)
+	if err != nil {
+		return false, err
+	}
+	l.Infof("removed device token %s of user %s for %s/%s", model.MaskDeviceToken(token), userID, orgID, appID)
+	return true, nil
+}
+
+// adminUnsubscribeUserFromAllTopics removes all the topics of the user. It gives nil if the user does not exist.
+func (app *Application) adminUnsubscribeUserFromAllTopics(l *logs.Log, orgID string, appID string, userID string) ([]string, error) {
+	return app.sharedUnsubscribeFromAllTopics(l, orgID, appID, userID)
+}
@@ -0,0 +1,542 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"errors"
+	"notifications/core/model"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+func TestAdminGetMessageRecipientsByApp(t *testing.T) {
+	storage := newFakeStorage(testScheduledMessage(time.Now().UTC()))
+	storage.recipients = []model.MessageRecipient{
+		{OrgID: "org", AppID: "app", MessageID: "message", UserID: "u1"},
+		{OrgID: "org", AppID: "other", MessageID: "message", UserID: "u2"},
+		{OrgID: "other", AppID: "app", MessageID: "message", UserID: "u3"},
+	}
+	app := newTestApplication(storage)
+
+	message, err := app.adminGetMessage("org", "app", "message", false)
+	if err != nil {
+		t.Fatalf("adminGetMessage() error = %v", err)
+	}
+	if got := recipientsUsersIDs(message.Recipients); !reflect.DeepEqual(got, []string{"u1"}) {
+		t.Errorf("adminGetMessage() recipients = %v, want %v", got, []string{"u1"})
+	}
+}
+
+func TestAdminGetMessageNotFound(t *testing.T) {
+	message := testScheduledMessage(time.Now().UTC())
+	message.Deleted = true
+	app := newTestApplication(newFakeStorage(message))
+
+	tests := []struct {
+		name           string
+		messageID      string
+		includeDeleted bool
+		wantErr        error
+	}{
+		{"missing", "other", false, model.ErrNotFound},
+		{"deleted", "message", false, model.ErrNotFound},
+		{"deleted included", "message", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := app.adminGetMessage("org", "app", tt.messageID, tt.includeDeleted)
+			//the logging library errors do not unwrap, the error they wrap is checked
+			var wrapper interface{ Internal() error }
+			if errors.As(err, &wrapper) {
+				err = wrapper.Internal()
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("adminGetMessage() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAdminGetMessageStatusFailedRecipients(t *testing.T) {
+	failed := model.DeliveryStatusFailed
+	delivered := model.DeliveryStatusDelivered
+	unregistered := model.DeliveryErrorUnregistered
+	storage := newFakeStorage(testScheduledMessage(time.Now().UTC()))
+	storage.recipients = []model.MessageRecipient{
+		{OrgID: "org", AppID: "app", MessageID: "message", UserID: "u1", DeliveryStatus: &delivered},
+		{OrgID: "org", AppID: "app", MessageID: "message", UserID: "u2", DeliveryStatus: &failed, DeliveryErrorCode: &unregistered},
+		{OrgID: "org", AppID: "app", MessageID: "message", UserID: "u3", DeliveryStatus: &failed},
+		{OrgID: "org", AppID: "app", MessageID: "message", UserID: "u4"},
+		{OrgID: "org", AppID: "other", MessageID: "message", UserID: "u5", DeliveryStatus: &failed},
+	}
+	app := newTestApplication(storage)
+
+	status, err := app.adminGetMessageStatus("org", "app", "message")
+	if err != nil {
+		t.Fatalf("adminGetMessageStatus() error = %v", err)
+	}
+	want := []model.FailedRecipient{{UserID: "u2", Reason: model.DeliveryErrorUnregistered}, {UserID: "u3", Reason: model.DeliveryErrorInternal}}
+	if !reflect.DeepEqual(status.FailedRecipients, want) {
+		t.Errorf("adminGetMessageStatus() failed recipients = %v, want %v", status.FailedRecipients, want)
+	}
+}
+
+// fakeFirebase records the topics subscriptions changes and the multicast sends
+type fakeFirebase struct {
+	Firebase
+
+	failedTopics map[string]bool //the subscription changes of these topics fail
+
+	lock         sync.Mutex
+	subscribed   []string //token:topic
+	unsubscribed []string //token:topic
+
+	sent chan []string //the tokens of every multicast
+}
+
+func (f *fakeFirebase) SubscribeToTopic(orgID string, appID string, token string, topic string) error {
+	if f.failedTopics[topic] {
+		return errors.New("firebase unavailable")
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.subscribed = append(f.subscribed, token+":"+topic)
+	return nil
+}
+
+func (f *fakeFirebase) UnsubscribeToTopic(orgID string, appID string, token string, topic string) error {
+	if f.failedTopics[topic] {
+		return errors.New("firebase unavailable")
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.unsubscribed = append(f.unsubscribed, token+":"+topic)
+	return nil
+}
+
+func (f *fakeFirebase) SendNotificationToTokens(orgID string, appID string, tokens []string, title string, body string, sound string, badge *int,
+	priority int, collapseKey string, imageURL string, androidChannelID string, data map[string]string) (*model.BatchResponse, error) {
+	f.sent <- tokens
+	return &model.BatchResponse{SuccessCount: len(tokens), Errors: make([]error, len(tokens))}, nil
+}
+
+func TestAdminRenameTopic(t *testing.T) {
+	oldTopic := "old"
+	otherTopic := "other"
+	storage := newFakeStorage(
+		model.Message{OrgID: "org", AppID: "app", ID: "m1", Topic: &oldTopic},
+		model.Message{OrgID: "org", AppID: "app", ID: "m2", Topic: &otherTopic},
+	)
+	storage.topics = []model.Topic{
+		{OrgID: "org", AppID: "app", ID: "t1", Name: "old", Aliases: []string{"older"}},
+		{OrgID: "org", AppID: "app", ID: "t2", Name: "taken"},
+	}
+	storage.topicUsers = []model.User{
+		{OrgID: "org", AppID: "app", UserID: "u1", Topics: []string{"old", "other"},
+			DeviceTokens: []model.DeviceToken{{Token: "firebase"}, {Token: "apns", TokenType: model.TokenTypeAPNs}}},
+	}
+	firebase := &fakeFirebase{}
+	app := newTestApplication(storage)
+	app.firebase = firebase
+	l := app.logger.NewLog("test", logs.RequestContext{})
+
+	topic, err := app.adminRenameTopic(l, "org", "app", "old", "new")
+	if err != nil {
+		t.Fatalf("adminRenameTopic() error = %v", err)
+	}
+	if topic.Name != "new" || !reflect.DeepEqual(topic.Aliases, []string{"older", "old"}) {
+		t.Errorf("adminRenameTopic() = %s aliases %v, want new aliases %v", topic.Name, topic.Aliases, []string{"older", "old"})
+	}
+
+	names := []string{}
+	for _, topic := range storage.topics {
+		names = append(names, topic.Name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"new", "taken"}) {
+		t.Errorf("adminRenameTopic() topics = %v, want %v", names, []string{"new", "taken"})
+	}
+	if got := storage.topicUsers[0].Topics; !reflect.DeepEqual(got, []string{"new", "other"}) {
+		t.Errorf("adminRenameTopic() user topics = %v, want %v", got, []string{"new", "other"})
+	}
+	if got := *storage.messages["m1"].Topic; got != "new" {
+		t.Errorf("adminRenameTopic() message topic = %s, want new", got)
+	}
+	if got := *storage.messages["m2"].Topic; got != "other" {
+		t.Errorf("adminRenameTopic() other message topic = %s, want other", got)
+	}
+	//only the firebase token is moved
+	if !reflect.DeepEqual(firebase.unsubscribed, []string{"firebase:old"}) || !reflect.DeepEqual(firebase.subscribed, []string{"firebase:new"}) {
+		t.Errorf("adminRenameTopic() firebase unsubscribed %v subscribed %v, want [firebase:old] [firebase:new]", firebase.unsubscribed, firebase.subscribed)
+	}
+}
+
+func TestAdminRenameTopicRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		topic   string
+		newName string
+		wantErr error
+	}{
+		{"same name", "old", "old", model.ErrValidation},
+		{"empty new name", "old", "", model.ErrValidation},
+		{"reserved new name", "old", "all-users", model.ErrInvalidTopicName},
+		{"missing topic", "missing", "new", model.ErrNotFound},
+		{"new name in use", "old", "taken", model.ErrConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.topics = []model.Topic{
+				{OrgID: "org", AppID: "app", ID: "t1", Name: "old"},
+				{OrgID: "org", AppID: "app", ID: "t2", Name: "taken"},
+			}
+			app := newTestApplication(storage)
+			app.firebase = &fakeFirebase{}
+
+			_, err := app.adminRenameTopic(app.logger.NewLog("test", logs.RequestContext{}), "org", "app", tt.topic, tt.newName)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr.Error()) {
+				t.Errorf("adminRenameTopic() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(storage.topics) != 2 || storage.topics[0].Name != "old" {
+				t.Errorf("adminRenameTopic() topics = %v, want unchanged", storage.topics)
+			}
+		})
+	}
+}
+
+func TestAdminGetTopicsAudience(t *testing.T) {
+	storage := newFakeStorage()
+	storage.topicUsers = []model.User{
+		{OrgID: "org", AppID: "app", UserID: "u1", Topics: []string{"athletics"}},
+		{OrgID: "org", AppID: "app", UserID: "u2", Topics: []string{"athletics", "news"}},
+		{OrgID: "org", AppID: "app", UserID: "u3", Topics: []string{"news", "events"}},
+		{OrgID: "org", AppID: "app", UserID: "u4", Topics: []string{"events"}},
+		{OrgID: "org", AppID: "other", UserID: "u5", Topics: []string{"athletics", "news"}},
+	}
+	app := newTestApplication(storage)
+
+	tests := []struct {
+		name    string
+		topics  []string
+		mode    string
+		want    int64
+		wantErr bool
+	}{
+		{"union", []string{"athletics", "news"}, model.TopicsAudienceModeUnion, 3, false},
+		{"intersection", []string{"athletics", "news"}, model.TopicsAudienceModeIntersection, 1, false},
+		{"no overlap", []string{"athletics", "events"}, model.TopicsAudienceModeIntersection, 0, false},
+		{"single topic", []string{"events"}, model.TopicsAudienceModeIntersection, 2, false},
+		{"invalid mode", []string{"athletics"}, "difference", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audience, err := app.adminGetTopicsAudience("org", "app", tt.topics, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("adminGetTopicsAudience() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && (audience.Count != tt.want || audience.Mode != tt.mode) {
+				t.Errorf("adminGetTopicsAudience() = %d %s, want %d", audience.Count, audience.Mode, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminPreviewMessageFor(t *testing.T) {
+	id := "m1"
+	app := newTestApplication(newFakeStorage())
+	app.config.DefaultMessageData = map[string]string{"source": "notifications"}
+
+	tests := []struct {
+		name      string
+		body      string
+		recipient model.MessageRecipient
+		want      string
+		wantErr   bool
+	}{
+		{"recipient attributes", "Hi {{.name}}, see {{.message_id}}", model.MessageRecipient{UserID: "u1", Data: map[string]string{"name": "Ann"}}, "Hi Ann, see m1", false},
+		{"message data", "From {{.source}} to {{.user_id}}", model.MessageRecipient{UserID: "u1"}, "From notifications to u1", false},
+		{"no placeholders", "Hello", model.MessageRecipient{UserID: "u1"}, "Hello", false},
+		{"missing attribute", "Hi {{.name}}", model.MessageRecipient{UserID: "u1"}, "", true},
+		{"no recipient", "Hello", model.MessageRecipient{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			im := model.InputMessage{OrgID: "org", AppID: "app", ID: &id, Subject: "subject", Body: tt.body, Data: map[string]string{"kind": "news"}}
+			preview, err := app.adminPreviewMessageFor(im, tt.recipient)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("adminPreviewMessageFor() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if preview.Body != tt.want || preview.Subject != "subject" || preview.UserID != tt.recipient.UserID {
+				t.Errorf("adminPreviewMessageFor() = %s %q %q, want %s %q %q", preview.UserID, preview.Subject, preview.Body, tt.recipient.UserID, "subject", tt.want)
+			}
+			wantData := map[string]string{"kind": "news", "source": "notifications", "message_id": id}
+			if !reflect.DeepEqual(preview.Data, wantData) {
+				t.Errorf("adminPreviewMessageFor() data = %v, want %v", preview.Data, wantData)
+			}
+		})
+	}
+}
+
+func TestAdminApproveMessage(t *testing.T) {
+	storage := newFakeStorage()
+	app := newTestApplication(storage)
+	sender := model.CoreAccountRef{UserID: "admin1"}
+
+	im := model.InputMessage{OrgID: "org", AppID: "app", Subject: "subject", Body: "body", Time: time.Now().UTC(), RequiresApproval: true,
+		Sender: model.Sender{Type: "user", User: &sender}, InputRecipients: []model.MessageRecipient{{UserID: "u1"}, {UserID: "u2"}}}
+	messages, err := app.sharedCreateMessages(context.Background(), []model.InputMessage{im}, false)
+	if err != nil {
+		t.Fatalf("sharedCreateMessages() error = %v", err)
+	}
+	message := messages[0]
+	if !message.IsPendingApproval() || len(storage.insertedQueueItems) != 0 {
+		t.Fatalf("sharedCreateMessages() approval status = %v with %d queue items, want pending without queue items", message.ApprovalStatus, len(storage.insertedQueueItems))
+	}
+	for _, recipient := range storage.insertedRecipients {
+		if !recipient.PendingApproval {
+			t.Errorf("sharedCreateMessages() recipient %s is visible before the approval", recipient.UserID)
+		}
+	}
+	storage.messages[message.ID] = message
+	storage.recipients = storage.insertedRecipients
+
+	//the sender cannot approve it
+	_, err = app.adminApproveMessage("org", "app", message.ID, sender)
+	if !errors.Is(err, model.ErrMessageSelfApproval) {
+		t.Errorf("adminApproveMessage() by the sender error = %v, want ErrMessageSelfApproval", err)
+	}
+	if stored := storage.messages[message.ID]; !stored.IsPendingApproval() || len(storage.insertedQueueItems) != 0 {
+		t.Errorf("adminApproveMessage() by the sender has approved the message")
+	}
+
+	approved, err := app.adminApproveMessage("org", "app", message.ID, model.CoreAccountRef{UserID: "admin2"})
+	if err != nil {
+		t.Fatalf("adminApproveMessage() error = %v", err)
+	}
+	if stored := storage.messages[message.ID]; approved.IsPendingApproval() || stored.IsPendingApproval() {
+		t.Errorf("adminApproveMessage() message is still pending approval")
+	}
+	queuedUsers := []string{}
+	for _, item := range storage.insertedQueueItems {
+		queuedUsers = append(queuedUsers, item.UserID)
+	}
+	if !reflect.DeepEqual(queuedUsers, []string{"u1", "u2"}) {
+		t.Errorf("adminApproveMessage() queued the pushes to %v, want [u1 u2]", queuedUsers)
+	}
+	for _, recipient := range storage.recipients {
+		if recipient.PendingApproval {
+			t.Errorf("adminApproveMessage() recipient %s has not been released", recipient.UserID)
+		}
+	}
+
+	//it is approved once only
+	_, err = app.adminApproveMessage("org", "app", message.ID, model.CoreAccountRef{UserID: "admin3"})
+	if !errors.Is(err, model.ErrMessageNotPendingApproval) {
+		t.Errorf("adminApproveMessage() of an approved message error = %v, want ErrMessageNotPendingApproval", err)
+	}
+}
+
+func TestAdminDeadTokens(t *testing.T) {
+	tests := []struct {
+		name        string
+		minFailures int
+		wantDead    []string
+		wantErr     bool
+	}{
+		{"any failure", 1, []string{"u2:t3", "u1:t1"}, false},
+		{"past the grace period", 3, []string{"u2:t3"}, false},
+		{"none", 10, []string{}, false},
+		{"invalid threshold", 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.users = []model.User{
+				{OrgID: "org", AppID: "app", UserID: "u1", DeviceTokens: []model.DeviceToken{{Token: "t1", FailuresCount: 1}, {Token: "t2"}}},
+				{OrgID: "org", AppID: "app", UserID: "u2", DeviceTokens: []model.DeviceToken{{Token: "t3", FailuresCount: 4}}},
+				{OrgID: "org", AppID: "other", UserID: "u3", DeviceTokens: []model.DeviceToken{{Token: "t4", FailuresCount: 5}}},
+			}
+			app := newTestApplication(storage)
+			deadTokens := func(tokens []model.DeadDeviceToken) []string {
+				result := []string{}
+				for _, token := range tokens {
+					result = append(result, token.UserID+":"+token.Token)
+				}
+				return result
+			}
+
+			listed, err := app.adminGetDeadTokens("org", "app", tt.minFailures)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("adminGetDeadTokens() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(deadTokens(listed), tt.wantDead) {
+				t.Errorf("adminGetDeadTokens() = %v, want %v", deadTokens(listed), tt.wantDead)
+			}
+
+			purged, err := app.adminPurgeDeadTokens(app.logger.NewLog("test", logs.RequestContext{}), "org", "app", tt.minFailures)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("adminPurgeDeadTokens() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(deadTokens(purged), tt.wantDead) {
+				t.Errorf("adminPurgeDeadTokens() = %v, want %v", deadTokens(purged), tt.wantDead)
+			}
+			left, _ := app.adminGetDeadTokens("org", "app", tt.minFailures)
+			if len(left) != 0 {
+				t.Errorf("adminPurgeDeadTokens() left %v", deadTokens(left))
+			}
+			//the other tokens and apps are kept
+			if len(storage.users[0].DeviceTokens) == 0 || storage.users[0].DeviceTokens[len(storage.users[0].DeviceTokens)-1].Token != "t2" ||
+				len(storage.users[2].DeviceTokens) != 1 {
+				t.Errorf("adminPurgeDeadTokens() removed other tokens - %v", storage.users)
+			}
+		})
+	}
+}
+
+func TestAdminResendMessage(t *testing.T) {
+	now := time.Now().UTC()
+	delivered, failed := model.DeliveryStatusDelivered, model.DeliveryStatusFailed
+	unregistered := model.DeliveryErrorUnregistered
+
+	tests := []struct {
+		name         string
+		message      func(message *model.Message)
+		pending      bool //a queue item of the first send is left
+		onlyFailed   bool
+		force        bool
+		wantQueued   []string
+		wantErr      error
+		wantSentDiff int
+	}{
+		{"only failed", nil, false, true, false, []string{"u2"}, nil, -1},
+		{"all", nil, false, false, false, []string{"u1", "u2"}, nil, -2},
+		{"still being sent", nil, true, true, false, nil, model.ErrMessageNotSent, 0},
+		{"scheduled", func(message *model.Message) { message.Time = now.Add(time.Hour) }, false, true, false, nil, model.ErrMessageNotSent, 0},
+		{"recalled", func(message *model.Message) { message.Recalled = true }, false, true, false, nil, model.ErrMessageRecalled, 0},
+		{"expired", func(message *model.Message) { message.ExpiresAt = &now }, false, true, false, nil, model.ErrMessageExpired, 0},
+		{"out of the window", func(message *model.Message) { message.Time = now.Add(-100 * time.Hour) }, false, true, false, nil, model.ErrMessageResendWindowPassed, 0},
+		{"forced out of the window", func(message *model.Message) { message.Time = now.Add(-100 * time.Hour) }, false, true, true, []string{"u2"}, nil, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := model.Message{OrgID: "org", AppID: "app", ID: "m1", Subject: "subject", Body: "body", Time: now.Add(-time.Hour)}
+			if tt.message != nil {
+				tt.message(&message)
+			}
+			storage := newFakeStorage(message)
+			storage.recipients = []model.MessageRecipient{
+				{OrgID: "org", AppID: "app", ID: "r1", UserID: "u1", MessageID: "m1", DeliveryStatus: &delivered},
+				{OrgID: "org", AppID: "app", ID: "r2", UserID: "u2", MessageID: "m1", DeliveryStatus: &failed, DeliveryErrorCode: &unregistered},
+			}
+			if tt.pending {
+				storage.insertedQueueItems = []model.QueueItem{{ID: "left", MessageID: "m1"}}
+			}
+			app := newTestApplication(storage)
+
+			_, err := app.adminResendMessage("org", "app", "m1", tt.onlyFailed, tt.force)
+			if tt.wantErr != nil || err != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("adminResendMessage() error = %v, want %v", err, tt.wantErr)
+				}
+				if tt.pending {
+					storage.insertedQueueItems = nil
+				}
+			}
+			queued := []string{}
+			for _, item := range storage.insertedQueueItems {
+				queued = append(queued, item.UserID)
+			}
+			if len(queued) != len(tt.wantQueued) || (len(queued) > 0 && !reflect.DeepEqual(queued, tt.wantQueued)) {
+				t.Errorf("adminResendMessage() queued %v, want %v", queued, tt.wantQueued)
+			}
+			//the previous results of the resent recipients are replaced
+			if got := storage.summaryDeltas["m1"].Sent; got != tt.wantSentDiff {
+				t.Errorf("adminResendMessage() sent delta = %d, want %d", got, tt.wantSentDiff)
+			}
+		})
+	}
+}
+
+func TestAdminSoftDeleteMessageRoundTrip(t *testing.T) {
+	storage := newFakeStorage(testScheduledMessage(time.Now().UTC()))
+	storage.recipients = []model.MessageRecipient{{OrgID: "org", AppID: "app", MessageID: "message", UserID: "u1"}}
+	app := newTestApplication(storage)
+
+	steps := []struct {
+		name        string
+		action      func() error
+		wantErr     error
+		wantDeleted bool
+	}{
+		{"delete", func() error { return app.adminDeleteMessage("org", "app", "message") }, nil, true},
+		{"delete again", func() error { return app.adminDeleteMessage("org", "app", "message") }, model.ErrMessageDeleted, true},
+		{"recall deleted", func() error {
+			_, err := app.adminRecallMessage("org", "app", "message")
+			return err
+		}, model.ErrMessageDeleted, true},
+		{"user get deleted", func() error {
+			_, err := app.getUserMessage("org", "app", "message", "u1")
+			return err
+		}, model.ErrNotFound, true},
+		{"restore", func() error {
+			_, err := app.adminRestoreMessage("org", "app", "message")
+			return err
+		}, nil, false},
+		{"restore again", func() error {
+			_, err := app.adminRestoreMessage("org", "app", "message")
+			return err
+		}, model.ErrMessageNotDeleted, false},
+		{"user get restored", func() error {
+			_, err := app.getUserMessage("org", "app", "message", "u1")
+			return err
+		}, nil, false},
+	}
+	for _, step := range steps {
+		err := step.action()
+		//the logging library errors do not unwrap, the error they wrap is checked
+		var wrapper interface{ Internal() error }
+		if errors.As(err, &wrapper) {
+			err = wrapper.Internal()
+		}
+		if !errors.Is(err, step.wantErr) {
+			t.Errorf("%s: error = %v, want %v", step.name, err, step.wantErr)
+		}
+		message := storage.messages["message"]
+		if message.Deleted != step.wantDeleted || (message.DateDeleted != nil) != step.wantDeleted || storage.recipients[0].Deleted != step.wantDeleted {
+			t.Errorf("%s: message deleted = %t, recipient deleted = %t, want %t", step.name, message.Deleted, storage.recipients[0].Deleted, step.wantDeleted)
+		}
+	}
+
+	if err := app.adminPurgeMessage("org", "app", "message"); err != nil {
+		t.Fatalf("adminPurgeMessage() error = %v", err)
+	}
+	if _, ok := storage.messages["message"]; ok || !reflect.DeepEqual(storage.deletedMessages, []string{"message"}) {
+		t.Errorf("adminPurgeMessage() deleted messages = %v, want %v", storage.deletedMessages, []string{"message"})
+	}
+}
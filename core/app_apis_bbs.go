@@ -15,7 +15,7 @@
 package core
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"notifications/core/model"
 	"notifications/driven/storage"
@@ -25,8 +25,8 @@ import (
 	"github.com/rokwire/logging-library-go/v2/logs"
 )
 
-func (app *Application) bbsCreateMessages(inputMessages []model.InputMessage, isBatch bool) ([]model.Message, error) {
-	return app.sharedCreateMessages(inputMessages, isBatch)
+func (app *Application) bbsCreateMessages(ctx context.Context, inputMessages []model.InputMessage, isBatch bool) ([]model.Message, error) {
+	return app.sharedCreateMessages(ctx, inputMessages, isBatch)
 }
 
 func (app *Application) bbsDeleteMessages(l *logs.Log, serviceAccountID string, messagesIDs []string) error {
@@ -38,14 +38,14 @@ func (app *Application) bbsDeleteMessages(l *logs.Log, serviceAccountID string,
 			return err
 		}
 		if len(messagesIDs) != len(messages) {
-			return errors.New("not found message's")
+			return fmt.Errorf("%w: not found message's", model.ErrNotFound)
 		}
 
 		//validate if the service account is the sender of the messages
 		for _, m := range messages {
 			valid := app.isSenderValid(serviceAccountID, m)
 			if !valid {
-				return errors.New("not valid service account id for message - " + m.ID)
+				return fmt.Errorf("%w: not valid service account id for message - %s", model.ErrUnauthorized, m.ID)
 			}
 		}
 
@@ -53,10 +53,10 @@ func (app *Application) bbsDeleteMessages(l *logs.Log, serviceAccountID string,
 		messagesIDs := make([]string, len(messages))
 		for i, m := range messages {
 			messagesIDs[i] = m.ID
-		}
-		err = app.storage.DeleteMessagesWithContext(context, messagesIDs)
-		if err != nil {
-			return err
+			err = app.storage.DeleteMessagesWithContext(context, m.OrgID, m.AppID, []string{m.ID})
+			if err != nil {
+				return err
+			}
 		}
 
 		//delete the messages recipients
@@ -109,14 +109,14 @@ func (app *Application) bbsAddRecipients(l *logs.Log, serviceAccountID string, m
 			return err
 		}
 		if len(messageses) == 0 {
-			return errors.New("not found message")
+			return fmt.Errorf("%w: not found message", model.ErrNotFound)
 		}
 		message := messageses[0]
 
 		//validate if the service account is the sender of the messages
 		valid := app.isSenderValid(serviceAccountID, message)
 		if !valid {
-			return errors.New("not valid service account id for message - " + message.ID)
+			return fmt.Errorf("%w: not valid service account id for message - %s", model.ErrUnauthorized, message.ID)
 		}
 
 		//create recipients objects
@@ -137,7 +137,10 @@ func (app *Application) bbsAddRecipients(l *logs.Log, serviceAccountID string, m
 		}
 
 		//create the notifications queue items and store them in the queue
-		queueItems := app.sharedCreateRecipientsQueueItems(&message, recipients)
+		queueItems, err := app.sharedCreateQueueItems(message, recipients)
+		if err != nil {
+			return err
+		}
 		if len(queueItems) > 0 {
 			err = app.storage.InsertQueueDataItemsWithContext(context, queueItems)
 			if err != nil {
@@ -177,14 +180,14 @@ func (app *Application) bbsDeleteRecipients(l *logs.Log, serviceAccountID string
 			return err
 		}
 		if len(messageses) == 0 {
-			return errors.New("not found message")
+			return fmt.Errorf("%w: not found message", model.ErrNotFound)
 		}
 		message := messageses[0]
 
 		//validate if the service account is the sender of the messages
 		valid := app.isSenderValid(serviceAccountID, message)
 		if !valid {
-			return errors.New("not valid service account id for message - " + message.ID)
+			return fmt.Errorf("%w: not valid service account id for message - %s", model.ErrUnauthorized, message.ID)
 		}
 
 		//find the message recipients for deletion
@@ -193,7 +196,7 @@ func (app *Application) bbsDeleteRecipients(l *logs.Log, serviceAccountID string
 			return err
 		}
 		if len(recipients) != len(usersIDs) {
-			return errors.New("not found recipient/s")
+			return fmt.Errorf("%w: not found recipient/s", model.ErrNotFound)
 		}
 
 		//prepare the messages recipients ids
@@ -16,14 +16,15 @@ package core
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"notifications/core/model"
+	"notifications/driven/storage"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rokwire/core-auth-library-go/v3/authutils"
 	"github.com/rokwire/core-auth-library-go/v3/tokenauth"
+	"github.com/rokwire/logging-library-go/v2/errors"
 	"github.com/rokwire/logging-library-go/v2/logs"
 	"github.com/rokwire/logging-library-go/v2/logutils"
 )
@@ -32,17 +33,69 @@ func (app *Application) getVersion() string {
 	return app.version
 }
 
+// checkReadiness gives an error when the database or the firebase adapter is not available
+func (app *Application) checkReadiness() error {
+	err := app.storage.Ping()
+	if err != nil {
+		return err
+	}
+	if !app.firebase.IsReady() {
+		return errors.ErrorData(logutils.StatusMissing, "firebase client", nil)
+	}
+	return nil
+}
+
+// sendMetricsWindow is how far back the messages sends are aggregated in the send metrics
+const sendMetricsWindow = time.Hour
+
+func (app *Application) getSendMetrics() (*model.SendMetrics, error) {
+	messages, err := app.storage.FindMessagesSendEndedAfter(time.Now().UTC().Add(-sendMetricsWindow))
+	if err != nil {
+		return nil, err
+	}
+	metrics := model.NewSendMetrics(sendMetricsWindow, messages)
+	return &metrics, nil
+}
+
+func (app *Application) getPrunedTokensCount() int64 {
+	return app.tokensCleanupLogic.prunedTokens.Load()
+}
+
 func (app *Application) storeToken(orgID string, appID string, tokenInfo *model.TokenInfo, userID string) error {
-	return app.storage.StoreDeviceToken(orgID, appID, tokenInfo, userID)
+	err := app.storage.StoreDeviceToken(orgID, appID, tokenInfo, userID)
+	if err != nil {
+		return err
+	}
+
+	app.updateUserLastActive(orgID, appID, userID)
+	return nil
+}
+
+// updateUserLastActive marks the user as active now. It is not critical, so the errors are only logged.
+func (app *Application) updateUserLastActive(orgID string, appID string, userID string) {
+	if len(userID) == 0 {
+		return
+	}
+	err := app.storage.UpdateUserLastActive(orgID, appID, userID, time.Now().UTC())
+	if err != nil {
+		app.logger.Warnf("error updating the last active time for user %s - %s", userID, err)
+	}
 }
 
 func (app *Application) subscribeToTopic(orgID string, appID string, token string, userID string, anonymous bool, topic string) error {
-	var err error
+	topic = app.resolveTopicName(orgID, appID, topic)
+	err := app.validateTopicName(topic)
+	if err != nil {
+		return err
+	}
 	if !anonymous {
 		err = app.storage.SubscribeToTopic(orgID, appID, token, userID, topic)
 		if err == nil && token != "" {
 			err = app.firebase.SubscribeToTopic(orgID, appID, token, topic)
 		}
+		if err == nil {
+			app.sharedNotifySubscription(orgID, appID, userID, topic, model.SubscriptionActionSubscribe)
+		}
 	} else if token != "" {
 		// Treat this user as anonymous.
 		err = app.firebase.SubscribeToTopic(orgID, appID, token, topic)
@@ -52,11 +105,15 @@ func (app *Application) subscribeToTopic(orgID string, appID string, token strin
 
 func (app *Application) unsubscribeToTopic(orgID string, appID string, token string, userID string, anonymous bool, topic string) error {
 	var err error
+	topic = app.resolveTopicName(orgID, appID, topic)
 	if !anonymous {
 		err = app.storage.UnsubscribeToTopic(orgID, appID, token, userID, topic)
 		if err == nil && token != "" {
 			err = app.firebase.UnsubscribeToTopic(orgID, appID, token, topic)
 		}
+		if err == nil {
+			app.sharedNotifySubscription(orgID, appID, userID, topic, model.SubscriptionActionUnsubscribe)
+		}
 	} else if token != "" {
 		// Treat this user as anonymous.
 		err = app.firebase.UnsubscribeToTopic(orgID, appID, token, topic)
@@ -64,21 +121,166 @@ func (app *Application) unsubscribeToTopic(orgID string, appID string, token str
 	return err
 }
 
-func (app *Application) getTopics(orgID string, appID string) ([]model.Topic, error) {
-	return app.storage.GetTopics(orgID, appID)
+func (app *Application) unsubscribeFromAllTopics(l *logs.Log, orgID string, appID string, userID string, anonymous bool) ([]string, error) {
+	//the anonymous users do not have stored topics
+	if anonymous {
+		return []string{}, nil
+	}
+
+	removed, err := app.sharedUnsubscribeFromAllTopics(l, orgID, appID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if removed == nil {
+		return []string{}, nil
+	}
+	return removed, nil
+}
+
+func (app *Application) updateTopicSubscriptions(l *logs.Log, orgID string, appID string, token string, userID string, anonymous bool, subscribe []string, unsubscribe []string) (*model.TopicSubscriptions, error) {
+	//apply every change separately so that a failed topic does not block the others
+	topicsNames := []string{}
+	tasks := []func() error{}
+	added := map[string]bool{}
+	for _, topic := range subscribe {
+		if added[topic] {
+			continue
+		}
+		added[topic] = true
+		topic := topic
+		topicsNames = append(topicsNames, topic)
+		tasks = append(tasks, func() error {
+			err := app.subscribeToTopic(orgID, appID, token, userID, anonymous, topic)
+			if err != nil {
+				return fmt.Errorf("error subscribing user %s to topic %s - %w", userID, topic, err)
+			}
+			return nil
+		})
+	}
+	for _, topic := range unsubscribe {
+		if added[topic] {
+			continue
+		}
+		added[topic] = true
+		topic := topic
+		topicsNames = append(topicsNames, topic)
+		tasks = append(tasks, func() error {
+			err := app.unsubscribeToTopic(orgID, appID, token, userID, anonymous, topic)
+			if err != nil {
+				return fmt.Errorf("error unsubscribing user %s from topic %s - %w", userID, topic, err)
+			}
+			return nil
+		})
+	}
+	errs := app.sharedRunResync(l, "topics subscriptions update", tasks)
+	failed := []string{}
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, topicsNames[i])
+		}
+	}
+
+	//the anonymous users do not have stored topics
+	topics := []string{}
+	if !anonymous {
+		user, err := app.storage.FindUserByID(orgID, appID, userID)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find user(%s): %s", userID, err)
+		}
+		if user != nil && user.Topics != nil {
+			topics = user.Topics
+		}
+	}
+
+	return &model.TopicSubscriptions{Topics: topics, Failed: failed}, nil
+}
+
+// validateTopicName checks the name of a new topic against the configured max length and the reserved prefixes
+func (app *Application) validateTopicName(name string) error {
+	maxLength := app.config.TopicNameMaxLength
+	if maxLength <= 0 {
+		maxLength = defaultTopicNameMaxLength
+	}
+	reservedPrefixes := append([]string{}, defaultTopicReservedPrefixes...)
+	reservedPrefixes = append(reservedPrefixes, app.config.TopicReservedPrefixes...)
+	return model.ValidateTopicName(name, maxLength, reservedPrefixes)
+}
+
+// resolveTopicName returns the current name of a topic which may have been renamed
+func (app *Application) resolveTopicName(orgID string, appID string, topic string) string {
+	aliasedTopic, err := app.storage.FindTopicByAlias(orgID, appID, topic)
+	if err != nil || aliasedTopic == nil {
+		return topic
+	}
+	return aliasedTopic.Name
+}
+
+// defaultTopicNameMaxLength is used when the topic name max length is not configured
+const defaultTopicNameMaxLength = 256
+
+// defaultTopicReservedPrefixes are the topic names prefixes which are used internally
+var defaultTopicReservedPrefixes = []string{"all-users"}
+
+// topicReachCacheTTL is how long a topic reach count is cached
+const topicReachCacheTTL = time.Minute
+
+type cachedTopicReach struct {
+	count   int64
+	expires time.Time
+}
+
+func (app *Application) getTopicReach(orgID string, appID string, topic string) (*model.TopicReach, error) {
+	topic = app.resolveTopicName(orgID, appID, topic)
+
+	//give the cached count if it is still fresh
+	cacheKey := fmt.Sprintf("%s_%s_%s", orgID, appID, topic)
+	if item, ok := app.topicsReach.Load(cacheKey); ok {
+		if cached, ok := item.(cachedTopicReach); ok && time.Now().Before(cached.expires) {
+			return &model.TopicReach{Topic: topic, Count: cached.count}, nil
+		}
+	}
+
+	count, err := app.storage.CountUsersByTopic(orgID, appID, topic)
+	if err != nil {
+		return nil, err
+	}
+	app.topicsReach.Store(cacheKey, cachedTopicReach{count: count, expires: time.Now().Add(topicReachCacheTTL)})
+
+	return &model.TopicReach{Topic: topic, Count: count}, nil
+}
+
+func (app *Application) getTopics(orgID string, appID string, offset *int64, limit *int64, sortBy *string, order *string) ([]model.Topic, int64, error) {
+	//the subscribers counts are given only by the admin APIs
+	return app.sharedGetTopics(orgID, appID, offset, limit, sortBy, order, false)
 }
 
 func (app *Application) appendTopic(topic *model.Topic) (*model.Topic, error) {
+	err := app.validateTopicName(topic.Name)
+	if err != nil {
+		return nil, err
+	}
 	return app.storage.InsertTopic(topic)
 }
 
 func (app *Application) updateTopic(topic *model.Topic) (*model.Topic, error) {
+	err := topic.Validate()
+	if err != nil {
+		return nil, err
+	}
 	return app.storage.UpdateTopic(topic)
 }
 
-func (app *Application) createMessage(inputMessage model.InputMessage) (*model.Message, error) {
+// idempotencyKeyTTL is how long a message idempotency key prevents the message from being created again
+const idempotencyKeyTTL = 24 * time.Hour
+
+func (app *Application) createMessage(ctx context.Context, inputMessage model.InputMessage) (*model.Message, error) {
+	existing, err := app.findIdempotentMessage(inputMessage)
+	if err != nil || existing != nil {
+		return existing, err
+	}
+
 	inputMessages := []model.InputMessage{inputMessage} //only one
-	messages, err := app.sharedCreateMessages(inputMessages, false)
+	messages, err := app.sharedCreateMessages(ctx, inputMessages, false)
 	if err != nil {
 		return nil, err
 	}
@@ -89,16 +291,119 @@ func (app *Application) createMessage(inputMessage model.InputMessage) (*model.M
 	return &messages[0], nil //return only one
 }
 
-func (app *Application) createMessages(inputMessages []model.InputMessage, isBatch bool) ([]model.Message, error) {
-	return app.sharedCreateMessages(inputMessages, isBatch)
+// createMessageAsync stores the message as queued and creates it in the background so that the caller does not wait for the recipients
+func (app *Application) createMessageAsync(ctx context.Context, inputMessage model.InputMessage) (*model.Message, error) {
+	existing, err := app.findIdempotentMessage(inputMessage)
+	if err != nil || existing != nil {
+		return existing, err
+	}
+
+	//check the source app, the settings and the content before accepting it so that the caller gets the errors
+	inputMessages := []model.InputMessage{inputMessage}
+	err = app.sharedValidateSourceApps(inputMessages)
+	if err != nil {
+		return nil, err
+	}
+	err = app.sharedValidateInputMessage(inputMessage)
+	if err != nil {
+		return nil, err
+	}
+	err = app.sharedModerateMessages(inputMessages)
+	if err != nil {
+		return nil, err
+	}
+	inputMessage = inputMessages[0]
+
+	if inputMessage.ID == nil {
+		messageID := uuid.NewString()
+		inputMessage.ID = &messageID
+	}
+	inputMessage.Data = app.sharedMessageData(inputMessage.Data, *inputMessage.ID)
+	err = model.ValidatePushPayloadSize(inputMessage.Subject, inputMessage.Body, inputMessage.Data)
+	if err != nil {
+		return nil, err
+	}
+	queuedMessage, err := app.storage.CreateMessageWithContext(ctx, model.NewQueuedMessage(inputMessage, time.Now().UTC()))
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionCreate, "queued message", &logutils.FieldArgs{"id": *inputMessage.ID}, err)
+	}
+
+	inputMessage.Queued = true
+	go app.createQueuedMessage(inputMessage)
+
+	return queuedMessage, nil
 }
 
-func (app *Application) getMessagesRecipientsDeep(orgID string, appID string, userID *string, read *bool, mute *bool, messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, offset *int64, limit *int64, order *string) ([]model.MessageRecipient, error) {
-	return app.storage.FindMessagesRecipientsDeep(orgID, appID, userID, read, mute, messageIDs, startDateEpoch, endDateEpoch, filterTopic, offset, limit, order)
+// createQueuedMessage creates the message accepted by an async create, the queue sends it as any other message
+func (app *Application) createQueuedMessage(inputMessage model.InputMessage) {
+	_, err := app.sharedCreateMessages(context.Background(), []model.InputMessage{inputMessage}, false)
+	if err == nil {
+		return
+	}
+
+	app.logger.Errorf("error creating the queued message %s - %s", *inputMessage.ID, err)
+	err = app.storage.FailQueuedMessage(inputMessage.OrgID, inputMessage.AppID, *inputMessage.ID, err.Error())
+	if err != nil {
+		app.logger.Errorf("error marking the queued message %s as failed - %s", *inputMessage.ID, err)
+	}
+}
+
+// findIdempotentMessage gives the message which has already been created by the same sender for a retried request
+func (app *Application) findIdempotentMessage(inputMessage model.InputMessage) (*model.Message, error) {
+	if inputMessage.IdempotencyKey == nil {
+		return nil, nil
+	}
+	existing, err := app.storage.GetMessageByIdempotencyKey(inputMessage.OrgID, inputMessage.AppID, inputMessage.Sender,
+		*inputMessage.IdempotencyKey, time.Now().UTC().Add(-idempotencyKeyTTL))
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "message", &logutils.FieldArgs{"idempotency_key": *inputMessage.IdempotencyKey}, err)
+	}
+	return existing, nil
+}
+
+func (app *Application) createMessages(ctx context.Context, inputMessages []model.InputMessage, isBatch bool) ([]model.Message, error) {
+	return app.sharedCreateMessages(ctx, inputMessages, isBatch)
+}
+
+func (app *Application) getMessagesRecipientsDeep(ctx context.Context, orgID string, appID string, userID *string, read *bool, mute *bool, messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, hasAttachment *bool, priority *int64, groupID *string, offset *int64, limit *int64, order *string, orderBy *string, fields []string) ([]model.MessageRecipient, int64, error) {
+	if filterTopic != nil {
+		resolvedTopic := app.resolveTopicName(orgID, appID, *filterTopic)
+		filterTopic = &resolvedTopic
+	}
+	if userID != nil {
+		//the user checks its messages
+		app.updateUserLastActive(orgID, appID, *userID)
+	}
+	return app.storage.FindMessagesRecipientsDeepWithCount(ctx, orgID, appID, userID, read, mute, messageIDs, startDateEpoch, endDateEpoch, filterTopic, hasAttachment, priority, groupID, offset, limit, order, orderBy, fields)
+}
+
+func (app *Application) getUserMessagesSince(ctx context.Context, orgID string, appID string, userID string, sinceID string, limit int64, fields []string) ([]model.MessageRecipient, string, error) {
+	cursor, err := app.storage.GetMessage(orgID, appID, sinceID)
+	if err != nil || cursor == nil {
+		return nil, "", fmt.Errorf("%w: %s", model.ErrInvalidCursor, sinceID)
+	}
+
+	//the user checks its messages
+	app.updateUserLastActive(orgID, appID, userID)
+
+	messages, err := app.storage.GetMessagesAfter(ctx, orgID, appID, userID, *cursor, limit, fields)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//the client keeps the cursor when there are no new messages
+	nextCursor := sinceID
+	if len(messages) > 0 {
+		nextCursor = messages[len(messages)-1].MessageID
+	}
+	return messages, nextCursor, nil
 }
 
 func (app *Application) getMessagesStats(orgID string, appID string, userID string) (*model.MessagesStats, error) {
-	stats, _ := app.storage.GetMessagesStats(userID)
+	stats, err := app.storage.GetUserMessagesStats(orgID, appID, userID)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "message stats", &logutils.FieldArgs{"user_id": userID}, err)
+	}
 	return stats, nil
 }
 
@@ -111,9 +416,9 @@ func (app *Application) getUserMessage(orgID string, appID string, ID string, ac
 	if err != nil {
 		return nil, err
 	}
-	if message == nil {
+	if message == nil || message.Deleted {
 		//no message for this id
-		return nil, nil
+		return nil, errors.WrapErrorData(logutils.StatusMissing, "message", &logutils.FieldArgs{"id": ID}, model.ErrNotFound)
 	}
 
 	//check if sender
@@ -126,11 +431,14 @@ func (app *Application) getUserMessage(orgID string, appID string, ID string, ac
 	if err != nil {
 		return nil, err
 	}
-	if len(messagesRecipients) > 0 {
-		return message, err //it is recipient
+	for _, recipient := range messagesRecipients {
+		if !recipient.PendingApproval && !recipient.Recalled && !recipient.Deleted {
+			return message, err //it is recipient
+		}
 	}
 
-	return nil, nil //not sender, not recipient
+	//not sender, not recipient - the message is not exposed to the other users
+	return nil, errors.WrapErrorData(logutils.StatusMissing, "message", &logutils.FieldArgs{"id": ID}, model.ErrNotFound)
 }
 
 func (app *Application) updateMessage(userID *string, message *model.Message) (*model.Message, error) {
@@ -139,12 +447,136 @@ func (app *Application) updateMessage(userID *string, message *model.Message) (*
 		if err == nil && persistedMessage != nil {
 			// If userID is nil, treat as system update, otherwise check sender match
 			if userID == nil || (persistedMessage.Sender.User != nil && persistedMessage.Sender.User.UserID == *userID) {
-				return app.storage.UpdateMessage(message)
+				if !persistedMessage.HasContentChanges(*message) {
+					return app.storage.UpdateMessage(message)
+				}
+				//the recipients and the content can be changed only until the message is sent
+				if !persistedMessage.IsScheduled(time.Now()) {
+					return nil, fmt.Errorf("%w: %s", model.ErrMessageNotEditable, message.ID)
+				}
+				return app.rescheduleMessage(*persistedMessage, *message)
 			}
-			return nil, fmt.Errorf("only creator can update the original message")
+			return nil, fmt.Errorf("%w: only creator can update the original message", model.ErrUnauthorized)
 		}
 	}
-	return nil, fmt.Errorf("missing id or record")
+	return nil, fmt.Errorf("%w: missing id or record", model.ErrNotFound)
+}
+
+// rescheduleMessage replaces a scheduled message with its updated version. The recipients and the queue items are
+// calculated again as on creating it. The criteria and the other settings of the message are kept, as well as the
+// topics, the recipients and the time when the update does not give them.
+func (app *Application) rescheduleMessage(persistedMessage model.Message, update model.Message) (*model.Message, error) {
+	excludeRecipients := make([]model.MessageRecipient, len(persistedMessage.ExcludedRecipients))
+	for i, userID := range persistedMessage.ExcludedRecipients {
+		excludeRecipients[i] = model.MessageRecipient{UserID: userID}
+	}
+
+	topic, topics := update.Topic, update.Topics
+	if topic == nil && len(topics) == 0 {
+		topic, topics = persistedMessage.Topic, persistedMessage.Topics
+	}
+	inputRecipients, err := app.rescheduledRecipients(persistedMessage, update)
+	if err != nil {
+		return nil, err
+	}
+
+	im := model.InputMessage{OrgID: persistedMessage.OrgID, AppID: persistedMessage.AppID, ID: &persistedMessage.ID,
+		Sender: persistedMessage.Sender, Time: update.Time, Priority: update.Priority, Subject: update.Subject, Body: update.Body,
+		Data: persistedMessage.Data, LocalizedSubjects: persistedMessage.LocalizedSubjects, LocalizedBodies: persistedMessage.LocalizedBodies,
+		InputRecipients: inputRecipients, ExcludeRecipients: excludeRecipients, RecipientsCriteriaList: persistedMessage.RecipientsCriteriaList,
+		RecipientAccountCriteria: persistedMessage.RecipientAccountCriteria, Topic: topic, Topics: topics,
+		Attachments: persistedMessage.Attachments, SourceApp: persistedMessage.SourceApp, ActiveWithinMinutes: persistedMessage.ActiveWithinMinutes,
+		DeferInactive: persistedMessage.DeferInactive, DeliveryChannels: persistedMessage.DeliveryChannels, RequiresApproval: persistedMessage.IsPendingApproval(),
+		Sound: persistedMessage.Sound, Badge: persistedMessage.Badge, Silent: persistedMessage.Silent, PerDevice: persistedMessage.PerDevice, ExpiresAt: persistedMessage.ExpiresAt,
+		CallbackURL: persistedMessage.CallbackURL, GroupID: persistedMessage.GroupID, CollapseKey: persistedMessage.CollapseKey, ImageURL: persistedMessage.ImageURL, AndroidChannelID: persistedMessage.AndroidChannelID, IdempotencyKey: persistedMessage.IdempotencyKey}
+
+	//the scheduled time is the update time, or the event time minus the offset - the given one or the kept one
+	switch {
+	case update.EventTime != nil:
+		im.EventTime = update.EventTime
+		if update.OffsetBefore != nil {
+			im.OffsetBefore = time.Duration(*update.OffsetBefore) * time.Second
+		}
+	case update.Time.IsZero() || update.Time.Equal(persistedMessage.Time):
+		im.Time = persistedMessage.Time
+		im.EventTime = persistedMessage.EventTime
+		if persistedMessage.OffsetBefore != nil {
+			im.OffsetBefore = time.Duration(*persistedMessage.OffsetBefore) * time.Second
+		}
+	}
+
+	imMessages := []model.InputMessage{im}
+	err = app.sharedModerateMessages(imMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	var message *model.Message
+	notifyQueue := false
+	transaction := func(context storage.TransactionContext) error {
+		//remove the scheduled message with its recipients and queue items
+		err := app.storage.DeleteQueueDataForMessagesWithContext(context, []string{persistedMessage.ID})
+		if err != nil {
+			return err
+		}
+		err = app.storage.DeleteMessagesRecipientsForMessagesWithContext(context, []string{persistedMessage.ID})
+		if err != nil {
+			return err
+		}
+		err = app.storage.DeleteMessagesWithContext(context, persistedMessage.OrgID, persistedMessage.AppID, []string{persistedMessage.ID})
+		if err != nil {
+			return err
+		}
+
+		//create it again with the same id
+		var recipients []model.MessageRecipient
+		message, recipients, err = app.sharedHandleInputMessage(context, imMessages[0])
+		if err != nil {
+			return err
+		}
+		message.DateCreated = persistedMessage.DateCreated
+		var queueItems []model.QueueItem
+		if !message.IsPendingApproval() {
+			queueItems, err = app.sharedCreateQueueItems(*message, recipients)
+			if err != nil {
+				return err
+			}
+		}
+		notifyQueue, err = app.sharedStoreMessages(context, []model.Message{*message}, recipients, queueItems)
+		return err
+	}
+	err = app.storage.PerformTransaction(transaction, 10000) //10 seconds timeout
+	if err != nil {
+		return nil, err
+	}
+
+	//notify the queue that the items are replaced
+	if notifyQueue {
+		go app.queueLogic.onQueuePush()
+	}
+	return message, nil
+}
+
+// rescheduledRecipients gives the recipients of the updated message - the updated ones, otherwise the persisted ones.
+// The recipients of a message without topics and criteria were all given on creating it, so they are loaded.
+func (app *Application) rescheduledRecipients(persistedMessage model.Message, update model.Message) ([]model.MessageRecipient, error) {
+	if len(update.Recipients) > 0 {
+		return update.Recipients, nil
+	}
+	if len(persistedMessage.Recipients) > 0 || len(persistedMessage.Topics) > 0 || persistedMessage.Topic != nil ||
+		len(persistedMessage.RecipientsCriteriaList) > 0 {
+		return persistedMessage.Recipients, nil
+	}
+
+	persistedRecipients, err := app.storage.FindMessagesRecipientsByMessages(persistedMessage.OrgID, persistedMessage.AppID, []string{persistedMessage.ID})
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]model.MessageRecipient, len(persistedRecipients))
+	for i, recipient := range persistedRecipients {
+		recipients[i] = model.MessageRecipient{UserID: recipient.UserID, Mute: recipient.Mute, Data: recipient.Data}
+	}
+	return recipients, nil
 }
 
 func (app *Application) updateReadMessage(orgID string, appID string, ID string, userID string) (*model.Message, error) {
@@ -155,16 +587,57 @@ func (app *Application) updateReadMessage(orgID string, appID string, ID string,
 	return updateReadMessage, nil
 }
 
-func (app *Application) updateAllUserMessagesRead(orgID string, appID string, userID string, read bool) error {
-	return app.storage.UpdateAllUserMessagesRead(context.Background(), orgID, appID, userID, read)
+func (app *Application) updateAllUserMessagesRead(orgID string, appID string, userID string, messageIDs []string, read bool) (int64, error) {
+	return app.storage.UpdateAllUserMessagesRead(context.Background(), orgID, appID, userID, messageIDs, read)
+}
+
+func (app *Application) reportMessage(l *logs.Log, orgID string, appID string, messageID string, userID string, reason string) error {
+	//only the recipients can report a message
+	recipients, err := app.storage.FindMessagesRecipients(orgID, appID, messageID, userID)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("user %s is not a recipient of message %s", userID, messageID)
+	}
+
+	report := model.MessageReport{ReporterID: userID, Reason: reason, DateCreated: time.Now().UTC()}
+	added, err := app.storage.AddMessageReport(orgID, appID, messageID, report)
+	if err != nil {
+		return err
+	}
+	if !added {
+		return nil //already reported by this user
+	}
+
+	if app.config.ReportsThreshold <= 0 {
+		return nil //flagging is disabled
+	}
+	flagged, err := app.storage.FlagMessage(orgID, appID, messageID, app.config.ReportsThreshold)
+	if err != nil {
+		return err
+	}
+	if flagged && len(app.config.ReportsAdminEmail) > 0 {
+		subject := "Message flagged"
+		body := fmt.Sprintf("The message %s has been reported %d or more times and has been flagged.", messageID, app.config.ReportsThreshold)
+		err = app.sharedSendMail(app.config.ReportsAdminEmail, subject, body)
+		if err != nil {
+			l.Warnf("error notifying the admins for flagged message %s - %s", messageID, err)
+		}
+	}
+	return nil
 }
 
 func (app *Application) deleteUserMessage(orgID string, appID string, userID string, messageID string) error {
+	//an empty user id would match the recipients of the message which are not bound to a user
+	if len(userID) == 0 {
+		return errors.WrapErrorData(logutils.StatusMissing, "user id", &logutils.FieldArgs{"message_id": messageID}, model.ErrValidation)
+	}
 	return app.storage.DeleteUserMessageWithContext(context.Background(), orgID, appID, userID, messageID)
 }
 
 func (app *Application) deleteMessage(orgID string, appID string, ID string) error {
-	return app.storage.DeleteMessagesWithContext(context.Background(), []string{ID})
+	return app.storage.DeleteMessagesWithContext(context.Background(), orgID, appID, []string{ID})
 }
 
 func (app *Application) getAllAppVersions(orgID string, appID string) ([]model.AppVersion, error) {
@@ -194,6 +667,140 @@ func (app *Application) updateUserByID(orgID string, appID string, userID string
 	return app.storage.UpdateUserByID(orgID, appID, userID, notificationsDisabled)
 }
 
+func (app *Application) heartbeat(orgID string, appID string, userID string, l *logs.Log) error {
+	//make sure that the user exists
+	_, err := app.findUserByID(orgID, appID, userID, l)
+	if err != nil {
+		return err
+	}
+	return app.storage.UpdateUserLastActive(orgID, appID, userID, time.Now().UTC())
+}
+
+func (app *Application) updateTokenPreferences(orgID string, appID string, userID string, token string, notificationsDisabled bool) error {
+	found, err := app.storage.UpdateDeviceTokenNotificationsDisabled(orgID, appID, userID, token, notificationsDisabled)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.WrapErrorData(logutils.StatusMissing, "device token", &logutils.FieldArgs{"user_id": userID}, model.ErrNotFound)
+	}
+	return nil
+}
+
+func (app *Application) getUserMutedTopics(orgID string, appID string, userID string, l *logs.Log) ([]string, error) {
+	user, err := app.findUserByID(orgID, appID, userID, l)
+	if err != nil {
+		return nil, err
+	}
+	if user.MutedTopics == nil {
+		return []string{}, nil
+	}
+	return user.MutedTopics, nil
+}
+
+func (app *Application) updateUserMutedTopics(orgID string, appID string, userID string, mutedTopics []string, l *logs.Log) ([]string, error) {
+	//make sure the user record exists
+	_, err := app.findUserByID(orgID, appID, userID, l)
+	if err != nil {
+		return nil, err
+	}
+
+	//remove the duplicates
+	topics := []string{}
+	added := map[string]bool{}
+	for _, topic := range mutedTopics {
+		if len(topic) > 0 && !added[topic] {
+			topics = append(topics, topic)
+			added[topic] = true
+		}
+	}
+
+	user, err := app.storage.UpdateUserMutedTopics(orgID, appID, userID, topics)
+	if err != nil {
+		return nil, fmt.Errorf("unable to update muted topics for user(%s): %s", userID, err)
+	}
+	if user == nil || user.MutedTopics == nil {
+		return []string{}, nil
+	}
+	return user.MutedTopics, nil
+}
+
+func (app *Application) getUserSettings(orgID string, appID string, userID string, l *logs.Log) (*model.UserSettings, error) {
+	user, err := app.findUserByID(orgID, appID, userID, l)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, nil
+	}
+	settings := user.GetSettings()
+	return &settings, nil
+}
+
+func (app *Application) updateUserSettings(orgID string, appID string, userID string, start *int, end *int, timeZone *string,
+	notificationsEnabled *bool, pausedUntil *time.Time, l *logs.Log) (*model.User, error) {
+	err := model.ValidateQuietHours(start, end, timeZone)
+	if err != nil {
+		return nil, err
+	}
+	if pausedUntil != nil && !pausedUntil.After(time.Now()) {
+		return nil, fmt.Errorf("%w: %s has passed", model.ErrInvalidPause, pausedUntil.UTC().Format(time.RFC3339))
+	}
+
+	//make sure the user record exists
+	user, err := app.findUserByID(orgID, appID, userID, l)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, nil
+	}
+
+	//the notifications stay as they are unless they are given
+	settings := model.UserSettings{NotificationsEnabled: !user.NotificationsDisabled, PausedUntil: pausedUntil,
+		QuietHoursStart: start, QuietHoursEnd: end, TimeZone: timeZone}
+	if notificationsEnabled != nil {
+		settings.NotificationsEnabled = *notificationsEnabled
+	}
+	return app.storage.UpdateUserSettings(orgID, appID, userID, settings)
+}
+
+func (app *Application) muteTopic(orgID string, appID string, userID string, topic string, l *logs.Log) ([]string, error) {
+	//make sure the user record exists
+	_, err := app.findUserByID(orgID, appID, userID, l)
+	if err != nil {
+		return nil, err
+	}
+
+	topic = app.resolveTopicName(orgID, appID, topic)
+	user, err := app.storage.MuteTopic(orgID, appID, userID, topic)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.MutedTopics == nil {
+		return []string{}, nil
+	}
+	return user.MutedTopics, nil
+}
+
+func (app *Application) unmuteTopic(orgID string, appID string, userID string, topic string, l *logs.Log) ([]string, error) {
+	//make sure the user record exists
+	_, err := app.findUserByID(orgID, appID, userID, l)
+	if err != nil {
+		return nil, err
+	}
+
+	topic = app.resolveTopicName(orgID, appID, topic)
+	user, err := app.storage.UnmuteTopic(orgID, appID, userID, topic)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.MutedTopics == nil {
+		return []string{}, nil
+	}
+	return user.MutedTopics, nil
+}
+
 func (app *Application) deleteUserWithID(orgID string, appID string, userID string) error {
 	user, err := app.storage.FindUserByID(orgID, appID, userID)
 	if err != nil {
@@ -210,6 +817,9 @@ func (app *Application) deleteUserWithID(orgID string, appID string, userID stri
 			for _, topic := range user.Topics {
 				if user.DeviceTokens != nil && len(user.DeviceTokens) > 0 {
 					for _, token := range user.DeviceTokens {
+						if token.TokenType == model.TokenTypeAirship || token.TokenType == model.TokenTypeAPNs {
+							continue //not firebase tokens
+						}
 						err := app.firebase.UnsubscribeToTopic(orgID, appID, token.Token, topic)
 						if err != nil {
 							return fmt.Errorf("error unsubscribe user(%s) with token(%s) from topic(%s): %s", userID, token.Token, topic, err)
@@ -0,0 +1,667 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"errors"
+	"notifications/core/model"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+// fakeModerator blocks the messages which contain the blocked word and flags the ones which contain the flagged word
+type fakeModerator struct {
+	blocked string
+	flagged string
+}
+
+func (m fakeModerator) Moderate(subject string, body string) (*model.ModerationResult, error) {
+	content := subject + " " + body
+	if len(m.blocked) > 0 && strings.Contains(content, m.blocked) {
+		return &model.ModerationResult{Decision: model.ModerationDecisionBlock, Reason: "blocked word"}, nil
+	}
+	if len(m.flagged) > 0 && strings.Contains(content, m.flagged) {
+		return &model.ModerationResult{Decision: model.ModerationDecisionFlag, Reason: "flagged word"}, nil
+	}
+	return &model.ModerationResult{Decision: model.ModerationDecisionAllow}, nil
+}
+
+func testScheduledMessage(sendTime time.Time) model.Message {
+	return model.Message{OrgID: "org", AppID: "app", ID: "message", Time: sendTime, Subject: "subject", Body: "body",
+		Sender: model.Sender{Type: "user", User: &model.CoreAccountRef{UserID: "sender"}}, DeliverySummary: &model.DeliverySummary{}}
+}
+
+func recipientsUsersIDs(recipients []model.MessageRecipient) []string {
+	usersIDs := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		usersIDs[i] = recipient.UserID
+	}
+	sort.Strings(usersIDs)
+	return usersIDs
+}
+
+func TestUpdateMessagePending(t *testing.T) {
+	sendTime := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	newTime := sendTime.Add(time.Hour)
+	eventTime := sendTime.Add(2 * time.Hour)
+	offsetBefore := int64(30 * 60)
+	topic := "news"
+
+	explicit := testScheduledMessage(sendTime)
+	explicitRecipients := []model.MessageRecipient{{OrgID: "org", AppID: "app", MessageID: "message", UserID: "u1"},
+		{OrgID: "org", AppID: "app", MessageID: "message", UserID: "u2", Mute: true}}
+
+	byTopic := testScheduledMessage(sendTime)
+	byTopic.Topic = &topic
+	byTopic.Topics = []string{topic}
+
+	byEvent := testScheduledMessage(eventTime.Add(-time.Duration(offsetBefore) * time.Second))
+	byEvent.EventTime = &eventTime
+	byEvent.OffsetBefore = &offsetBefore
+
+	tests := []struct {
+		name           string
+		persisted      model.Message
+		update         model.Message
+		wantTime       time.Time
+		wantTopics     []string
+		wantRecipients []string
+		wantQueued     int
+	}{
+		{"new subject keeps the recipients and the time", explicit, model.Message{Subject: "new subject", Body: "body"},
+			sendTime, nil, []string{"u1", "u2"}, 1},
+		{"new recipients", explicit, model.Message{Subject: "subject", Body: "body", Recipients: []model.MessageRecipient{{UserID: "u3"}}},
+			sendTime, nil, []string{"u3"}, 1},
+		{"new time", explicit, model.Message{Subject: "subject", Body: "new body", Time: newTime},
+			newTime, nil, []string{"u1", "u2"}, 1},
+		{"topic kept", byTopic, model.Message{Subject: "new subject", Body: "body"},
+			sendTime, []string{topic}, []string{"t1", "t2"}, 2},
+		{"event time kept", byEvent, model.Message{Subject: "new subject", Body: "body"},
+			byEvent.Time, nil, []string{"u1", "u2"}, 1},
+		{"new event time", byEvent, model.Message{Subject: "subject", Body: "body", EventTime: &newTime, OffsetBefore: &offsetBefore},
+			newTime.Add(-time.Duration(offsetBefore) * time.Second), nil, []string{"u1", "u2"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage(tt.persisted)
+			storage.recipients = explicitRecipients
+			storage.topicUsers = []model.User{{UserID: "t1"}, {UserID: "t2"}}
+			app := newTestApplication(storage)
+
+			update := tt.update
+			update.OrgID, update.AppID, update.ID = "org", "app", "message"
+			userID := "sender"
+			message, err := app.updateMessage(&userID, &update)
+			if err != nil {
+				t.Fatalf("updateMessage() error = %v", err)
+			}
+
+			if message.ID != "message" || message.Subject != update.Subject || message.Body != update.Body {
+				t.Errorf("updateMessage() = %s %s %s, want the updated message", message.ID, message.Subject, message.Body)
+			}
+			if !message.Time.Equal(tt.wantTime) {
+				t.Errorf("updateMessage() time = %s, want %s", message.Time, tt.wantTime)
+			}
+			if !reflect.DeepEqual(message.Topics, tt.wantTopics) {
+				t.Errorf("updateMessage() topics = %v, want %v", message.Topics, tt.wantTopics)
+			}
+			if !reflect.DeepEqual(storage.deletedMessages, []string{"message"}) || len(storage.insertedMessages) != 1 {
+				t.Errorf("deleted %v and inserted %d messages, want the message replaced", storage.deletedMessages, len(storage.insertedMessages))
+			}
+			if got := recipientsUsersIDs(storage.insertedRecipients); !reflect.DeepEqual(got, tt.wantRecipients) {
+				t.Errorf("inserted recipients = %v, want %v", got, tt.wantRecipients)
+			}
+			if len(storage.insertedQueueItems) != tt.wantQueued {
+				t.Errorf("inserted queue items = %d, want %d", len(storage.insertedQueueItems), tt.wantQueued)
+			}
+		})
+	}
+}
+
+func TestUpdateMessageSent(t *testing.T) {
+	now := time.Now().UTC()
+	sendStarted := now.Add(-time.Minute)
+
+	sent := testScheduledMessage(now.Add(-time.Hour))
+	sent.DeliverySummary = &model.DeliverySummary{Sent: 2}
+	sending := testScheduledMessage(now.Add(time.Hour))
+	sending.DateSendStarted = &sendStarted
+
+	tests := []struct {
+		name        string
+		persisted   model.Message
+		update      model.Message
+		userID      string
+		wantErr     error
+		wantUpdated bool
+	}{
+		{"sent subject", sent, model.Message{Subject: "new subject", Body: "body", Time: sent.Time}, "sender", model.ErrMessageNotEditable, false},
+		{"sent recipients", sent, model.Message{Subject: "subject", Body: "body", Recipients: []model.MessageRecipient{{UserID: "u3"}}}, "sender", model.ErrMessageNotEditable, false},
+		{"sent time", sent, model.Message{Subject: "subject", Body: "body", Time: now.Add(time.Hour)}, "sender", model.ErrMessageNotEditable, false},
+		{"sending", sending, model.Message{Subject: "new subject", Body: "body"}, "sender", model.ErrMessageNotEditable, false},
+		{"sent priority", sent, model.Message{Subject: "subject", Body: "body", Priority: 10}, "sender", nil, true},
+		{"not the sender", sent, model.Message{Subject: "subject", Body: "body", Priority: 10}, "other", model.ErrUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage(tt.persisted)
+			app := newTestApplication(storage)
+
+			update := tt.update
+			update.OrgID, update.AppID, update.ID = "org", "app", "message"
+			_, err := app.updateMessage(&tt.userID, &update)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("updateMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(storage.deletedMessages) > 0 || len(storage.insertedMessages) > 0 {
+				t.Errorf("the message is replaced, want it kept")
+			}
+			if (len(storage.updatedMessages) > 0) != tt.wantUpdated {
+				t.Errorf("updated messages = %d, want updated %t", len(storage.updatedMessages), tt.wantUpdated)
+			}
+		})
+	}
+}
+
+func TestGetMessagesStatsByApp(t *testing.T) {
+	storage := newFakeStorage()
+	storage.recipients = []model.MessageRecipient{
+		{OrgID: "org", AppID: "app", MessageID: "m1", UserID: "u1", Read: true},
+		{OrgID: "org", AppID: "app", MessageID: "m2", UserID: "u1"},
+		{OrgID: "org", AppID: "other", MessageID: "m3", UserID: "u1"},
+		{OrgID: "other", AppID: "app", MessageID: "m4", UserID: "u1", Read: true},
+	}
+	app := newTestApplication(storage)
+
+	tests := []struct {
+		name      string
+		orgID     string
+		appID     string
+		wantTotal int64
+		wantRead  int64
+	}{
+		{"app", "org", "app", 2, 1},
+		{"other app", "org", "other", 1, 0},
+		{"other org", "other", "app", 1, 1},
+		{"no messages", "other", "other", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := app.getMessagesStats(tt.orgID, tt.appID, "u1")
+			if err != nil {
+				t.Fatalf("getMessagesStats() error = %v", err)
+			}
+			if *stats.TotalCount != tt.wantTotal || *stats.Read != tt.wantRead {
+				t.Errorf("getMessagesStats() = %d total %d read, want %d total %d read", *stats.TotalCount, *stats.Read, tt.wantTotal, tt.wantRead)
+			}
+		})
+	}
+}
+
+func TestDeleteMessageByApp(t *testing.T) {
+	tests := []struct {
+		name        string
+		orgID       string
+		appID       string
+		wantDeleted bool
+	}{
+		{"app", "org", "app", true},
+		{"other app", "org", "other", false},
+		{"other org", "other", "app", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage(testScheduledMessage(time.Now().UTC()))
+			app := newTestApplication(storage)
+
+			err := app.deleteMessage(tt.orgID, tt.appID, "message")
+			if err != nil {
+				t.Fatalf("deleteMessage() error = %v", err)
+			}
+			if _, kept := storage.messages["message"]; kept == tt.wantDeleted {
+				t.Errorf("deleteMessage() kept the message = %t, want %t", kept, !tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestCreateMessageAsyncRejected(t *testing.T) {
+	negativeBadge := -1
+	invalidImageURL := "ftp://images.example.com/image.png"
+	emptyCollapseKey := ""
+	tests := []struct {
+		name    string
+		message model.InputMessage
+		wantErr error
+	}{
+		{"blocked", model.InputMessage{Subject: "subject", Body: "spam"}, model.ErrMessageBlocked},
+		{"negative badge", model.InputMessage{Subject: "subject", Body: "body", Badge: &negativeBadge}, model.ErrInvalidBadge},
+		{"invalid image url", model.InputMessage{Subject: "subject", Body: "body", ImageURL: &invalidImageURL}, model.ErrInvalidImageURL},
+		{"empty collapse key", model.InputMessage{Subject: "subject", Body: "body", CollapseKey: &emptyCollapseKey}, model.ErrInvalidCollapseKey},
+		{"too large", model.InputMessage{Subject: "subject", Body: strings.Repeat("a", 5000)}, model.ErrPayloadTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			app := newTestApplication(storage)
+			app.moderator = fakeModerator{blocked: "spam"}
+
+			message := tt.message
+			message.OrgID, message.AppID = "org", "app"
+			_, err := app.createMessageAsync(context.Background(), message)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("createMessageAsync() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(storage.createdMessages) > 0 {
+				t.Errorf("createMessageAsync() stored the queued message, want it rejected")
+			}
+		})
+	}
+}
+
+func TestCalculateRecipientsMutedTopics(t *testing.T) {
+	storage := newFakeStorage()
+	storage.topicUsers = []model.User{
+		{UserID: "all muted", Topics: []string{"news", "sports"}, MutedTopics: []string{"news", "sports"}},
+		{UserID: "one muted", Topics: []string{"news", "sports"}, MutedTopics: []string{"news"}},
+		{UserID: "muted not followed", Topics: []string{"news"}, MutedTopics: []string{"news", "sports"}},
+		{UserID: "muted other", Topics: []string{"sports"}, MutedTopics: []string{"news"}},
+		{UserID: "none muted", Topics: []string{"news", "sports"}},
+	}
+	app := newTestApplication(storage)
+
+	recipients, err := app.sharedCalculateRecipients(nil, "org", "app", "subject", "body", nil, nil, nil, []string{"news", "sports"}, "message")
+	if err != nil {
+		t.Fatalf("sharedCalculateRecipients() error = %v", err)
+	}
+
+	want := map[string]bool{"all muted": true, "one muted": false, "muted not followed": true, "muted other": false, "none muted": false}
+	if len(recipients) != len(want) {
+		t.Fatalf("sharedCalculateRecipients() = %d recipients, want %d", len(recipients), len(want))
+	}
+	for _, recipient := range recipients {
+		if recipient.Mute != want[recipient.UserID] {
+			t.Errorf("recipient %s mute = %t, want %t", recipient.UserID, recipient.Mute, want[recipient.UserID])
+		}
+	}
+}
+
+func TestDeleteUserMessageNoUser(t *testing.T) {
+	app := newTestApplication(newFakeStorage()) //the fake storage panics if the message is deleted
+
+	err := app.deleteUserMessage("org", "app", "", "message")
+	if err == nil {
+		t.Errorf("deleteUserMessage() error = nil, want an error for an empty user id")
+	}
+}
+
+func TestGetUserMessageWithoutSender(t *testing.T) {
+	noSender := model.Message{OrgID: "org", AppID: "app", ID: "message", Subject: "subject", Body: "body"}
+	noSenderUser := noSender
+	noSenderUser.Sender = model.Sender{Type: "system"}
+	recipients := []model.MessageRecipient{{OrgID: "org", AppID: "app", MessageID: "message", UserID: "u1"}}
+
+	tests := []struct {
+		name    string
+		message model.Message
+		userID  string
+		wantErr error
+	}{
+		{"no sender recipient", noSender, "u1", nil},
+		{"no sender not a recipient", noSender, "u2", model.ErrNotFound},
+		{"no sender user recipient", noSenderUser, "u1", nil},
+		{"no sender user not a recipient", noSenderUser, "u2", model.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage(tt.message)
+			storage.recipients = recipients
+			app := newTestApplication(storage)
+
+			message, err := app.getUserMessage("org", "app", "message", tt.userID)
+			if tt.wantErr == nil && (err != nil || message == nil || message.ID != "message") {
+				t.Errorf("getUserMessage() = %v, %v, want the message", message, err)
+			}
+			if tt.wantErr != nil && (err == nil || !strings.Contains(err.Error(), tt.wantErr.Error())) {
+				t.Errorf("getUserMessage() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveTopicName(t *testing.T) {
+	storage := newFakeStorage()
+	storage.topics = []model.Topic{
+		{OrgID: "org", AppID: "app", ID: "t1", Name: "current", Aliases: []string{"first", "second"}},
+	}
+	app := newTestApplication(storage)
+
+	tests := []struct {
+		name  string
+		appID string
+		topic string
+		want  string
+	}{
+		{"first alias", "app", "first", "current"},
+		{"second alias", "app", "second", "current"},
+		{"current name", "app", "current", "current"},
+		{"unknown topic", "app", "unknown", "unknown"},
+		{"alias of another app", "other", "first", "first"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := app.resolveTopicName("org", tt.appID, tt.topic); got != tt.want {
+				t.Errorf("resolveTopicName(%s) = %s, want %s", tt.topic, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscribeToTopicAlias(t *testing.T) {
+	storage := newFakeStorage()
+	storage.topics = []model.Topic{{OrgID: "org", AppID: "app", ID: "t1", Name: "current", Aliases: []string{"old"}}}
+	firebase := &fakeFirebase{}
+	app := newTestApplication(storage)
+	app.firebase = firebase
+
+	err := app.subscribeToTopic("org", "app", "token", "", true, "old")
+	if err != nil {
+		t.Fatalf("subscribeToTopic() error = %v", err)
+	}
+	if !reflect.DeepEqual(firebase.subscribed, []string{"token:current"}) {
+		t.Errorf("subscribeToTopic() firebase subscribed %v, want [token:current]", firebase.subscribed)
+	}
+}
+
+func TestReportMessage(t *testing.T) {
+	type report struct {
+		userID      string
+		wantErr     bool
+		wantCount   int
+		wantFlagged bool
+	}
+	tests := []struct {
+		name      string
+		threshold int
+		reports   []report
+	}{
+		{"threshold reached", 2, []report{{"u1", false, 1, false}, {"u2", false, 2, true}, {"u3", false, 3, true}}},
+		{"reported again", 2, []report{{"u1", false, 1, false}, {"u1", false, 1, false}}},
+		{"not a recipient", 1, []report{{"u4", true, 0, false}}},
+		{"flagging disabled", 0, []report{{"u1", false, 1, false}, {"u2", false, 2, false}, {"u3", false, 3, false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage(model.Message{OrgID: "org", AppID: "app", ID: "message"})
+			for _, userID := range []string{"u1", "u2", "u3"} {
+				storage.recipients = append(storage.recipients, model.MessageRecipient{OrgID: "org", AppID: "app", MessageID: "message", UserID: userID})
+			}
+			app := newTestApplication(storage)
+			app.config = &model.Config{ReportsThreshold: tt.threshold}
+			l := app.logger.NewLog("test", logs.RequestContext{})
+
+			for i, r := range tt.reports {
+				err := app.reportMessage(l, "org", "app", "message", r.userID, "abusive")
+				message := storage.messages["message"]
+				if (err != nil) != r.wantErr || message.ReportsCount != r.wantCount || message.Flagged != r.wantFlagged {
+					t.Errorf("report %d: reportMessage() error = %v, reports %d flagged %t, want error %t, reports %d flagged %t",
+						i, err, message.ReportsCount, message.Flagged, r.wantErr, r.wantCount, r.wantFlagged)
+				}
+			}
+			if message := storage.messages["message"]; len(message.Reports) > 0 && message.Reports[0].Reason != "abusive" {
+				t.Errorf("reportMessage() reason = %s, want abusive", message.Reports[0].Reason)
+			}
+		})
+	}
+}
+
+func TestUserMutedTopicsRoundTrip(t *testing.T) {
+	storage := newFakeStorage()
+	app := newTestApplication(storage)
+	l := app.logger.NewLog("test", logs.RequestContext{})
+
+	steps := []struct {
+		name   string
+		update []string //nil to get the muted topics
+		want   []string
+	}{
+		{"new user", nil, []string{}},
+		{"set", []string{"news", "sports", "news", ""}, []string{"news", "sports"}},
+		{"get after set", nil, []string{"news", "sports"}},
+		{"replace", []string{"dining"}, []string{"dining"}},
+		{"get after replace", nil, []string{"dining"}},
+		{"clear", []string{}, []string{}},
+		{"get after clear", nil, []string{}},
+	}
+	for _, step := range steps {
+		var got []string
+		var err error
+		if step.update == nil {
+			got, err = app.getUserMutedTopics("org", "app", "u1", l)
+		} else {
+			got, err = app.updateUserMutedTopics("org", "app", "u1", step.update, l)
+		}
+		if err != nil || !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: muted topics = %v, %v, want %v", step.name, got, err, step.want)
+		}
+	}
+	if len(storage.users) != 1 {
+		t.Errorf("users count = %d, want 1", len(storage.users))
+	}
+}
+
+func TestUpdateTopicSubscriptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		anonymous    bool
+		subscribe    []string
+		unsubscribe  []string
+		failedTopics map[string]bool
+		wantTopics   []string
+		wantFailed   []string
+	}{
+		{"mixed batch", false, []string{"c", "d"}, []string{"a"}, nil, []string{"b", "c", "d"}, []string{}},
+		{"topic in both lists", false, []string{"c"}, []string{"c", "b"}, nil, []string{"a", "c"}, []string{}},
+		{"partial firebase failure", false, []string{"c", "d"}, []string{"a"}, map[string]bool{"c": true}, []string{"b", "c", "d"}, []string{"c"}},
+		{"anonymous", true, []string{"c"}, []string{"a"}, nil, []string{}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.users = []model.User{{OrgID: "org", AppID: "app", UserID: "u1", Topics: []string{"a", "b"}}}
+			firebase := &fakeFirebase{failedTopics: tt.failedTopics}
+			app := newTestApplication(storage)
+			app.firebase = firebase
+			l := app.logger.NewLog("test", logs.RequestContext{})
+
+			result, err := app.updateTopicSubscriptions(l, "org", "app", "token", "u1", tt.anonymous, tt.subscribe, tt.unsubscribe)
+			if err != nil {
+				t.Fatalf("updateTopicSubscriptions() error = %v", err)
+			}
+			sort.Strings(result.Topics)
+			if !reflect.DeepEqual(result.Topics, tt.wantTopics) || !reflect.DeepEqual(result.Failed, tt.wantFailed) {
+				t.Errorf("updateTopicSubscriptions() = topics %v failed %v, want topics %v failed %v", result.Topics, result.Failed, tt.wantTopics, tt.wantFailed)
+			}
+
+			//every other change reaches firebase
+			changes := len(firebase.subscribed) + len(firebase.unsubscribed)
+			if want := len(tt.subscribe) + len(tt.unsubscribe) - len(tt.failedTopics); tt.name != "topic in both lists" && changes != want {
+				t.Errorf("updateTopicSubscriptions() made %d firebase changes, want %d", changes, want)
+			}
+		})
+	}
+}
+
+func TestGetTopicReach(t *testing.T) {
+	storage := newFakeStorage()
+	storage.topics = []model.Topic{{OrgID: "org", AppID: "app", Name: "athletics", Aliases: []string{"sports"}}}
+	storage.topicUsers = []model.User{
+		{OrgID: "org", AppID: "app", UserID: "u1", Topics: []string{"athletics"}},
+		{OrgID: "org", AppID: "app", UserID: "u2", Topics: []string{"athletics", "news"}},
+		{OrgID: "org", AppID: "app", UserID: "u3", Topics: []string{"news"}},
+		{OrgID: "org", AppID: "other", UserID: "u4", Topics: []string{"athletics"}},
+	}
+	app := newTestApplication(storage)
+
+	reach, err := app.getTopicReach("org", "app", "sports")
+	if err != nil || reach.Topic != "athletics" || reach.Count != 2 {
+		t.Fatalf("getTopicReach() = %+v, %v, want athletics 2", reach, err)
+	}
+
+	//the count is cached
+	storage.topicUsers = append(storage.topicUsers, model.User{OrgID: "org", AppID: "app", UserID: "u5", Topics: []string{"athletics"}})
+	reach, err = app.getTopicReach("org", "app", "athletics")
+	if err != nil || reach.Count != 2 || storage.usersCounts != 1 {
+		t.Errorf("getTopicReach() = %+v, %v with %d storage counts, want the cached 2 with 1 storage count", reach, err, storage.usersCounts)
+	}
+
+	//the expired count is read again
+	app.topicsReach.Store("org_app_athletics", cachedTopicReach{count: 2, expires: time.Now().Add(-time.Second)})
+	reach, err = app.getTopicReach("org", "app", "athletics")
+	if err != nil || reach.Count != 3 || storage.usersCounts != 2 {
+		t.Errorf("getTopicReach() = %+v, %v with %d storage counts, want 3 with 2 storage counts", reach, err, storage.usersCounts)
+	}
+}
+
+func TestValidateTopicNameConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  model.Config
+		topic   string
+		wantErr bool
+	}{
+		{"default max length", model.Config{}, strings.Repeat("a", defaultTopicNameMaxLength), false},
+		{"longer than the default", model.Config{}, strings.Repeat("a", defaultTopicNameMaxLength+1), true},
+		{"configured max length", model.Config{TopicNameMaxLength: 8}, "athletics", true},
+		{"default reserved prefix", model.Config{TopicReservedPrefixes: []string{"internal-"}}, "all-users-campus", true},
+		{"configured reserved prefix", model.Config{TopicReservedPrefixes: []string{"internal-"}}, "internal-sync", true},
+		{"valid", model.Config{TopicReservedPrefixes: []string{"internal-"}}, "athletics", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(newFakeStorage())
+			app.config = &tt.config
+			err := app.validateTopicName(tt.topic)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateTopicName() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserSettingsRoundTrip(t *testing.T) {
+	storage := newFakeStorage()
+	app := newTestApplication(storage)
+	l := app.logger.NewLog("test", logs.RequestContext{})
+	enabled, disabled := true, false
+	pausedUntil := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	passed := time.Now().UTC().Add(-time.Hour)
+	start, end := 22*60, 7*60
+
+	steps := []struct {
+		name          string
+		update        bool
+		enabled       *bool
+		pausedUntil   *time.Time
+		start         *int
+		end           *int
+		wantEnabled   bool
+		wantPaused    *time.Time
+		wantErr       bool
+		wantNotifying bool //the pushes are sent now
+	}{
+		{"new user", false, nil, nil, nil, nil, true, nil, false, true},
+		{"disable", true, &disabled, nil, nil, nil, false, nil, false, false},
+		{"quiet hours only keep disabled", true, nil, nil, &start, &end, false, nil, false, false},
+		{"enable and pause", true, &enabled, &pausedUntil, nil, nil, true, &pausedUntil, false, false},
+		{"get while paused", false, nil, nil, nil, nil, true, &pausedUntil, false, false},
+		{"past pause", true, nil, &passed, nil, nil, true, &pausedUntil, true, false},
+		{"resume", true, nil, nil, nil, nil, true, nil, false, true},
+	}
+	for _, step := range steps {
+		if step.update {
+			_, err := app.updateUserSettings("org", "app", "u1", step.start, step.end, nil, step.enabled, step.pausedUntil, l)
+			if (err != nil) != step.wantErr {
+				t.Fatalf("%s: updateUserSettings() error = %v, want error %t", step.name, err, step.wantErr)
+			}
+		}
+		settings, err := app.getUserSettings("org", "app", "u1", l)
+		if err != nil {
+			t.Fatalf("%s: getUserSettings() error = %v", step.name, err)
+		}
+		if settings.NotificationsEnabled != step.wantEnabled || !reflect.DeepEqual(settings.PausedUntil, step.wantPaused) {
+			t.Errorf("%s: settings = %t %v, want %t %v", step.name, settings.NotificationsEnabled, settings.PausedUntil, step.wantEnabled, step.wantPaused)
+		}
+		if off := storage.users[0].NotificationsOff(time.Now()); off == step.wantNotifying {
+			t.Errorf("%s: NotificationsOff() = %t, want %t", step.name, off, !step.wantNotifying)
+		}
+	}
+}
+
+func TestGetUserMessagesSince(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	storage := newFakeStorage()
+	addMessage := func(id string, minutes int) {
+		storage.messages[id] = model.Message{OrgID: "org", AppID: "app", ID: id, Time: start.Add(time.Duration(minutes) * time.Minute)}
+		storage.recipients = append(storage.recipients, model.MessageRecipient{OrgID: "org", AppID: "app", ID: "r" + id, UserID: "u1", MessageID: id})
+	}
+	addMessage("m1", 0)
+	addMessage("m2", 1)
+	addMessage("m3", 2)
+	addMessage("m4", 2) //the same time as m3
+	addMessage("scheduled", 10)
+	storage.users = []model.User{{OrgID: "org", AppID: "app", UserID: "u1"}}
+	app := newTestApplication(storage)
+
+	pages := []struct {
+		name       string
+		sinceID    string
+		newMessage string //added before getting the page
+		want       []string
+		wantCursor string
+	}{
+		{"first page", "m1", "", []string{"m2", "m3"}, "m3"},
+		{"same time", "m3", "", []string{"m4", "scheduled"}, "scheduled"},
+		{"no new messages", "scheduled", "", []string{}, "scheduled"},
+		{"new message", "scheduled", "new", []string{"new"}, "new"},
+	}
+	for _, page := range pages {
+		if len(page.newMessage) > 0 {
+			addMessage(page.newMessage, 20)
+		}
+		messages, cursor, err := app.getUserMessagesSince(context.Background(), "org", "app", "u1", page.sinceID, 2, nil)
+		if err != nil {
+			t.Fatalf("%s: getUserMessagesSince() error = %v", page.name, err)
+		}
+		got := []string{}
+		for _, message := range messages {
+			got = append(got, message.MessageID)
+		}
+		if !reflect.DeepEqual(got, page.want) || cursor != page.wantCursor {
+			t.Errorf("%s: getUserMessagesSince() = %v, %s, want %v, %s", page.name, got, cursor, page.want, page.wantCursor)
+		}
+	}
+
+	_, _, err := app.getUserMessagesSince(context.Background(), "org", "app", "u1", "unknown", 2, nil)
+	if !errors.Is(err, model.ErrInvalidCursor) {
+		t.Errorf("getUserMessagesSince() with an unknown cursor error = %v, want ErrInvalidCursor", err)
+	}
+}
@@ -15,23 +15,38 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"notifications/core/model"
 	"notifications/driven/storage"
+	"sort"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rokwire/logging-library-go/v2/errors"
+	"github.com/rokwire/logging-library-go/v2/logs"
+	"github.com/rokwire/logging-library-go/v2/logutils"
 )
 
-func (app *Application) sharedCreateMessages(imMessages []model.InputMessage, isBatch bool) ([]model.Message, error) {
+func (app *Application) sharedCreateMessages(ctx context.Context, imMessages []model.InputMessage, isBatch bool) ([]model.Message, error) {
 
 	if len(imMessages) == 0 {
 		return nil, errors.New("no data")
 	}
 
-	var err error
+	//check the source app and the content before doing anything else
+	err := app.sharedValidateSourceApps(imMessages)
+	if err != nil {
+		return nil, err
+	}
+	err = app.sharedModerateMessages(imMessages)
+	if err != nil {
+		return nil, err
+	}
+
 	resultMessages := []model.Message{}
 	notifyQueue := false
 
@@ -41,6 +56,7 @@ func (app *Application) sharedCreateMessages(imMessages []model.InputMessage, is
 		allMessages := []model.Message{}
 		allRecipients := []model.MessageRecipient{}
 		allQueueItems := []model.QueueItem{}
+		queuedMessages := []model.Message{}
 
 		recipientsMap := map[string]bool{}
 
@@ -64,47 +80,140 @@ func (app *Application) sharedCreateMessages(imMessages []model.InputMessage, is
 				recipientCount := len(recipients)
 				message.CalculatedRecipientsCount = &recipientCount
 			}
-			queueItems := app.sharedCreateQueueItems(*message, recipients)
+			//the queue items of the messages which require approval are created on approving them
+			var queueItems []model.QueueItem
+			if !message.IsPendingApproval() {
+				queueItems, err = app.sharedCreateQueueItems(*message, recipients)
+				if err != nil {
+					fmt.Printf("error on creating queue items: %s", err)
+					return err
+				}
+			}
+			if im.IncludeFailedRecipients {
+				message.FailedRecipients, err = app.sharedGetFailedRecipients(im.OrgID, im.AppID, recipients)
+				if err != nil {
+					fmt.Printf("error on getting failed recipients: %s", err)
+					return err
+				}
+			}
+			if im.Queued {
+				queuedMessages = append(queuedMessages, *message)
+			}
 			allMessages = append(allMessages, *message)
 			allRecipients = append(allRecipients, recipients...)
 			allQueueItems = append(allQueueItems, queueItems...)
 		}
 
-		//store the messages object
-		err = app.storage.InsertMessagesWithContext(context, allMessages)
+		//the created messages replace the queued ones stored by the async creates
+		for _, queued := range queuedMessages {
+			err := app.storage.DeleteMessagesWithContext(context, queued.OrgID, queued.AppID, []string{queued.ID})
+			if err != nil {
+				return err
+			}
+		}
+
+		notifyQueue, err = app.sharedStoreMessages(context, allMessages, allRecipients, allQueueItems)
 		if err != nil {
-			fmt.Printf("error on creating a message: %s", err)
 			return err
 		}
 
-		//store recipients
-		err = app.storage.InsertMessagesRecipientsWithContext(context, allRecipients)
+		resultMessages = allMessages
+
+		return nil
+	}
+
+	//perform transactions
+	err = app.storage.PerformTransactionWithContext(ctx, transaction, 10000) //10 seconds timeout
+	if err != nil {
+		fmt.Printf("error performing create message transaction - %s", err)
+		return nil, err
+	}
+
+	//notify the queue that new items are added
+	if notifyQueue {
+		go app.queueLogic.onQueuePush()
+	}
+
+	return resultMessages, nil
+}
+
+// sharedCreateMessagesPartially creates the valid messages together and gives the error of every other message by its index
+func (app *Application) sharedCreateMessagesPartially(ctx context.Context, imMessages []model.InputMessage) ([]model.BulkMessageResult, error) {
+	results := make([]model.BulkMessageResult, len(imMessages))
+
+	//check the source app and the content of every message
+	valid := make([]bool, len(imMessages))
+	for i := range imMessages {
+		results[i].Index = i
+		err := app.sharedValidateSourceApps(imMessages[i : i+1])
+		if err == nil {
+			err = app.sharedModerateMessages(imMessages[i : i+1])
+		}
 		if err != nil {
-			fmt.Printf("error on inserting recipients: %s", err)
-			return err
+			errMessage := err.Error()
+			results[i].Error = &errMessage
+			continue
 		}
+		valid[i] = true
+	}
 
-		//store the notifications queue items in the queue
-		if len(allQueueItems) > 0 {
-			err = app.storage.InsertQueueDataItemsWithContext(context, allQueueItems)
-			if err != nil {
-				fmt.Printf("error on inserting queue data items: %s", err)
-				return err
+	notifyQueue := false
+
+	//in transaction
+	transaction := func(context storage.TransactionContext) error {
+		allMessages := []model.Message{}
+		allRecipients := []model.MessageRecipient{}
+		allQueueItems := []model.QueueItem{}
+		createdIndexes := []int{}
+
+		for i, im := range imMessages {
+			if !valid[i] {
+				continue
 			}
+			results[i].Error = nil //the transaction may be retried
 
-			//notify the queue that new items are added
-			notifyQueue = true
+			message, recipients, err := app.sharedHandleInputMessage(context, im)
+			if err != nil {
+				errMessage := err.Error()
+				results[i].Error = &errMessage
+				continue
+			}
+			var queueItems []model.QueueItem
+			if !message.IsPendingApproval() {
+				queueItems, err = app.sharedCreateQueueItems(*message, recipients)
+				if err != nil {
+					return err
+				}
+			}
+			if im.IncludeFailedRecipients {
+				message.FailedRecipients, err = app.sharedGetFailedRecipients(im.OrgID, im.AppID, recipients)
+				if err != nil {
+					return err
+				}
+			}
+			allMessages = append(allMessages, *message)
+			allRecipients = append(allRecipients, recipients...)
+			allQueueItems = append(allQueueItems, queueItems...)
+			createdIndexes = append(createdIndexes, i)
+		}
+		if len(allMessages) == 0 {
+			return nil
 		}
 
-		resultMessages = allMessages
+		var err error
+		notifyQueue, err = app.sharedStoreMessages(context, allMessages, allRecipients, allQueueItems)
+		if err != nil {
+			return err
+		}
 
+		for j, i := range createdIndexes {
+			results[i].Message = &allMessages[j]
+		}
 		return nil
 	}
 
-	//perform transactions
-	err = app.storage.PerformTransaction(transaction, 10000) //10 seconds timeout
+	err := app.storage.PerformTransactionWithContext(ctx, transaction, 10000) //10 seconds timeout
 	if err != nil {
-		fmt.Printf("error performing create message transaction - %s", err)
 		return nil, err
 	}
 
@@ -113,10 +222,145 @@ func (app *Application) sharedCreateMessages(imMessages []model.InputMessage, is
 		go app.queueLogic.onQueuePush()
 	}
 
-	return resultMessages, nil
+	return results, nil
+}
+
+// sharedStoreMessages inserts the messages with their recipients and queue items. It gives true if queue items are inserted.
+func (app *Application) sharedStoreMessages(context storage.TransactionContext, messages []model.Message,
+	recipients []model.MessageRecipient, queueItems []model.QueueItem) (bool, error) {
+	//store the messages object
+	err := app.storage.InsertMessagesWithContext(context, messages)
+	if err != nil {
+		fmt.Printf("error on creating a message: %s", err)
+		return false, err
+	}
+
+	//store recipients
+	err = app.storage.InsertMessagesRecipientsWithContext(context, recipients)
+	if err != nil {
+		fmt.Printf("error on inserting recipients: %s", err)
+		return false, err
+	}
+
+	//store the notifications queue items in the queue
+	if len(queueItems) == 0 {
+		return false, nil
+	}
+	err = app.storage.InsertQueueDataItemsWithContext(context, queueItems)
+	if err != nil {
+		fmt.Printf("error on inserting queue data items: %s", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// sharedValidateSourceApps checks the messages source apps against the known apps if they are configured
+func (app *Application) sharedValidateSourceApps(imMessages []model.InputMessage) error {
+	if len(app.config.SourceApps) == 0 {
+		return nil //any source app is allowed
+	}
+
+	for _, im := range imMessages {
+		if len(im.SourceApp) == 0 {
+			continue
+		}
+		known := false
+		for _, sourceApp := range app.config.SourceApps {
+			if sourceApp == im.SourceApp {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("%w: %s", model.ErrInvalidSourceApp, im.SourceApp)
+		}
+	}
+	return nil
+}
+
+// sharedModerateMessages sets the moderation result of the messages. It fails if any of the messages is blocked.
+func (app *Application) sharedModerateMessages(imMessages []model.InputMessage) error {
+	if app.moderator == nil {
+		return nil //the moderation is disabled
+	}
+
+	for i, im := range imMessages {
+		if im.Moderation != nil {
+			continue //moderated on accepting the async create
+		}
+		result, err := app.moderator.Moderate(im.Subject, im.Body)
+		if err != nil {
+			return errors.WrapErrorAction("moderating", "message", nil, err)
+		}
+		if result.Decision == model.ModerationDecisionBlock {
+			return fmt.Errorf("%w: %s", model.ErrMessageBlocked, result.Reason)
+		}
+		imMessages[i].Moderation = result
+	}
+	return nil
+}
+
+// sharedValidateInputMessage checks the settings of the message which do not depend on the stored data
+func (app *Application) sharedValidateInputMessage(im model.InputMessage) error {
+	if im.ActiveWithinMinutes != nil && *im.ActiveWithinMinutes <= 0 {
+		return errors.WrapErrorData(logutils.StatusInvalid, "active within minutes", &logutils.FieldArgs{"active_within_minutes": *im.ActiveWithinMinutes}, model.ErrValidation)
+	}
+
+	for _, channel := range im.DeliveryChannels {
+		if channel != model.DeliveryChannelPush && channel != model.DeliveryChannelEmail && channel != model.DeliveryChannelSMS {
+			return errors.WrapErrorData(logutils.StatusInvalid, "delivery channel", &logutils.FieldArgs{"delivery_channels": channel}, model.ErrValidation)
+		}
+	}
+
+	if im.CallbackURL != nil {
+		err := model.ValidateCallbackURL(*im.CallbackURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	if im.CollapseKey != nil && (len(*im.CollapseKey) == 0 || len(*im.CollapseKey) > model.MaxCollapseKeyLength) {
+		return fmt.Errorf("%w: the length must be between 1 and %d bytes", model.ErrInvalidCollapseKey, model.MaxCollapseKeyLength)
+	}
+
+	if im.Badge != nil && *im.Badge < 0 {
+		return fmt.Errorf("%w: %d is negative", model.ErrInvalidBadge, *im.Badge)
+	}
+
+	if im.ImageURL != nil {
+		err := model.ValidateImageURL(*im.ImageURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	if im.EventTime != nil && im.OffsetBefore < 0 {
+		return errors.WrapErrorData(logutils.StatusInvalid, "offset before", &logutils.FieldArgs{"offset_before": int64(im.OffsetBefore.Seconds())}, model.ErrValidation)
+	}
+	return nil
 }
 
 func (app *Application) sharedHandleInputMessage(context storage.TransactionContext, im model.InputMessage) (*model.Message, []model.MessageRecipient, error) {
+	err := app.sharedValidateInputMessage(im)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//the single topic is kept for the older clients, the recipients are calculated from the topics
+	im.Topics = model.MergeTopics(im.Topic, im.Topics)
+
+	//the messages relative to an event are sent the offset before it
+	var offsetBefore *int64
+	if im.EventTime != nil {
+		eventMessageTime, send := model.MessageTimeBeforeEvent(*im.EventTime, im.OffsetBefore, im.SkipIfPast, time.Now())
+		if !send {
+			return nil, nil, fmt.Errorf("%w: %s", model.ErrMessageTimePassed, eventMessageTime.Format(time.RFC3339))
+		}
+		im.Time = eventMessageTime
+		offsetSeconds := int64(im.OffsetBefore.Seconds())
+		offsetBefore = &offsetSeconds
+	}
+
 	//use from input if available
 	messageID := im.ID
 	if messageID == nil {
@@ -133,25 +377,228 @@ func (app *Application) sharedHandleInputMessage(context storage.TransactionCont
 		return nil, nil, err
 	}
 
+	//every user gets the message once even if it is listed or matched more than once
+	recipients = sharedDeduplicateRecipients(recipients)
+
+	//remove the excluded users
+	recipients, excludedRecipients := sharedExcludeRecipients(recipients, im.ExcludeRecipients)
+
 	//create message object
-	if im.Data == nil { //we add message id to the data
-		im.Data = map[string]string{}
+	im.Data = app.sharedMessageData(im.Data, *messageID)
+
+	//check the size with the default data fields, the rendered bodies are checked on sending
+	err = model.ValidatePushPayloadSize(im.Subject, im.Body, im.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//localize the subject and the body by the recipients locales
+	var usersLocales map[string]*string
+	if len(im.LocalizedSubjects) > 0 || len(im.LocalizedBodies) > 0 {
+		usersLocales, err = app.sharedFindUsersLocales(im.OrgID, im.AppID, recipients)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	//render the body for every recipient if it contains placeholders
+	for i, recipient := range recipients {
+		locale := usersLocales[recipient.UserID]
+		if subject := model.LocalizedValue(im.LocalizedSubjects, locale, im.Subject); subject != im.Subject {
+			recipients[i].RenderedSubject = &subject
+		}
+
+		body := model.LocalizedValue(im.LocalizedBodies, locale, im.Body)
+		if sharedHasPlaceholders(body) {
+			renderedBody, err := sharedRenderBody(body, im.Data, recipient)
+			if err != nil {
+				return nil, nil, err
+			}
+			recipients[i].RenderedBody = &renderedBody
+		} else if body != im.Body {
+			recipients[i].RenderedBody = &body
+		}
 	}
-	im.Data["message_id"] = *messageID
+
 	calculatedRecipients := len(recipients)
 	dateCreated := time.Now()
-	message := model.Message{OrgID: im.OrgID, AppID: im.AppID, ID: *messageID, Priority: im.Priority, Time: im.Time,
-		Subject: im.Subject, Sender: im.Sender, Body: im.Body, Data: im.Data, RecipientsCriteriaList: im.RecipientsCriteriaList,
-		RecipientAccountCriteria: im.RecipientAccountCriteria, Topic: im.Topic, Topics: im.Topics,
-		CalculatedRecipientsCount: &calculatedRecipients, DateCreated: &dateCreated}
+	messageTime := app.sharedApplyDeliveryWindows(im.OrgID, im.AppID, im.Topics, im.Time)
+
+	//the expiration is checked against the final message time - after the event offset and the delivery windows
+	if im.ExpiresAt != nil && !messageTime.Before(*im.ExpiresAt) {
+		return nil, nil, fmt.Errorf("%w: %s is not after %s", model.ErrInvalidExpiration,
+			im.ExpiresAt.Format(time.RFC3339), messageTime.Format(time.RFC3339))
+	}
+	message := model.Message{OrgID: im.OrgID, AppID: im.AppID, ID: *messageID, Priority: im.Priority, Time: messageTime,
+		Subject: im.Subject, Sender: im.Sender, Body: im.Body, Data: im.Data, LocalizedSubjects: im.LocalizedSubjects, LocalizedBodies: im.LocalizedBodies, RecipientsCriteriaList: im.RecipientsCriteriaList,
+		RecipientAccountCriteria: im.RecipientAccountCriteria, Topic: im.Topic, Topics: im.Topics, Attachments: im.Attachments, SourceApp: im.SourceApp,
+		ExcludedRecipients: excludedRecipients, ActiveWithinMinutes: im.ActiveWithinMinutes, DeferInactive: im.DeferInactive,
+		EventTime: im.EventTime, OffsetBefore: offsetBefore, DeliveryChannels: im.DeliveryChannels,
+		Sound: app.sharedResolveSound(im.OrgID, im.AppID, im.Topics, im.Sound), Badge: im.Badge, Silent: im.Silent, PerDevice: im.PerDevice, CallbackURL: im.CallbackURL, GroupID: im.GroupID, CollapseKey: im.CollapseKey, ImageURL: im.ImageURL, AndroidChannelID: im.AndroidChannelID, ExpiresAt: im.ExpiresAt, IdempotencyKey: im.IdempotencyKey, CalculatedRecipientsCount: &calculatedRecipients, DeliverySummary: &model.DeliverySummary{},
+		DateCreated: &dateCreated}
+
+	//the flagged messages are sent but an admin should review them
+	if im.Moderation != nil && im.Moderation.Decision == model.ModerationDecisionFlag {
+		reason := im.Moderation.Reason
+		message.Flagged = true
+		message.ModerationReason = &reason
+	}
+
+	//the messages which require approval are stored but not sent, their recipients do not see them until then
+	if im.RequiresApproval {
+		approvalStatus := model.ApprovalStatusPending
+		message.ApprovalStatus = &approvalStatus
+		for i := range recipients {
+			recipients[i].PendingApproval = true
+		}
+	}
 
 	return &message, recipients, nil
 }
 
-func (app *Application) sharedCreateQueueItems(message model.Message, messageRecipients []model.MessageRecipient) []model.QueueItem {
+// sharedGetFailedRecipients gives the recipients to which the push cannot be sent. The reasons do not expose the users tokens.
+func (app *Application) sharedGetFailedRecipients(orgID string, appID string, recipients []model.MessageRecipient) ([]model.FailedRecipient, error) {
+	failedRecipients := []model.FailedRecipient{}
+	if len(recipients) == 0 {
+		return failedRecipients, nil
+	}
+
+	usersIDs := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		usersIDs[i] = recipient.UserID
+	}
+	users, err := app.storage.FindUsersByIDs(orgID, appID, usersIDs)
+	if err != nil {
+		return nil, err
+	}
+	usersMap := make(map[string]model.User, len(users))
+	for _, user := range users {
+		usersMap[user.UserID] = user
+	}
+
+	for _, recipient := range recipients {
+		if recipient.Mute {
+			continue //no push is expected
+		}
+
+		user, ok := usersMap[recipient.UserID]
+		if !ok || len(user.DeviceTokens) == 0 {
+			failedRecipients = append(failedRecipients, model.FailedRecipient{UserID: recipient.UserID, Reason: model.FailureReasonNoDeviceTokens})
+		} else if user.NotificationsOff(time.Now()) || len(user.GetNotificationsTokens()) == 0 {
+			failedRecipients = append(failedRecipients, model.FailedRecipient{UserID: recipient.UserID, Reason: model.FailureReasonNotificationsDisabled})
+		}
+	}
+	return failedRecipients, nil
+}
+
+// sharedDeduplicateRecipients keeps the first recipient of every user. The push is muted only if all the duplicates are muted.
+func sharedDeduplicateRecipients(recipients []model.MessageRecipient) []model.MessageRecipient {
+	if len(recipients) <= 1 {
+		return recipients
+	}
+
+	result := []model.MessageRecipient{}
+	usersIndexes := make(map[string]int, len(recipients))
+	for _, recipient := range recipients {
+		if index, ok := usersIndexes[recipient.UserID]; ok {
+			result[index].Mute = result[index].Mute && recipient.Mute
+			continue
+		}
+		usersIndexes[recipient.UserID] = len(result)
+		result = append(result, recipient)
+	}
+	return result
+}
+
+// sharedExcludeRecipients removes the excluded users from the recipients. It gives the ids of the users which were removed.
+func sharedExcludeRecipients(recipients []model.MessageRecipient, exclude []model.MessageRecipient) ([]model.MessageRecipient, []string) {
+	if len(exclude) == 0 || len(recipients) == 0 {
+		return recipients, nil
+	}
+
+	excludedUsers := make(map[string]bool, len(exclude))
+	for _, item := range exclude {
+		excludedUsers[item.UserID] = true
+	}
+
+	result := []model.MessageRecipient{}
+	var excluded []string
+	for _, recipient := range recipients {
+		if excludedUsers[recipient.UserID] {
+			excluded = append(excluded, recipient.UserID)
+			continue
+		}
+		result = append(result, recipient)
+	}
+	return result, excluded
+}
+
+// sharedApplyDeliveryWindows defers the message time to the next delivery window of its topics
+func (app *Application) sharedApplyDeliveryWindows(orgID string, appID string, topics []string, messageTime time.Time) time.Time {
+	result := messageTime
+	for _, topicName := range topics {
+		topic, err := app.storage.GetTopicByName(orgID, appID, topicName)
+		if err != nil || topic == nil {
+			continue
+		}
+		topicTime := topic.NextDeliveryTime(messageTime)
+		if topicTime.After(result) {
+			result = topicTime
+		}
+	}
+	return result
+}
+
+// sharedResolveSound gives the message sound if set, otherwise the default sound of the first of its topics which has one
+func (app *Application) sharedResolveSound(orgID string, appID string, topics []string, sound *string) *string {
+	if sound != nil {
+		return sound
+	}
+	for _, topicName := range topics {
+		topic, err := app.storage.GetTopicByName(orgID, appID, topicName)
+		if err != nil || topic == nil {
+			continue
+		}
+		if topic.DefaultSound != nil && len(*topic.DefaultSound) > 0 {
+			return topic.DefaultSound
+		}
+	}
+	return nil
+}
+
+// quietHoursBypassPriority is the priority from which the messages are sent within the users quiet hours
+const quietHoursBypassPriority = 1000
+
+func (app *Application) sharedCreateQueueItems(message model.Message, messageRecipients []model.MessageRecipient) ([]model.QueueItem, error) {
 	queueItems := []model.QueueItem{}
+	if len(messageRecipients) == 0 {
+		return queueItems, nil
+	}
+
+	//get the users last active time so that the queue processes the recently active users first
+	usersIDs := make([]string, len(messageRecipients))
+	for i, messageRecipient := range messageRecipients {
+		usersIDs[i] = messageRecipient.UserID
+	}
+	users, err := app.storage.FindUsersByIDs(message.OrgID, message.AppID, usersIDs)
+	if err != nil {
+		return nil, err
+	}
+	usersLastActive := make(map[string]*time.Time, len(users))
+	usersQuietUntil := map[string]*time.Time{}
+	for _, user := range users {
+		usersLastActive[user.UserID] = user.DateLastActive
+		//the high priority messages are sent within the quiet hours too
+		if message.Priority < quietHoursBypassPriority {
+			usersQuietUntil[user.UserID] = user.QuietUntil(message.Time)
+		}
+	}
 
 	for _, messageRecipient := range messageRecipients {
+		if messageRecipient.Mute {
+			continue //the message is listed for the recipient but no push is sent
+		}
+
 		orgID := messageRecipient.OrgID
 		appID := messageRecipient.AppID
 		id := uuid.NewString()
@@ -162,20 +609,46 @@ func (app *Application) sharedCreateQueueItems(message model.Message, messageRec
 		userID := messageRecipient.UserID
 
 		subject := message.Subject
+		if messageRecipient.RenderedSubject != nil {
+			subject = *messageRecipient.RenderedSubject
+		}
 		body := message.Body
+		if messageRecipient.RenderedBody != nil {
+			body = *messageRecipient.RenderedBody
+		}
 		data := message.Data
+		sound := ""
+		if message.Sound != nil {
+			sound = *message.Sound
+		}
+		if message.Silent {
+			//the push without subject and body is sent as data only
+			subject, body, sound = "", "", ""
+		}
 
 		time := message.Time
+		if quietUntil := usersQuietUntil[userID]; quietUntil != nil {
+			time = *quietUntil //deferred until the user quiet hours end
+		}
 		priority := message.Priority
 
 		queueItem := model.QueueItem{OrgID: orgID, AppID: appID, ID: id,
 			MessageID: messageID, MessageRecipientID: messageRecipientID, UserID: userID,
-			Subject: subject, Body: body, Data: data, Time: time, Priority: priority}
+			Subject: subject, Body: body, Data: data, Sound: sound, Badge: message.Badge, Time: time, Priority: priority,
+			UserLastActive: usersLastActive[userID], ActiveWithinMinutes: message.ActiveWithinMinutes,
+			FallbackChannels: message.GetFallbackChannels(), ExpiresAt: message.ExpiresAt, PerDevice: message.PerDevice, CollapseKey: message.GetCollapseKey(), ImageURL: message.GetImageURL(),
+			AndroidChannelID: message.GetAndroidChannelID()}
+
+		//the push for an inactive user is deferred until the deadline
+		if message.ActiveWithinMinutes != nil && message.DeferInactive {
+			activeDeadline := time.Add(activeSessionMaxDefer)
+			queueItem.ActiveDeadline = &activeDeadline
+		}
 
 		queueItems = append(queueItems, queueItem)
 	}
 
-	return queueItems
+	return queueItems, nil
 }
 
 func (app *Application) sharedCalculateRecipients(context storage.TransactionContext,
@@ -217,9 +690,10 @@ func (app *Application) sharedCalculateRecipients(context storage.TransactionCon
 
 		topicRecipients := make([]model.MessageRecipient, len(topicUsers))
 		for i, item := range topicUsers {
+			//users who have muted every topic of the message they follow receive it without a push notification
 			topicRecipients[i] = model.MessageRecipient{
 				OrgID: orgID, AppID: appID, ID: uuid.NewString(), UserID: item.UserID,
-				MessageID: messageID, DateCreated: &now,
+				MessageID: messageID, Mute: item.HasMutedAllTopics(topics), DateCreated: &now,
 			}
 		}
 
@@ -290,6 +764,68 @@ func (app *Application) sharedCalculateRecipients(context storage.TransactionCon
 	return messageRecipients, nil
 }
 
+// sharedMessageData gives the message data with the defaults and the message id which are sent with the push
+func (app *Application) sharedMessageData(data map[string]string, messageID string) map[string]string {
+	if data == nil { //we add message id to the data
+		data = map[string]string{}
+	}
+	for key, value := range app.config.DefaultMessageData { //the message values have priority over the defaults
+		if _, ok := data[key]; !ok {
+			data[key] = value
+		}
+	}
+	data["message_id"] = messageID
+	return data
+}
+
+// sharedFindUsersLocales gives the locales of the recipients users, the users without a locale are not in the result
+func (app *Application) sharedFindUsersLocales(orgID string, appID string, recipients []model.MessageRecipient) (map[string]*string, error) {
+	usersIDs := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		usersIDs[i] = recipient.UserID
+	}
+	users, err := app.storage.FindUsersByIDs(orgID, appID, usersIDs)
+	if err != nil {
+		return nil, err
+	}
+	usersLocales := make(map[string]*string, len(users))
+	for _, user := range users {
+		if user.Locale != nil {
+			usersLocales[user.UserID] = user.Locale
+		}
+	}
+	return usersLocales, nil
+}
+
+func sharedHasPlaceholders(body string) bool {
+	return strings.Contains(body, "{{")
+}
+
+// sharedRenderBody renders the body placeholders (i.e. {{.name}}) with the recipient attributes.
+// The message data is used for the values which the recipient does not have.
+func sharedRenderBody(body string, messageData map[string]string, recipient model.MessageRecipient) (string, error) {
+	values := make(map[string]string, len(messageData)+len(recipient.Data)+1)
+	for key, value := range messageData {
+		values[key] = value
+	}
+	for key, value := range recipient.Data {
+		values[key] = value
+	}
+	values["user_id"] = recipient.UserID
+
+	//fail on unresolved placeholders instead of sending "<no value>" to the user
+	bodyTemplate, err := template.New("body").Option("missingkey=error").Parse(body)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", model.ErrInvalidBodyTemplate, err)
+	}
+	var rendered strings.Builder
+	err = bodyTemplate.Execute(&rendered, values)
+	if err != nil {
+		return "", fmt.Errorf("%w: user %s - %s", model.ErrInvalidBodyTemplate, recipient.UserID, err)
+	}
+	return rendered.String(), nil
+}
+
 func sharedGetCommonRecipients(messageRecipients, topicRecipients []model.MessageRecipient) []model.MessageRecipient {
 	//
 	// Recipients who don't belong to a topic will still receive a muted message (just skipping the push notification)
@@ -302,7 +838,7 @@ func sharedGetCommonRecipients(messageRecipients, topicRecipients []model.Messag
 	}
 
 	for _, recipient := range messageRecipients {
-		if _, ok := topicReciepientsMap[recipient.UserID]; !ok {
+		if topicRecipient, ok := topicReciepientsMap[recipient.UserID]; !ok || topicRecipient.Mute {
 			recipient.Mute = true
 		}
 		common = append(common, recipient)
@@ -315,27 +851,131 @@ func (app *Application) sharedSendMail(toEmail string, subject string, body stri
 	return app.mailer.SendMail(toEmail, subject, body)
 }
 
-func (app *Application) sharedCreateRecipientsQueueItems(message *model.Message, messageRecipients []model.MessageRecipient) []model.QueueItem {
-	queueItems := []model.QueueItem{}
+// sharedUnsubscribeFromAllTopics removes all the topics of the user and unsubscribes the user firebase tokens from them.
+// It gives the removed topics or nil if the user does not exist.
+func (app *Application) sharedUnsubscribeFromAllTopics(l *logs.Log, orgID string, appID string, userID string) ([]string, error) {
+	user, err := app.storage.FindUserByID(orgID, appID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find user(%s): %s", userID, err)
+	}
+	if user == nil {
+		return nil, nil
+	}
+	if len(user.Topics) == 0 {
+		return []string{}, nil
+	}
 
-	for _, messageRecipient := range messageRecipients {
-		orgID := messageRecipient.OrgID
-		appID := messageRecipient.AppID
-		id := messageRecipient.ID
-		userID := messageRecipient.UserID
-		messageID := messageRecipient.MessageID
-		subject := message.Subject
-		body := message.Body
-		data := message.Data
-		time := message.Time
-		priority := message.Priority
+	topics := user.Topics
+	err = app.storage.RemoveUserTopics(orgID, appID, userID, topics)
+	if err != nil {
+		return nil, fmt.Errorf("unable to remove the topics of user(%s): %s", userID, err)
+	}
+	for _, topic := range topics {
+		app.sharedNotifySubscription(orgID, appID, userID, topic, model.SubscriptionActionUnsubscribe)
+	}
 
-		queueItem := model.QueueItem{OrgID: orgID, AppID: appID, ID: id,
-			MessageID: messageID, MessageRecipientID: id, UserID: userID, Subject: subject, Body: body,
-			Data: data, Time: time, Priority: priority}
+	//the stored topics are already removed, so a failed firebase call is only logged
+	tasks := []func() error{}
+	for _, topic := range topics {
+		for _, token := range user.DeviceTokens {
+			if token.TokenType == model.TokenTypeAirship || token.TokenType == model.TokenTypeAPNs {
+				continue //not firebase tokens
+			}
+			topic := topic
+			token := token.Token
+			tasks = append(tasks, func() error {
+				err := app.firebase.UnsubscribeToTopic(orgID, appID, token, topic)
+				if err != nil {
+					return fmt.Errorf("error unsubscribing user %s token %s from topic %s - %w", userID, model.MaskDeviceToken(token), topic, err)
+				}
+				return nil
+			})
+		}
+	}
+	app.sharedRunResync(l, "user "+userID+" unsubscribe from all topics", tasks)
 
-		queueItems = append(queueItems, queueItem)
+	l.Infof("unsubscribed user %s from %d topics for %s/%s", userID, len(topics), orgID, appID)
+	return topics, nil
+}
+
+// sharedGetTopics gives a page of the topics and the count of all topics. The topics are sorted by the name unless sortBy is given.
+// The subscribers counts are computed, so sorting by them loads all topics and pages them in memory.
+func (app *Application) sharedGetTopics(orgID string, appID string, offset *int64, limit *int64, sortBy *string, order *string, withCounts bool) ([]model.Topic, int64, error) {
+	sortField := model.TopicsSortByName
+	if sortBy != nil {
+		sortField = *sortBy
+	}
+	sortOrder := "asc"
+	if order != nil {
+		sortOrder = *order
+	}
+
+	if sortField != model.TopicsSortBySubscribers {
+		topics, total, err := app.storage.GetTopicsPaged(orgID, appID, offset, limit, sortField, sortOrder)
+		if err != nil {
+			return nil, 0, err
+		}
+		if withCounts && len(topics) > 0 {
+			counts, err := app.storage.CountTopicsSubscribers(orgID, appID)
+			if err != nil {
+				return nil, 0, err
+			}
+			for i := range topics {
+				topics[i].SubscribersCount = counts[topics[i].Name]
+			}
+		}
+		return topics, total, nil
 	}
 
-	return queueItems
+	topics, err := app.storage.GetTopics(orgID, appID)
+	if err != nil {
+		return nil, 0, err
+	}
+	counts, err := app.storage.CountTopicsSubscribers(orgID, appID)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range topics {
+		topics[i].SubscribersCount = counts[topics[i].Name]
+	}
+	sort.SliceStable(topics, func(i, j int) bool {
+		if topics[i].SubscribersCount == topics[j].SubscribersCount {
+			return topics[i].Name < topics[j].Name
+		}
+		if sortOrder == "desc" {
+			return topics[i].SubscribersCount > topics[j].SubscribersCount
+		}
+		return topics[i].SubscribersCount < topics[j].SubscribersCount
+	})
+
+	total := int64(len(topics))
+	start := int64(0)
+	if offset != nil && *offset > 0 {
+		start = *offset
+	}
+	if start > total {
+		start = total
+	}
+	end := total
+	if limit != nil && *limit >= 0 && start+*limit < total {
+		end = start + *limit
+	}
+	page := topics[start:end]
+
+	if !withCounts {
+		for i := range page {
+			page[i].SubscribersCount = 0
+		}
+	}
+	return page, total, nil
+}
+
+// sharedNotifySubscription posts the topic subscription change of the user in the background if the subscription webhook is configured
+func (app *Application) sharedNotifySubscription(orgID string, appID string, userID string, topic string, action string) {
+	url := app.config.SubscriptionWebhookURL
+	if len(url) == 0 {
+		return
+	}
+	event := model.SubscriptionEvent{OrgID: orgID, AppID: appID, UserID: userID, Topic: topic, Action: action, Date: time.Now().UTC()}
+	go app.queueLogic.sendSubscriptionEvent(url, event) //new thread
 }
@@ -0,0 +1,521 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"errors"
+	"notifications/core/model"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+func TestSharedMessageData(t *testing.T) {
+	defaults := map[string]string{"source": "notifications", "env": "prod"}
+
+	tests := []struct {
+		name     string
+		defaults map[string]string
+		data     map[string]string
+		want     map[string]string
+	}{
+		{"defaults injected", defaults, map[string]string{"key": "value"},
+			map[string]string{"key": "value", "source": "notifications", "env": "prod", "message_id": "message"}},
+		{"defaults overridden", defaults, map[string]string{"env": "test"},
+			map[string]string{"source": "notifications", "env": "test", "message_id": "message"}},
+		{"no data", defaults, nil, map[string]string{"source": "notifications", "env": "prod", "message_id": "message"}},
+		{"no defaults", nil, map[string]string{"key": "value"}, map[string]string{"key": "value", "message_id": "message"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(newFakeStorage())
+			app.config = &model.Config{DefaultMessageData: tt.defaults}
+			if got := app.sharedMessageData(tt.data, "message"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sharedMessageData() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSharedRenderBody(t *testing.T) {
+	messageData := map[string]string{"name": "student", "campus": "Urbana"}
+	alice := model.MessageRecipient{UserID: "u1", Data: map[string]string{"name": "Alice", "balance": "$10"}}
+	bob := model.MessageRecipient{UserID: "u2", Data: map[string]string{"name": "Bob", "balance": "$0"}}
+
+	tests := []struct {
+		name      string
+		body      string
+		recipient model.MessageRecipient
+		want      string
+		wantErr   bool
+	}{
+		{"first recipient", "Hi {{.name}}, your balance is {{.balance}}", alice, "Hi Alice, your balance is $10", false},
+		{"second recipient", "Hi {{.name}}, your balance is {{.balance}}", bob, "Hi Bob, your balance is $0", false},
+		{"message data", "Hi {{.name}} from {{.campus}}", alice, "Hi Alice from Urbana", false},
+		{"no recipient data", "Hi {{.name}}", model.MessageRecipient{UserID: "u3"}, "Hi student", false},
+		{"user id", "Your id is {{.user_id}}", bob, "Your id is u2", false},
+		{"unresolved placeholder", "Hi {{.nickname}}", alice, "", true},
+		{"invalid template", "Hi {{.name", alice, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sharedRenderBody(tt.body, messageData, tt.recipient)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("sharedRenderBody() = %q, %v, want %q, error %t", got, err, tt.want, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, model.ErrInvalidBodyTemplate) {
+				t.Errorf("sharedRenderBody() error = %v, want ErrInvalidBodyTemplate", err)
+			}
+		})
+	}
+}
+
+func TestSharedApplyDeliveryWindows(t *testing.T) {
+	storage := newFakeStorage()
+	storage.topics = []model.Topic{
+		{OrgID: "org", AppID: "app", Name: "dining", DeliveryWindows: []model.DeliveryWindow{{Start: "07:00", End: "09:00"}}},
+		{OrgID: "org", AppID: "app", Name: "sports", DeliveryWindows: []model.DeliveryWindow{{Start: "10:00", End: "20:00"}}},
+		{OrgID: "org", AppID: "app", Name: "alerts"},
+	}
+	app := newTestApplication(storage)
+	date := func(hour int) time.Time {
+		return time.Date(2024, 1, 10, hour, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name        string
+		topics      []string
+		messageTime time.Time
+		want        time.Time
+	}{
+		{"in window", []string{"dining"}, date(8), date(8)},
+		{"out of window", []string{"dining"}, date(12), time.Date(2024, 1, 11, 7, 0, 0, 0, time.UTC)},
+		{"topic without windows", []string{"alerts"}, date(3), date(3)},
+		{"unknown topic", []string{"unknown"}, date(3), date(3)},
+		{"latest of the topics", []string{"dining", "sports", "alerts"}, date(8), date(10)},
+		{"no topics", nil, date(3), date(3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := app.sharedApplyDeliveryWindows("org", "app", tt.topics, tt.messageTime); !got.Equal(tt.want) {
+				t.Errorf("sharedApplyDeliveryWindows() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSharedCreateQueueItemsUserLastActive(t *testing.T) {
+	recently := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	longAgo := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	storage := newFakeStorage()
+	storage.users = []model.User{
+		{OrgID: "org", AppID: "app", UserID: "active", DateLastActive: &recently},
+		{OrgID: "org", AppID: "app", UserID: "inactive", DateLastActive: &longAgo},
+		{OrgID: "org", AppID: "app", UserID: "never"},
+		{OrgID: "org", AppID: "other", UserID: "other app", DateLastActive: &recently},
+	}
+	app := newTestApplication(storage)
+
+	message := model.Message{OrgID: "org", AppID: "app", ID: "message", Subject: "subject", Body: "body"}
+	recipients := []model.MessageRecipient{}
+	for _, userID := range []string{"inactive", "never", "active", "other app"} {
+		recipients = append(recipients, model.MessageRecipient{OrgID: "org", AppID: "app", ID: userID, MessageID: "message", UserID: userID})
+	}
+	queueItems, err := app.sharedCreateQueueItems(message, recipients)
+	if err != nil {
+		t.Fatalf("sharedCreateQueueItems() error = %v", err)
+	}
+
+	want := map[string]*time.Time{"active": &recently, "inactive": &longAgo, "never": nil, "other app": nil}
+	if len(queueItems) != len(want) {
+		t.Fatalf("sharedCreateQueueItems() gives %d items, want %d", len(queueItems), len(want))
+	}
+	for _, item := range queueItems {
+		if got := item.UserLastActive; !reflect.DeepEqual(got, want[item.UserID]) {
+			t.Errorf("sharedCreateQueueItems() %s last active = %v, want %v", item.UserID, got, want[item.UserID])
+		}
+	}
+}
+
+func TestSharedModerateMessages(t *testing.T) {
+	tests := []struct {
+		name        string
+		moderator   Moderator
+		bodies      []string
+		wantErr     error
+		wantFlagged []bool
+	}{
+		{"allowed", fakeModerator{blocked: "spam", flagged: "phone"}, []string{"body", "other body"}, nil, []bool{false, false}},
+		{"flagged", fakeModerator{blocked: "spam", flagged: "phone"}, []string{"body", "call my phone"}, nil, []bool{false, true}},
+		{"blocked", fakeModerator{blocked: "spam", flagged: "phone"}, []string{"body", "spam"}, model.ErrMessageBlocked, nil},
+		{"disabled", nil, []string{"spam", "call my phone"}, nil, []bool{false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(newFakeStorage())
+			app.moderator = tt.moderator
+
+			messages := make([]model.InputMessage, len(tt.bodies))
+			for i, body := range tt.bodies {
+				recipients := []model.MessageRecipient{{UserID: "u1"}}
+				messages[i] = model.InputMessage{OrgID: "org", AppID: "app", Subject: "subject", Body: body, InputRecipients: recipients}
+			}
+			err := app.sharedModerateMessages(messages)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("sharedModerateMessages() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "blocked word") {
+				t.Errorf("sharedModerateMessages() error = %v, want the reason", err)
+			}
+
+			//the flagged messages are created to be sent, marked for the review
+			for i, im := range messages {
+				if err != nil {
+					break
+				}
+				message, _, handleErr := app.sharedHandleInputMessage(nil, im)
+				if handleErr != nil {
+					t.Fatalf("sharedHandleInputMessage() error = %v", handleErr)
+				}
+				if message.Flagged != tt.wantFlagged[i] || (message.ModerationReason != nil) != tt.wantFlagged[i] {
+					t.Errorf("message %d flagged %t with reason %v, want flagged %t", i, message.Flagged, message.ModerationReason, tt.wantFlagged[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSharedHandleInputMessageExcludeRecipients(t *testing.T) {
+	tests := []struct {
+		name           string
+		exclude        []model.MessageRecipient
+		wantRecipients []string
+		wantExcluded   []string
+	}{
+		{"no exclusions", nil, []string{"u1", "u2", "u3"}, nil},
+		{"excluded users", []model.MessageRecipient{{UserID: "u1"}, {UserID: "u3"}}, []string{"u2"}, []string{"u1", "u3"}},
+		{"excluded user not in the topic", []model.MessageRecipient{{UserID: "u4"}}, []string{"u1", "u2", "u3"}, nil},
+		{"all excluded", []model.MessageRecipient{{UserID: "u1"}, {UserID: "u2"}, {UserID: "u3"}}, []string{}, []string{"u1", "u2", "u3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.topicUsers = []model.User{{UserID: "u1"}, {UserID: "u2"}, {UserID: "u3"}}
+			app := newTestApplication(storage)
+
+			topic := "athletics"
+			im := model.InputMessage{OrgID: "org", AppID: "app", Subject: "subject", Body: "body", Topic: &topic, ExcludeRecipients: tt.exclude}
+			message, recipients, err := app.sharedHandleInputMessage(nil, im)
+			if err != nil {
+				t.Fatalf("sharedHandleInputMessage() error = %v", err)
+			}
+			if got := recipientsUsersIDs(recipients); !reflect.DeepEqual(got, tt.wantRecipients) {
+				t.Errorf("sharedHandleInputMessage() recipients = %v, want %v", got, tt.wantRecipients)
+			}
+			if !reflect.DeepEqual(message.ExcludedRecipients, tt.wantExcluded) {
+				t.Errorf("sharedHandleInputMessage() excluded = %v, want %v", message.ExcludedRecipients, tt.wantExcluded)
+			}
+		})
+	}
+}
+
+func TestSharedValidateSourceApps(t *testing.T) {
+	tests := []struct {
+		name       string
+		sourceApps []string
+		messages   []string //the messages source apps
+		wantErr    bool
+	}{
+		{"known source apps", []string{"events", "groups"}, []string{"events", "groups"}, false},
+		{"no source app", []string{"events"}, []string{""}, false},
+		{"unknown source app", []string{"events"}, []string{"events", "unknown"}, true},
+		{"no known source apps", nil, []string{"unknown"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(newFakeStorage())
+			app.config = &model.Config{SourceApps: tt.sourceApps}
+
+			messages := make([]model.InputMessage, len(tt.messages))
+			for i, sourceApp := range tt.messages {
+				messages[i] = model.InputMessage{SourceApp: sourceApp}
+			}
+			err := app.sharedValidateSourceApps(messages)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, model.ErrInvalidSourceApp)) {
+				t.Errorf("sharedValidateSourceApps() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSharedGetFailedRecipients(t *testing.T) {
+	paused := time.Now().Add(time.Hour)
+	storage := newFakeStorage()
+	storage.users = []model.User{
+		{OrgID: "org", AppID: "app", UserID: "tokens", DeviceTokens: []model.DeviceToken{{Token: "token-1"}}},
+		{OrgID: "org", AppID: "app", UserID: "no tokens"},
+		{OrgID: "org", AppID: "app", UserID: "disabled", NotificationsDisabled: true, DeviceTokens: []model.DeviceToken{{Token: "token-2"}}},
+		{OrgID: "org", AppID: "app", UserID: "paused", PausedUntil: &paused, DeviceTokens: []model.DeviceToken{{Token: "token-3"}}},
+		{OrgID: "org", AppID: "app", UserID: "device disabled", DeviceTokens: []model.DeviceToken{{Token: "token-4", NotificationsDisabled: true}}},
+		{OrgID: "org", AppID: "app", UserID: "muted"},
+	}
+	app := newTestApplication(storage)
+
+	recipients := []model.MessageRecipient{}
+	for _, userID := range []string{"tokens", "no tokens", "disabled", "paused", "device disabled", "no user"} {
+		recipients = append(recipients, model.MessageRecipient{UserID: userID})
+	}
+	recipients = append(recipients, model.MessageRecipient{UserID: "muted", Mute: true})
+
+	failed, err := app.sharedGetFailedRecipients("org", "app", recipients)
+	if err != nil {
+		t.Fatalf("sharedGetFailedRecipients() error = %v", err)
+	}
+	want := []model.FailedRecipient{
+		{UserID: "no tokens", Reason: model.FailureReasonNoDeviceTokens},
+		{UserID: "disabled", Reason: model.FailureReasonNotificationsDisabled},
+		{UserID: "paused", Reason: model.FailureReasonNotificationsDisabled},
+		{UserID: "device disabled", Reason: model.FailureReasonNotificationsDisabled},
+		{UserID: "no user", Reason: model.FailureReasonNoDeviceTokens},
+	}
+	if !reflect.DeepEqual(failed, want) {
+		t.Errorf("sharedGetFailedRecipients() = %v, want %v", failed, want)
+	}
+}
+
+func TestSharedHandleInputMessageEventTime(t *testing.T) {
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name       string
+		eventTime  time.Time
+		skipIfPast bool
+		wantTime   time.Time //zero if the time is the current time
+		wantErr    bool
+	}{
+		{"before the event", now.Add(3 * time.Hour), false, now.Add(2 * time.Hour), false},
+		{"past sent now", now.Add(30 * time.Minute), false, time.Time{}, false},
+		{"past skipped", now.Add(30 * time.Minute), true, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(newFakeStorage())
+
+			eventTime := tt.eventTime
+			im := model.InputMessage{OrgID: "org", AppID: "app", Subject: "subject", Body: "body", Time: now,
+				InputRecipients: []model.MessageRecipient{{UserID: "u1"}}, EventTime: &eventTime, OffsetBefore: time.Hour, SkipIfPast: tt.skipIfPast}
+			message, _, err := app.sharedHandleInputMessage(nil, im)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sharedHandleInputMessage() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, model.ErrMessageTimePassed) {
+					t.Errorf("sharedHandleInputMessage() error = %v, want ErrMessageTimePassed", err)
+				}
+				return
+			}
+
+			if !tt.wantTime.IsZero() && !message.Time.Equal(tt.wantTime) {
+				t.Errorf("sharedHandleInputMessage() time = %s, want %s", message.Time, tt.wantTime)
+			}
+			if tt.wantTime.IsZero() && (message.Time.Before(now) || message.Time.After(time.Now())) {
+				t.Errorf("sharedHandleInputMessage() time = %s, want the current time", message.Time)
+			}
+			if message.OffsetBefore == nil || *message.OffsetBefore != 3600 {
+				t.Errorf("sharedHandleInputMessage() offset before = %v, want 3600", message.OffsetBefore)
+			}
+		})
+	}
+}
+
+func TestSharedResolveSound(t *testing.T) {
+	siren := "siren.caf"
+	chime := "chime.caf"
+	empty := ""
+	emergency, news, other := "emergency", "news", "other"
+
+	tests := []struct {
+		name   string
+		topic  *string
+		topics []string
+		sound  *string
+		want   string
+	}{
+		{"topic default", &emergency, nil, nil, siren},
+		{"message sound overrides", &emergency, nil, &chime, chime},
+		{"first topic with a default", nil, []string{"news", "athletics", "emergency"}, nil, chime},
+		{"topic without a default", &news, nil, nil, ""},
+		{"unknown topic", &other, nil, nil, ""},
+		{"no topic", nil, nil, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.topics = []model.Topic{
+				{OrgID: "org", AppID: "app", Name: "emergency", DefaultSound: &siren},
+				{OrgID: "org", AppID: "app", Name: "athletics", DefaultSound: &chime},
+				{OrgID: "org", AppID: "app", Name: "news", DefaultSound: &empty},
+			}
+			storage.topicUsers = []model.User{{UserID: "u1"}}
+			app := newTestApplication(storage)
+
+			im := model.InputMessage{OrgID: "org", AppID: "app", Subject: "subject", Body: "body", Topic: tt.topic, Topics: tt.topics, Sound: tt.sound,
+				InputRecipients: []model.MessageRecipient{{UserID: "u1"}}}
+			message, recipients, err := app.sharedHandleInputMessage(nil, im)
+			if err != nil {
+				t.Fatalf("sharedHandleInputMessage() error = %v", err)
+			}
+			queueItems, err := app.sharedCreateQueueItems(*message, recipients)
+			if err != nil {
+				t.Fatalf("sharedCreateQueueItems() error = %v", err)
+			}
+			if len(queueItems) != 1 || queueItems[0].Sound != tt.want {
+				t.Errorf("sharedCreateQueueItems() = %v, want one item with sound %q", queueItems, tt.want)
+			}
+		})
+	}
+}
+
+func TestSharedHandleInputMessageLocalized(t *testing.T) {
+	fr, frCA, en := "fr", "fr-CA", "en"
+	storage := newFakeStorage()
+	storage.users = []model.User{
+		{OrgID: "org", AppID: "app", UserID: "french", Locale: &fr},
+		{OrgID: "org", AppID: "app", UserID: "canadian", Locale: &frCA},
+		{OrgID: "org", AppID: "app", UserID: "english", Locale: &en},
+		{OrgID: "org", AppID: "app", UserID: "unknown"},
+	}
+	app := newTestApplication(storage)
+
+	im := model.InputMessage{OrgID: "org", AppID: "app", Subject: "Reminder", Body: "The event starts soon",
+		LocalizedSubjects: map[string]string{"fr": "Rappel"}, LocalizedBodies: map[string]string{"fr": "L'événement commence bientôt"},
+		InputRecipients: []model.MessageRecipient{{UserID: "french"}, {UserID: "canadian"}, {UserID: "english"}, {UserID: "unknown"}}}
+	message, recipients, err := app.sharedHandleInputMessage(nil, im)
+	if err != nil {
+		t.Fatalf("sharedHandleInputMessage() error = %v", err)
+	}
+	queueItems, err := app.sharedCreateQueueItems(*message, recipients)
+	if err != nil {
+		t.Fatalf("sharedCreateQueueItems() error = %v", err)
+	}
+
+	want := map[string]string{
+		"french":   "Rappel: L'événement commence bientôt",
+		"canadian": "Rappel: L'événement commence bientôt",
+		"english":  "Reminder: The event starts soon",
+		"unknown":  "Reminder: The event starts soon",
+	}
+	got := map[string]string{}
+	for _, item := range queueItems {
+		got[item.UserID] = item.Subject + ": " + item.Body
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sharedCreateQueueItems() contents = %v, want %v", got, want)
+	}
+}
+
+func TestSharedUnsubscribeFromAllTopics(t *testing.T) {
+	tests := []struct {
+		name             string
+		userID           string
+		wantRemoved      []string
+		wantUnsubscribed []string
+	}{
+		{"tokens", "u1", []string{"athletics", "news"}, []string{"t1:athletics", "t1:news", "t2:athletics", "t2:news"}},
+		{"no tokens", "u2", []string{"events"}, nil},
+		{"no topics", "u3", []string{}, nil},
+		{"no user", "other", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.users = []model.User{
+				{OrgID: "org", AppID: "app", UserID: "u1", Topics: []string{"athletics", "news"},
+					DeviceTokens: []model.DeviceToken{{Token: "t1"}, {Token: "t2"}, {Token: "apns", TokenType: model.TokenTypeAPNs}}},
+				{OrgID: "org", AppID: "app", UserID: "u2", Topics: []string{"events"}},
+				{OrgID: "org", AppID: "app", UserID: "u3", DeviceTokens: []model.DeviceToken{{Token: "t3"}}},
+			}
+			firebase := &fakeFirebase{}
+			app := newTestApplication(storage)
+			app.firebase = firebase
+
+			removed, err := app.sharedUnsubscribeFromAllTopics(app.logger.NewLog("test", logs.RequestContext{}), "org", "app", tt.userID)
+			if err != nil {
+				t.Fatalf("sharedUnsubscribeFromAllTopics() error = %v", err)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("sharedUnsubscribeFromAllTopics() = %v, want %v", removed, tt.wantRemoved)
+			}
+			sort.Strings(firebase.unsubscribed)
+			if !reflect.DeepEqual(firebase.unsubscribed, tt.wantUnsubscribed) {
+				t.Errorf("sharedUnsubscribeFromAllTopics() unsubscribed %v, want %v", firebase.unsubscribed, tt.wantUnsubscribed)
+			}
+			if user, _ := storage.FindUserByID("org", "app", tt.userID); user != nil && len(user.Topics) != 0 {
+				t.Errorf("sharedUnsubscribeFromAllTopics() kept the topics %v", user.Topics)
+			}
+		})
+	}
+}
+
+func TestSharedGetTopicsBySubscribers(t *testing.T) {
+	storage := newFakeStorage()
+	for _, name := range []string{"athletics", "events", "news", "weather"} {
+		storage.topics = append(storage.topics, model.Topic{OrgID: "org", AppID: "app", Name: name})
+	}
+	storage.topicUsers = []model.User{
+		{OrgID: "org", AppID: "app", UserID: "u1", Topics: []string{"news", "events"}},
+		{OrgID: "org", AppID: "app", UserID: "u2", Topics: []string{"news", "weather"}},
+		{OrgID: "org", AppID: "app", UserID: "u3", Topics: []string{"news", "events"}},
+		{OrgID: "org", AppID: "other", UserID: "u4", Topics: []string{"athletics", "athletics", "athletics"}},
+	}
+	app := newTestApplication(storage)
+	int64Ptr := func(value int64) *int64 { return &value }
+	sortBy := model.TopicsSortBySubscribers
+	asc, desc := "asc", "desc"
+
+	tests := []struct {
+		name   string
+		offset *int64
+		limit  *int64
+		order  *string
+		want   []string
+	}{
+		{"ascending", nil, nil, &asc, []string{"athletics", "weather", "events", "news"}},
+		{"descending", nil, nil, &desc, []string{"news", "events", "weather", "athletics"}},
+		{"default order", nil, nil, nil, []string{"athletics", "weather", "events", "news"}},
+		{"page", int64Ptr(1), int64Ptr(2), &desc, []string{"events", "weather"}},
+		{"last page", int64Ptr(3), int64Ptr(2), &desc, []string{"athletics"}},
+		{"past the last page", int64Ptr(10), int64Ptr(2), &desc, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topics, total, err := app.sharedGetTopics("org", "app", tt.offset, tt.limit, &sortBy, tt.order, false)
+			if err != nil {
+				t.Fatalf("sharedGetTopics() error = %v", err)
+			}
+			names := []string{}
+			for _, topic := range topics {
+				names = append(names, topic.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) || total != 4 {
+				t.Errorf("sharedGetTopics() = %v of %d, want %v of 4", names, total, tt.want)
+			}
+		})
+	}
+}
@@ -15,20 +15,69 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"notifications/core/model"
 	"notifications/driven/storage"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/rokwire/logging-library-go/v2/logs"
 )
 
+const (
+	activeSessionMaxDefer    = 24 * time.Hour  //how long a push for an inactive user may be deferred
+	activeSessionRetryPeriod = 5 * time.Minute //how often a deferred push is retried
+
+	firebaseMulticastSize = 500 //the max tokens which FCM accepts in one multicast send
+
+	defaultSendConcurrency = 10 //max batch sends in progress when the send concurrency is not configured
+)
+
+// queueItemSend is the push of a queue item to the user devices
+type queueItemSend struct {
+	item   model.QueueItem
+	tokens []model.DeviceToken
+
+	delivered bool
+	errorCode string
+
+	retryTokens    []string //the Firebase tokens which failed with a transient error
+	retryErrorCode string
+}
+
+// tokenSend is a user token within a batch send
+type tokenSend struct {
+	send        *queueItemSend
+	deviceToken model.DeviceToken
+}
+
+// batchSend is a send to a single token or a multicast send to many Firebase tokens
+type batchSend struct {
+	tokens  []tokenSend
+	perform func() []error // gives the send error of every token in the tokens order, nil if it has been sent
+}
+
 type queueLogic struct {
 	logger *logs.Logger
 
 	storage  Storage
 	firebase Firebase
 	airship  Airship
+	apns     APNs
+	mailer   Mailer
+	sms      SMS
+	core     Core
+	webhook  Webhook
+
+	tokenFailuresLimit int //invalid token failures after which a token is removed, 0 means never
+
+	retryBase        time.Duration //wait before the first retry of a push failed with a transient error
+	retryMaxAttempts int           //max sends of a push failed with a transient error, 1 means no retries
+
+	sendSlots chan struct{} //bounds the batch sends in progress of all the messages
 
 	//timer
 	queueTimer *time.Timer
@@ -206,68 +255,441 @@ func (q queueLogic) unlockQueue(queue model.Queue) {
 
 func (q queueLogic) processQueueItem(queueItems []model.QueueItem) error {
 
-	//get the users as we need their tokens and if they have disabled notifications
-	usersIDs := make([]string, len(queueItems))
-	for i, item := range queueItems {
-		usersIDs[i] = item.UserID
+	//get the users as we need their tokens and if they have disabled notifications, the items may be of different apps
+	appsItems := map[string]model.QueueItem{} //by org and app
+	appsUsersIDs := map[string][]string{}
+	for _, item := range queueItems {
+		appKey := item.OrgID + "_" + item.AppID
+		appsItems[appKey] = item
+		appsUsersIDs[appKey] = append(appsUsersIDs[appKey], item.UserID)
 	}
-	users, err := q.storage.FindUsersByIDs(usersIDs)
-	if err != nil {
-		q.logger.Errorf("error on getting users - %s", err)
-		return err
+	users := []model.User{}
+	var err error
+	for appKey, usersIDs := range appsUsersIDs {
+		var appUsers []model.User
+		appUsers, err = q.storage.FindUsersByIDs(appsItems[appKey].OrgID, appsItems[appKey].AppID, usersIDs)
+		if err != nil {
+			q.logger.Errorf("error on getting users - %s", err)
+			return err
+		}
+		users = append(users, appUsers...)
 	}
 
 	//process every item
-	itemsIDs := make([]string, len(queueItems))
-	for i, item := range queueItems {
-		itemsIDs[i] = item.ID
+	now := time.Now().UTC()
+	itemsIDs := []string{}
+	deferredItemsIDs := []string{}
+	noTokenRecipientsIDs := []string{}
+	disabledRecipientsIDs := []string{}
+	expiredRecipientsIDs := []string{}
+	expiredCounts := map[string]int{}            //by message
+	expiredItems := map[string]model.QueueItem{} //by message
+	sendsGroups := map[string][]*queueItemSend{} //the items with the same content are sent together
+	sendsKeys := []string{}
+	for _, item := range queueItems {
+		//the stale items are not sent at all
+		if item.ExpiresAt != nil && !now.Before(*item.ExpiresAt) {
+			itemsIDs = append(itemsIDs, item.ID)
+			expiredRecipientsIDs = append(expiredRecipientsIDs, item.MessageRecipientID)
+			expiredCounts[item.MessageID]++
+			expiredItems[item.MessageID] = item
+			continue
+		}
 
 		var user *model.User
 
 		//get the user
 		for _, cUser := range users {
-			if cUser.UserID == item.UserID {
+			if cUser.UserID == item.UserID && cUser.OrgID == item.OrgID && cUser.AppID == item.AppID {
 				user = &cUser
 				break
 			}
 		}
 
+		//send only to the active users if required
+		if user != nil && item.ActiveWithinMinutes != nil && !user.IsActiveWithin(*item.ActiveWithinMinutes, now) {
+			if item.ActiveDeadline != nil && now.Before(*item.ActiveDeadline) {
+				deferredItemsIDs = append(deferredItemsIDs, item.ID)
+				continue //keep it in the queue for later
+			}
+			itemsIDs = append(itemsIDs, item.ID)
+			q.logger.Infof("queue item(%s) dropped as user %s is not active", item.ID, item.UserID)
+			continue
+		}
+
+		itemsIDs = append(itemsIDs, item.ID)
+
 		if user == nil {
+			if len(item.FallbackChannels) > 0 {
+				go q.sendFallback(item) //new thread
+				continue
+			}
+			noTokenRecipientsIDs = append(noTokenRecipientsIDs, item.MessageRecipientID)
 			continue //for some reasons there is no a corresponding user
 		}
 
-		if user.NotificationsDisabled {
-			continue //do not send notification if disabled for the user
+		if user.NotificationsOff(now) {
+			disabledRecipientsIDs = append(disabledRecipientsIDs, item.MessageRecipientID)
+			continue //do not send notification if disabled or paused by the user
 		}
 
-		tokens := user.DeviceTokens
+		if len(user.DeviceTokens) == 0 {
+			if len(item.FallbackChannels) > 0 {
+				go q.sendFallback(item) //new thread
+				continue
+			}
+			noTokenRecipientsIDs = append(noTokenRecipientsIDs, item.MessageRecipientID)
+			continue //nothing to send
+		}
 
-		go q.sendNotifications(item, tokens) //new thread
+		//the user is still a recipient but the push is sent only to the devices on which the notifications are enabled
+		tokens := user.GetPushTokens(item.PerDevice)
+		if len(tokens) == 0 {
+			disabledRecipientsIDs = append(disabledRecipientsIDs, item.MessageRecipientID)
+			continue //disabled on all the user devices
+		}
+
+		//the rendered and the localized contents differ between the recipients of the same message
+		key := item.MessageID + "_" + item.Subject + "_" + item.Body
+		if _, ok := sendsGroups[key]; !ok {
+			sendsKeys = append(sendsKeys, key)
+		}
+		sendsGroups[key] = append(sendsGroups[key], &queueItemSend{item: item, tokens: tokens})
+	}
+
+	for _, key := range sendsKeys {
+		go q.sendNotifications(sendsGroups[key]) //new thread
 	}
 
 	//remove the items from the queue
-	err = q.storage.DeleteQueueData(itemsIDs)
-	if err != nil {
-		q.logger.Errorf("error on deleting queue datas - %s", err)
-		return err
+	if len(itemsIDs) > 0 {
+		err = q.storage.DeleteQueueData(itemsIDs)
+		if err != nil {
+			q.logger.Errorf("error on deleting queue datas - %s", err)
+			return err
+		}
+	}
+
+	//set the delivery status of the recipients to which nothing was sent
+	if len(noTokenRecipientsIDs) > 0 {
+		err = q.storage.UpdateMessageRecipientsDeliveryStatus(noTokenRecipientsIDs, model.DeliveryStatusNoToken)
+		if err != nil {
+			q.logger.Errorf("error on updating the delivery status for recipients without tokens - %s", err)
+		}
+	}
+	if len(disabledRecipientsIDs) > 0 {
+		err = q.storage.UpdateMessageRecipientsDeliveryStatus(disabledRecipientsIDs, model.DeliveryStatusDisabled)
+		if err != nil {
+			q.logger.Errorf("error on updating the delivery status for recipients with disabled notifications - %s", err)
+		}
+	}
+
+	if len(expiredRecipientsIDs) > 0 {
+		err = q.storage.UpdateMessageRecipientsDeliveryStatus(expiredRecipientsIDs, model.DeliveryStatusExpired)
+		if err != nil {
+			q.logger.Errorf("error on updating the delivery status for expired recipients - %s", err)
+		}
+		for messageID, count := range expiredCounts {
+			q.logger.Infof("%d queue items of message %s dropped as it has expired", count, messageID)
+			err = q.storage.IncrementMessageDeliverySummaryWithContext(context.Background(), expiredItems[messageID].OrgID, expiredItems[messageID].AppID,
+				messageID, model.DeliverySummary{Expired: count})
+			if err != nil {
+				q.logger.Errorf("error on updating the delivery summary of expired message %s - %s", messageID, err)
+			}
+		}
+	}
+
+	//retry the deferred items later
+	if len(deferredItemsIDs) > 0 {
+		err = q.storage.UpdateQueueDataTime(deferredItemsIDs, now.Add(activeSessionRetryPeriod))
+		if err != nil {
+			q.logger.Errorf("error on deferring queue datas - %s", err)
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (q queueLogic) sendNotifications(queueItem model.QueueItem, tokens []model.DeviceToken) {
-	for _, deviceToken := range tokens {
-		token := deviceToken.Token
-		var sendErr error
-		if deviceToken.TokenType == "airship" {
-			sendErr = q.airship.SendNotificationToToken(queueItem.OrgID, queueItem.AppID, token, queueItem.Subject, queueItem.Body, queueItem.Data)
-		} else {
-			sendErr = q.firebase.SendNotificationToToken(queueItem.OrgID, queueItem.AppID, token, queueItem.Subject, queueItem.Body, queueItem.Data)
+// sendNotifications sends the items which have the same content. The Firebase tokens of all the items are sent
+// with multicast requests, the other tokens are sent one by one. The batches are sent in parallel within the send
+// concurrency and their results are recorded once all of them have ended.
+func (q queueLogic) sendNotifications(sends []*queueItemSend) {
+	if len(sends) == 0 {
+		return
+	}
+
+	batches := []batchSend{}
+	firebaseTokens := []tokenSend{}
+	for _, send := range sends {
+		item := send.item
+		for _, deviceToken := range send.tokens {
+			token := tokenSend{send: send, deviceToken: deviceToken}
+			switch deviceToken.TokenType {
+			case model.TokenTypeAirship:
+				batches = append(batches, batchSend{tokens: []tokenSend{token}, perform: func() []error {
+					return []error{q.airship.SendNotificationToToken(item.OrgID, item.AppID, deviceToken.Token, item.Subject, item.Body, item.Sound, item.Data)}
+				}})
+			case model.TokenTypeAPNs:
+				batches = append(batches, batchSend{tokens: []tokenSend{token}, perform: func() []error {
+					return []error{q.apns.SendNotificationToToken(item.OrgID, item.AppID, deviceToken.Token, item.Subject, item.Body, item.Sound, item.Badge, item.CollapseKey, item.Data)}
+				}})
+			default:
+				firebaseTokens = append(firebaseTokens, token)
+			}
 		}
-		if sendErr != nil {
-			q.logger.Errorf("error send notification to token (%s): %s", token, sendErr)
-		} else {
-			q.logger.Infof("queue item(%s:%s:%s) has been sent to token: %s", queueItem.ID, queueItem.Subject, queueItem.Body, token)
+	}
+
+	//all the items have the same content, so the first one is used for the multicast
+	item := sends[0].item
+	for start := 0; start < len(firebaseTokens); start += firebaseMulticastSize {
+		end := start + firebaseMulticastSize
+		if end > len(firebaseTokens) {
+			end = len(firebaseTokens)
 		}
+		chunk := firebaseTokens[start:end]
+		batches = append(batches, batchSend{tokens: chunk, perform: func() []error {
+			return q.sendMulticast(item, chunk)
+		}})
+	}
+
+	//every batch writes its own results only, the items are updated by this thread once all the batches have ended
+	results := make([][]error, len(batches))
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		q.sendSlots <- struct{}{} //wait for a free slot
+		wg.Add(1)
+		go func(i int, batch batchSend) {
+			defer func() {
+				<-q.sendSlots
+				wg.Done()
+			}()
+
+			results[i] = batch.perform()
+		}(i, batch)
+	}
+	wg.Wait()
+
+	for i, batch := range batches {
+		for j, token := range batch.tokens {
+			q.onTokenSent(token.send, token.deviceToken, results[i][j])
+		}
+	}
+
+	for _, send := range sends {
+		q.onItemSent(send)
+	}
+}
+
+// sendMulticast sends the item content to the Firebase tokens with one request and gives the send error of every token
+func (q queueLogic) sendMulticast(item model.QueueItem, chunk []tokenSend) []error {
+	tokens := make([]string, len(chunk))
+	for i, token := range chunk {
+		tokens[i] = token.deviceToken.Token
+	}
+
+	errs := make([]error, len(chunk))
+	response, err := q.firebase.SendNotificationToTokens(item.OrgID, item.AppID, tokens, item.Subject, item.Body, item.Sound, item.Badge, item.Priority, item.CollapseKey, item.ImageURL, item.AndroidChannelID, item.Data)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	q.logger.Infof("message %s multicast to %d tokens - %d succeeded, %d failed", item.MessageID, len(tokens), response.SuccessCount, response.FailureCount)
+	for i := range errs {
+		if i < len(response.Errors) {
+			errs[i] = response.Errors[i]
+		}
+	}
+	return errs
+}
+
+// onTokenSent records the result of sending an item to one of the user tokens
+func (q queueLogic) onTokenSent(send *queueItemSend, deviceToken model.DeviceToken, sendErr error) {
+	queueItem := send.item
+	token := deviceToken.Token
+	if sendErr != nil {
+		q.logger.Errorf("error send notification to token (%s): %s", token, sendErr)
+		send.errorCode = model.GetDeliveryErrorCode(sendErr)
+		//only the Firebase sends are retried
+		isFirebase := deviceToken.TokenType != model.TokenTypeAirship && deviceToken.TokenType != model.TokenTypeAPNs
+		if isFirebase && q.retryMaxAttempts > 1 && model.IsRetryableDeliveryError(sendErr) {
+			send.retryTokens = append(send.retryTokens, token)
+			send.retryErrorCode = send.errorCode
+			return
+		}
+		if errors.Is(sendErr, model.ErrInvalidDeviceToken) {
+			q.onInvalidToken(queueItem, token)
+		}
+		return
+	}
+
+	q.logger.Infof("queue item(%s:%s:%s) has been sent to token: %s", queueItem.ID, queueItem.Subject, queueItem.Body, token)
+	send.delivered = true
+	if deviceToken.FailuresCount > 0 {
+		err := q.storage.ResetDeviceTokenFailures(queueItem.OrgID, queueItem.AppID, queueItem.UserID, token)
+		if err != nil {
+			q.logger.Errorf("error on resetting the failures for token (%s) - %s", token, err)
+		}
+	}
+}
+
+// onItemSent records the result of sending an item to all the user tokens
+func (q queueLogic) onItemSent(send *queueItemSend) {
+	queueItem := send.item
+
+	if send.delivered {
+		q.logDelivery(queueItem, model.DeliveryChannelPush, model.DeliveryStatusDelivered, "")
+	} else {
+		errorCode := send.errorCode
+		if len(errorCode) == 0 {
+			errorCode = send.retryErrorCode
+		}
+		q.logDelivery(queueItem, model.DeliveryChannelPush, model.DeliveryStatusFailed, errorCode)
+	}
+
+	//the tokens which failed with a transient error are sent again later
+	if len(send.retryTokens) > 0 {
+		retry := model.NewPushRetry(queueItem, send.retryTokens, !send.delivered, send.retryErrorCode, uuid.NewString(), q.retryBase, time.Now().UTC())
+		err := q.storage.InsertPushRetry(retry)
+		if err != nil {
+			q.logger.Errorf("error on inserting the push retry for recipient %s - %s", queueItem.MessageRecipientID, err)
+		} else if !send.delivered {
+			q.logger.Infof("queue item(%s) send to %d tokens will be retried at %s", queueItem.ID, len(send.retryTokens), retry.NextAttempt)
+			return //the result is recorded when the retry ends
+		}
+		if len(send.errorCode) == 0 {
+			send.errorCode = send.retryErrorCode
+		}
+	}
+
+	q.recordDelivery(queueItem.OrgID, queueItem.AppID, queueItem.MessageID, queueItem.MessageRecipientID, send.delivered, send.errorCode)
+}
+
+// logDelivery appends the attempt to send the queue item through the channel to the delivery audit log
+func (q queueLogic) logDelivery(queueItem model.QueueItem, channel string, status string, errorCode string) {
+	entry := model.NewDeliveryLog(queueItem, channel, status, errorCode, uuid.NewString(), time.Now().UTC())
+	err := q.storage.LogDelivery(entry)
+	if err != nil {
+		q.logger.Errorf("error on logging the delivery for recipient %s - %s", queueItem.MessageRecipientID, err)
+	}
+}
+
+// recordDelivery updates the message delivery summary and the recipient delivery status with the send result
+func (q queueLogic) recordDelivery(orgID string, appID string, messageID string, messageRecipientID string, delivered bool, errorCode string) {
+	//update the message delivery summary
+	summaryDelta := model.DeliverySummary{Sent: 1}
+	status := model.DeliveryStatusDelivered
+	var recipientErrorCode *string
+	if delivered {
+		summaryDelta.Delivered = 1
+	} else {
+		summaryDelta.Failed = 1
+		summaryDelta.Errors = map[string]int{errorCode: 1}
+		status = model.DeliveryStatusFailed
+		recipientErrorCode = &errorCode
+	}
+	err := q.storage.IncrementMessageDeliverySummaryWithContext(context.Background(), orgID, appID, messageID, summaryDelta)
+	if err != nil {
+		q.logger.Errorf("error on updating the delivery summary for message %s - %s", messageID, err)
+	}
+
+	//set the recipient delivery status, the broadcasts have no recipients
+	if len(messageRecipientID) == 0 {
+		return
+	}
+	err = q.storage.UpdateMessageRecipientDeliveryStatus(orgID, appID, messageRecipientID, status, recipientErrorCode)
+	if err != nil {
+		q.logger.Errorf("error on updating the delivery status for recipient %s - %s", messageRecipientID, err)
+	}
+}
+
+// sendFallback sends the message through the fallback channels to a user without device tokens. The channels are tried in order
+// until one of them succeeds. The data is not sent. A failure is recorded for the recipient only.
+func (q queueLogic) sendFallback(queueItem model.QueueItem) {
+	var sendErr error
+	errorCode := model.DeliveryErrorInternal
+	status := model.DeliveryStatusFailed
+
+	accounts, err := q.core.RetrieveCoreUserAccountByCriteria(map[string]interface{}{"_id": queueItem.UserID}, &queueItem.AppID, &queueItem.OrgID)
+	if err != nil || len(accounts) == 0 {
+		sendErr = fmt.Errorf("no account for user %s - %v", queueItem.UserID, err)
+	} else {
+		profile := accounts[0].Profile
+		for _, channel := range queueItem.FallbackChannels {
+			switch channel {
+			case model.DeliveryChannelEmail:
+				if len(profile.Email) == 0 {
+					sendErr = fmt.Errorf("no email for user %s", queueItem.UserID)
+					errorCode = model.DeliveryErrorNoEmail
+					continue
+				}
+				sendErr = q.mailer.SendMail(profile.Email, queueItem.Subject, queueItem.Body)
+				status = model.DeliveryStatusEmailed
+			case model.DeliveryChannelSMS:
+				if len(profile.Phone) == 0 {
+					sendErr = fmt.Errorf("no phone for user %s", queueItem.UserID)
+					errorCode = model.DeliveryErrorNoPhone
+					continue
+				}
+				sendErr = q.sms.SendSMS(profile.Phone, queueItem.Body)
+				status = model.DeliveryStatusTexted
+			default:
+				continue
+			}
+			if sendErr == nil {
+				q.logDelivery(queueItem, channel, status, "")
+				break
+			}
+			q.logDelivery(queueItem, channel, model.DeliveryStatusFailed, model.DeliveryErrorInternal)
+			errorCode = model.DeliveryErrorInternal
+			status = model.DeliveryStatusFailed
+		}
+	}
+
+	//update the message delivery summary
+	summaryDelta := model.DeliverySummary{Sent: 1}
+	var recipientErrorCode *string
+	if sendErr == nil && status != model.DeliveryStatusFailed {
+		q.logger.Infof("queue item(%s:%s) has been sent as %s to user %s", queueItem.ID, queueItem.Subject, status, queueItem.UserID)
+		summaryDelta.Delivered = 1
+	} else {
+		q.logger.Errorf("error sending the fallback for queue item(%s) to user %s - %v", queueItem.ID, queueItem.UserID, sendErr)
+		summaryDelta.Failed = 1
+		summaryDelta.Errors = map[string]int{errorCode: 1}
+		status = model.DeliveryStatusFailed
+		recipientErrorCode = &errorCode
+	}
+	err = q.storage.IncrementMessageDeliverySummaryWithContext(context.Background(), queueItem.OrgID, queueItem.AppID, queueItem.MessageID, summaryDelta)
+	if err != nil {
+		q.logger.Errorf("error on updating the delivery summary for message %s - %s", queueItem.MessageID, err)
+	}
+
+	//set the recipient delivery status
+	err = q.storage.UpdateMessageRecipientDeliveryStatus(queueItem.OrgID, queueItem.AppID, queueItem.MessageRecipientID, status, recipientErrorCode)
+	if err != nil {
+		q.logger.Errorf("error on updating the delivery status for recipient %s - %s", queueItem.MessageRecipientID, err)
+	}
+}
+
+// onInvalidToken counts the failure and removes the token once it has failed too many times.
+// The token is not removed on the first failure as the device may be offline temporarily.
+func (q queueLogic) onInvalidToken(queueItem model.QueueItem, token string) {
+	failures, err := q.storage.IncrementDeviceTokenFailures(queueItem.OrgID, queueItem.AppID, queueItem.UserID, token)
+	if err != nil {
+		q.logger.Errorf("error on counting the failures for token (%s) - %s", token, err)
+		return
+	}
+
+	if q.tokenFailuresLimit <= 0 || failures < q.tokenFailuresLimit {
+		return
+	}
+
+	err = q.storage.RemoveDeviceToken(queueItem.OrgID, queueItem.AppID, queueItem.UserID, token)
+	if err != nil {
+		q.logger.Errorf("error on removing token (%s) - %s", token, err)
+		return
 	}
+	q.logger.Infof("token (%s) of user %s has been removed after %d failures", token, queueItem.UserID, failures)
 }
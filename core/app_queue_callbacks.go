@@ -0,0 +1,183 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"encoding/json"
+	"notifications/core/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	callbacksPeriod     = 30 * time.Second //how often the messages pending a delivery receipt are checked
+	callbacksBatchSize  = 100              //how many messages are loaded at once
+	callbackSettleDelay = time.Minute      //wait after the dispatching has ended so that the in-flight sends record their results
+)
+
+// startCallbacks starts the worker which posts the delivery receipts of the dispatched messages to their callback urls
+func (q queueLogic) startCallbacks() {
+	q.logger.Info("queueLogic startCallbacks")
+
+	go func() {
+		ticker := time.NewTicker(callbacksPeriod)
+		for range ticker.C {
+			q.processCallbacks()
+		}
+	}()
+}
+
+func (q queueLogic) processCallbacks() {
+	offset := 0
+	for {
+		now := time.Now().UTC()
+		messages, err := q.storage.FindMessagesPendingCallback(now, offset, callbacksBatchSize)
+		if err != nil {
+			q.logger.Errorf("error on finding the messages pending a callback - %s", err)
+			return
+		}
+
+		for _, message := range messages {
+			if !q.processCallback(message, now) {
+				offset++ //still pending, so it is in the next batch too
+			}
+		}
+
+		if len(messages) < callbacksBatchSize {
+			return //no more pending messages
+		}
+	}
+}
+
+// processCallback sends the delivery receipt of the message if its dispatching has ended. It gives false if the message is still pending.
+func (q queueLogic) processCallback(message model.Message, now time.Time) bool {
+	if message.IsPendingApproval() {
+		return false //nothing is dispatched until it is approved
+	}
+
+	//the dispatching has ended when the message has neither queue items nor push retries
+	queued, err := q.storage.CountQueueDataForMessage(message.ID)
+	if err != nil {
+		q.logger.Errorf("error on counting the queue data for message %s - %s", message.ID, err)
+		return false
+	}
+	retries, err := q.storage.CountPushRetriesForMessage(message.ID)
+	if err != nil {
+		q.logger.Errorf("error on counting the push retries for message %s - %s", message.ID, err)
+		return false
+	}
+	if queued > 0 || retries > 0 {
+		return false
+	}
+
+	if message.DateCallbackDue == nil {
+		err = q.storage.SetMessageCallbackDue(message.ID, now.Add(callbackSettleDelay))
+		if err != nil {
+			q.logger.Errorf("error on setting the callback due time for message %s - %s", message.ID, err)
+		}
+		return false
+	}
+	if now.Before(*message.DateCallbackDue) {
+		return false
+	}
+
+	//make sure that no other instance sends it too
+	claimed, err := q.storage.ClaimMessageCallback(message.ID, now)
+	if err != nil {
+		q.logger.Errorf("error on claiming the callback for message %s - %s", message.ID, err)
+		return false
+	}
+	if claimed {
+		q.sendCallback(message, now)
+	}
+	return true
+}
+
+// sendCallback posts the delivery receipt of the message. A failed callback is retried with the push retries.
+func (q queueLogic) sendCallback(message model.Message, now time.Time) {
+	recipients, err := q.storage.FindMessagesRecipientsByMessages(message.OrgID, message.AppID, []string{message.ID})
+	if err != nil {
+		q.logger.Errorf("error on finding the recipients for the callback of message %s - %s", message.ID, err)
+		return
+	}
+	payload, err := json.Marshal(model.NewDeliveryReceipt(message, recipients, now))
+	if err != nil {
+		q.logger.Errorf("error on marshalling the delivery receipt of message %s - %s", message.ID, err)
+		return
+	}
+
+	err = q.webhook.SendCallback(*message.CallbackURL, payload)
+	if err == nil {
+		return
+	}
+	q.logger.Errorf("error on sending the callback for message %s - %s", message.ID, err)
+
+	if q.retryMaxAttempts <= 1 {
+		return //the retries are disabled
+	}
+	retry := model.NewCallbackRetry(message, payload, uuid.NewString(), q.retryBase, now)
+	err = q.storage.InsertPushRetry(retry)
+	if err != nil {
+		q.logger.Errorf("error on inserting the callback retry for message %s - %s", message.ID, err)
+	}
+}
+
+// sendSubscriptionEvent posts the topic subscription change to the subscription webhook. A failed post is retried with the push retries.
+func (q queueLogic) sendSubscriptionEvent(url string, event model.SubscriptionEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		q.logger.Errorf("error on marshalling the subscription event of user %s - %s", event.UserID, err)
+		return
+	}
+
+	err = q.webhook.SendCallback(url, payload)
+	if err == nil {
+		return
+	}
+	q.logger.Errorf("error on sending the %s event of user %s for topic %s - %s", event.Action, event.UserID, event.Topic, err)
+
+	if q.retryMaxAttempts <= 1 {
+		return //the retries are disabled
+	}
+	retry := model.NewWebhookRetry(event.OrgID, event.AppID, url, payload, uuid.NewString(), q.retryBase, event.Date)
+	err = q.storage.InsertPushRetry(retry)
+	if err != nil {
+		q.logger.Errorf("error on inserting the subscription event retry of user %s - %s", event.UserID, err)
+	}
+}
+
+// processCallbackRetry sends again a failed delivery receipt. It is scheduled again with a longer wait until the max attempts are reached.
+func (q queueLogic) processCallbackRetry(retry model.PushRetry, now time.Time) {
+	err := q.webhook.SendCallback(retry.CallbackURL, retry.CallbackPayload)
+	retry.Attempts++
+	if err != nil {
+		q.logger.Errorf("error on retrying the callback %s for message %s - %s", retry.ID, retry.MessageID, err)
+		if retry.Attempts < q.retryMaxAttempts {
+			retry.NextAttempt = now.Add(model.RetryBackoff(q.retryBase, retry.Attempts))
+			err = q.storage.UpdatePushRetry(retry)
+			if err != nil {
+				q.logger.Errorf("error on rescheduling the callback retry %s - %s", retry.ID, err)
+			}
+			return
+		}
+		q.logger.Infof("callback retry %s for message %s gave up after %d attempts", retry.ID, retry.MessageID, retry.Attempts)
+	}
+
+	err = q.storage.DeletePushRetry(retry.ID)
+	if err != nil {
+		q.logger.Errorf("error on deleting the callback retry %s - %s", retry.ID, err)
+	}
+}
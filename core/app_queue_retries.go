@@ -0,0 +1,160 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"errors"
+	"notifications/core/model"
+	"time"
+)
+
+const (
+	pushRetriesPeriod    = 30 * time.Second //how often the due push retries are processed
+	pushRetriesBatchSize = 100              //how many push retries are loaded at once
+	pushRetryLease       = 5 * time.Minute  //a claimed retry is not processed by another instance within this time
+
+	defaultPushRetryBaseSeconds = 30
+	defaultPushRetryMaxAttempts = 5
+)
+
+// startRetries starts the worker which sends again the pushes failed with a transient error
+func (q queueLogic) startRetries() {
+	if q.retryMaxAttempts <= 1 {
+		q.logger.Info("queueLogic push retries are disabled")
+		return
+	}
+	q.logger.Info("queueLogic startRetries")
+
+	go func() {
+		ticker := time.NewTicker(pushRetriesPeriod)
+		for range ticker.C {
+			q.processRetries()
+		}
+	}()
+}
+
+func (q queueLogic) processRetries() {
+	for {
+		now := time.Now().UTC()
+		retries, err := q.storage.FindPushRetriesDue(now, pushRetriesBatchSize)
+		if err != nil {
+			q.logger.Errorf("error on finding the due push retries - %s", err)
+			return
+		}
+
+		for _, retry := range retries {
+			//make sure that no other instance sends it too
+			claimed, err := q.storage.ClaimPushRetry(retry.ID, retry.NextAttempt, now.Add(pushRetryLease))
+			if err != nil {
+				q.logger.Errorf("error on claiming the push retry %s - %s", retry.ID, err)
+				continue
+			}
+			if !claimed {
+				continue
+			}
+			q.processRetry(retry, now)
+		}
+
+		if len(retries) < pushRetriesBatchSize {
+			return //no more due retries
+		}
+	}
+}
+
+// processRetry sends the retry to its remaining tokens. It is scheduled again with a longer wait while some tokens fail
+// with a transient error and the max attempts are not reached.
+func (q queueLogic) processRetry(retry model.PushRetry, now time.Time) {
+	if retry.Kind == model.PushRetryKindCallback {
+		q.processCallbackRetry(retry, now)
+		return
+	}
+
+	//the stale pushes are not sent anymore
+	if retry.ExpiresAt != nil && !now.Before(*retry.ExpiresAt) {
+		q.logger.Infof("push retry %s for recipient %s dropped as the message has expired", retry.ID, retry.MessageRecipientID)
+		q.endRetry(retry, false)
+		return
+	}
+
+	//the recipient for the token failures and the delivery log
+	item := model.QueueItem{OrgID: retry.OrgID, AppID: retry.AppID, UserID: retry.UserID, MessageID: retry.MessageID, MessageRecipientID: retry.MessageRecipientID}
+
+	delivered := false
+	remainingTokens := []string{}
+	response, err := q.firebase.SendNotificationToTokens(retry.OrgID, retry.AppID, retry.Tokens, retry.Subject, retry.Body, retry.Sound, retry.Badge, retry.Priority, retry.CollapseKey, retry.ImageURL, retry.AndroidChannelID, retry.Data)
+	if err != nil {
+		q.logger.Errorf("error on retrying the push %s - %s", retry.ID, err)
+		retry.LastErrorCode = model.GetDeliveryErrorCode(err)
+		if model.IsRetryableDeliveryError(err) {
+			remainingTokens = retry.Tokens
+		}
+	} else {
+		for i, token := range retry.Tokens {
+			var sendErr error
+			if i < len(response.Errors) {
+				sendErr = response.Errors[i]
+			}
+			if sendErr == nil {
+				delivered = true
+				continue
+			}
+			retry.LastErrorCode = model.GetDeliveryErrorCode(sendErr)
+			if model.IsRetryableDeliveryError(sendErr) {
+				remainingTokens = append(remainingTokens, token)
+			} else if errors.Is(sendErr, model.ErrInvalidDeviceToken) {
+				q.onInvalidToken(item, token)
+			}
+		}
+	}
+	retry.Attempts++
+	if delivered {
+		q.logDelivery(item, model.DeliveryChannelPush, model.DeliveryStatusDelivered, "")
+	} else {
+		q.logDelivery(item, model.DeliveryChannelPush, model.DeliveryStatusFailed, retry.LastErrorCode)
+	}
+
+	//the push has reached at least one device
+	if delivered && retry.RecordResult {
+		q.recordDelivery(retry.OrgID, retry.AppID, retry.MessageID, retry.MessageRecipientID, true, "")
+		retry.RecordResult = false
+	}
+
+	if len(remainingTokens) > 0 && retry.Attempts < q.retryMaxAttempts {
+		retry.Tokens = remainingTokens
+		retry.NextAttempt = now.Add(model.RetryBackoff(q.retryBase, retry.Attempts))
+		err = q.storage.UpdatePushRetry(retry)
+		if err != nil {
+			q.logger.Errorf("error on rescheduling the push retry %s - %s", retry.ID, err)
+		}
+		return
+	}
+
+	if len(remainingTokens) > 0 {
+		q.logger.Infof("push retry %s for recipient %s gave up after %d attempts", retry.ID, retry.MessageRecipientID, retry.Attempts)
+	}
+	q.endRetry(retry, delivered)
+}
+
+// endRetry removes the retry and records the recipient failure if it has not been recorded yet
+func (q queueLogic) endRetry(retry model.PushRetry, delivered bool) {
+	if retry.RecordResult && !delivered {
+		q.recordDelivery(retry.OrgID, retry.AppID, retry.MessageID, retry.MessageRecipientID, false, retry.LastErrorCode)
+	}
+
+	err := q.storage.DeletePushRetry(retry.ID)
+	if err != nil {
+		q.logger.Errorf("error on deleting the push retry %s - %s", retry.ID, err)
+	}
+}
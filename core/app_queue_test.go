@@ -0,0 +1,385 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"notifications/core/model"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+func TestRecordDeliveryByApp(t *testing.T) {
+	tests := []struct {
+		name       string
+		orgID      string
+		appID      string
+		wantUpdate bool
+	}{
+		{"app", "org", "app", true},
+		{"other app", "org", "other", false},
+		{"other org", "other", "app", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage(model.Message{OrgID: "org", AppID: "app", ID: "message"})
+			storage.recipients = []model.MessageRecipient{{OrgID: "org", AppID: "app", ID: "recipient", MessageID: "message", UserID: "u1"}}
+			q := queueLogic{logger: logs.NewLogger("notifications", nil), storage: storage}
+
+			q.recordDelivery(tt.orgID, tt.appID, "message", "recipient", true, "")
+
+			_, summaryUpdated := storage.summaryDeltas["message"]
+			_, statusUpdated := storage.deliveryStatuses["recipient"]
+			if summaryUpdated != tt.wantUpdate || statusUpdated != tt.wantUpdate {
+				t.Errorf("recordDelivery() updated the summary %t and the status %t, want %t", summaryUpdated, statusUpdated, tt.wantUpdate)
+			}
+		})
+	}
+}
+
+func TestRecordDeliveryConcurrent(t *testing.T) {
+	storage := newFakeStorage(model.Message{OrgID: "org", AppID: "app", ID: "message"})
+	q := queueLogic{logger: logs.NewLogger("notifications", nil), storage: storage}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(delivered bool) {
+			defer wg.Done()
+			q.recordDelivery("org", "app", "message", "", delivered, model.DeliveryErrorInternal)
+		}(i%4 != 0)
+	}
+	wg.Wait()
+
+	want := model.DeliverySummary{Sent: 100, Delivered: 75, Failed: 25}
+	if got := storage.summaryDeltas["message"]; got.Sent != want.Sent || got.Delivered != want.Delivered || got.Failed != want.Failed {
+		t.Errorf("recordDelivery() summary = %+v, want %+v", got, want)
+	}
+}
+
+func TestOnTokenSentInvalidTokenGrace(t *testing.T) {
+	invalid := fmt.Errorf("send: %w", model.ErrInvalidDeviceToken)
+	item := model.QueueItem{OrgID: "org", AppID: "app", ID: "item", MessageID: "message", UserID: "u1"}
+
+	tests := []struct {
+		name        string
+		results     []error
+		wantRemoved bool
+		wantCount   int
+	}{
+		{"failures under the limit", []error{invalid, invalid}, false, 2},
+		{"failures reach the limit", []error{invalid, invalid, invalid}, true, 0},
+		{"success resets the failures", []error{invalid, invalid, nil, invalid, invalid}, false, 2},
+		{"other errors are not counted", []error{invalid, errors.New("unavailable"), invalid}, false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.users = []model.User{{OrgID: "org", AppID: "app", UserID: "u1", DeviceTokens: []model.DeviceToken{{Token: "token"}}}}
+			q := queueLogic{logger: logs.NewLogger("notifications", nil), storage: storage, tokenFailuresLimit: 3}
+
+			for _, result := range tt.results {
+				user, _ := storage.FindUserByID("org", "app", "u1")
+				for _, deviceToken := range user.DeviceTokens {
+					q.onTokenSent(&queueItemSend{item: item}, deviceToken, result)
+				}
+			}
+
+			user, _ := storage.FindUserByID("org", "app", "u1")
+			if removed := len(user.DeviceTokens) == 0; removed != tt.wantRemoved {
+				t.Fatalf("onTokenSent() removed the token %t, want %t", removed, tt.wantRemoved)
+			}
+			if !tt.wantRemoved && user.DeviceTokens[0].FailuresCount != tt.wantCount {
+				t.Errorf("onTokenSent() failures count = %d, want %d", user.DeviceTokens[0].FailuresCount, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestProcessQueueItemActiveSession(t *testing.T) {
+	now := time.Now().UTC()
+	recently := now.Add(-10 * time.Minute)
+	longAgo := now.Add(-2 * time.Hour)
+	deadline := now.Add(time.Hour)
+	passedDeadline := now.Add(-time.Minute)
+	activeWithin := 30
+
+	storage := newFakeStorage()
+	storage.users = []model.User{
+		{OrgID: "org", AppID: "app", UserID: "active", DateLastActive: &recently},
+		{OrgID: "org", AppID: "app", UserID: "inactive", DateLastActive: &longAgo},
+		{OrgID: "org", AppID: "app", UserID: "never"},
+	}
+	q := queueLogic{logger: logs.NewLogger("notifications", nil), storage: storage}
+
+	item := func(id string, userID string, activeWithinMinutes *int, activeDeadline *time.Time) model.QueueItem {
+		return model.QueueItem{OrgID: "org", AppID: "app", ID: id, MessageID: "message", MessageRecipientID: id, UserID: userID,
+			ActiveWithinMinutes: activeWithinMinutes, ActiveDeadline: activeDeadline}
+	}
+	err := q.processQueueItem([]model.QueueItem{
+		item("active", "active", &activeWithin, nil),
+		item("inactive dropped", "inactive", &activeWithin, nil),
+		item("inactive deferred", "inactive", &activeWithin, &deadline),
+		item("inactive past deadline", "inactive", &activeWithin, &passedDeadline),
+		item("never active deferred", "never", &activeWithin, &deadline),
+		item("no gate", "inactive", nil, nil),
+	})
+	if err != nil {
+		t.Fatalf("processQueueItem() error = %v", err)
+	}
+
+	wantDeleted := []string{"active", "inactive dropped", "inactive past deadline", "no gate"}
+	if !reflect.DeepEqual(storage.deletedQueueItems, wantDeleted) {
+		t.Errorf("processQueueItem() removed %v from the queue, want %v", storage.deletedQueueItems, wantDeleted)
+	}
+	wantDeferred := []string{"inactive deferred", "never active deferred"}
+	if !reflect.DeepEqual(storage.deferredQueueItems, wantDeferred) {
+		t.Errorf("processQueueItem() deferred %v, want %v", storage.deferredQueueItems, wantDeferred)
+	}
+	//the users have no tokens, so only the items which are sent get the no token status
+	for _, id := range []string{"active", "no gate"} {
+		if storage.deliveryStatuses[id] != model.DeliveryStatusNoToken {
+			t.Errorf("processQueueItem() %s status = %s, want it sent", id, storage.deliveryStatuses[id])
+		}
+	}
+	if len(storage.deliveryStatuses) != 2 {
+		t.Errorf("processQueueItem() statuses = %v, want only the sent items", storage.deliveryStatuses)
+	}
+}
+
+func TestProcessQueueItemDisabledTokens(t *testing.T) {
+	storage := newFakeStorage()
+	storage.users = []model.User{
+		{OrgID: "org", AppID: "app", UserID: "u1", DeviceTokens: []model.DeviceToken{
+			{Token: "phone"},
+			{Token: "tablet", NotificationsDisabled: true},
+		}},
+		{OrgID: "org", AppID: "app", UserID: "u2", DeviceTokens: []model.DeviceToken{
+			{Token: "laptop", NotificationsDisabled: true},
+		}},
+	}
+	firebase := &fakeFirebase{sent: make(chan []string, 1)}
+	q := queueLogic{logger: logs.NewLogger("notifications", nil), storage: storage, firebase: firebase, sendSlots: make(chan struct{}, 1)}
+
+	item := func(id string, userID string) model.QueueItem {
+		return model.QueueItem{OrgID: "org", AppID: "app", ID: id, MessageID: "message", MessageRecipientID: id, UserID: userID,
+			Subject: "subject", Body: "body", PerDevice: true}
+	}
+	err := q.processQueueItem([]model.QueueItem{item("r1", "u1"), item("r2", "u2")})
+	if err != nil {
+		t.Fatalf("processQueueItem() error = %v", err)
+	}
+
+	select {
+	case tokens := <-firebase.sent:
+		if !reflect.DeepEqual(tokens, []string{"phone"}) {
+			t.Errorf("processQueueItem() sent to %v, want [phone]", tokens)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("processQueueItem() has not sent the push")
+	}
+	//the user who disabled all the devices is still a recipient
+	if storage.deliveryStatuses["r2"] != model.DeliveryStatusDisabled {
+		t.Errorf("processQueueItem() r2 status = %s, want %s", storage.deliveryStatuses["r2"], model.DeliveryStatusDisabled)
+	}
+	if !reflect.DeepEqual(storage.deletedQueueItems, []string{"r1", "r2"}) {
+		t.Errorf("processQueueItem() removed %v from the queue, want [r1 r2]", storage.deletedQueueItems)
+	}
+}
+
+// slowAPNs takes the same time for every send as a remote call would
+type slowAPNs struct {
+	delay time.Duration
+}
+
+func (a slowAPNs) SendNotificationToToken(orgID string, appID string, deviceToken string, title string, body string, sound string, badge *int, collapseKey string, data map[string]string) error {
+	time.Sleep(a.delay)
+	return nil
+}
+
+// failingAPNs rejects every token as unregistered
+type failingAPNs struct{}
+
+func (a failingAPNs) SendNotificationToToken(orgID string, appID string, deviceToken string, title string, body string, sound string, badge *int, collapseKey string, data map[string]string) error {
+	return fmt.Errorf("%w: unregistered", model.ErrInvalidDeviceToken)
+}
+
+func TestSendNotificationsDeliveryLog(t *testing.T) {
+	storage := newFakeStorage()
+	firebase := &fakeFirebase{sent: make(chan []string, 1)}
+	q := queueLogic{logger: logs.NewLogger("notifications", nil), storage: storage, firebase: firebase, apns: failingAPNs{},
+		sendSlots: make(chan struct{}, 2)}
+
+	item := func(id string, userID string) model.QueueItem {
+		return model.QueueItem{OrgID: "org", AppID: "app", ID: id, MessageID: "message", MessageRecipientID: id, UserID: userID, Subject: "subject", Body: "body"}
+	}
+	q.sendNotifications([]*queueItemSend{
+		{item: item("r1", "u1"), tokens: []model.DeviceToken{{Token: "t1"}}},
+		{item: item("r2", "u2"), tokens: []model.DeviceToken{{Token: "t2", TokenType: model.TokenTypeAPNs}}},
+	})
+
+	entries := map[string]string{}
+	for _, entry := range storage.deliveryLogs {
+		errorCode := ""
+		if entry.ErrorCode != nil {
+			errorCode = *entry.ErrorCode
+		}
+		if entry.MessageID != "message" || entry.Channel != model.DeliveryChannelPush || entry.DateCreated.IsZero() {
+			t.Errorf("sendNotifications() logged %+v, want a push entry of the message", entry)
+		}
+		entries[entry.UserID+"/"+entry.MessageRecipientID] = entry.Status + " " + errorCode
+	}
+	want := map[string]string{
+		"u1/r1": model.DeliveryStatusDelivered + " ",
+		"u2/r2": model.DeliveryStatusFailed + " " + model.GetDeliveryErrorCode(fmt.Errorf("%w: unregistered", model.ErrInvalidDeviceToken)),
+	}
+	if !reflect.DeepEqual(entries, want) || len(storage.deliveryLogs) != 2 {
+		t.Errorf("sendNotifications() logged %v, want %v", entries, want)
+	}
+}
+
+func BenchmarkSendNotifications(b *testing.B) {
+	tokens := make([]model.DeviceToken, 16)
+	for i := range tokens {
+		tokens[i] = model.DeviceToken{Token: fmt.Sprintf("token-%d", i), TokenType: model.TokenTypeAPNs}
+	}
+	item := model.QueueItem{OrgID: "org", AppID: "app", ID: "item", MessageID: "message", MessageRecipientID: "recipient", UserID: "u1", Subject: "subject", Body: "body"}
+
+	benchmarks := []struct {
+		name        string
+		concurrency int
+	}{
+		{"serial", 1},
+		{"parallel", defaultSendConcurrency},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			q := queueLogic{logger: logs.NewLogger("notifications", nil), storage: newFakeStorage(), apns: slowAPNs{delay: time.Millisecond},
+				sendSlots: make(chan struct{}, bm.concurrency)}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				q.sendNotifications([]*queueItemSend{{item: item, tokens: tokens}})
+			}
+		})
+	}
+}
+
+func TestMutedAndDisabledRecipients(t *testing.T) {
+	storage := newFakeStorage()
+	storage.users = []model.User{
+		{OrgID: "org", AppID: "app", UserID: "enabled", DeviceTokens: []model.DeviceToken{{Token: "t1"}}},
+		{OrgID: "org", AppID: "app", UserID: "muted", DeviceTokens: []model.DeviceToken{{Token: "t2"}}},
+		{OrgID: "org", AppID: "app", UserID: "disabled", NotificationsDisabled: true, DeviceTokens: []model.DeviceToken{{Token: "t3"}}},
+	}
+	firebase := &fakeFirebase{sent: make(chan []string, 1)}
+	app := newTestApplication(storage)
+	q := queueLogic{logger: app.logger, storage: storage, firebase: firebase, sendSlots: make(chan struct{}, 1)}
+
+	im := model.InputMessage{OrgID: "org", AppID: "app", Subject: "subject", Body: "body", Time: time.Now().UTC(),
+		InputRecipients: []model.MessageRecipient{{UserID: "enabled"}, {UserID: "muted", Mute: true}, {UserID: "disabled"}}}
+	_, err := app.sharedCreateMessages(context.Background(), []model.InputMessage{im}, false)
+	if err != nil {
+		t.Fatalf("sharedCreateMessages() error = %v", err)
+	}
+	//the message is listed for all of them
+	if len(storage.insertedRecipients) != 3 {
+		t.Errorf("sharedCreateMessages() stored %d recipients, want 3", len(storage.insertedRecipients))
+	}
+
+	err = q.processQueueItem(storage.insertedQueueItems)
+	if err != nil {
+		t.Fatalf("processQueueItem() error = %v", err)
+	}
+	select {
+	case tokens := <-firebase.sent:
+		if !reflect.DeepEqual(tokens, []string{"t1"}) {
+			t.Errorf("processQueueItem() sent to %v, want [t1]", tokens)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("processQueueItem() has not sent the push")
+	}
+	//no push is queued for the muted recipient, the disabled one is still a recipient
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	queued := []string{}
+	for _, item := range storage.insertedQueueItems {
+		queued = append(queued, item.UserID)
+		if item.UserID == "disabled" && storage.deliveryStatuses[item.MessageRecipientID] != model.DeliveryStatusDisabled {
+			t.Errorf("processQueueItem() disabled status = %s, want %s", storage.deliveryStatuses[item.MessageRecipientID], model.DeliveryStatusDisabled)
+		}
+	}
+	if !reflect.DeepEqual(queued, []string{"enabled", "disabled"}) {
+		t.Errorf("sharedCreateMessages() queued %v, want [enabled disabled]", queued)
+	}
+}
+
+// fakeWebhook records the posted payloads
+type fakeWebhook struct {
+	err      error
+	payloads map[string][]byte //by url
+}
+
+func (w *fakeWebhook) SendCallback(callbackURL string, payload []byte) error {
+	w.payloads[callbackURL] = payload
+	return w.err
+}
+
+func TestSendSubscriptionEvent(t *testing.T) {
+	url := "https://groups.example.com/subscriptions"
+	event := model.SubscriptionEvent{OrgID: "org", AppID: "app", UserID: "u1", Topic: "athletics", Action: model.SubscriptionActionSubscribe,
+		Date: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+
+	tests := []struct {
+		name             string
+		err              error
+		retryMaxAttempts int
+		wantRetries      int
+	}{
+		{"posted", nil, 3, 0},
+		{"failed", errors.New("connection refused"), 3, 1},
+		{"failed without retries", errors.New("connection refused"), 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			webhook := &fakeWebhook{err: tt.err, payloads: map[string][]byte{}}
+			q := queueLogic{logger: logs.NewLogger("notifications", nil), storage: storage, webhook: webhook,
+				retryMaxAttempts: tt.retryMaxAttempts, retryBase: time.Minute}
+
+			q.sendSubscriptionEvent(url, event)
+
+			var posted model.SubscriptionEvent
+			err := json.Unmarshal(webhook.payloads[url], &posted)
+			if err != nil || posted != event {
+				t.Errorf("sendSubscriptionEvent() posted %s, want %v - %v", webhook.payloads[url], event, err)
+			}
+			if len(storage.insertedRetries) != tt.wantRetries {
+				t.Fatalf("sendSubscriptionEvent() stored %d retries, want %d", len(storage.insertedRetries), tt.wantRetries)
+			}
+			for _, retry := range storage.insertedRetries {
+				if retry.Kind != model.PushRetryKindCallback || retry.CallbackURL != url || string(retry.CallbackPayload) != string(webhook.payloads[url]) {
+					t.Errorf("sendSubscriptionEvent() retry = %s %s %s, want the callback retry of the event", retry.Kind, retry.CallbackURL, retry.CallbackPayload)
+				}
+			}
+		})
+	}
+}
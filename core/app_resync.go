@@ -0,0 +1,76 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+// defaultResyncConcurrency is used when the resync concurrency is not configured
+const defaultResyncConcurrency = 10
+
+// sharedRunResync runs the topics subscriptions tasks with a bounded concurrency so that large resyncs stay within the FCM limits.
+// It logs the progress and the failed tasks, and gives the error of every task at the task index. The tasks must not log
+// through l as it is not safe for concurrent use.
+func (app *Application) sharedRunResync(l *logs.Log, operation string, tasks []func() error) []error {
+	concurrency := app.config.TopicsResyncConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultResyncConcurrency
+	}
+
+	total := int64(len(tasks))
+	progressStep := total / 10 //report every 10%
+	if progressStep == 0 {
+		progressStep = 1
+	}
+
+	errs := make([]error, len(tasks))
+	var processed int64
+	var failed int64
+	var logLock sync.Mutex
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		slots <- struct{}{} //wait for a free slot
+		wg.Add(1)
+		go func(i int, task func() error) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			errs[i] = task()
+			if errs[i] != nil {
+				atomic.AddInt64(&failed, 1)
+			}
+			done := atomic.AddInt64(&processed, 1)
+
+			logLock.Lock()
+			defer logLock.Unlock()
+			if errs[i] != nil {
+				l.Warnf("%s - %s", operation, errs[i])
+			}
+			if done%progressStep == 0 || done == total {
+				l.Infof("%s progress - %d/%d processed, %d failed", operation, done, total, atomic.LoadInt64(&failed))
+			}
+		}(i, task)
+	}
+	wg.Wait()
+
+	return errs
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"notifications/core/model"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+func TestSharedRunResyncConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		wantBound   int64
+	}{
+		{"configured", 3, 3},
+		{"serial", 1, 1},
+		{"default", 0, defaultResyncConcurrency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(newFakeStorage())
+			app.config = &model.Config{TopicsResyncConcurrency: tt.concurrency}
+			l := app.logger.NewLog("test", logs.RequestContext{})
+
+			var running int64
+			var maxRunning int64
+			tasks := make([]func() error, 40)
+			for i := range tasks {
+				i := i
+				tasks[i] = func() error {
+					current := atomic.AddInt64(&running, 1)
+					for {
+						max := atomic.LoadInt64(&maxRunning)
+						if current <= max || atomic.CompareAndSwapInt64(&maxRunning, max, current) {
+							break
+						}
+					}
+					time.Sleep(time.Millisecond)
+					atomic.AddInt64(&running, -1)
+
+					if i%5 == 0 {
+						return fmt.Errorf("task %d failed", i)
+					}
+					return nil
+				}
+			}
+
+			errs := app.sharedRunResync(l, "test resync", tasks)
+			if maxRunning > tt.wantBound {
+				t.Errorf("sharedRunResync() ran %d tasks at once, want at most %d", maxRunning, tt.wantBound)
+			}
+			for i, err := range errs {
+				if (err != nil) != (i%5 == 0) || (err != nil && err.Error() != fmt.Sprintf("task %d failed", i)) {
+					t.Errorf("sharedRunResync() error %d = %v, want the error of task %d only if it failed", i, err, i)
+				}
+			}
+		})
+	}
+}
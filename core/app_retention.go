@@ -0,0 +1,152 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"notifications/core/model"
+	"notifications/driven/storage"
+	"time"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+const messagesRetentionPeriod = 24 * time.Hour
+
+type retentionLogic struct {
+	logger *logs.Logger
+
+	storage Storage
+
+	retentionDays int //global messages retention, 0 means the messages are kept forever
+	ttlDays       int //the expired messages are deleted this many days after they expire, 0 means they follow the retention
+}
+
+func (r retentionLogic) start() {
+	r.logger.Info("retentionLogic start")
+
+	go func() {
+		r.processRetention()
+
+		ticker := time.NewTicker(messagesRetentionPeriod)
+		for range ticker.C {
+			r.processRetention()
+		}
+	}()
+}
+
+func (r retentionLogic) processRetention() {
+	r.logger.Info("retentionLogic processRetention")
+
+	//the topics which override the global retention
+	topics, err := r.storage.FindTopicsWithRetention()
+	if err != nil {
+		r.logger.Errorf("error on finding topics with retention - %s", err)
+		return
+	}
+
+	now := time.Now().UTC()
+
+	//the expired messages are deleted regardless of their topics
+	if r.ttlDays > 0 {
+		messages, err := r.storage.FindMessagesExpiredBefore(now.AddDate(0, 0, -r.ttlDays))
+		if err != nil {
+			r.logger.Errorf("error on finding the messages expired more than %d days ago - %s", r.ttlDays, err)
+		} else {
+			r.deleteMessages(messages)
+		}
+	}
+
+	for _, topic := range topics {
+		//the messages which are in another topic with longer retention are kept for it
+		var longerTopics []model.Topic
+		for _, other := range topics {
+			if other.OrgID == topic.OrgID && other.AppID == topic.AppID && *other.RetentionDays > *topic.RetentionDays {
+				longerTopics = append(longerTopics, other)
+			}
+		}
+
+		currentTopic := topic
+		before := now.AddDate(0, 0, -*topic.RetentionDays)
+		messages, err := r.storage.FindMessagesCreatedBefore(before, &currentTopic, longerTopics)
+		if err != nil {
+			r.logger.Errorf("error on finding expired messages for topic %s - %s", topic.Name, err)
+			continue
+		}
+		r.deleteMessages(messages)
+	}
+
+	//the global retention applies to the messages which are not in any of the topics above
+	if r.retentionDays <= 0 {
+		return
+	}
+	before := now.AddDate(0, 0, -r.retentionDays)
+	messages, err := r.storage.FindMessagesCreatedBefore(before, nil, topics)
+	if err != nil {
+		r.logger.Errorf("error on finding expired messages - %s", err)
+		return
+	}
+	r.deleteMessages(messages)
+}
+
+func (r retentionLogic) deleteMessages(messages []model.Message) {
+	if len(messages) == 0 {
+		return
+	}
+
+	//the messages are deleted by their org and app
+	messagesIDs := make([]string, len(messages))
+	appsMessages := map[string][]model.Message{}
+	appsKeys := []string{}
+	for i, message := range messages {
+		messagesIDs[i] = message.ID
+		appKey := message.OrgID + "_" + message.AppID
+		if _, ok := appsMessages[appKey]; !ok {
+			appsKeys = append(appsKeys, appKey)
+		}
+		appsMessages[appKey] = append(appsMessages[appKey], message)
+	}
+
+	transaction := func(context storage.TransactionContext) error {
+		//delete the messages
+		for _, appKey := range appsKeys {
+			appMessages := appsMessages[appKey]
+			appMessagesIDs := make([]string, len(appMessages))
+			for i, message := range appMessages {
+				appMessagesIDs[i] = message.ID
+			}
+			err := r.storage.DeleteMessagesWithContext(context, appMessages[0].OrgID, appMessages[0].AppID, appMessagesIDs)
+			if err != nil {
+				return err
+			}
+		}
+
+		//delete the messages recipients
+		err := r.storage.DeleteMessagesRecipientsForMessagesWithContext(context, messagesIDs)
+		if err != nil {
+			return err
+		}
+
+		//delete the queue data items
+		return r.storage.DeleteQueueDataForMessagesWithContext(context, messagesIDs)
+	}
+
+	err := r.storage.PerformTransaction(transaction, 2000)
+	if err != nil {
+		r.logger.Errorf("error on deleting expired messages - %s", err)
+		return
+	}
+
+	r.logger.Infof("%d expired messages deleted", len(messagesIDs))
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"notifications/core/model"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+func TestProcessRetentionTopics(t *testing.T) {
+	intPtr := func(value int) *int { return &value }
+	now := time.Now().UTC()
+	message := func(id string, ageDays int, topics ...string) model.Message {
+		created := now.AddDate(0, 0, -ageDays)
+		return model.Message{OrgID: "org", AppID: "app", ID: id, Topics: topics, DateCreated: &created}
+	}
+
+	storage := newFakeStorage(
+		message("chat old", 10, "chat"),
+		message("chat new", 3, "chat"),
+		message("alert old", 400, "alerts"),
+		message("alert kept", 100, "alerts"),
+		message("chat and alert", 100, "chat", "alerts"),
+		message("no topic old", 40),
+		message("no topic new", 20),
+		message("other topic old", 40, "news"),
+	)
+	storage.topics = []model.Topic{
+		{OrgID: "org", AppID: "app", Name: "chat", RetentionDays: intPtr(7)},
+		{OrgID: "org", AppID: "app", Name: "alerts", RetentionDays: intPtr(365)},
+		{OrgID: "org", AppID: "app", Name: "news"},
+	}
+	r := retentionLogic{logger: logs.NewLogger("notifications", nil), storage: storage, retentionDays: 30}
+
+	r.processRetention()
+
+	sort.Strings(storage.deletedMessages)
+	want := []string{"alert old", "chat old", "no topic old", "other topic old"}
+	if !reflect.DeepEqual(storage.deletedMessages, want) {
+		t.Errorf("processRetention() deleted %v, want %v", storage.deletedMessages, want)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+const tokensCleanupPeriod = 24 * time.Hour
+
+type tokensCleanupLogic struct {
+	logger *logs.Logger
+
+	storage Storage
+
+	staleDays int //the tokens not registered again for this many days are removed, 0 means they are kept
+
+	prunedTokens *atomic.Int64 //the tokens removed since the start
+}
+
+func (t tokensCleanupLogic) start() {
+	if t.staleDays <= 0 {
+		return
+	}
+	t.logger.Info("tokensCleanupLogic start")
+
+	go func() {
+		t.processCleanup()
+
+		ticker := time.NewTicker(tokensCleanupPeriod)
+		for range ticker.C {
+			t.processCleanup()
+		}
+	}()
+}
+
+func (t tokensCleanupLogic) processCleanup() {
+	before := time.Now().UTC().AddDate(0, 0, -t.staleDays)
+	tokens, users, err := t.storage.RemoveStaleDeviceTokens(before)
+	t.prunedTokens.Add(tokens)
+	if err != nil {
+		t.logger.Errorf("error on removing the device tokens not registered for %d days - %s", t.staleDays, err)
+		return
+	}
+
+	t.logger.Infof("%d stale device tokens removed, %d users without tokens deleted", tokens, users)
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"notifications/core/model"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+func TestProcessTokensCleanup(t *testing.T) {
+	now := time.Now().UTC()
+	daysAgo := func(days int) *time.Time {
+		date := now.AddDate(0, 0, -days)
+		return &date
+	}
+	deviceToken := func(token string, createdDaysAgo int, updated *time.Time) model.DeviceToken {
+		return model.DeviceToken{Token: token, DateCreated: *daysAgo(createdDaysAgo), DateUpdated: updated}
+	}
+
+	storage := newFakeStorage()
+	storage.users = []model.User{
+		{ID: "stale", UserID: "stale", DeviceTokens: []model.DeviceToken{deviceToken("never updated", 100, nil)}},
+		{ID: "registered again", UserID: "registered again", DeviceTokens: []model.DeviceToken{deviceToken("updated", 100, daysAgo(5))}},
+		{ID: "subscribed", UserID: "subscribed", Topics: []string{"news"}, DeviceTokens: []model.DeviceToken{deviceToken("stale update", 100, daysAgo(60))}},
+		{ID: "mixed", UserID: "mixed", DeviceTokens: []model.DeviceToken{deviceToken("old", 100, nil), deviceToken("new", 10, nil)}},
+		{ID: "no tokens", UserID: "no tokens"},
+	}
+	prunedTokens := &atomic.Int64{}
+	prunedTokens.Store(2) //removed by the earlier runs
+	cleanup := tokensCleanupLogic{logger: logs.NewLogger("notifications", nil), storage: storage, staleDays: 30, prunedTokens: prunedTokens}
+
+	cleanup.processCleanup()
+
+	if got := prunedTokens.Load(); got != 5 {
+		t.Errorf("processCleanup() pruned tokens = %d, want 5", got)
+	}
+	got := map[string][]string{}
+	for _, user := range storage.users {
+		tokens := []string{}
+		for _, deviceToken := range user.DeviceTokens {
+			tokens = append(tokens, deviceToken.Token)
+		}
+		got[user.UserID] = tokens
+	}
+	want := map[string][]string{"registered again": {"updated"}, "subscribed": {}, "mixed": {"new"}, "no tokens": {}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("processCleanup() users tokens = %v, want %v", got, want)
+	}
+}
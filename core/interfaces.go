@@ -27,28 +27,45 @@ import (
 // Services exposes APIs for the driver adapters
 type Services interface {
 	GetVersion() string
+	CheckReadiness() error
+	GetSendMetrics() (*model.SendMetrics, error)
+	GetPrunedTokensCount() int64
 	StoreToken(orgID string, appID string, tokenInfo *model.TokenInfo, userID string) error
 	SubscribeToTopic(orgID string, appID string, token string, userID string, anonymous bool, topic string) error
 	UnsubscribeToTopic(orgID string, appID string, token string, userID string, anonymous bool, topic string) error
-	GetTopics(orgID string, appID string) ([]model.Topic, error)
+	UnsubscribeFromAllTopics(l *logs.Log, orgID string, appID string, userID string, anonymous bool) ([]string, error)
+	GetTopicReach(orgID string, appID string, topic string) (*model.TopicReach, error)
+	UpdateTopicSubscriptions(l *logs.Log, orgID string, appID string, token string, userID string, anonymous bool, subscribe []string, unsubscribe []string) (*model.TopicSubscriptions, error)
+	GetTopics(orgID string, appID string, offset *int64, limit *int64, sortBy *string, order *string) ([]model.Topic, int64, error)
 	AppendTopic(*model.Topic) (*model.Topic, error)
 	UpdateTopic(*model.Topic) (*model.Topic, error)
 	FindUserByID(orgID string, appID string, userID string, l *logs.Log) (*model.User, error)
 	UpdateUserByID(orgID string, appID string, userID string, notificationsEnabled bool) (*model.User, error)
 	DeleteUserWithID(orgID string, appID string, userID string) error
-
-	GetMessagesRecipientsDeep(orgID string, appID string, userID *string, read *bool, mute *bool, messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, offset *int64, limit *int64, order *string) ([]model.MessageRecipient, error)
+	GetUserMutedTopics(orgID string, appID string, userID string, l *logs.Log) ([]string, error)
+	UpdateUserMutedTopics(orgID string, appID string, userID string, mutedTopics []string, l *logs.Log) ([]string, error)
+	GetUserSettings(orgID string, appID string, userID string, l *logs.Log) (*model.UserSettings, error)
+	UpdateUserSettings(orgID string, appID string, userID string, start *int, end *int, timeZone *string, notificationsEnabled *bool, pausedUntil *time.Time, l *logs.Log) (*model.User, error)
+	MuteTopic(orgID string, appID string, userID string, topic string, l *logs.Log) ([]string, error)
+	UnmuteTopic(orgID string, appID string, userID string, topic string, l *logs.Log) ([]string, error)
+	Heartbeat(orgID string, appID string, userID string, l *logs.Log) error
+	UpdateTokenPreferences(orgID string, appID string, userID string, token string, notificationsDisabled bool) error
+
+	GetMessagesRecipientsDeep(ctx context.Context, orgID string, appID string, userID *string, read *bool, mute *bool, messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, hasAttachment *bool, priority *int64, groupID *string, offset *int64, limit *int64, order *string, orderBy *string, fields []string) ([]model.MessageRecipient, int64, error)
+	GetUserMessagesSince(ctx context.Context, orgID string, appID string, userID string, sinceID string, limit int64, fields []string) ([]model.MessageRecipient, string, error)
 
 	GetMessagesStats(orgID string, appID string, userID string) (*model.MessagesStats, error)
 	GetMessage(orgID string, appID string, ID string) (*model.Message, error)
 	GetUserMessage(orgID string, appID string, ID string, accountID string) (*model.Message, error)
-	CreateMessage(inputMessage model.InputMessage) (*model.Message, error)
-	CreateMessages(inputMessages []model.InputMessage, isBatch bool) ([]model.Message, error)
+	CreateMessage(ctx context.Context, inputMessage model.InputMessage) (*model.Message, error)
+	CreateMessageAsync(ctx context.Context, inputMessage model.InputMessage) (*model.Message, error)
+	CreateMessages(ctx context.Context, inputMessages []model.InputMessage, isBatch bool) ([]model.Message, error)
 	UpdateMessage(userID *string, message *model.Message) (*model.Message, error)
 	DeleteUserMessage(orgID string, appID string, userID string, messageID string) error
 	DeleteMessage(orgID string, appID string, ID string) error
 	UpdateReadMessage(orgID string, appID string, ID string, userID string) (*model.Message, error)
-	UpdateAllUserMessagesRead(orgID string, appID string, userID string, read bool) error
+	UpdateAllUserMessagesRead(orgID string, appID string, userID string, messageIDs []string, read bool) (int64, error)
+	ReportMessage(l *logs.Log, orgID string, appID string, messageID string, userID string, reason string) error
 
 	GetAllAppVersions(orgID string, appID string) ([]model.AppVersion, error)
 	GetAllAppPlatforms(orgID string, appID string) ([]model.AppPlatform, error)
@@ -72,6 +89,18 @@ func (s *servicesImpl) GetVersion() string {
 	return s.app.getVersion()
 }
 
+func (s *servicesImpl) CheckReadiness() error {
+	return s.app.checkReadiness()
+}
+
+func (s *servicesImpl) GetSendMetrics() (*model.SendMetrics, error) {
+	return s.app.getSendMetrics()
+}
+
+func (s *servicesImpl) GetPrunedTokensCount() int64 {
+	return s.app.getPrunedTokensCount()
+}
+
 func (s *servicesImpl) StoreToken(orgID string, appID string, tokenInfo *model.TokenInfo, userID string) error {
 	return s.app.storeToken(orgID, appID, tokenInfo, userID)
 }
@@ -84,8 +113,20 @@ func (s *servicesImpl) UnsubscribeToTopic(orgID string, appID string, token stri
 	return s.app.unsubscribeToTopic(orgID, appID, token, userID, anonymous, topic)
 }
 
-func (s *servicesImpl) GetTopics(orgID string, appID string) ([]model.Topic, error) {
-	return s.app.getTopics(orgID, appID)
+func (s *servicesImpl) UnsubscribeFromAllTopics(l *logs.Log, orgID string, appID string, userID string, anonymous bool) ([]string, error) {
+	return s.app.unsubscribeFromAllTopics(l, orgID, appID, userID, anonymous)
+}
+
+func (s *servicesImpl) GetTopicReach(orgID string, appID string, topic string) (*model.TopicReach, error) {
+	return s.app.getTopicReach(orgID, appID, topic)
+}
+
+func (s *servicesImpl) UpdateTopicSubscriptions(l *logs.Log, orgID string, appID string, token string, userID string, anonymous bool, subscribe []string, unsubscribe []string) (*model.TopicSubscriptions, error) {
+	return s.app.updateTopicSubscriptions(l, orgID, appID, token, userID, anonymous, subscribe, unsubscribe)
+}
+
+func (s *servicesImpl) GetTopics(orgID string, appID string, offset *int64, limit *int64, sortBy *string, order *string) ([]model.Topic, int64, error) {
+	return s.app.getTopics(orgID, appID, offset, limit, sortBy, order)
 }
 
 func (s *servicesImpl) AppendTopic(topic *model.Topic) (*model.Topic, error) {
@@ -96,8 +137,12 @@ func (s *servicesImpl) UpdateTopic(topic *model.Topic) (*model.Topic, error) {
 	return s.app.updateTopic(topic)
 }
 
-func (s *servicesImpl) GetMessagesRecipientsDeep(orgID string, appID string, userID *string, read *bool, mute *bool, messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, offset *int64, limit *int64, order *string) ([]model.MessageRecipient, error) {
-	return s.app.getMessagesRecipientsDeep(orgID, appID, userID, read, mute, messageIDs, startDateEpoch, endDateEpoch, filterTopic, offset, limit, order)
+func (s *servicesImpl) GetMessagesRecipientsDeep(ctx context.Context, orgID string, appID string, userID *string, read *bool, mute *bool, messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, hasAttachment *bool, priority *int64, groupID *string, offset *int64, limit *int64, order *string, orderBy *string, fields []string) ([]model.MessageRecipient, int64, error) {
+	return s.app.getMessagesRecipientsDeep(ctx, orgID, appID, userID, read, mute, messageIDs, startDateEpoch, endDateEpoch, filterTopic, hasAttachment, priority, groupID, offset, limit, order, orderBy, fields)
+}
+
+func (s *servicesImpl) GetUserMessagesSince(ctx context.Context, orgID string, appID string, userID string, sinceID string, limit int64, fields []string) ([]model.MessageRecipient, string, error) {
+	return s.app.getUserMessagesSince(ctx, orgID, appID, userID, sinceID, limit, fields)
 }
 
 func (s *servicesImpl) GetMessagesStats(orgID string, appID string, userID string) (*model.MessagesStats, error) {
@@ -112,12 +157,16 @@ func (s *servicesImpl) GetUserMessage(orgID string, appID string, ID string, acc
 	return s.app.getUserMessage(orgID, appID, ID, accountID)
 }
 
-func (s *servicesImpl) CreateMessage(inputMessage model.InputMessage) (*model.Message, error) {
-	return s.app.createMessage(inputMessage)
+func (s *servicesImpl) CreateMessage(ctx context.Context, inputMessage model.InputMessage) (*model.Message, error) {
+	return s.app.createMessage(ctx, inputMessage)
+}
+
+func (s *servicesImpl) CreateMessageAsync(ctx context.Context, inputMessage model.InputMessage) (*model.Message, error) {
+	return s.app.createMessageAsync(ctx, inputMessage)
 }
 
-func (s *servicesImpl) CreateMessages(inputMessages []model.InputMessage, isBatch bool) ([]model.Message, error) {
-	return s.app.createMessages(inputMessages, isBatch)
+func (s *servicesImpl) CreateMessages(ctx context.Context, inputMessages []model.InputMessage, isBatch bool) ([]model.Message, error) {
+	return s.app.createMessages(ctx, inputMessages, isBatch)
 }
 
 func (s *servicesImpl) UpdateMessage(userID *string, message *model.Message) (*model.Message, error) {
@@ -128,8 +177,12 @@ func (s *servicesImpl) UpdateReadMessage(orgID string, appID string, ID string,
 	return s.app.updateReadMessage(orgID, appID, ID, userID)
 }
 
-func (s *servicesImpl) UpdateAllUserMessagesRead(orgID string, appID string, userID string, read bool) error {
-	return s.app.updateAllUserMessagesRead(orgID, appID, userID, read)
+func (s *servicesImpl) UpdateAllUserMessagesRead(orgID string, appID string, userID string, messageIDs []string, read bool) (int64, error) {
+	return s.app.updateAllUserMessagesRead(orgID, appID, userID, messageIDs, read)
+}
+
+func (s *servicesImpl) ReportMessage(l *logs.Log, orgID string, appID string, messageID string, userID string, reason string) error {
+	return s.app.reportMessage(l, orgID, appID, messageID, userID, reason)
 }
 
 func (s *servicesImpl) DeleteUserMessage(orgID string, appID string, userID string, messageID string) error {
@@ -160,6 +213,38 @@ func (s *servicesImpl) DeleteUserWithID(orgID string, appID string, userID strin
 	return s.app.deleteUserWithID(orgID, appID, userID)
 }
 
+func (s *servicesImpl) GetUserMutedTopics(orgID string, appID string, userID string, l *logs.Log) ([]string, error) {
+	return s.app.getUserMutedTopics(orgID, appID, userID, l)
+}
+
+func (s *servicesImpl) UpdateUserMutedTopics(orgID string, appID string, userID string, mutedTopics []string, l *logs.Log) ([]string, error) {
+	return s.app.updateUserMutedTopics(orgID, appID, userID, mutedTopics, l)
+}
+
+func (s *servicesImpl) GetUserSettings(orgID string, appID string, userID string, l *logs.Log) (*model.UserSettings, error) {
+	return s.app.getUserSettings(orgID, appID, userID, l)
+}
+
+func (s *servicesImpl) UpdateUserSettings(orgID string, appID string, userID string, start *int, end *int, timeZone *string, notificationsEnabled *bool, pausedUntil *time.Time, l *logs.Log) (*model.User, error) {
+	return s.app.updateUserSettings(orgID, appID, userID, start, end, timeZone, notificationsEnabled, pausedUntil, l)
+}
+
+func (s *servicesImpl) MuteTopic(orgID string, appID string, userID string, topic string, l *logs.Log) ([]string, error) {
+	return s.app.muteTopic(orgID, appID, userID, topic, l)
+}
+
+func (s *servicesImpl) UnmuteTopic(orgID string, appID string, userID string, topic string, l *logs.Log) ([]string, error) {
+	return s.app.unmuteTopic(orgID, appID, userID, topic, l)
+}
+
+func (s *servicesImpl) Heartbeat(orgID string, appID string, userID string, l *logs.Log) error {
+	return s.app.heartbeat(orgID, appID, userID, l)
+}
+
+func (s *servicesImpl) UpdateTokenPreferences(orgID string, appID string, userID string, token string, notificationsDisabled bool) error {
+	return s.app.updateTokenPreferences(orgID, appID, userID, token, notificationsDisabled)
+}
+
 func (s *servicesImpl) SendMail(toEmail string, subject string, body string) error {
 	return s.app.sendMail(toEmail, subject, body)
 }
@@ -190,20 +275,140 @@ func (s *servicesImpl) DeleteConfig(id string, claims *tokenauth.Claims) error {
 
 // Admin exposes APIs for the driver adapters
 type Admin interface {
-	AdminGetMessagesStats(orgID string, appID string, adminAccountID string, source string, offset *int64, limit *int64, order *string) (map[int][]interface{}, error)
+	AdminGetMessagesStats(ctx context.Context, orgID string, appID string, adminAccountID string, source string, sourceApp *string, includeDeleted bool, offset *int64, limit *int64, order *string, orderBy *string) (map[int][]interface{}, error)
+	AdminGetTopics(orgID string, appID string, offset *int64, limit *int64, sortBy *string, order *string) ([]model.Topic, int64, error)
+	AdminGetTopicSubscribers(orgID string, appID string, name string, offset *int64, limit *int64) ([]string, int64, error)
+	AdminGetTopicStats(orgID string, appID string, name string, startDate *int64, endDate *int64) (*model.TopicStats, error)
+	AdminRenameTopic(l *logs.Log, orgID string, appID string, name string, newName string) (*model.Topic, error)
+	AdminDeleteTopic(l *logs.Log, orgID string, appID string, name string, force bool) (*model.TopicDeletion, error)
+	AdminGetMessageStatus(orgID string, appID string, messageID string) (*model.MessageStatus, error)
+	AdminGetMessageDeliveries(orgID string, appID string, messageID string, offset *int64, limit *int64) ([]model.DeliveryLog, int64, error)
+	AdminGetMessage(orgID string, appID string, messageID string, includeDeleted bool) (*model.Message, error)
+	AdminGetTopicsAudience(orgID string, appID string, topics []string, mode string) (*model.TopicsAudience, error)
+	AdminPreviewMessageFor(inputMessage model.InputMessage, recipient model.MessageRecipient) (*model.MessagePreview, error)
+	AdminApproveMessage(orgID string, appID string, messageID string, approver model.CoreAccountRef) (*model.Message, error)
+	AdminRecallMessage(orgID string, appID string, messageID string) (*model.Message, error)
+	AdminDeleteMessage(orgID string, appID string, messageID string) error
+	AdminRestoreMessage(orgID string, appID string, messageID string) (*model.Message, error)
+	AdminPurgeMessage(orgID string, appID string, messageID string) error
+	AdminResendMessage(orgID string, appID string, messageID string, onlyFailed bool, force bool) (*model.Message, error)
+	AdminCreateMessagesBulk(ctx context.Context, inputMessages []model.InputMessage) ([]model.BulkMessageResult, error)
+	AdminPreviewBroadcast(orgID string, appID string, subject string, body string) (*model.BroadcastPreview, error)
+	AdminBroadcastMessage(inputMessage model.InputMessage, confirmationToken string) (*model.Message, error)
+	AdminGetDeadTokens(orgID string, appID string, minFailures int) ([]model.DeadDeviceToken, error)
+	AdminPurgeDeadTokens(l *logs.Log, orgID string, appID string, minFailures int) ([]model.DeadDeviceToken, error)
+	AdminGetUser(orgID string, appID string, userID string) (*model.User, error)
+	AdminRemoveUserToken(l *logs.Log, orgID string, appID string, userID string, token string) (bool, error)
+	AdminUnsubscribeUserFromAllTopics(l *logs.Log, orgID string, appID string, userID string) ([]string, error)
 }
 
 type adminImpl struct {
 	app *Application
 }
 
-func (s *adminImpl) AdminGetMessagesStats(orgID string, appID string, adminAccountID string, source string, offset *int64, limit *int64, order *string) (map[int][]interface{}, error) {
-	return s.app.adminGetMessagesStats(orgID, appID, adminAccountID, source, offset, limit, order)
+func (s *adminImpl) AdminGetMessagesStats(ctx context.Context, orgID string, appID string, adminAccountID string, source string, sourceApp *string, includeDeleted bool, offset *int64, limit *int64, order *string, orderBy *string) (map[int][]interface{}, error) {
+	return s.app.adminGetMessagesStats(ctx, orgID, appID, adminAccountID, source, sourceApp, includeDeleted, offset, limit, order, orderBy)
+}
+
+func (s *adminImpl) AdminGetTopics(orgID string, appID string, offset *int64, limit *int64, sortBy *string, order *string) ([]model.Topic, int64, error) {
+	return s.app.adminGetTopics(orgID, appID, offset, limit, sortBy, order)
+}
+
+func (s *adminImpl) AdminGetTopicSubscribers(orgID string, appID string, name string, offset *int64, limit *int64) ([]string, int64, error) {
+	return s.app.adminGetTopicSubscribers(orgID, appID, name, offset, limit)
+}
+
+func (s *adminImpl) AdminGetTopicStats(orgID string, appID string, name string, startDate *int64, endDate *int64) (*model.TopicStats, error) {
+	return s.app.adminGetTopicStats(orgID, appID, name, startDate, endDate)
+}
+
+func (s *adminImpl) AdminRenameTopic(l *logs.Log, orgID string, appID string, name string, newName string) (*model.Topic, error) {
+	return s.app.adminRenameTopic(l, orgID, appID, name, newName)
+}
+
+func (s *adminImpl) AdminDeleteTopic(l *logs.Log, orgID string, appID string, name string, force bool) (*model.TopicDeletion, error) {
+	return s.app.adminDeleteTopic(l, orgID, appID, name, force)
+}
+
+func (s *adminImpl) AdminGetMessageStatus(orgID string, appID string, messageID string) (*model.MessageStatus, error) {
+	return s.app.adminGetMessageStatus(orgID, appID, messageID)
+}
+
+func (s *adminImpl) AdminGetMessageDeliveries(orgID string, appID string, messageID string, offset *int64, limit *int64) ([]model.DeliveryLog, int64, error) {
+	return s.app.adminGetMessageDeliveries(orgID, appID, messageID, offset, limit)
+}
+
+func (s *adminImpl) AdminGetMessage(orgID string, appID string, messageID string, includeDeleted bool) (*model.Message, error) {
+	return s.app.adminGetMessage(orgID, appID, messageID, includeDeleted)
+}
+
+func (s *adminImpl) AdminGetTopicsAudience(orgID string, appID string, topics []string, mode string) (*model.TopicsAudience, error) {
+	return s.app.adminGetTopicsAudience(orgID, appID, topics, mode)
+}
+
+func (s *adminImpl) AdminPreviewMessageFor(inputMessage model.InputMessage, recipient model.MessageRecipient) (*model.MessagePreview, error) {
+	return s.app.adminPreviewMessageFor(inputMessage, recipient)
+}
+
+func (s *adminImpl) AdminApproveMessage(orgID string, appID string, messageID string, approver model.CoreAccountRef) (*model.Message, error) {
+	return s.app.adminApproveMessage(orgID, appID, messageID, approver)
+}
+
+func (s *adminImpl) AdminRecallMessage(orgID string, appID string, messageID string) (*model.Message, error) {
+	return s.app.adminRecallMessage(orgID, appID, messageID)
+}
+
+func (s *adminImpl) AdminDeleteMessage(orgID string, appID string, messageID string) error {
+	return s.app.adminDeleteMessage(orgID, appID, messageID)
+}
+
+func (s *adminImpl) AdminRestoreMessage(orgID string, appID string, messageID string) (*model.Message, error) {
+	return s.app.adminRestoreMessage(orgID, appID, messageID)
+}
+
+func (s *adminImpl) AdminPurgeMessage(orgID string, appID string, messageID string) error {
+	return s.app.adminPurgeMessage(orgID, appID, messageID)
+}
+
+func (s *adminImpl) AdminResendMessage(orgID string, appID string, messageID string, onlyFailed bool, force bool) (*model.Message, error) {
+	return s.app.adminResendMessage(orgID, appID, messageID, onlyFailed, force)
+}
+
+func (s *adminImpl) AdminCreateMessagesBulk(ctx context.Context, inputMessages []model.InputMessage) ([]model.BulkMessageResult, error) {
+	return s.app.adminCreateMessagesBulk(ctx, inputMessages)
+}
+
+func (s *adminImpl) AdminPreviewBroadcast(orgID string, appID string, subject string, body string) (*model.BroadcastPreview, error) {
+	return s.app.adminPreviewBroadcast(orgID, appID, subject, body)
+}
+
+func (s *adminImpl) AdminBroadcastMessage(inputMessage model.InputMessage, confirmationToken string) (*model.Message, error) {
+	return s.app.adminBroadcastMessage(inputMessage, confirmationToken)
+}
+
+func (s *adminImpl) AdminGetDeadTokens(orgID string, appID string, minFailures int) ([]model.DeadDeviceToken, error) {
+	return s.app.adminGetDeadTokens(orgID, appID, minFailures)
+}
+
+func (s *adminImpl) AdminPurgeDeadTokens(l *logs.Log, orgID string, appID string, minFailures int) ([]model.DeadDeviceToken, error) {
+	return s.app.adminPurgeDeadTokens(l, orgID, appID, minFailures)
+}
+
+func (s *adminImpl) AdminGetUser(orgID string, appID string, userID string) (*model.User, error) {
+	return s.app.adminGetUser(orgID, appID, userID)
+}
+
+func (s *adminImpl) AdminRemoveUserToken(l *logs.Log, orgID string, appID string, userID string, token string) (bool, error) {
+	return s.app.adminRemoveUserToken(l, orgID, appID, userID, token)
+}
+
+func (s *adminImpl) AdminUnsubscribeUserFromAllTopics(l *logs.Log, orgID string, appID string, userID string) ([]string, error) {
+	return s.app.adminUnsubscribeUserFromAllTopics(l, orgID, appID, userID)
 }
 
 // BBs exposes users related APIs used by the platform building blocks
 type BBs interface {
-	BBsCreateMessages(inputMessages []model.InputMessage, isBatch bool) ([]model.Message, error)
+	BBsCreateMessages(ctx context.Context, inputMessages []model.InputMessage, isBatch bool) ([]model.Message, error)
 	BBsDeleteMessages(l *logs.Log, serviceAccountID string, messagesIDs []string) error
 	BBsSendMail(toEmail string, subject string, body string) error
 	BBsAddRecipients(l *logs.Log, serviceAccountID string, messageID string, recipients []model.InputMessageRecipient) ([]model.MessageRecipient, error)
@@ -214,8 +419,8 @@ type bbsImpl struct {
 	app *Application
 }
 
-func (s *bbsImpl) BBsCreateMessages(inputMessages []model.InputMessage, isBatch bool) ([]model.Message, error) {
-	return s.app.bbsCreateMessages(inputMessages, isBatch)
+func (s *bbsImpl) BBsCreateMessages(ctx context.Context, inputMessages []model.InputMessage, isBatch bool) ([]model.Message, error) {
+	return s.app.bbsCreateMessages(ctx, inputMessages, isBatch)
 }
 
 func (s *bbsImpl) BBsDeleteMessages(l *logs.Log, serviceAccountID string, messagesIDs []string) error {
@@ -236,48 +441,101 @@ func (s *bbsImpl) BBsDeleteRecipients(l *logs.Log, serviceAccountID string, mess
 
 // Storage is used by core to storage data - DB storage adapter, file storage adapter etc
 type Storage interface {
+	Ping() error
 	RegisterStorageListener(storageListener storage.Listener)
 
 	PerformTransaction(func(context storage.TransactionContext) error, int64) error
+	PerformTransactionWithContext(context.Context, func(context storage.TransactionContext) error, int64) error
 
 	LoadFirebaseConfigurations() ([]model.FirebaseConf, error)
 
-	FindUsersByIDs(usersIDs []string) ([]model.User, error)
+	FindUsersByIDs(orgID string, appID string, usersIDs []string) ([]model.User, error)
 	FindUserByID(orgID string, appID string, userID string) (*model.User, error)
 	InsertUser(orgID string, appID string, userID string) (*model.User, error)
 	UpdateUserByID(orgID string, appID string, userID string, notificationsEnabled bool) (*model.User, error)
+	UpdateUserMutedTopics(orgID string, appID string, userID string, mutedTopics []string) (*model.User, error)
+	UpdateUserSettings(orgID string, appID string, userID string, settings model.UserSettings) (*model.User, error)
+	MuteTopic(orgID string, appID string, userID string, topic string) (*model.User, error)
+	UnmuteTopic(orgID string, appID string, userID string, topic string) (*model.User, error)
 	DeleteUserWithID(orgID string, appID string, userID string) error
 
 	FindUserByToken(orgID string, appID string, token string) (*model.User, error)
 	StoreDeviceToken(orgID string, appID string, tokenInfo *model.TokenInfo, userID string) error
+	UpdateUserLastActive(orgID string, appID string, userID string, lastActive time.Time) error
+	IncrementDeviceTokenFailures(orgID string, appID string, userID string, token string) (int, error)
+	ResetDeviceTokenFailures(orgID string, appID string, userID string, token string) error
+	UpdateDeviceTokenNotificationsDisabled(orgID string, appID string, userID string, token string, notificationsDisabled bool) (bool, error)
+	RemoveDeviceToken(orgID string, appID string, userID string, token string) error
+	FindDeadDeviceTokens(orgID string, appID string, minFailures int) ([]model.DeadDeviceToken, error)
+	RemoveDeadDeviceTokens(orgID string, appID string, minFailures int) (int64, error)
+	RemoveStaleDeviceTokens(before time.Time) (int64, int64, error)
 	GetDeviceTokensByRecipients(orgID string, appID string, recipient []model.MessageRecipient, criteriaList []model.RecipientCriteria) ([]string, error)
+	CountUsersByTopic(orgID string, appID string, topic string) (int64, error)
+	CountMessagesByTopic(orgID string, appID string, topic string, createdAfter time.Time) (int64, error)
+	CountTopicsSubscribers(orgID string, appID string) (map[string]int, error)
+	GetTopicStats(orgID string, appID string, topic string, startDateEpoch *int64, endDateEpoch *int64) (*model.TopicStats, error)
+	FindTopicSubscribersIDs(orgID string, appID string, topic string, offset *int64, limit *int64) ([]string, error)
+	CountUsersByTopics(orgID string, appID string, topics []string, all bool) (int64, error)
+	CountUsersWithTokens(orgID string, appID string) (int64, error)
+	FindUsersWithTokens(orgID string, appID string, afterUserID string, limit int64) ([]model.User, error)
 	GetUsersByTopicsWithContext(ctx context.Context, orgID string, appID string, topic []string) ([]model.User, error)
 	GetUsersByRecipientCriteriasWithContext(ctx context.Context, orgID string, appID string, recipientCriterias []model.RecipientCriteria) ([]model.User, error)
 	SubscribeToTopic(orgID string, appID string, token string, userID string, topic string) error
 	UnsubscribeToTopic(orgID string, appID string, token string, userID string, topic string) error
+	RemoveUserTopics(orgID string, appID string, userID string, topics []string) error
 	GetTopics(orgID string, appID string) ([]model.Topic, error)
+	GetTopicsPaged(orgID string, appID string, offset *int64, limit *int64, sortBy string, order string) ([]model.Topic, int64, error)
 	InsertTopic(*model.Topic) (*model.Topic, error)
 	UpdateTopic(*model.Topic) (*model.Topic, error)
+	GetTopicByName(orgID string, appID string, name string) (*model.Topic, error)
+	FindTopicByAlias(orgID string, appID string, alias string) (*model.Topic, error)
+	FindTopicsWithRetention() ([]model.Topic, error)
+	InsertTopicWithContext(ctx context.Context, topic model.Topic) error
+	DeleteTopicWithContext(ctx context.Context, orgID string, appID string, name string) error
+	RemoveUsersTopicWithContext(ctx context.Context, orgID string, appID string, topic string) (int64, error)
+	RenameUsersTopicWithContext(ctx context.Context, orgID string, appID string, oldName string, newName string) error
+	RenameMessagesTopicWithContext(ctx context.Context, orgID string, appID string, oldName string, newName string) error
 
 	FindMessagesRecipients(orgID string, appID string, messageID string, userID string) ([]model.MessageRecipient, error)
 	FindMessagesRecipientsByMessageAndUsers(messageID string, usersIDs []string) ([]model.MessageRecipient, error)
-	FindMessagesRecipientsByMessages(messagesIDs []string) ([]model.MessageRecipient, error)
-	FindMessagesRecipientsDeep(orgID string, appID string, userID *string, read *bool, mute *bool, messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, offset *int64, limit *int64, order *string) ([]model.MessageRecipient, error)
+	FindMessagesRecipientsByMessages(orgID string, appID string, messagesIDs []string) ([]model.MessageRecipient, error)
+	FindFailedMessageRecipients(orgID string, appID string, messageID string) ([]model.MessageRecipient, error)
+	FindMessagesRecipientsDeep(orgID string, appID string, userID *string, read *bool, mute *bool, messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, hasAttachment *bool, priority *int64, groupID *string, offset *int64, limit *int64, order *string, orderBy *string, fields []string) ([]model.MessageRecipient, error)
+	FindMessagesRecipientsDeepWithCount(ctx context.Context, orgID string, appID string, userID *string, read *bool, mute *bool, messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, hasAttachment *bool, priority *int64, groupID *string, offset *int64, limit *int64, order *string, orderBy *string, fields []string) ([]model.MessageRecipient, int64, error)
+	GetMessagesAfter(ctx context.Context, orgID string, appID string, userID string, cursor model.Message, limit int64, fields []string) ([]model.MessageRecipient, error)
 	InsertMessagesRecipientsWithContext(ctx context.Context, items []model.MessageRecipient) error
 	DeleteMessagesRecipientsForIDsWithContext(ctx context.Context, ids []string) error
 	DeleteMessagesRecipientsForMessagesWithContext(ctx context.Context, messagesIDs []string) error
 
 	FindMessagesWithContext(ctx context.Context, ids []string) ([]model.Message, error)
-	FindMessagesByParams(orgID string, appID string, senderType string, senderAccountID *string, offset *int64, limit *int64, order *string) ([]model.Message, error)
+	FindMessagesByParams(ctx context.Context, orgID string, appID string, senderType string, senderAccountID *string, sourceApp *string, includeDeleted bool, offset *int64, limit *int64, order *string, orderBy *string) ([]model.Message, error)
 	GetMessage(orgID string, appID string, ID string) (*model.Message, error)
+	GetMessageByIdempotencyKey(orgID string, appID string, sender model.Sender, key string, createdAfter time.Time) (*model.Message, error)
 	CreateMessageWithContext(ctx context.Context, message model.Message) (*model.Message, error)
 	InsertMessagesWithContext(ctx context.Context, messages []model.Message) error
 	UpdateMessage(message *model.Message) (*model.Message, error)
 	DeleteUserMessageWithContext(ctx context.Context, orgID string, appID string, userID string, messageID string) error
-	DeleteMessagesWithContext(ctx context.Context, ids []string) error
-	GetMessagesStats(userID string) (*model.MessagesStats, error)
+	DeleteMessagesWithContext(ctx context.Context, orgID string, appID string, ids []string) error
+	FindMessagesCreatedBefore(before time.Time, topic *model.Topic, excludedTopics []model.Topic) ([]model.Message, error)
+	FindMessagesExpiredBefore(before time.Time) ([]model.Message, error)
+	GetUserMessagesStats(orgID string, appID string, userID string) (*model.MessagesStats, error)
 	UpdateUnreadMessage(ctx context.Context, orgID string, appID string, ID string, userID string) (*model.Message, error)
-	UpdateAllUserMessagesRead(ctx context.Context, orgID string, appID string, userID string, read bool) error
+	UpdateAllUserMessagesRead(ctx context.Context, orgID string, appID string, userID string, messageIDs []string, read bool) (int64, error)
+	AddMessageReport(orgID string, appID string, messageID string, report model.MessageReport) (bool, error)
+	FlagMessage(orgID string, appID string, messageID string, reportsThreshold int) (bool, error)
+	ApproveMessageWithContext(ctx context.Context, orgID string, appID string, messageID string, approvedBy model.CoreAccountRef, dateApproved time.Time) (bool, error)
+	ReleaseMessageRecipientsWithContext(ctx context.Context, messageID string) error
+	RecallMessageWithContext(ctx context.Context, orgID string, appID string, messageID string, dateRecalled time.Time) (bool, error)
+	RecallMessageRecipientsWithContext(ctx context.Context, messageID string) error
+	SoftDeleteMessageWithContext(ctx context.Context, orgID string, appID string, messageID string, dateDeleted time.Time) (bool, error)
+	RestoreMessageWithContext(ctx context.Context, orgID string, appID string, messageID string, dateRestored time.Time) (bool, error)
+	UpdateMessageRecipientsDeletedWithContext(ctx context.Context, messageID string, deleted bool) error
+	FailQueuedMessage(orgID string, appID string, messageID string, reason string) error
+	EndBroadcast(orgID string, appID string, messageID string, ended time.Time) error
+	IncrementMessageDeliverySummaryWithContext(ctx context.Context, orgID string, appID string, messageID string, delta model.DeliverySummary) error
+	FindMessagesSendEndedAfter(after time.Time) ([]model.Message, error)
+	UpdateMessageRecipientDeliveryStatus(orgID string, appID string, recipientID string, status string, errorCode *string) error
+	UpdateMessageRecipientsDeliveryStatus(recipientsIDs []string, status string) error
 	GetAllAppVersions(orgID string, appID string) ([]model.AppVersion, error)
 	GetAllAppPlatforms(orgID string, appID string) ([]model.AppPlatform, error)
 
@@ -288,9 +546,25 @@ type Storage interface {
 
 	FindQueueData(time *time.Time, limit int) ([]model.QueueItem, error)
 	DeleteQueueData(ids []string) error
+	UpdateQueueDataTime(ids []string, time time.Time) error
 	DeleteQueueDataForMessagesWithContext(ctx context.Context, messagesIDs []string) error
+	CountQueueDataForMessage(messageID string) (int64, error)
 	DeleteQueueDataForRecipientsWithContext(ctx context.Context, recipientsIDs []string) error
 
+	InsertPushRetry(retry model.PushRetry) error
+	FindPushRetriesDue(time time.Time, limit int) ([]model.PushRetry, error)
+	ClaimPushRetry(id string, nextAttempt time.Time, leaseEnd time.Time) (bool, error)
+	UpdatePushRetry(retry model.PushRetry) error
+	DeletePushRetry(id string) error
+	CountPushRetriesForMessage(messageID string) (int64, error)
+
+	LogDelivery(entry model.DeliveryLog) error
+	FindDeliveryLogs(orgID string, appID string, messageID string, offset *int64, limit *int64) ([]model.DeliveryLog, int64, error)
+
+	FindMessagesPendingCallback(time time.Time, offset int, limit int) ([]model.Message, error)
+	SetMessageCallbackDue(messageID string, due time.Time) error
+	ClaimMessageCallback(messageID string, now time.Time) (bool, error)
+
 	FindConfig(configType string, appID string, orgID string) (*model.Configs, error)
 	FindConfigByID(id string) (*model.Configs, error)
 	FindConfigs(configType *string) ([]model.Configs, error)
@@ -301,13 +575,24 @@ type Storage interface {
 
 // Firebase is used to wrap all Firebase Messaging API functions
 type Firebase interface {
+	IsReady() bool
 	UpdateFirebaseConfigurations(firebaseConfs []model.FirebaseConf) error
-	SendNotificationToToken(orgID string, appID string, token string, title string, body string, data map[string]string) error
+	SendNotificationToTokens(orgID string, appID string, tokens []string, title string, body string, sound string, badge *int, priority int, collapseKey string, imageURL string, androidChannelID string, data map[string]string) (*model.BatchResponse, error)
 	SendNotificationToTopic(orgID string, appID string, topic string, title string, body string, data map[string]string) error
 	SubscribeToTopic(orgID string, appID string, token string, topic string) error
 	UnsubscribeToTopic(orgID string, appID string, token string, topic string) error
 }
 
+// Webhook is used to post the messages delivery receipts to their callback urls
+type Webhook interface {
+	SendCallback(callbackURL string, payload []byte) error
+}
+
+// Moderator is used to check the messages content before sending them
+type Moderator interface {
+	Moderate(subject string, body string) (*model.ModerationResult, error)
+}
+
 // Mailer is used to wrap all Email Messaging functions
 type Mailer interface {
 	SendMail(toEmail string, subject string, body string) error
@@ -320,5 +605,15 @@ type Core interface {
 
 // Airship is used to wrap all Airship Messaging API Functions
 type Airship interface {
-	SendNotificationToToken(orgID string, appID string, deviceToken string, title string, body string, data map[string]string) error
+	SendNotificationToToken(orgID string, appID string, deviceToken string, title string, body string, sound string, data map[string]string) error
+}
+
+// SMS is used to wrap all SMS functions
+type SMS interface {
+	SendSMS(phone string, body string) error
+}
+
+// APNs is used to wrap all Apple Push Notification service functions
+type APNs interface {
+	SendNotificationToToken(orgID string, appID string, deviceToken string, title string, body string, sound string, badge *int, collapseKey string, data map[string]string) error
 }
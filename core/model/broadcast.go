@@ -0,0 +1,101 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidBroadcastConfirmation is given when a broadcast is sent without the confirmation token of its dry run
+var ErrInvalidBroadcastConfirmation = newKindError(ErrValidation, "invalid broadcast confirmation token")
+
+// BroadcastConfirmationTTL is the time for which the confirmation token of a dry run can be used
+const BroadcastConfirmationTTL = 10 * time.Minute
+
+// BroadcastPreview represents the audience of a broadcast which has not been sent
+type BroadcastPreview struct {
+	AudienceCount       int64     `json:"audience_count"`       // the users with at least one token
+	ConfirmationToken   string    `json:"confirmation_token"`   // sends the broadcast of the same content to up to the audience count
+	ConfirmationExpires time.Time `json:"confirmation_expires"` // the confirmation token cannot be used after it
+} // @name BroadcastPreview
+
+// NewBroadcastConfirmationToken gives the token which confirms the broadcast of the content to up to the audience count until it expires.
+// The dry run gives it so that a broadcast is sent only after its audience has been previewed. It is signed with the key.
+func NewBroadcastConfirmationToken(key []byte, orgID string, appID string, subject string, body string, audienceCount int64, expires time.Time) string {
+	payload := strconv.FormatInt(expires.Unix(), 10) + "." + strconv.FormatInt(audienceCount, 10)
+	return payload + "." + broadcastConfirmationSignature(key, orgID, appID, subject, body, payload)
+}
+
+// ValidateBroadcastConfirmationToken checks that the token has been given for the content and that it has not expired.
+// It gives the confirmed audience count.
+func ValidateBroadcastConfirmationToken(key []byte, token string, orgID string, appID string, subject string, body string, now time.Time) (int64, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("%w: malformed", ErrInvalidBroadcastConfirmation)
+	}
+	payload := parts[0] + "." + parts[1]
+	signature := broadcastConfirmationSignature(key, orgID, appID, subject, body, payload)
+	if !hmac.Equal([]byte(parts[2]), []byte(signature)) {
+		return 0, fmt.Errorf("%w: it is not given for this broadcast", ErrInvalidBroadcastConfirmation)
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: malformed expiry", ErrInvalidBroadcastConfirmation)
+	}
+	if !now.Before(time.Unix(expires, 0)) {
+		return 0, fmt.Errorf("%w: expired, run the dry run again", ErrInvalidBroadcastConfirmation)
+	}
+	audienceCount, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: malformed audience count", ErrInvalidBroadcastConfirmation)
+	}
+	return audienceCount, nil
+}
+
+func broadcastConfirmationSignature(key []byte, orgID string, appID string, subject string, body string, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(orgID + "\n" + appID + "\n" + subject + "\n" + body + "\n" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewBroadcastMessage gives the message which is stored for a broadcast to the audience. It has no recipients,
+// its delivery summary counts the users to which it has been sent
+func NewBroadcastMessage(im InputMessage, audienceCount int, now time.Time) Message {
+	return Message{OrgID: im.OrgID, AppID: im.AppID, ID: *im.ID, Time: now, Priority: im.Priority, Subject: im.Subject,
+		Sender: im.Sender, Body: im.Body, Data: im.Data, PerDevice: im.PerDevice, Broadcast: true, CalculatedRecipientsCount: &audienceCount,
+		DeliverySummary: &DeliverySummary{}, DateCreated: &now, DateUpdated: &now}
+}
+
+// BroadcastPendingCount gives the users to which the broadcast has not been sent yet, 0 once it has ended
+func (m *Message) BroadcastPendingCount() int64 {
+	if m.DateBroadcastEnded != nil || m.CalculatedRecipientsCount == nil {
+		return 0
+	}
+	pending := *m.CalculatedRecipientsCount
+	if m.DeliverySummary != nil {
+		pending -= m.DeliverySummary.Sent
+	}
+	if pending < 1 {
+		return 1 //the users which have registered a token meanwhile are sent too
+	}
+	return int64(pending)
+}
@@ -0,0 +1,117 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateBroadcastConfirmationToken(t *testing.T) {
+	key := []byte("broadcast-key")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(BroadcastConfirmationTTL)
+	token := NewBroadcastConfirmationToken(key, "org", "app", "subject", "body", 42, expires)
+	//the signature of the token with a later expiry
+	parts := strings.Split(token, ".")
+	extended := strconv.FormatInt(expires.Add(time.Hour).Unix(), 10) + "." + parts[1] + "." + parts[2]
+
+	tests := []struct {
+		name    string
+		key     []byte
+		token   string
+		orgID   string
+		appID   string
+		subject string
+		body    string
+		at      time.Time
+		want    int64
+		wantErr bool
+	}{
+		{"valid", key, token, "org", "app", "subject", "body", now, 42, false},
+		{"valid just before the expiry", key, token, "org", "app", "subject", "body", expires.Add(-time.Second), 42, false},
+		{"expired", key, token, "org", "app", "subject", "body", expires, 0, true},
+		{"other key", []byte("other-key"), token, "org", "app", "subject", "body", now, 0, true},
+		{"other org", key, token, "other", "app", "subject", "body", now, 0, true},
+		{"other app", key, token, "org", "other", "subject", "body", now, 0, true},
+		{"other subject", key, token, "org", "app", "other", "body", now, 0, true},
+		{"other body", key, token, "org", "app", "subject", "other", now, 0, true},
+		{"raised audience count", key, strings.Replace(token, ".42.", ".4200.", 1), "org", "app", "subject", "body", now, 0, true},
+		{"extended expiry", key, extended, "org", "app", "subject", "body", now, 0, true},
+		{"malformed", key, "not-a-token", "org", "app", "subject", "body", now, 0, true},
+		{"empty", key, "", "org", "app", "subject", "body", now, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateBroadcastConfirmationToken(tt.key, tt.token, tt.orgID, tt.appID, tt.subject, tt.body, tt.at)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ValidateBroadcastConfirmationToken() = %d, %v, want %d, error %t", got, err, tt.want, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidBroadcastConfirmation) {
+				t.Errorf("ValidateBroadcastConfirmationToken() error = %v, want ErrInvalidBroadcastConfirmation", err)
+			}
+		})
+	}
+}
+
+func TestBroadcastPendingCount(t *testing.T) {
+	intPtr := func(value int) *int { return &value }
+	ended := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		message Message
+		want    int64
+	}{
+		{"not sent", Message{CalculatedRecipientsCount: intPtr(10), DeliverySummary: &DeliverySummary{}}, 10},
+		{"partially sent", Message{CalculatedRecipientsCount: intPtr(10), DeliverySummary: &DeliverySummary{Sent: 4}}, 6},
+		{"audience grown", Message{CalculatedRecipientsCount: intPtr(10), DeliverySummary: &DeliverySummary{Sent: 12}}, 1},
+		{"ended", Message{CalculatedRecipientsCount: intPtr(10), DeliverySummary: &DeliverySummary{Sent: 4}, DateBroadcastEnded: &ended}, 0},
+		{"no audience", Message{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.message.BroadcastPendingCount(); got != tt.want {
+				t.Errorf("BroadcastPendingCount() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBroadcastMessage(t *testing.T) {
+	id := "broadcast"
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		perDevice bool
+	}{
+		{"every device", true},
+		{"latest device", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			im := InputMessage{OrgID: "org", AppID: "app", ID: &id, Subject: "subject", Body: "body", PerDevice: tt.perDevice}
+			message := NewBroadcastMessage(im, 42, now)
+			if !message.Broadcast || message.PerDevice != tt.perDevice || *message.CalculatedRecipientsCount != 42 {
+				t.Errorf("NewBroadcastMessage() = broadcast %t per device %t audience %d, want broadcast true per device %t audience 42",
+					message.Broadcast, message.PerDevice, *message.CalculatedRecipientsCount, tt.perDevice)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCallbackURL is given when the message callback url is not a public https url
+var ErrInvalidCallbackURL = newKindError(ErrValidation, "invalid callback url")
+
+// sharedAddressSpace is the carrier-grade NAT range which is not reachable from the internet
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// DeliveryReceipt is posted to the message callback url when the message has been dispatched to all its recipients
+type DeliveryReceipt struct {
+	MessageID  string                    `json:"message_id"`
+	Summary    DeliverySummary           `json:"delivery_summary"`
+	Recipients []RecipientDeliveryStatus `json:"recipients"`
+	DateSent   time.Time                 `json:"date_sent"`
+} // @name DeliveryReceipt
+
+// RecipientDeliveryStatus is the delivery status of a recipient in a delivery receipt
+type RecipientDeliveryStatus struct {
+	UserID         string  `json:"user_id"`
+	DeliveryStatus *string `json:"delivery_status"` // not set if nothing was sent to the recipient, i.e. muted
+	ErrorCode      *string `json:"error_code,omitempty"`
+} // @name RecipientDeliveryStatus
+
+// NewDeliveryReceipt creates the delivery receipt of a message
+func NewDeliveryReceipt(message Message, recipients []MessageRecipient, now time.Time) DeliveryReceipt {
+	summary := DeliverySummary{}
+	if message.DeliverySummary != nil {
+		summary = *message.DeliverySummary
+	}
+	statuses := make([]RecipientDeliveryStatus, len(recipients))
+	for i, recipient := range recipients {
+		statuses[i] = RecipientDeliveryStatus{UserID: recipient.UserID, DeliveryStatus: recipient.DeliveryStatus, ErrorCode: recipient.DeliveryErrorCode}
+	}
+	return DeliveryReceipt{MessageID: message.ID, Summary: summary, Recipients: statuses, DateSent: now}
+}
+
+// ValidateCallbackURL checks that the callback url is an https url which does not point to a private or a loopback address.
+// The host names are resolved on sending, so that the resolved addresses are checked then.
+func ValidateCallbackURL(callbackURL string) error {
+	parsed, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCallbackURL, err)
+	}
+	if parsed.Scheme != "https" {
+		return fmt.Errorf("%w: %s is not https", ErrInvalidCallbackURL, callbackURL)
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	if len(host) == 0 {
+		return fmt.Errorf("%w: missing host", ErrInvalidCallbackURL)
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s is a loopback host", ErrInvalidCallbackURL, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !IsPublicIP(ip) {
+		return fmt.Errorf("%w: %s is not a public address", ErrInvalidCallbackURL, host)
+	}
+	return nil
+}
+
+// IsPublicIP checks if the address is reachable from the internet - it is not a loopback, private, link local or unspecified address
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	return !sharedAddressSpace.Contains(ip)
+}
@@ -14,10 +14,38 @@
 
 package model
 
+import "time"
+
 // Config the main config structure
 type Config struct {
-	CoreAuthPrivateKey      string
-	CoreBBHost              string
-	NotificationsServiceURL string
-	InternalAPIKey          string
+	CoreAuthPrivateKey         string
+	CoreBBHost                 string
+	NotificationsServiceURL    string
+	InternalAPIKey             string
+	PreviousInternalAPIKeys    []string          // keys which are still accepted during a key rotation
+	InternalAPIKeysRotationEnd *time.Time        // the previous keys are not accepted after this time, never expire if nil
+	DefaultMessageData         map[string]string // data fields added to every message unless the message sets them
+	RateLimitAllowlist         []string          // senders which are not rate limited
+	SenderRatePerMin           int               // messages which a sender can create per minute, 0 means no limit
+	ReportsThreshold           int               // reports count after which a message is flagged
+	ReportsAdminEmail          string            // email to notify when a message is flagged
+	TokenFailuresLimit         int               // invalid token failures after which a token is removed
+	MessagesRetentionDays      int               // messages older than this are deleted, 0 means the messages are kept forever
+	MessageTTLDays             int               // expired messages are deleted this many days after they expire, 0 means they follow the retention
+	TokenStaleDays             int               // device tokens not registered again for this many days are removed, 0 means they are kept
+	SourceApps                 []string          // the known messages source apps, any source app is allowed if empty
+	TopicsResyncConcurrency    int               // max concurrent firebase calls of the topics subscriptions resync
+	TopicNameMaxLength         int               // max length of the new topics names
+	TopicReservedPrefixes      []string          // prefixes which the new topics names cannot use, in addition to the internal ones
+	DefaultPaginationLimit     int64             // limit of the list APIs when the request does not give one
+	MaxPaginationLimit         int64             // the list APIs limits are clamped to this value
+	BulkMessagesLimit          int               // max messages of a bulk create request
+	PushRetryBaseSeconds       int               // wait before the first retry of a push failed with a transient error, doubled for every next retry
+	PushRetryMaxAttempts       int               // max sends of a push failed with a transient error including the first one, 1 means no retries
+	DefaultPerDevice           bool              // the messages without per_device are sent to every device of a recipient if true, only to the latest one otherwise
+	SubscriptionWebhookURL     string            // the users topics subscriptions changes are posted to it, nothing is posted if empty
+	MessageResendWindowHours   int               // the messages older than this cannot be resent unless forced
+	FirebaseSendConcurrency    int               // max push sends in progress - the Firebase multicasts and the APNs and Airship single sends
+	CORSExcludeAdmin           bool              // the admin APIs do not allow the CORS origins, the internal and BBs APIs never do
+	MaxRequestBodyBytes        int64             // max size of the requests bodies, the larger requests are rejected
 }
@@ -0,0 +1,48 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import "errors"
+
+// Error kinds, the errors given by the core are of one of them so that the APIs respond with the matching status
+var (
+	// ErrNotFound is given when the requested entity does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrValidation is given when the input is not valid
+	ErrValidation = errors.New("validation failed")
+	// ErrConflict is given when the current state of the entity does not allow the operation
+	ErrConflict = errors.New("conflict")
+	// ErrUnauthorized is given when the caller is not allowed to perform the operation on the entity
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// kindError is an error of a kind, errors.Is matches it both to itself and to its kind
+type kindError struct {
+	kind    error
+	message string
+}
+
+func (e *kindError) Error() string {
+	return e.message
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+// newKindError gives a new error of the kind with the message
+func newKindError(kind error, message string) error {
+	return &kindError{kind: kind, message: message}
+}
@@ -15,9 +15,103 @@
 package model
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
 	"time"
 )
 
+// ErrInvalidSourceApp is given when the message source application is not within the known applications
+var ErrInvalidSourceApp = newKindError(ErrValidation, "invalid message source app")
+
+// ErrMessageTimePassed is given when the message is relative to an event and its time has passed but it must not be sent late
+var ErrMessageTimePassed = newKindError(ErrValidation, "message time relative to the event has passed")
+
+// ErrInvalidExpiration is given when the message expires before its time so that it could never be sent
+var ErrInvalidExpiration = newKindError(ErrValidation, "message expires before its time")
+
+// ErrInvalidCollapseKey is given when the message collapse key is longer than the APNs collapse id limit
+var ErrInvalidCollapseKey = newKindError(ErrValidation, "invalid message collapse key")
+
+// MaxCollapseKeyLength is the max length in bytes of the message collapse key, APNs does not accept a longer collapse id
+const MaxCollapseKeyLength = 64
+
+// ErrInvalidImageURL is given when the message image url is not an http(s) url or it is too long
+var ErrInvalidImageURL = newKindError(ErrValidation, "invalid message image url")
+
+// MaxImageURLLength is the max length of the message image url
+const MaxImageURLLength = 2048
+
+// ErrInvalidBadge is given when the message badge is negative
+var ErrInvalidBadge = newKindError(ErrValidation, "invalid message badge")
+
+// ErrPayloadTooLarge is given when the push payload is larger than Firebase accepts
+var ErrPayloadTooLarge = newKindError(ErrValidation, "push payload too large")
+
+// MaxPushPayloadSize is the max size in bytes of the push data with the subject and the body, Firebase rejects a larger payload
+const MaxPushPayloadSize = 4096
+
+// ErrInvalidBodyTemplate is given when the message body placeholders cannot be rendered for a recipient
+var ErrInvalidBodyTemplate = newKindError(ErrValidation, "invalid message body template")
+
+// ErrMessageSelfApproval is given when an admin tries to approve a message which the same admin has created
+var ErrMessageSelfApproval = newKindError(ErrValidation, "message cannot be approved by its sender")
+
+// ErrMessageNotPendingApproval is given when a message which does not wait for approval is approved
+var ErrMessageNotPendingApproval = newKindError(ErrValidation, "message is not pending approval")
+
+// ErrMessageNotEditable is given when the recipients or the content of a message which has been sent are changed
+var ErrMessageNotEditable = newKindError(ErrConflict, "message has been sent and its recipients and content cannot be changed")
+
+// ErrMessageRecalled is given when a message which has already been recalled is recalled again
+var ErrMessageRecalled = newKindError(ErrConflict, "message has already been recalled")
+
+// ErrMessageDeleted is given when a message which has been deleted is deleted again, approved, recalled or resent
+var ErrMessageDeleted = newKindError(ErrConflict, "message has been deleted")
+
+// ErrMessageNotDeleted is given when a message which has not been deleted is restored
+var ErrMessageNotDeleted = newKindError(ErrConflict, "message has not been deleted")
+
+// ErrTooManyMessages is given when a bulk request has more messages than the configured limit
+var ErrTooManyMessages = newKindError(ErrValidation, "too many messages")
+
+// ErrMessageNotSent is given when a message which is pending approval, is scheduled or is still being sent is resent
+var ErrMessageNotSent = newKindError(ErrValidation, "message has not been sent yet")
+
+// ErrMessageExpired is given when a message which has expired is resent
+var ErrMessageExpired = newKindError(ErrValidation, "message has expired")
+
+// ErrMessageResendWindowPassed is given when a message older than the resend window is resent without forcing it
+var ErrMessageResendWindowPassed = newKindError(ErrValidation, "message resend window has passed")
+
+// ErrInvalidCursor is given when the messages are paged since a message which does not exist
+var ErrInvalidCursor = newKindError(ErrValidation, "invalid messages cursor")
+
+// Message approval states
+const (
+	ApprovalStatusPending  = "pending_approval" // stored but not sent until a different admin approves it
+	ApprovalStatusApproved = "approved"
+)
+
+// Messages order by fields
+const (
+	MessagesOrderByDateCreated = "date_created" // the default
+	MessagesOrderByDateUpdated = "date_updated" // for the delta syncs
+)
+
+// UserMessageFields are the fields of the user messages which the clients may select in order to get partial messages
+var UserMessageFields = []string{"id", "org_id", "app_id", "priority", "subject", "sender", "body", "data", "attachments",
+	"recipients", "recipients_criteria_list", "recipient_account_criteria", "topic", "calculated_recipients_count",
+	"date_created", "date_updated", "time", "mute", "read", "group_id"}
+
+// Message delivery channels
+const (
+	DeliveryChannelPush  = "push"  // the default channel
+	DeliveryChannelEmail = "email" // email to the recipients without device tokens
+	DeliveryChannelSMS   = "sms"   // sms to the recipients without device tokens
+)
+
 // InputMessage represents the data structure needed for creating a message. It is the input data for the core module.
 type InputMessage struct {
 	OrgID string
@@ -31,11 +125,38 @@ type InputMessage struct {
 	Subject                  string
 	Body                     string
 	Data                     map[string]string
+	LocalizedSubjects        map[string]string //the subject by locale
+	LocalizedBodies          map[string]string //the body by locale
 	InputRecipients          []MessageRecipient
+	ExcludeRecipients        []MessageRecipient //removed from the recipients after the topics and criteria resolution
 	RecipientsCriteriaList   []RecipientCriteria
 	RecipientAccountCriteria map[string]interface{}
 	Topic                    *string
 	Topics                   []string
+	Attachments              []Attachment
+	SourceApp                string        //the building block or application which sends the message
+	ActiveWithinMinutes      *int          //if set, the push is sent only to the users active within these minutes
+	DeferInactive            bool          //defer the push for the inactive users instead of dropping it
+	IncludeFailedRecipients  bool          //give the recipients to which the push cannot be sent in the result
+	EventTime                *time.Time    //if set, the message time is computed relative to it
+	OffsetBefore             time.Duration //the message is sent this duration before the event time
+	SkipIfPast               bool          //do not send the message if its time relative to the event has passed
+	DeliveryChannels         []string      //push if empty, email and sms are fallbacks for the recipients without device tokens
+	RequiresApproval         bool          //the message is not sent until a different admin approves it
+	Sound                    *string       //the push sound, the default sound of the topics is used if not set
+	Badge                    *int          //the app badge count, the badge is left unchanged if not set
+	Silent                   bool          //send a data only push without an alert
+	PerDevice                bool          //send the push to every device of a recipient, only to the latest one otherwise
+	CallbackURL              *string       //the delivery receipt is posted to it when the message has been dispatched
+	GroupID                  *string       //the thread of the message
+	CollapseKey              *string       //a later push with the same key replaces the notification on the device
+	ImageURL                 *string       //the image of the rich notification
+	AndroidChannelID         *string       //the Android notification channel, the service default is used if not set
+	ExpiresAt                *time.Time    //the push is not sent after this time
+	IdempotencyKey           *string       //a retried create with the same key gives the existing message
+	Queued                   bool          //replaces the queued message stored by an async create
+
+	Moderation *ModerationResult //set by the core when the moderation is enabled
 }
 
 // InputMessageRecipient represents the data structure needed for creating a message recipient. It is the input data for the core module.
@@ -59,6 +180,49 @@ type Message struct {
 	Body     string            `json:"body" bson:"body"`
 	Data     map[string]string `json:"data" bson:"data"`
 
+	//the subject and the body variants by locale, the recipients without a matching locale get the default ones
+	LocalizedSubjects map[string]string `json:"localized_subjects,omitempty" bson:"localized_subjects,omitempty"`
+	LocalizedBodies   map[string]string `json:"localized_bodies,omitempty" bson:"localized_bodies,omitempty"`
+
+	Attachments []Attachment `json:"attachments,omitempty" bson:"attachments,omitempty"`
+
+	SourceApp string `json:"source_app,omitempty" bson:"source_app,omitempty"` // the building block or application which sent the message
+
+	//active session only delivery - the push is sent only to the users active within these minutes
+	ActiveWithinMinutes *int `json:"active_within_minutes,omitempty" bson:"active_within_minutes,omitempty"`
+	DeferInactive       bool `json:"defer_inactive,omitempty" bson:"defer_inactive,omitempty"` // defer the push for the inactive users instead of dropping it
+
+	//event relative delivery - the message time is the event time minus the offset
+	EventTime    *time.Time `json:"event_time,omitempty" bson:"event_time,omitempty"`
+	OffsetBefore *int64     `json:"offset_before,omitempty" bson:"offset_before,omitempty"` // in seconds
+
+	DeliveryChannels []string `json:"delivery_channels,omitempty" bson:"delivery_channels,omitempty"` // push if empty
+
+	Sound *string `json:"sound,omitempty" bson:"sound,omitempty"` // the push sound, the device default if not set
+	Badge *int    `json:"badge,omitempty" bson:"badge,omitempty"` // the app badge count, the badge is left unchanged if not set
+
+	Silent bool `json:"silent,omitempty" bson:"silent,omitempty"` // the push is data only, the subject and the body are optional
+
+	PerDevice bool `json:"per_device,omitempty" bson:"per_device,omitempty"` // the push is sent to every device of a recipient, only to the latest one otherwise
+
+	//grouping - the messages of a thread share the group id, the device keeps only the latest notification with the same collapse key
+	GroupID     *string `json:"group_id,omitempty" bson:"group_id,omitempty"`
+	CollapseKey *string `json:"collapse_key,omitempty" bson:"collapse_key,omitempty"`
+
+	ImageURL *string `json:"image_url,omitempty" bson:"image_url,omitempty"` // the image shown in the rich notification
+
+	AndroidChannelID *string `json:"android_channel_id,omitempty" bson:"android_channel_id,omitempty"` // the service default channel is used if not set
+
+	//the recipients which have not been sent until this time are skipped, it must be after the message time
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+
+	IdempotencyKey *string `json:"idempotency_key,omitempty" bson:"idempotency_key,omitempty"` // scoped per sender
+
+	//the delivery receipt is posted to the callback url when no recipient is pending anymore
+	CallbackURL      *string    `json:"callback_url,omitempty" bson:"callback_url,omitempty"`
+	DateCallbackDue  *time.Time `json:"-" bson:"date_callback_due,omitempty"` // the dispatching has ended, the receipt is sent after the in-flight sends are recorded
+	DateCallbackSent *time.Time `json:"date_callback_sent,omitempty" bson:"date_callback_sent,omitempty"`
+
 	//recipients related
 	Recipients               []MessageRecipient     `json:"recipients" bson:"recipients"` //keep it for back compatability
 	RecipientsCriteriaList   []RecipientCriteria    `json:"recipients_criteria_list" bson:"recipients_criteria_list"`
@@ -66,14 +230,229 @@ type Message struct {
 	Topic                    *string                `json:"topic" bson:"topic"`
 	Topics                   []string               `json:"topics" bson:"topics"`
 
+	//the users which were excluded from the resolved recipients
+	ExcludedRecipients []string `json:"excluded_recipients,omitempty" bson:"excluded_recipients,omitempty"`
+
 	//initialy calculated recipients count
 	//if nil then it means that the message was created before the refactoring
 	CalculatedRecipientsCount *int `json:"calculated_recipients_count" bson:"calculated_recipients_count"`
 
+	//rolled-up delivery state of the recipients
+	//if nil then it means that the message was created before the summary was introduced
+	DeliverySummary *DeliverySummary `json:"delivery_summary" bson:"delivery_summary"`
+
+	//when the first and the last recipients have been sent
+	DateSendStarted *time.Time          `json:"date_send_started,omitempty" bson:"date_send_started,omitempty"`
+	DateSendEnded   *time.Time          `json:"date_send_ended,omitempty" bson:"date_send_ended,omitempty"`
+	SendMetrics     *MessageSendMetrics `json:"send_metrics,omitempty" bson:"-"` // computed for the admins
+
+	//abuse reports from the recipients
+	Reports      []MessageReport `json:"reports,omitempty" bson:"reports,omitempty"`
+	ReportsCount int             `json:"reports_count" bson:"reports_count"`
+	Flagged      bool            `json:"flagged" bson:"flagged"` // true when the reports count has reached the threshold or the moderation has flagged it
+
+	//the recipients to which the push cannot be sent, given only to the sender on creating when requested
+	FailedRecipients []FailedRecipient `json:"failed_recipients,omitempty" bson:"-"`
+
+	//the reason for which the moderation has flagged the message for admin review
+	ModerationReason *string `json:"moderation_reason,omitempty" bson:"moderation_reason,omitempty"`
+
+	//two-person approval, not set if the message does not require approval
+	ApprovalStatus *string         `json:"approval_status,omitempty" bson:"approval_status,omitempty"` // pending_approval or approved
+	ApprovedBy     *CoreAccountRef `json:"approved_by,omitempty" bson:"approved_by,omitempty"`
+	DateApproved   *time.Time      `json:"date_approved,omitempty" bson:"date_approved,omitempty"`
+
+	//an async create stores the message as queued until its recipients have been calculated, not set once it has been created
+	CreateStatus *string `json:"create_status,omitempty" bson:"create_status,omitempty"` // queued or failed
+	CreateError  *string `json:"create_error,omitempty" bson:"create_error,omitempty"`
+
+	//a recalled message is removed from the recipients view and the clients are asked to remove its notification
+	Recalled     bool       `json:"recalled,omitempty" bson:"recalled,omitempty"`
+	DateRecalled *time.Time `json:"date_recalled,omitempty" bson:"date_recalled,omitempty"`
+
+	//a deleted message is hidden from its recipients and from the admin queries until it is restored, the purge removes it permanently
+	Deleted     bool       `json:"deleted,omitempty" bson:"deleted,omitempty"`
+	DateDeleted *time.Time `json:"date_deleted,omitempty" bson:"date_deleted,omitempty"`
+
+	//a broadcast is sent to all the users with a token, it has no recipients and it is complete once it has ended
+	Broadcast          bool       `json:"broadcast,omitempty" bson:"broadcast,omitempty"`
+	DateBroadcastEnded *time.Time `json:"date_broadcast_ended,omitempty" bson:"date_broadcast_ended,omitempty"`
+
 	DateCreated *time.Time `json:"date_created" bson:"date_created"`
 	DateUpdated *time.Time `json:"date_updated" bson:"date_updated"`
 }
 
+// MessageTimeBeforeEvent gives the time at which a message has to be sent so that it is delivered offsetBefore before the event time.
+// It gives the current time if that time has passed and false if the message must not be sent late.
+func MessageTimeBeforeEvent(eventTime time.Time, offsetBefore time.Duration, skipIfPast bool, now time.Time) (time.Time, bool) {
+	messageTime := eventTime.Add(-offsetBefore)
+	if messageTime.Before(now) {
+		if skipIfPast {
+			return messageTime, false
+		}
+		return now, true
+	}
+	return messageTime, true
+}
+
+// HasDeliveryChannel checks if the message is delivered through the channel
+func (m *Message) HasDeliveryChannel(channel string) bool {
+	if len(m.DeliveryChannels) == 0 {
+		return channel == DeliveryChannelPush
+	}
+	for _, current := range m.DeliveryChannels {
+		if current == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// NewQueuedMessage gives the message which an async create stores until the message is created from the input in the background
+func NewQueuedMessage(im InputMessage, now time.Time) Message {
+	createStatus := MessageStatusQueued
+	return Message{OrgID: im.OrgID, AppID: im.AppID, ID: *im.ID, Time: im.Time, Priority: im.Priority, Subject: im.Subject,
+		Sender: im.Sender, Body: im.Body, Data: im.Data, Topic: im.Topic, Topics: im.Topics, SourceApp: im.SourceApp,
+		IdempotencyKey: im.IdempotencyKey, CreateStatus: &createStatus, DateCreated: &now, DateUpdated: &now}
+}
+
+// IsQueued checks if the message is stored by an async create which has not created it yet
+func (m *Message) IsQueued() bool {
+	return m.CreateStatus != nil && *m.CreateStatus == MessageStatusQueued
+}
+
+// IsPendingApproval checks if the message waits for an admin approval before it is sent
+func (m *Message) IsPendingApproval() bool {
+	return m.ApprovalStatus != nil && *m.ApprovalStatus == ApprovalStatusPending
+}
+
+// GetCollapseKey gives the collapse key of the message push, empty if the notifications of the message are not collapsed
+func (m *Message) GetCollapseKey() string {
+	if m.CollapseKey == nil {
+		return ""
+	}
+	return *m.CollapseKey
+}
+
+// GetImageURL gives the image url of the message push, empty if the message does not have an image
+func (m *Message) GetImageURL() string {
+	if m.ImageURL == nil {
+		return ""
+	}
+	return *m.ImageURL
+}
+
+// ValidateImageURL checks that the image url is an absolute http(s) url which is not too long
+func ValidateImageURL(imageURL string) error {
+	if len(imageURL) > MaxImageURLLength {
+		return fmt.Errorf("%w: longer than %d", ErrInvalidImageURL, MaxImageURLLength)
+	}
+	parsed, err := url.Parse(imageURL)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidImageURL, err)
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return fmt.Errorf("%w: %s is not http(s)", ErrInvalidImageURL, imageURL)
+	}
+	if len(parsed.Host) == 0 {
+		return fmt.Errorf("%w: missing host", ErrInvalidImageURL)
+	}
+	return nil
+}
+
+// PushPayloadSize gives the size in bytes of the push payload - the data keys and values with the subject and the body
+func PushPayloadSize(subject string, body string, data map[string]string) int {
+	size := len(subject) + len(body)
+	for key, value := range data {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+// ValidatePushPayloadSize checks that the push payload is not larger than Firebase accepts
+func ValidatePushPayloadSize(subject string, body string, data map[string]string) error {
+	size := PushPayloadSize(subject, body, data)
+	if size > MaxPushPayloadSize {
+		return fmt.Errorf("%w: %d bytes, max %d", ErrPayloadTooLarge, size, MaxPushPayloadSize)
+	}
+	return nil
+}
+
+// MergeTopics gives the message topics with the single topic folded in, every topic once and in the given order
+func MergeTopics(topic *string, topics []string) []string {
+	if topic == nil && len(topics) == 0 {
+		return topics
+	}
+	merged := make([]string, 0, len(topics)+1)
+	added := map[string]bool{}
+	for _, entry := range topics {
+		if !added[entry] {
+			added[entry] = true
+			merged = append(merged, entry)
+		}
+	}
+	if topic != nil && !added[*topic] {
+		merged = append(merged, *topic)
+	}
+	return merged
+}
+
+// GetAndroidChannelID gives the Android notification channel of the message push, empty for the service default channel
+func (m *Message) GetAndroidChannelID() string {
+	if m.AndroidChannelID == nil {
+		return ""
+	}
+	return *m.AndroidChannelID
+}
+
+// IsExpired checks if the message has expired at the given time
+func (m *Message) IsExpired(now time.Time) bool {
+	return m.ExpiresAt != nil && !now.Before(*m.ExpiresAt)
+}
+
+// GetFallbackChannels gives the channels which are tried in order for the recipients without device tokens
+func (m *Message) GetFallbackChannels() []string {
+	channels := []string{}
+	for _, channel := range m.DeliveryChannels {
+		if channel != DeliveryChannelPush {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// IsScheduled checks if the message time has not come yet and none of its recipients has been sent
+func (m *Message) IsScheduled(now time.Time) bool {
+	if m.DeliverySummary != nil && (m.DeliverySummary.Sent > 0 || m.DeliverySummary.Expired > 0) {
+		return false
+	}
+	return m.DateSendStarted == nil && m.Time.After(now)
+}
+
+// HasContentChanges checks if the update changes the recipients, the subject, the body or the time of the message.
+// The time is kept if the update does not give it.
+func (m *Message) HasContentChanges(update Message) bool {
+	if m.Subject != update.Subject || m.Body != update.Body || (!update.Time.IsZero() && !m.Time.Equal(update.Time)) {
+		return true
+	}
+	if update.EventTime != nil && (m.EventTime == nil || !m.EventTime.Equal(*update.EventTime)) {
+		return true
+	}
+	if len(m.Recipients) != len(update.Recipients) {
+		return true
+	}
+	usersIDs := make(map[string]bool, len(m.Recipients))
+	for _, recipient := range m.Recipients {
+		usersIDs[recipient.UserID] = true
+	}
+	for _, recipient := range update.Recipients {
+		if !usersIDs[recipient.UserID] {
+			return true
+		}
+	}
+	return false
+}
+
 // IsSender checks if the user is a sender
 func (m *Message) IsSender(userID string) bool {
 	if m.Sender.User != nil && userID == m.Sender.User.UserID {
@@ -82,6 +461,27 @@ func (m *Message) IsSender(userID string) bool {
 	return false
 }
 
+// LocalizedValue gives the variant for the locale. The language variant (i.e. fr for fr-CA) is used when there is no
+// variant for the exact locale and the default value when there is none for the language either.
+func LocalizedValue(variants map[string]string, locale *string, defaultValue string) string {
+	if locale == nil || len(variants) == 0 {
+		return defaultValue
+	}
+	if value, ok := variants[*locale]; ok {
+		return value
+	}
+	language, _, found := strings.Cut(*locale, "-")
+	if !found {
+		language, _, found = strings.Cut(*locale, "_")
+	}
+	if found {
+		if value, ok := variants[language]; ok {
+			return value
+		}
+	}
+	return defaultValue
+}
+
 // Sender is a system generated fingerprint for the originator of the message. It may be a user from the admin app or an external system
 // @name Sender
 // @ID Sender
@@ -90,6 +490,227 @@ type Sender struct {
 	User *CoreAccountRef `json:"user,omitempty" bson:"user,omitempty"`
 }
 
+// Attachment represents a file or an image attached to a message
+// @name Attachment
+// @ID Attachment
+type Attachment struct {
+	URL  string  `json:"url" bson:"url"`
+	Type *string `json:"type,omitempty" bson:"type,omitempty"` // MIME type
+	Name *string `json:"name,omitempty" bson:"name,omitempty"`
+}
+
+// MessageReport represents an abuse report for a message from one of its recipients
+// @name MessageReport
+// @ID MessageReport
+type MessageReport struct {
+	ReporterID  string    `json:"reporter_id" bson:"reporter_id"`
+	Reason      string    `json:"reason" bson:"reason"`
+	DateCreated time.Time `json:"date_created" bson:"date_created"`
+}
+
+// DeliverySummary wraps the delivery counts for the recipients of a message
+// @name DeliverySummary
+// @ID DeliverySummary
+type DeliverySummary struct {
+	Sent      int `json:"sent" bson:"sent"`           // recipients for which a push was attempted
+	Delivered int `json:"delivered" bson:"delivered"` // recipients for which at least one token accepted the push
+	Read      int `json:"read" bson:"read"`           // recipients which have read the message
+	Failed    int `json:"failed" bson:"failed"`       // recipients for which no token accepted the push
+	Expired   int `json:"expired" bson:"expired"`     // recipients which were not sent as the message had expired
+
+	Errors map[string]int `json:"errors,omitempty" bson:"errors,omitempty"` // failed recipients count by delivery error code
+}
+
+// NewResendSummaryDelta gives the delivery summary change which removes the recorded results of the recipients
+// to which the message is sent again, so that they are counted once when the resend ends
+func NewResendSummaryDelta(recipients []MessageRecipient) DeliverySummary {
+	delta := DeliverySummary{Errors: map[string]int{}}
+	for _, recipient := range recipients {
+		if recipient.DeliveryStatus == nil {
+			continue
+		}
+		switch *recipient.DeliveryStatus {
+		case DeliveryStatusDelivered, DeliveryStatusEmailed, DeliveryStatusTexted:
+			delta.Sent--
+			delta.Delivered--
+		case DeliveryStatusFailed:
+			delta.Sent--
+			delta.Failed--
+			if recipient.DeliveryErrorCode != nil {
+				delta.Errors[*recipient.DeliveryErrorCode]--
+			}
+		case DeliveryStatusExpired:
+			delta.Expired--
+		}
+	}
+	return delta
+}
+
+// Delivery error codes
+const (
+	DeliveryErrorUnregistered    = "unregistered"      // the token is not registered anymore
+	DeliveryErrorInvalidArgument = "invalid_argument"  // the token or the message is invalid
+	DeliveryErrorQuotaExceeded   = "quota_exceeded"    // the sending limits are exceeded
+	DeliveryErrorUnavailable     = "unavailable"       // the push service is temporarily unavailable or cannot be reached
+	DeliveryErrorPayloadTooLarge = "payload_too_large" // the push payload is larger than the push service accepts
+	DeliveryErrorInternal        = "internal"          // any other error
+	DeliveryErrorNoEmail         = "no_email"          // the email fallback is not possible as the user does not have an email
+	DeliveryErrorNoPhone         = "no_phone"          // the sms fallback is not possible as the user does not have a phone
+)
+
+// DeliveryError is a push send error with a structured code
+type DeliveryError struct {
+	Code      string
+	Err       error
+	Retryable bool // the send may succeed if it is tried again later
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// BatchResponse represents the result of sending a notification to many tokens at once
+type BatchResponse struct {
+	SuccessCount int
+	FailureCount int
+	Errors       []error // the send error for every token in the batch order, nil if it has been sent
+}
+
+// GetDeliveryErrorCode gives the code of a send error, internal if the error does not have a code
+func GetDeliveryErrorCode(err error) string {
+	var deliveryErr *DeliveryError
+	if errors.As(err, &deliveryErr) {
+		return deliveryErr.Code
+	}
+	return DeliveryErrorInternal
+}
+
+// IsRetryableDeliveryError tells if a send error is transient, so the send may be tried again
+func IsRetryableDeliveryError(err error) bool {
+	var deliveryErr *DeliveryError
+	if errors.As(err, &deliveryErr) {
+		return deliveryErr.Retryable
+	}
+	return false
+}
+
+// Message send states
+const (
+	MessageStatusQueued          = "queued"              // an async create has accepted the message but has not created it yet
+	MessageStatusFailed          = "failed"              // the async create of the message has failed
+	MessageStatusPendingApproval = ApprovalStatusPending // the message waits for an admin approval
+	MessageStatusPending         = "pending"             // no recipient has been processed yet
+	MessageStatusSending         = "sending"             // some of the recipients are still in the queue
+	MessageStatusComplete        = "complete"            // all the recipients have been processed
+	MessageStatusExpired         = "expired"             // the message has expired before all the recipients were sent
+)
+
+// MessageStatus wraps the send state of a message
+// @name MessageStatus
+// @ID MessageStatus
+type MessageStatus struct {
+	ID              string           `json:"id"`
+	Status          string           `json:"status"`
+	RecipientsCount *int             `json:"recipients_count"`
+	PendingCount    int64            `json:"pending_count"` // recipients which are still in the queue
+	DeliverySummary *DeliverySummary `json:"delivery_summary"`
+	Error           *string          `json:"error,omitempty"` // why the async create has failed
+
+	FailedRecipients []FailedRecipient `json:"failed_recipients"` // the recipients for which the delivery has failed
+
+	SendMetrics *MessageSendMetrics `json:"send_metrics,omitempty"` // nil until the first recipient is sent
+}
+
+// NewMessageStatus gives the send state of the message based on its recipients which are still in the queue
+func NewMessageStatus(message Message, pendingCount int64) MessageStatus {
+	status := MessageStatusSending
+	if message.CreateStatus != nil {
+		status = *message.CreateStatus
+	} else if message.IsPendingApproval() {
+		status = MessageStatusPendingApproval
+	} else if message.DeliverySummary != nil && message.DeliverySummary.Expired > 0 {
+		status = MessageStatusExpired
+	} else if pendingCount == 0 {
+		status = MessageStatusComplete
+	} else if message.DeliverySummary == nil || message.DeliverySummary.Sent == 0 {
+		status = MessageStatusPending
+	}
+	return MessageStatus{ID: message.ID, Status: status, RecipientsCount: message.CalculatedRecipientsCount,
+		PendingCount: pendingCount, DeliverySummary: message.DeliverySummary, Error: message.CreateError, SendMetrics: NewMessageSendMetrics(message)}
+}
+
+// MessageSendMetrics represents how long the send of a message has taken
+// @name MessageSendMetrics
+// @ID MessageSendMetrics
+type MessageSendMetrics struct {
+	DateStarted     time.Time `json:"date_started"`
+	DateEnded       time.Time `json:"date_ended"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Throughput      float64   `json:"throughput"` // sent recipients per second
+}
+
+// NewMessageSendMetrics gives the send metrics of the message, nil if no recipient has been sent yet
+func NewMessageSendMetrics(message Message) *MessageSendMetrics {
+	if message.DateSendStarted == nil || message.DateSendEnded == nil {
+		return nil
+	}
+
+	duration := message.DateSendEnded.Sub(*message.DateSendStarted)
+	sent := 0
+	if message.DeliverySummary != nil {
+		sent = message.DeliverySummary.Sent
+	}
+	//a send which has taken less than a second is counted as one second
+	throughputDuration := duration
+	if throughputDuration < time.Second {
+		throughputDuration = time.Second
+	}
+	return &MessageSendMetrics{DateStarted: *message.DateSendStarted, DateEnded: *message.DateSendEnded,
+		DurationSeconds: duration.Seconds(), Throughput: float64(sent) / throughputDuration.Seconds()}
+}
+
+// SendMetrics represents the send metrics of the messages whose send has ended within a time window
+type SendMetrics struct {
+	Window time.Duration
+
+	Messages           int     // messages whose send has ended within the window
+	Sent               int     // recipients sent by these messages
+	DurationSecondsAvg float64 // the average send duration
+	DurationSecondsMax float64 // the longest send duration
+	ThroughputAvg      float64 // the average sent recipients per second
+}
+
+// NewSendMetrics aggregates the send metrics of the messages
+func NewSendMetrics(window time.Duration, messages []Message) SendMetrics {
+	metrics := SendMetrics{Window: window}
+	throughputSum := 0.0
+	durationSum := 0.0
+	for _, message := range messages {
+		messageMetrics := NewMessageSendMetrics(message)
+		if messageMetrics == nil {
+			continue
+		}
+		metrics.Messages++
+		if message.DeliverySummary != nil {
+			metrics.Sent += message.DeliverySummary.Sent
+		}
+		durationSum += messageMetrics.DurationSeconds
+		throughputSum += messageMetrics.Throughput
+		if messageMetrics.DurationSeconds > metrics.DurationSecondsMax {
+			metrics.DurationSecondsMax = messageMetrics.DurationSeconds
+		}
+	}
+	if metrics.Messages > 0 {
+		metrics.DurationSecondsAvg = durationSum / float64(metrics.Messages)
+		metrics.ThroughputAvg = throughputSum / float64(metrics.Messages)
+	}
+	return metrics
+}
+
 // RecipientCriteria defines common search criteria for end users and their FCM tokens
 // @name RecipientCriteria
 // @ID RecipientCriteria
@@ -98,6 +719,15 @@ type RecipientCriteria struct {
 	AppPlatform *string `json:"app_platform" bson:"app_platform"`
 }
 
+// BulkMessageResult is the result of creating one of the messages of a bulk request
+// @name BulkMessageResult
+// @ID BulkMessageResult
+type BulkMessageResult struct {
+	Index   int      `json:"index"`           // the position of the message in the request
+	Message *Message `json:"message"`         // nil if the message is not created
+	Error   *string  `json:"error,omitempty"` // the reason for which the message is not created
+}
+
 // MessagesStats wraps messages statistics aggregation result
 // @name MessagesStats
 // @ID MessagesStats
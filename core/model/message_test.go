@@ -0,0 +1,225 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidatePushPayloadSize(t *testing.T) {
+	data := map[string]string{"message_id": "id"} //12 bytes
+	tests := []struct {
+		name    string
+		subject string
+		body    string
+		data    map[string]string
+		wantErr bool
+	}{
+		{"small", "subject", "body", data, false},
+		{"at the limit", "subject", strings.Repeat("a", MaxPushPayloadSize-7-12), data, false},
+		{"one byte over the limit", "subject", strings.Repeat("a", MaxPushPayloadSize-7-12+1), data, true},
+		{"data over the limit", "", "", map[string]string{"key": strings.Repeat("a", MaxPushPayloadSize)}, true},
+		{"multibyte body at the limit", "", strings.Repeat("é", MaxPushPayloadSize/2), nil, false},
+		{"multibyte body over the limit", "", strings.Repeat("é", MaxPushPayloadSize/2) + "a", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePushPayloadSize(tt.subject, tt.body, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePushPayloadSize() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrPayloadTooLarge) {
+				t.Errorf("ValidatePushPayloadSize() error = %v, want ErrPayloadTooLarge", err)
+			}
+		})
+	}
+}
+
+func TestMessageIsSender(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		userID  string
+		want    bool
+	}{
+		{"no sender", Message{}, "u1", false},
+		{"no sender user", Message{Sender: Sender{Type: "system"}}, "u1", false},
+		{"no sender user and no user", Message{Sender: Sender{Type: "system"}}, "", false},
+		{"sender", Message{Sender: Sender{Type: "user", User: &CoreAccountRef{UserID: "u1"}}}, "u1", true},
+		{"other sender", Message{Sender: Sender{Type: "user", User: &CoreAccountRef{UserID: "u2"}}}, "u1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.message.IsSender(tt.userID); got != tt.want {
+				t.Errorf("IsSender(%s) = %t, want %t", tt.userID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMessageStatusTransitions(t *testing.T) {
+	recipients := 3
+
+	tests := []struct {
+		name         string
+		summary      *DeliverySummary
+		pendingCount int64
+		want         string
+	}{
+		{"queued", &DeliverySummary{}, 3, MessageStatusPending},
+		{"no summary", nil, 3, MessageStatusPending},
+		{"first sent", &DeliverySummary{Sent: 1, Delivered: 1}, 2, MessageStatusSending},
+		{"last in the queue", &DeliverySummary{Sent: 2, Delivered: 1, Failed: 1}, 1, MessageStatusSending},
+		{"all sent", &DeliverySummary{Sent: 3, Delivered: 2, Failed: 1}, 0, MessageStatusComplete},
+		{"no recipients", &DeliverySummary{}, 0, MessageStatusComplete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := Message{ID: "message", CalculatedRecipientsCount: &recipients, DeliverySummary: tt.summary}
+			got := NewMessageStatus(message, tt.pendingCount)
+			if got.Status != tt.want || got.PendingCount != tt.pendingCount || got.DeliverySummary != tt.summary {
+				t.Errorf("NewMessageStatus() = %s with %d pending, want %s with %d pending and the summary", got.Status, got.PendingCount, tt.want, tt.pendingCount)
+			}
+		})
+	}
+}
+
+func TestGetDeliveryErrorCode(t *testing.T) {
+	unregistered := &DeliveryError{Code: DeliveryErrorUnregistered, Err: errors.New("app instance has been unregistered")}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"delivery error", unregistered, DeliveryErrorUnregistered},
+		{"wrapped delivery error", fmt.Errorf("send to token: %w", unregistered), DeliveryErrorUnregistered},
+		{"other error", errors.New("firebase unavailable"), DeliveryErrorInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetDeliveryErrorCode(tt.err); got != tt.want {
+				t.Errorf("GetDeliveryErrorCode(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageTimeBeforeEvent(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		eventTime    time.Time
+		offsetBefore time.Duration
+		skipIfPast   bool
+		want         time.Time
+		wantSend     bool
+	}{
+		{"an hour before", now.Add(3 * time.Hour), time.Hour, false, now.Add(2 * time.Hour), true},
+		{"no offset", now.Add(time.Hour), 0, false, now.Add(time.Hour), true},
+		{"exactly now", now.Add(time.Hour), time.Hour, true, now, true},
+		{"past sent now", now.Add(30 * time.Minute), time.Hour, false, now, true},
+		{"past skipped", now.Add(30 * time.Minute), time.Hour, true, now.Add(-30 * time.Minute), false},
+		{"event passed", now.Add(-time.Hour), 0, false, now, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, send := MessageTimeBeforeEvent(tt.eventTime, tt.offsetBefore, tt.skipIfPast, now)
+			if !got.Equal(tt.want) || send != tt.wantSend {
+				t.Errorf("MessageTimeBeforeEvent() = %s, %t, want %s, %t", got, send, tt.want, tt.wantSend)
+			}
+		})
+	}
+}
+
+func TestNewMessageSendMetrics(t *testing.T) {
+	started := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ended := started.Add(10 * time.Second)
+	quick := started.Add(200 * time.Millisecond)
+
+	tests := []struct {
+		name           string
+		message        Message
+		wantNil        bool
+		wantDuration   float64
+		wantThroughput float64
+	}{
+		{"completed", Message{DateSendStarted: &started, DateSendEnded: &ended, DeliverySummary: &DeliverySummary{Sent: 50}}, false, 10, 5},
+		{"under a second", Message{DateSendStarted: &started, DateSendEnded: &quick, DeliverySummary: &DeliverySummary{Sent: 3}}, false, 0.2, 3},
+		{"no summary", Message{DateSendStarted: &started, DateSendEnded: &ended}, false, 10, 0},
+		{"not sent", Message{}, true, 0, 0},
+		{"started only", Message{DateSendStarted: &started}, true, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewMessageSendMetrics(tt.message)
+			if (got == nil) != tt.wantNil {
+				t.Fatalf("NewMessageSendMetrics() = %v, want nil %t", got, tt.wantNil)
+			}
+			if got != nil && (got.DurationSeconds != tt.wantDuration || got.Throughput != tt.wantThroughput) {
+				t.Errorf("NewMessageSendMetrics() = %v s %v/s, want %v s %v/s", got.DurationSeconds, got.Throughput, tt.wantDuration, tt.wantThroughput)
+			}
+		})
+	}
+}
+
+func TestNewSendMetrics(t *testing.T) {
+	started := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tenSeconds := started.Add(10 * time.Second)
+	twentySeconds := started.Add(20 * time.Second)
+	messages := []Message{
+		{DateSendStarted: &started, DateSendEnded: &tenSeconds, DeliverySummary: &DeliverySummary{Sent: 100}},
+		{DateSendStarted: &started, DateSendEnded: &twentySeconds, DeliverySummary: &DeliverySummary{Sent: 40}},
+		{DeliverySummary: &DeliverySummary{}}, //not sent yet
+	}
+
+	got := NewSendMetrics(time.Hour, messages)
+	want := SendMetrics{Window: time.Hour, Messages: 2, Sent: 140, DurationSecondsAvg: 15, DurationSecondsMax: 20, ThroughputAvg: 6}
+	if got != want {
+		t.Errorf("NewSendMetrics() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLocalizedValue(t *testing.T) {
+	variants := map[string]string{"fr": "Bonjour", "fr-CA": "Allô", "es": "Hola"}
+	locale := func(value string) *string { return &value }
+
+	tests := []struct {
+		name     string
+		variants map[string]string
+		locale   *string
+		want     string
+	}{
+		{"exact locale", variants, locale("fr-CA"), "Allô"},
+		{"language", variants, locale("fr-BE"), "Bonjour"},
+		{"underscore language", variants, locale("es_MX"), "Hola"},
+		{"language only", variants, locale("fr"), "Bonjour"},
+		{"no variant", variants, locale("en"), "Hello"},
+		{"no locale", variants, nil, "Hello"},
+		{"no variants", nil, locale("fr"), "Hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LocalizedValue(tt.variants, tt.locale, "Hello"); got != tt.want {
+				t.Errorf("LocalizedValue() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
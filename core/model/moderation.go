@@ -0,0 +1,33 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+const (
+	//ModerationDecisionAllow the message can be sent
+	ModerationDecisionAllow string = "allow"
+	//ModerationDecisionFlag the message can be sent but an admin should review it
+	ModerationDecisionFlag string = "flag"
+	//ModerationDecisionBlock the message must not be sent
+	ModerationDecisionBlock string = "block"
+)
+
+// ErrMessageBlocked is given when the message content is blocked by the moderation
+var ErrMessageBlocked = newKindError(ErrValidation, "message blocked by moderation")
+
+// ModerationResult represents the moderation decision for a message content
+type ModerationResult struct {
+	Decision string
+	Reason   string
+}
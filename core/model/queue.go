@@ -40,8 +40,106 @@ type QueueItem struct {
 	Subject string            `bson:"subject"`
 	Body    string            `bson:"body"`
 	Data    map[string]string `bson:"data"`
+	Sound   string            `bson:"sound,omitempty"` // the device default if empty
+	Badge   *int              `bson:"badge,omitempty"` // the badge is left unchanged if nil
+
+	CollapseKey string `bson:"collapse_key,omitempty"` // the device keeps only the latest notification with the same key
+	ImageURL    string `bson:"image_url,omitempty"`    // the image of the rich notification
+
+	AndroidChannelID string `bson:"android_channel_id,omitempty"` // the default channel if empty
 
 	//when to send
 	Time     time.Time `bson:"time"`
 	Priority int       `bson:"priority"`
+
+	//the recently active users are processed first
+	UserLastActive *time.Time `bson:"user_last_active"`
+
+	//active session only delivery
+	ActiveWithinMinutes *int       `bson:"active_within_minutes,omitempty"`
+	ActiveDeadline      *time.Time `bson:"active_deadline,omitempty"` // the item is deferred until this time if the user is not active, dropped if nil
+
+	//the item is dropped if it has not been sent until this time
+	ExpiresAt *time.Time `bson:"expires_at,omitempty"`
+
+	//the channels which are tried in order if the user does not have device tokens
+	FallbackChannels []string `bson:"fallback_channels,omitempty"`
+
+	//the push is sent to every device of the user, only to the latest one otherwise
+	PerDevice bool `bson:"per_device,omitempty"`
+}
+
+// PushRetryKindCallback is the kind of the retries of the failed delivery receipt callbacks, the push retries do not have a kind
+const PushRetryKindCallback = "callback"
+
+// PushRetry is a push to the Firebase tokens of a recipient which failed with a transient error and is sent again later
+type PushRetry struct {
+	OrgID string `bson:"org_id"`
+	AppID string `bson:"app_id"`
+	ID    string `bson:"_id"`
+
+	Kind string `bson:"kind,omitempty"` // empty for a push, callback for a delivery receipt
+
+	//the delivery receipt callback
+	CallbackURL     string `bson:"callback_url,omitempty"`
+	CallbackPayload []byte `bson:"callback_payload,omitempty"`
+
+	MessageID          string   `bson:"message_id"`
+	MessageRecipientID string   `bson:"message_recipient_id"`
+	UserID             string   `bson:"user_id"`
+	Tokens             []string `bson:"tokens"` // the tokens which are still to be sent
+
+	Subject string            `bson:"subject"`
+	Body    string            `bson:"body"`
+	Data    map[string]string `bson:"data"`
+	Sound   string            `bson:"sound,omitempty"`
+	Badge   *int              `bson:"badge,omitempty"`
+
+	CollapseKey string `bson:"collapse_key,omitempty"`
+	ImageURL    string `bson:"image_url,omitempty"`
+
+	AndroidChannelID string `bson:"android_channel_id,omitempty"`
+
+	Priority int `bson:"priority"` // the message priority
+
+	ExpiresAt *time.Time `bson:"expires_at,omitempty"`
+
+	//the recipient delivery result is recorded when the retry ends, it has been recorded already if the push reached another device
+	RecordResult bool `bson:"record_result"`
+
+	Attempts      int       `bson:"attempts"` // the sends done so far, including the first one
+	NextAttempt   time.Time `bson:"next_attempt"`
+	LastErrorCode string    `bson:"last_error_code"`
+
+	DateCreated time.Time `bson:"date_created"`
+}
+
+// NewPushRetry creates a retry for the tokens of the queue item after its first send has failed
+func NewPushRetry(item QueueItem, tokens []string, recordResult bool, errorCode string, id string, base time.Duration, now time.Time) PushRetry {
+	return PushRetry{OrgID: item.OrgID, AppID: item.AppID, ID: id, MessageID: item.MessageID, MessageRecipientID: item.MessageRecipientID,
+		UserID: item.UserID, Tokens: tokens, Subject: item.Subject, Body: item.Body, Data: item.Data, Sound: item.Sound, Badge: item.Badge, CollapseKey: item.CollapseKey, ImageURL: item.ImageURL, AndroidChannelID: item.AndroidChannelID, Priority: item.Priority, ExpiresAt: item.ExpiresAt,
+		RecordResult: recordResult, Attempts: 1, NextAttempt: now.Add(RetryBackoff(base, 1)), LastErrorCode: errorCode, DateCreated: now}
+}
+
+// NewCallbackRetry creates a retry for a delivery receipt callback after its first send has failed
+func NewCallbackRetry(message Message, payload []byte, id string, base time.Duration, now time.Time) PushRetry {
+	return PushRetry{OrgID: message.OrgID, AppID: message.AppID, ID: id, Kind: PushRetryKindCallback, MessageID: message.ID,
+		CallbackURL: *message.CallbackURL, CallbackPayload: payload, Attempts: 1, NextAttempt: now.Add(RetryBackoff(base, 1)), DateCreated: now}
+}
+
+// NewWebhookRetry creates a retry for a webhook event which is not related to a message after its first post has failed
+func NewWebhookRetry(orgID string, appID string, url string, payload []byte, id string, base time.Duration, now time.Time) PushRetry {
+	return PushRetry{OrgID: orgID, AppID: appID, ID: id, Kind: PushRetryKindCallback,
+		CallbackURL: url, CallbackPayload: payload, Attempts: 1, NextAttempt: now.Add(RetryBackoff(base, 1)), DateCreated: now}
+}
+
+// RetryBackoff gives the wait before the next send after the given number of failed attempts - base, 2*base, 4*base...
+func RetryBackoff(base time.Duration, attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > 16 {
+		attempts = 16 //do not overflow
+	}
+	return base * time.Duration(1<<(attempts-1))
 }
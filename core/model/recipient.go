@@ -16,6 +16,41 @@ package model
 
 import "time"
 
+// Recipient delivery statuses
+const (
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
+	DeliveryStatusNoToken   = "no_token"               // the user does not have device tokens
+	DeliveryStatusDisabled  = "notifications_disabled" // the user has disabled the notifications
+	DeliveryStatusEmailed   = "emailed"                // sent by email as the user does not have device tokens
+	DeliveryStatusTexted    = "texted"                 // sent by sms as the user does not have device tokens
+	DeliveryStatusExpired   = "expired"                // not sent as the message had expired
+)
+
+// Failure reasons known before the push is sent
+const (
+	FailureReasonNoDeviceTokens        = "no_device_tokens"
+	FailureReasonNotificationsDisabled = "notifications_disabled"
+)
+
+// FailedRecipient represents a recipient to which the push cannot be or was not delivered
+// @name FailedRecipient
+// @ID FailedRecipient
+type FailedRecipient struct {
+	UserID string `json:"user_id"`
+	Reason string `json:"reason"` // a failure reason or a delivery error code
+}
+
+// MessagePreview represents a message as it is sent to a specific recipient
+// @name MessagePreview
+// @ID MessagePreview
+type MessagePreview struct {
+	UserID  string            `json:"user_id"`
+	Subject string            `json:"subject"`
+	Body    string            `json:"body"` // rendered with the recipient attributes
+	Data    map[string]string `json:"data"`
+}
+
 // MessageRecipient represent recipient of a message
 type MessageRecipient struct {
 	OrgID string `json:"org_id" bson:"org_id"`
@@ -27,7 +62,47 @@ type MessageRecipient struct {
 	Mute      bool   `json:"mute" bson:"mute"`
 	Read      bool   `json:"read" bson:"read"`
 
+	Data         map[string]string `json:"data,omitempty" bson:"data,omitempty"`                   // recipient attributes used for rendering the message body
+	RenderedBody *string           `json:"rendered_body,omitempty" bson:"rendered_body,omitempty"` // the message body rendered for this recipient
+
+	RenderedSubject *string `json:"rendered_subject,omitempty" bson:"rendered_subject,omitempty"` // the message subject localized for this recipient
+
+	DeliveryStatus    *string `json:"delivery_status,omitempty" bson:"delivery_status,omitempty"`         // delivered, emailed, texted, failed, no_token, notifications_disabled or expired, not set until the push is sent
+	DeliveryErrorCode *string `json:"delivery_error_code,omitempty" bson:"delivery_error_code,omitempty"` // the error code if the delivery has failed
+
+	PendingApproval bool `json:"-" bson:"pending_approval,omitempty"` // hidden from the user until the message is approved
+	Recalled        bool `json:"-" bson:"recalled,omitempty"`         // hidden from the user as the message has been recalled
+	Deleted         bool `json:"-" bson:"deleted,omitempty"`          // hidden from the user until the deleted message is restored
+
 	Message Message `json:"-" bson:"-"`
 
 	DateCreated *time.Time `json:"date_created" bson:"date_created"`
 }
+
+// DeliveryLog represents an attempt to send a message to a recipient. The entries are only appended, they are never updated
+// @name DeliveryLog
+// @ID DeliveryLog
+type DeliveryLog struct {
+	OrgID string `json:"org_id" bson:"org_id"`
+	AppID string `json:"app_id" bson:"app_id"`
+
+	ID                 string  `json:"id" bson:"_id"`
+	MessageID          string  `json:"message_id" bson:"message_id"`
+	MessageRecipientID string  `json:"message_recipient_id" bson:"message_recipient_id"`
+	UserID             string  `json:"user_id" bson:"user_id"`
+	Channel            string  `json:"channel" bson:"channel"`                           // push, email or sms
+	Status             string  `json:"status" bson:"status"`                             // delivered, emailed, texted or failed
+	ErrorCode          *string `json:"error_code,omitempty" bson:"error_code,omitempty"` // the delivery error code if the attempt has failed
+
+	DateCreated time.Time `json:"date_created" bson:"date_created"`
+}
+
+// NewDeliveryLog creates the delivery log entry for an attempt to send the queue item through the channel
+func NewDeliveryLog(item QueueItem, channel string, status string, errorCode string, id string, now time.Time) DeliveryLog {
+	entry := DeliveryLog{OrgID: item.OrgID, AppID: item.AppID, ID: id, MessageID: item.MessageID, MessageRecipientID: item.MessageRecipientID,
+		UserID: item.UserID, Channel: channel, Status: status, DateCreated: now}
+	if status == DeliveryStatusFailed && len(errorCode) > 0 {
+		entry.ErrorCode = &errorCode
+	}
+	return entry
+}
@@ -14,14 +14,55 @@
 
 package model
 
-import "time"
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidDeviceToken is given when the push provider reports that a token is not registered or invalid
+var ErrInvalidDeviceToken = errors.New("invalid device token")
+
+// Device token types, the tokens without a type are Firebase tokens
+const (
+	TokenTypeAirship = "airship"
+	TokenTypeAPNs    = "apns"
+)
 
 // DeviceToken Firebase token
 type DeviceToken struct {
-	Token       string     `json:"token" bson:"token"`
-	TokenType   string     `json:"token_type" bson:"token_type"`
-	AppPlatform *string    `json:"app_platform" bson:"app_platform"`
-	AppVersion  *string    `json:"app_version" bson:"app_version"`
-	DateCreated time.Time  `json:"date_created" bson:"date_created"`
-	DateUpdated *time.Time `json:"date_updated" bson:"date_updated"`
+	Token                 string     `json:"token" bson:"token"`
+	TokenType             string     `json:"token_type" bson:"token_type"`
+	AppPlatform           *string    `json:"app_platform" bson:"app_platform"`
+	AppVersion            *string    `json:"app_version" bson:"app_version"`
+	DeviceID              *string    `json:"device_id" bson:"device_id"`
+	FailuresCount         int        `json:"failures_count" bson:"failures_count"`                 // consecutive sends failed because of an invalid token
+	NotificationsDisabled bool       `json:"notifications_disabled" bson:"notifications_disabled"` // the user does not receive the pushes on this device
+	DateCreated           time.Time  `json:"date_created" bson:"date_created"`
+	DateUpdated           *time.Time `json:"date_updated" bson:"date_updated"`
 } // @name FirebaseToken
+
+// lastRegistered gives the last time when the device registered the token
+func (t DeviceToken) lastRegistered() time.Time {
+	if t.DateUpdated != nil && t.DateUpdated.After(t.DateCreated) {
+		return *t.DateUpdated
+	}
+	return t.DateCreated
+}
+
+// MaskDeviceToken gives the token with only its last 6 characters visible
+func MaskDeviceToken(token string) string {
+	if len(token) <= 6 {
+		return "****"
+	}
+	return "****" + token[len(token)-6:]
+}
+
+// DeadDeviceToken is a device token reported as invalid by the push provider which is pending removal
+type DeadDeviceToken struct {
+	UserID        string     `json:"user_id" bson:"user_id"`
+	Token         string     `json:"token" bson:"token"`
+	TokenType     string     `json:"token_type" bson:"token_type"`
+	AppPlatform   *string    `json:"app_platform" bson:"app_platform"`
+	FailuresCount int        `json:"failures_count" bson:"failures_count"`
+	DateUpdated   *time.Time `json:"date_updated" bson:"date_updated"`
+} // @name DeadDeviceToken
@@ -14,11 +14,27 @@
 
 package model
 
+import "time"
+
 // TokenInfo wraps the input json while registering token
 type TokenInfo struct {
 	PreviousToken *string `json:"previous_token" bson:"previous_token"`
 	Token         string  `json:"token" bson:"token"`
 	AppVersion    *string `json:"app_version" bson:"app_version"`
 	AppPlatform   *string `json:"app_platform" bson:"app_platform"`
+	DeviceID      *string `json:"device_id" bson:"device_id"`
 	TokenType     string  `json:"token_type" bson:"token_type"`
+	Locale        *string `json:"locale" bson:"locale"` // the device locale (i.e. fr or en-US), the user locale is kept if not set
 } // @name TokenInfo
+
+// NewDeviceToken gives the device token to store for the token info
+func (t *TokenInfo) NewDeviceToken(now time.Time) DeviceToken {
+	return DeviceToken{
+		Token:       t.Token,
+		TokenType:   t.TokenType,
+		AppVersion:  t.AppVersion,
+		AppPlatform: t.AppPlatform,
+		DeviceID:    t.DeviceID,
+		DateCreated: now,
+	}
+}
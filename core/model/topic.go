@@ -14,15 +14,201 @@
 
 package model
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidTopicName is given when a topic name is too long or uses a reserved prefix
+var ErrInvalidTopicName = newKindError(ErrValidation, "invalid topic name")
+
+// ErrTopicHasRecentMessages is given when deleting a topic which still has recent messages without forcing it
+var ErrTopicHasRecentMessages = newKindError(ErrConflict, "topic has recent messages")
+
+// Topics sort by fields
+const (
+	TopicsSortByName        = "name" // the default
+	TopicsSortByDateCreated = "date_created"
+	TopicsSortBySubscribers = "subscribers" // computed, so the topics are sorted in memory
+)
+
+// ValidateTopicName checks the topic name length and that it does not start with any of the reserved prefixes
+func ValidateTopicName(name string, maxLength int, reservedPrefixes []string) error {
+	if len(name) == 0 {
+		return fmt.Errorf("%w: empty", ErrInvalidTopicName)
+	}
+	if maxLength > 0 && len(name) > maxLength {
+		return fmt.Errorf("%w: %s is longer than %d", ErrInvalidTopicName, name, maxLength)
+	}
+	for _, prefix := range reservedPrefixes {
+		if len(prefix) > 0 && strings.HasPrefix(name, prefix) {
+			return fmt.Errorf("%w: %s uses the reserved prefix %s", ErrInvalidTopicName, name, prefix)
+		}
+	}
+	return nil
+}
 
 // Topic wraps a firebase topic and description
 type Topic struct {
 	OrgID string `json:"org_id" bson:"org_id"`
 	AppID string `json:"app_id" bson:"app_id"`
 
-	Name        string    `json:"name" bson:"_id"`
-	Description *string   `json:"description" bson:"description"`
+	ID          string   `json:"-" bson:"_id"`
+	Name        string   `json:"name" bson:"name"` // unique within the org and the app
+	Description *string  `json:"description" bson:"description"`
+	Aliases     []string `json:"aliases" bson:"aliases"` // previous names of the topic
+
+	//the pushes for the topic are sent only within these windows, no windows means any time
+	DeliveryWindows []DeliveryWindow `json:"delivery_windows" bson:"delivery_windows"`
+	TimeZone        *string          `json:"time_zone" bson:"time_zone"` // IANA time zone of the windows, UTC if not set
+
+	RetentionDays *int `json:"retention_days" bson:"retention_days"` // overrides the global messages retention for the topic messages
+
+	DefaultSound *string `json:"default_sound" bson:"default_sound"` // the push sound for the topic messages which do not give their own sound
+
+	SubscribersCount int `json:"subscribers_count" bson:"-"` // computed, given only by the admin APIs
+
 	DateCreated time.Time `json:"date_created" bson:"date_created"`
 	DateUpdated time.Time `json:"date_updated" bson:"date_updated"`
 } // @name Topic
+
+// TopicReach represents how many users a message to a topic would reach
+type TopicReach struct {
+	Topic string `json:"topic"`
+	Count int64  `json:"count"`
+} // @name TopicReach
+
+// TopicDeletion represents the result of a topic deletion
+type TopicDeletion struct {
+	Topic         string `json:"topic"`
+	AffectedUsers int64  `json:"affected_users"` // the users unsubscribed from the topic
+} // @name TopicDeletion
+
+// Topic subscription actions
+const (
+	SubscriptionActionSubscribe   = "subscribe"
+	SubscriptionActionUnsubscribe = "unsubscribe"
+)
+
+// SubscriptionEvent is posted to the subscription webhook when a user subscribes to or unsubscribes from a topic
+type SubscriptionEvent struct {
+	OrgID  string    `json:"org_id"`
+	AppID  string    `json:"app_id"`
+	UserID string    `json:"user_id"`
+	Topic  string    `json:"topic"`
+	Action string    `json:"action"` // subscribe or unsubscribe
+	Date   time.Time `json:"date"`
+}
+
+// TopicStats represents the sending activity of a topic
+type TopicStats struct {
+	Topic             string     `json:"topic" bson:"-"`
+	MessagesCount     int64      `json:"messages_count" bson:"messages_count"`         // the messages sent to the topic
+	LastSent          *time.Time `json:"last_sent" bson:"last_sent"`                   // nil if no message has been sent
+	AverageRecipients float64    `json:"average_recipients" bson:"average_recipients"` // per message
+} // @name TopicStats
+
+// Topics audience modes
+const (
+	TopicsAudienceModeUnion        = "union"        // the users subscribed to any of the topics
+	TopicsAudienceModeIntersection = "intersection" // the users subscribed to all of the topics
+)
+
+// TopicsAudience represents how many unique users are subscribed to a set of topics
+type TopicsAudience struct {
+	Topics []string `json:"topics"`
+	Mode   string   `json:"mode"`
+	Count  int64    `json:"count"`
+} // @name TopicsAudience
+
+// TopicSubscriptions represents the result of a bulk topics subscription update
+type TopicSubscriptions struct {
+	Topics []string `json:"topics"` // the user topics after the update
+	Failed []string `json:"failed"` // the topics which could not be subscribed/unsubscribed
+} // @name TopicSubscriptions
+
+// Validate validates the topic delivery settings
+func (t Topic) Validate() error {
+	if t.RetentionDays != nil && *t.RetentionDays <= 0 {
+		return fmt.Errorf("invalid retention days %d", *t.RetentionDays)
+	}
+	_, err := t.location()
+	if err != nil {
+		return err
+	}
+	for _, window := range t.DeliveryWindows {
+		_, _, err = window.bounds(time.Now(), time.UTC)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NextDeliveryTime gives the first time at or after "from" which is within a delivery window
+func (t Topic) NextDeliveryTime(from time.Time) time.Time {
+	if len(t.DeliveryWindows) == 0 {
+		return from
+	}
+	loc, err := t.location()
+	if err != nil {
+		return from
+	}
+
+	var next *time.Time
+	localFrom := from.In(loc)
+	//start from the previous day as a window may cross midnight
+	for day := -1; day <= 7; day++ {
+		date := localFrom.AddDate(0, 0, day)
+		for _, window := range t.DeliveryWindows {
+			start, end, err := window.bounds(date, loc)
+			if err != nil {
+				continue
+			}
+			if !localFrom.Before(start) && localFrom.Before(end) {
+				return from //within the window
+			}
+			if start.After(localFrom) && (next == nil || start.Before(*next)) {
+				candidate := start
+				next = &candidate
+			}
+		}
+	}
+	if next == nil {
+		return from
+	}
+	return next.UTC()
+}
+
+func (t Topic) location() (*time.Location, error) {
+	if t.TimeZone == nil || len(*t.TimeZone) == 0 {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(*t.TimeZone)
+}
+
+// DeliveryWindow represents a daily period in which pushes are allowed. End before start means that the window ends the next day
+type DeliveryWindow struct {
+	Start string `json:"start" bson:"start"` // 15:04 format
+	End   string `json:"end" bson:"end"`     // 15:04 format
+} // @name DeliveryWindow
+
+func (w DeliveryWindow) bounds(date time.Time, loc *time.Location) (time.Time, time.Time, error) {
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid delivery window start %s: %s", w.Start, err)
+	}
+	end, err := time.Parse("15:04", w.End)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid delivery window end %s: %s", w.End, err)
+	}
+
+	year, month, day := date.Date()
+	startTime := time.Date(year, month, day, start.Hour(), start.Minute(), 0, 0, loc)
+	endTime := time.Date(year, month, day, end.Hour(), end.Minute(), 0, 0, loc)
+	if !endTime.After(startTime) {
+		endTime = endTime.AddDate(0, 0, 1)
+	}
+	return startTime, endTime, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopicNextDeliveryTime(t *testing.T) {
+	chicago := "America/Chicago"
+	invalidZone := "Nowhere/Else"
+	meals := []DeliveryWindow{{Start: "07:00", End: "09:00"}, {Start: "11:00", End: "13:00"}}
+	night := []DeliveryWindow{{Start: "22:00", End: "02:00"}}
+	date := func(day int, hour int, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		topic Topic
+		from  time.Time
+		want  time.Time
+	}{
+		{"no windows", Topic{}, date(10, 5, 0), date(10, 5, 0)},
+		{"in window", Topic{DeliveryWindows: meals}, date(10, 8, 30), date(10, 8, 30)},
+		{"at the window start", Topic{DeliveryWindows: meals}, date(10, 11, 0), date(10, 11, 0)},
+		{"before the first window", Topic{DeliveryWindows: meals}, date(10, 5, 0), date(10, 7, 0)},
+		{"between the windows", Topic{DeliveryWindows: meals}, date(10, 9, 0), date(10, 11, 0)},
+		{"after the last window", Topic{DeliveryWindows: meals}, date(10, 13, 0), date(11, 7, 0)},
+		{"in a window across midnight", Topic{DeliveryWindows: night}, date(10, 1, 0), date(10, 1, 0)},
+		{"before a window across midnight", Topic{DeliveryWindows: night}, date(10, 3, 0), date(10, 22, 0)},
+		{"time zone", Topic{DeliveryWindows: meals, TimeZone: &chicago}, date(10, 12, 0), date(10, 13, 0)}, //07:00 in Chicago
+		{"invalid time zone", Topic{DeliveryWindows: meals, TimeZone: &invalidZone}, date(10, 5, 0), date(10, 5, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.topic.NextDeliveryTime(tt.from); !got.Equal(tt.want) {
+				t.Errorf("NextDeliveryTime(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTopicName(t *testing.T) {
+	reserved := []string{"all-users", "internal-"}
+
+	tests := []struct {
+		name      string
+		topic     string
+		maxLength int
+		wantErr   bool
+	}{
+		{"valid", "athletics", 16, false},
+		{"max length", strings.Repeat("a", 16), 16, false},
+		{"too long", strings.Repeat("a", 17), 16, true},
+		{"no max length", strings.Repeat("a", 1000), 0, false},
+		{"empty", "", 16, true},
+		{"reserved name", "all-users", 16, true},
+		{"reserved prefix", "internal-sync", 16, true},
+		{"reserved prefix inside", "my-all-users", 16, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTopicName(tt.topic, tt.maxLength, reserved)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidTopicName)) {
+				t.Errorf("ValidateTopicName(%s) error = %v, want error %t", tt.topic, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -14,7 +14,19 @@
 
 package model
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
+
+// ErrInvalidQuietHours is given when the user quiet hours are out of the day or their time zone is unknown
+var ErrInvalidQuietHours = newKindError(ErrValidation, "invalid quiet hours")
+
+// ErrInvalidPause is given when the user pauses the pushes until a time which has passed
+var ErrInvalidPause = newKindError(ErrValidation, "invalid notifications pause")
+
+// minutesPerDay bounds the quiet hours minutes of the day
+const minutesPerDay = 24 * 60
 
 // User represents user entity and all its relationship with firebase tokens and topics
 type User struct {
@@ -26,10 +38,42 @@ type User struct {
 	DeviceTokens          []DeviceToken `json:"firebase_tokens" bson:"firebase_tokens"`
 	UserID                string        `json:"user_id" bson:"user_id"`
 	Topics                []string      `json:"topics" bson:"topics"`
+	MutedTopics           []string      `json:"muted_topics" bson:"muted_topics"`
+	DateLastActive        *time.Time    `json:"date_last_active" bson:"date_last_active"`
 	DateCreated           time.Time     `json:"date_created" bson:"date_created"`
 	DateUpdated           time.Time     `json:"date_updated" bson:"date_updated"`
+
+	//the pushes are deferred until the end of the quiet hours, both must be set. End before start means that they end the next day
+	QuietHoursStart *int    `json:"quiet_hours_start" bson:"quiet_hours_start"` // minutes of the day
+	QuietHoursEnd   *int    `json:"quiet_hours_end" bson:"quiet_hours_end"`     // minutes of the day
+	TimeZone        *string `json:"time_zone" bson:"time_zone"`                 // IANA time zone of the quiet hours, UTC if not set
+
+	Locale *string `json:"locale" bson:"locale"` // set when a device token is stored, the messages are localized for it
+
+	PausedUntil *time.Time `json:"paused_until" bson:"paused_until"` // no pushes are sent until then, the messages are still listed
 } //@name User
 
+// UserSettings represents the user preferences for receiving the pushes
+type UserSettings struct {
+	NotificationsEnabled bool       `json:"notifications_enabled"` // the user receives the pushes, the messages are listed either way
+	PausedUntil          *time.Time `json:"paused_until"`
+
+	QuietHoursStart *int    `json:"quiet_hours_start"`
+	QuietHoursEnd   *int    `json:"quiet_hours_end"`
+	TimeZone        *string `json:"time_zone"`
+} //@name UserSettings
+
+// GetSettings gives the user preferences for receiving the pushes
+func (t *User) GetSettings() UserSettings {
+	return UserSettings{NotificationsEnabled: !t.NotificationsDisabled, PausedUntil: t.PausedUntil,
+		QuietHoursStart: t.QuietHoursStart, QuietHoursEnd: t.QuietHoursEnd, TimeZone: t.TimeZone}
+}
+
+// NotificationsOff checks if the user does not receive any pushes at the given time - disabled or paused
+func (t *User) NotificationsOff(at time.Time) bool {
+	return t.NotificationsDisabled || (t.PausedUntil != nil && at.Before(*t.PausedUntil))
+}
+
 // AddToken adds topic to the list
 func (t *User) AddToken(token string) {
 	if t.DeviceTokens == nil {
@@ -63,6 +107,63 @@ func (t *User) RemoveToken(token string) {
 	}
 }
 
+// HasToken checks if the user has the device token
+func (t *User) HasToken(token string) bool {
+	for _, entry := range t.DeviceTokens {
+		if entry.Token == token {
+			return true
+		}
+	}
+	return false
+}
+
+// WithMaskedTokens gives a copy of the user with only the last 6 characters of its device tokens visible
+func (t User) WithMaskedTokens() User {
+	tokens := make([]DeviceToken, len(t.DeviceTokens))
+	for i, entry := range t.DeviceTokens {
+		entry.Token = MaskDeviceToken(entry.Token)
+		tokens[i] = entry
+	}
+	t.DeviceTokens = tokens
+	return t
+}
+
+// GetNotificationsTokens gives the tokens of the devices on which the user receives the pushes
+func (t *User) GetNotificationsTokens() []DeviceToken {
+	tokens := []DeviceToken{}
+	for _, entry := range t.DeviceTokens {
+		if !entry.NotificationsDisabled {
+			tokens = append(tokens, entry)
+		}
+	}
+	return tokens
+}
+
+// GetPushTokens gives the tokens to which a push is sent - every device if perDevice is set,
+// otherwise only the most recently registered device so that the user gets a single copy
+func (t *User) GetPushTokens(perDevice bool) []DeviceToken {
+	tokens := []DeviceToken{}
+	added := map[string]bool{}
+	for _, entry := range t.GetNotificationsTokens() {
+		if added[entry.Token] {
+			continue //the same token is sent once
+		}
+		added[entry.Token] = true
+		tokens = append(tokens, entry)
+	}
+	if perDevice || len(tokens) <= 1 {
+		return tokens
+	}
+
+	latest := tokens[0]
+	for _, entry := range tokens[1:] {
+		if entry.lastRegistered().After(latest.lastRegistered()) {
+			latest = entry
+		}
+	}
+	return []DeviceToken{latest}
+}
+
 // HasTopic checks if topic already exists
 func (t *User) HasTopic(topic string) bool {
 	exists := false
@@ -75,6 +176,90 @@ func (t *User) HasTopic(topic string) bool {
 	return exists
 }
 
+// HasMutedTopic checks if the user has muted the topic
+func (t *User) HasMutedTopic(topic string) bool {
+	for _, entry := range t.MutedTopics {
+		if topic == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// HasMutedAllTopics checks if the user has muted every topic of the given ones to which the user is subscribed.
+// It is false if the user is not subscribed to any of them.
+func (t *User) HasMutedAllTopics(topics []string) bool {
+	subscribed := false
+	for _, topic := range topics {
+		if !t.HasTopic(topic) {
+			continue
+		}
+		if !t.HasMutedTopic(topic) {
+			return false
+		}
+		subscribed = true
+	}
+	return subscribed
+}
+
+// IsActiveWithin checks if the user has been active within the given minutes
+func (t *User) IsActiveWithin(minutes int, now time.Time) bool {
+	if t.DateLastActive == nil {
+		return false
+	}
+	return t.DateLastActive.After(now.Add(-time.Duration(minutes) * time.Minute))
+}
+
+// QuietUntil gives the end of the quiet hours if the time is within them, nil if it is not
+func (t *User) QuietUntil(at time.Time) *time.Time {
+	if t.QuietHoursStart == nil || t.QuietHoursEnd == nil || *t.QuietHoursStart == *t.QuietHoursEnd {
+		return nil
+	}
+	loc, err := quietHoursLocation(t.TimeZone)
+	if err != nil {
+		return nil
+	}
+
+	localAt := at.In(loc)
+	//start from the previous day as the quiet hours may cross midnight
+	for day := -1; day <= 0; day++ {
+		year, month, date := localAt.AddDate(0, 0, day).Date()
+		midnight := time.Date(year, month, date, 0, 0, 0, 0, loc)
+		start := midnight.Add(time.Duration(*t.QuietHoursStart) * time.Minute)
+		end := midnight.Add(time.Duration(*t.QuietHoursEnd) * time.Minute)
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !localAt.Before(start) && localAt.Before(end) {
+			result := end.UTC()
+			return &result
+		}
+	}
+	return nil
+}
+
+// ValidateQuietHours checks the quiet hours settings, all of them may be nil to disable the quiet hours
+func ValidateQuietHours(start *int, end *int, timeZone *string) error {
+	if (start == nil) != (end == nil) {
+		return fmt.Errorf("%w: both start and end must be set", ErrInvalidQuietHours)
+	}
+	if start != nil && (*start < 0 || *start >= minutesPerDay || *end < 0 || *end >= minutesPerDay) {
+		return fmt.Errorf("%w: %d-%d minutes are out of the day", ErrInvalidQuietHours, *start, *end)
+	}
+	_, err := quietHoursLocation(timeZone)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidQuietHours, err)
+	}
+	return nil
+}
+
+func quietHoursLocation(timeZone *string) (*time.Location, error) {
+	if timeZone == nil || len(*timeZone) == 0 {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(*timeZone)
+}
+
 //////////////////////////
 
 // CoreAccount represents an account in the Core BB
@@ -87,6 +272,8 @@ type CoreAccount struct {
 type CoreProfile struct {
 	FirstName string `json:"first_name" bson:"first_name"`
 	LastName  string `json:"last_name" bson:"last_name"`
+	Email     string `json:"email" bson:"email"`
+	Phone     string `json:"phone" bson:"phone"`
 } //@name CoreProfile
 
 // Name returns the full name from the profile
@@ -0,0 +1,163 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	_ "time/tzdata" //the time zones do not depend on the system
+)
+
+func TestUserGetPushTokens(t *testing.T) {
+	day1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	day3 := day1.AddDate(0, 0, 2)
+
+	old := DeviceToken{Token: "old", DateCreated: day1}
+	renewed := DeviceToken{Token: "renewed", DateCreated: day1, DateUpdated: &day3}
+	recent := DeviceToken{Token: "recent", DateCreated: day2}
+	disabled := DeviceToken{Token: "disabled", DateCreated: day3.AddDate(0, 0, 1), NotificationsDisabled: true}
+
+	tests := []struct {
+		name      string
+		tokens    []DeviceToken
+		perDevice bool
+		want      []string
+	}{
+		{"no tokens", nil, false, []string{}},
+		{"no tokens per device", nil, true, []string{}},
+		{"latest device", []DeviceToken{old, recent}, false, []string{"recent"}},
+		{"latest device by the update", []DeviceToken{old, recent, renewed}, false, []string{"renewed"}},
+		{"every device", []DeviceToken{old, recent, renewed}, true, []string{"old", "recent", "renewed"}},
+		{"disabled device skipped", []DeviceToken{old, disabled}, false, []string{"old"}},
+		{"disabled device skipped per device", []DeviceToken{old, disabled, recent}, true, []string{"old", "recent"}},
+		{"duplicated token sent once", []DeviceToken{old, old, recent}, true, []string{"old", "recent"}},
+		{"only disabled devices", []DeviceToken{disabled}, false, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := User{DeviceTokens: tt.tokens}
+			tokens := user.GetPushTokens(tt.perDevice)
+			got := make([]string, len(tokens))
+			for i, token := range tokens {
+				got[i] = token.Token
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetPushTokens(%t) = %v, want %v", tt.perDevice, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserQuietUntil(t *testing.T) {
+	intPtr := func(value int) *int { return &value }
+	strPtr := func(value string) *string { return &value }
+	utc := func(hour int, minute int) time.Time { return time.Date(2024, 1, 10, hour, minute, 0, 0, time.UTC) }
+	timePtr := func(value time.Time) *time.Time { return &value }
+
+	tests := []struct {
+		name     string
+		start    *int
+		end      *int
+		timeZone *string
+		at       time.Time
+		want     *time.Time
+	}{
+		{"not set", nil, nil, nil, utc(3, 0), nil},
+		{"only start set", intPtr(60), nil, nil, utc(3, 0), nil},
+		{"empty window", intPtr(60), intPtr(60), nil, utc(1, 0), nil},
+		{"within", intPtr(9 * 60), intPtr(17 * 60), nil, utc(12, 0), timePtr(utc(17, 0))},
+		{"at the start", intPtr(9 * 60), intPtr(17 * 60), nil, utc(9, 0), timePtr(utc(17, 0))},
+		{"at the end", intPtr(9 * 60), intPtr(17 * 60), nil, utc(17, 0), nil},
+		{"before", intPtr(9 * 60), intPtr(17 * 60), nil, utc(8, 59), nil},
+		{"crossing midnight before midnight", intPtr(22 * 60), intPtr(7 * 60), nil, utc(23, 30), timePtr(utc(7, 0).AddDate(0, 0, 1))},
+		{"crossing midnight after midnight", intPtr(22 * 60), intPtr(7 * 60), nil, utc(2, 0), timePtr(utc(7, 0))},
+		{"crossing midnight outside", intPtr(22 * 60), intPtr(7 * 60), nil, utc(12, 0), nil},
+		{"crossing midnight at the end", intPtr(22 * 60), intPtr(7 * 60), nil, utc(7, 0), nil},
+		{"time zone", intPtr(22 * 60), intPtr(7 * 60), strPtr("America/Chicago"), utc(5, 0), timePtr(utc(13, 0))},
+		{"time zone outside", intPtr(22 * 60), intPtr(7 * 60), strPtr("America/Chicago"), utc(14, 0), nil},
+		{"unknown time zone", intPtr(22 * 60), intPtr(7 * 60), strPtr("Nowhere/Unknown"), utc(23, 0), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := User{QuietHoursStart: tt.start, QuietHoursEnd: tt.end, TimeZone: tt.timeZone}
+			got := user.QuietUntil(tt.at)
+			if (got == nil) != (tt.want == nil) || (got != nil && !got.Equal(*tt.want)) {
+				t.Errorf("QuietUntil(%s) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateQuietHours(t *testing.T) {
+	intPtr := func(value int) *int { return &value }
+	strPtr := func(value string) *string { return &value }
+
+	tests := []struct {
+		name     string
+		start    *int
+		end      *int
+		timeZone *string
+		wantErr  bool
+	}{
+		{"not set", nil, nil, nil, false},
+		{"valid", intPtr(22 * 60), intPtr(7 * 60), strPtr("Europe/Sofia"), false},
+		{"valid without time zone", intPtr(0), intPtr(minutesPerDay - 1), nil, false},
+		{"empty time zone", intPtr(60), intPtr(120), strPtr(""), false},
+		{"only start", intPtr(60), nil, nil, true},
+		{"only end", nil, intPtr(60), nil, true},
+		{"negative start", intPtr(-1), intPtr(60), nil, true},
+		{"end out of the day", intPtr(60), intPtr(minutesPerDay), nil, true},
+		{"unknown time zone", intPtr(60), intPtr(120), strPtr("Nowhere/Unknown"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateQuietHours(tt.start, tt.end, tt.timeZone)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateQuietHours() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidQuietHours) {
+				t.Errorf("ValidateQuietHours() error = %v, want ErrInvalidQuietHours", err)
+			}
+		})
+	}
+}
+
+func TestUserHasMutedAllTopics(t *testing.T) {
+	user := User{Topics: []string{"news", "sports", "events"}, MutedTopics: []string{"news", "sports", "weather"}}
+
+	tests := []struct {
+		name   string
+		topics []string
+		want   bool
+	}{
+		{"muted topic", []string{"news"}, true},
+		{"not muted topic", []string{"events"}, false},
+		{"all muted", []string{"news", "sports"}, true},
+		{"one not muted", []string{"news", "events"}, false},
+		{"muted and not subscribed", []string{"news", "traffic"}, true},
+		{"muted but not subscribed", []string{"weather"}, false},
+		{"no topics", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := user.HasMutedAllTopics(tt.topics); got != tt.want {
+				t.Errorf("HasMutedAllTopics(%v) = %t, want %t", tt.topics, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,727 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"notifications/core/model"
+	"notifications/driven/storage"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+// fakeStorage keeps the data of a test in memory. The storage methods which it does not implement panic.
+type fakeStorage struct {
+	Storage
+
+	messages   map[string]model.Message
+	recipients []model.MessageRecipient
+	users      []model.User
+	topicUsers []model.User
+	topics     []model.Topic
+
+	createdMessages    []model.Message
+	updatedMessages    []model.Message
+	deletedMessages    []string
+	insertedMessages   []model.Message
+	insertedRecipients []model.MessageRecipient
+	insertedQueueItems []model.QueueItem
+	summaryDeltas      map[string]model.DeliverySummary //by message
+	deliveryStatuses   map[string]string                //by recipient
+	usersCounts        int                              //the users counts read from the storage
+	deletedQueueItems  []string
+	deferredQueueItems []string
+	insertedRetries    []model.PushRetry
+	deliveryLogs       []model.DeliveryLog
+
+	lock sync.Mutex //the delivery is recorded concurrently
+}
+
+func newFakeStorage(messages ...model.Message) *fakeStorage {
+	s := fakeStorage{messages: map[string]model.Message{}, summaryDeltas: map[string]model.DeliverySummary{}, deliveryStatuses: map[string]string{}}
+	for _, message := range messages {
+		s.messages[message.ID] = message
+	}
+	return &s
+}
+
+// newTestApplication creates an application on the storage which does not start the background processing
+func newTestApplication(s Storage) *Application {
+	return NewApplication("test", "test", s, nil, nil, logs.NewLogger("notifications", nil), nil, nil, nil, nil, nil, nil, &model.Config{})
+}
+
+func (s *fakeStorage) PerformTransaction(transaction func(context storage.TransactionContext) error, timeoutMilliSeconds int64) error {
+	return transaction(nil)
+}
+
+func (s *fakeStorage) PerformTransactionWithContext(ctx context.Context, transaction func(context storage.TransactionContext) error, timeoutMilliSeconds int64) error {
+	return transaction(nil)
+}
+
+func (s *fakeStorage) GetMessage(orgID string, appID string, ID string) (*model.Message, error) {
+	message, ok := s.messages[ID]
+	if !ok || message.OrgID != orgID || message.AppID != appID {
+		return nil, nil
+	}
+	return &message, nil
+}
+
+func (s *fakeStorage) CreateMessageWithContext(ctx context.Context, message model.Message) (*model.Message, error) {
+	s.createdMessages = append(s.createdMessages, message)
+	return &message, nil
+}
+
+func (s *fakeStorage) UpdateMessage(message *model.Message) (*model.Message, error) {
+	s.updatedMessages = append(s.updatedMessages, *message)
+	return message, nil
+}
+
+func (s *fakeStorage) FindMessagesRecipientsByMessages(orgID string, appID string, messagesIDs []string) ([]model.MessageRecipient, error) {
+	result := []model.MessageRecipient{}
+	for _, recipient := range s.recipients {
+		for _, messageID := range messagesIDs {
+			if recipient.OrgID == orgID && recipient.AppID == appID && recipient.MessageID == messageID {
+				result = append(result, recipient)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeStorage) ApproveMessageWithContext(ctx context.Context, orgID string, appID string, messageID string, approvedBy model.CoreAccountRef, dateApproved time.Time) (bool, error) {
+	message, ok := s.messages[messageID]
+	if !ok || message.OrgID != orgID || message.AppID != appID || !message.IsPendingApproval() {
+		return false, nil
+	}
+	approvalStatus := model.ApprovalStatusApproved
+	message.ApprovalStatus = &approvalStatus
+	s.messages[messageID] = message
+	return true, nil
+}
+
+func (s *fakeStorage) ReleaseMessageRecipientsWithContext(ctx context.Context, messageID string) error {
+	for i := range s.recipients {
+		if s.recipients[i].MessageID == messageID {
+			s.recipients[i].PendingApproval = false
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) GetMessagesAfter(ctx context.Context, orgID string, appID string, userID string, cursor model.Message, limit int64, fields []string) ([]model.MessageRecipient, error) {
+	result := []model.MessageRecipient{}
+	for _, recipient := range s.recipients {
+		message := s.messages[recipient.MessageID]
+		after := message.Time.After(cursor.Time) || (message.Time.Equal(cursor.Time) && message.ID > cursor.ID)
+		if recipient.OrgID == orgID && recipient.AppID == appID && recipient.UserID == userID && after {
+			recipient.Message = message
+			result = append(result, recipient)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Message.Time.Equal(result[j].Message.Time) {
+			return result[i].MessageID < result[j].MessageID
+		}
+		return result[i].Message.Time.Before(result[j].Message.Time)
+	})
+	if int64(len(result)) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (s *fakeStorage) FindMessagesRecipients(orgID string, appID string, messageID string, userID string) ([]model.MessageRecipient, error) {
+	result := []model.MessageRecipient{}
+	for _, recipient := range s.recipients {
+		if recipient.OrgID == orgID && recipient.AppID == appID && recipient.MessageID == messageID && recipient.UserID == userID {
+			result = append(result, recipient)
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeStorage) FindFailedMessageRecipients(orgID string, appID string, messageID string) ([]model.MessageRecipient, error) {
+	result := []model.MessageRecipient{}
+	for _, recipient := range s.recipients {
+		if recipient.OrgID == orgID && recipient.AppID == appID && recipient.MessageID == messageID &&
+			recipient.DeliveryStatus != nil && *recipient.DeliveryStatus == model.DeliveryStatusFailed {
+			result = append(result, recipient)
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeStorage) AddMessageReport(orgID string, appID string, messageID string, report model.MessageReport) (bool, error) {
+	message, ok := s.messages[messageID]
+	if !ok || message.OrgID != orgID || message.AppID != appID {
+		return false, nil
+	}
+	for _, existing := range message.Reports {
+		if existing.ReporterID == report.ReporterID {
+			return false, nil
+		}
+	}
+	message.Reports = append(message.Reports, report)
+	message.ReportsCount++
+	s.messages[messageID] = message
+	return true, nil
+}
+
+func (s *fakeStorage) FlagMessage(orgID string, appID string, messageID string, reportsThreshold int) (bool, error) {
+	message, ok := s.messages[messageID]
+	if !ok || message.OrgID != orgID || message.AppID != appID || message.ReportsCount < reportsThreshold || message.Flagged {
+		return false, nil
+	}
+	message.Flagged = true
+	s.messages[messageID] = message
+	return true, nil
+}
+
+func (s *fakeStorage) GetUserMessagesStats(orgID string, appID string, userID string) (*model.MessagesStats, error) {
+	var total, read int64
+	for _, recipient := range s.recipients {
+		if recipient.OrgID == orgID && recipient.AppID == appID && recipient.UserID == userID {
+			total++
+			if recipient.Read {
+				read++
+			}
+		}
+	}
+	unread := total - read
+	return &model.MessagesStats{TotalCount: &total, Read: &read, Unread: &unread}, nil
+}
+
+func (s *fakeStorage) IncrementMessageDeliverySummaryWithContext(ctx context.Context, orgID string, appID string, messageID string, delta model.DeliverySummary) error {
+	message, ok := s.messages[messageID]
+	if !ok || message.OrgID != orgID || message.AppID != appID {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	summary := s.summaryDeltas[messageID]
+	summary.Sent += delta.Sent
+	summary.Delivered += delta.Delivered
+	summary.Read += delta.Read
+	summary.Failed += delta.Failed
+	summary.Expired += delta.Expired
+	s.summaryDeltas[messageID] = summary
+	return nil
+}
+
+func (s *fakeStorage) UpdateMessageRecipientDeliveryStatus(orgID string, appID string, recipientID string, status string, errorCode *string) error {
+	for _, recipient := range s.recipients {
+		if recipient.OrgID == orgID && recipient.AppID == appID && recipient.ID == recipientID {
+			s.lock.Lock()
+			s.deliveryStatuses[recipientID] = status
+			s.lock.Unlock()
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) GetUsersByTopicsWithContext(ctx context.Context, orgID string, appID string, topics []string) ([]model.User, error) {
+	return s.topicUsers, nil
+}
+
+func (s *fakeStorage) CountUsersByTopic(orgID string, appID string, topic string) (int64, error) {
+	s.usersCounts++
+	var count int64
+	for _, user := range s.topicUsers {
+		for _, userTopic := range user.Topics {
+			if user.OrgID == orgID && user.AppID == appID && userTopic == topic {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+func (s *fakeStorage) CountUsersByTopics(orgID string, appID string, topics []string, all bool) (int64, error) {
+	var count int64
+	for _, user := range s.topicUsers {
+		matched := 0
+		for _, topic := range topics {
+			for _, userTopic := range user.Topics {
+				if userTopic == topic {
+					matched++
+					break
+				}
+			}
+		}
+		if user.OrgID == orgID && user.AppID == appID && ((all && matched == len(topics)) || (!all && matched > 0)) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *fakeStorage) GetTopics(orgID string, appID string) ([]model.Topic, error) {
+	result := []model.Topic{}
+	for _, topic := range s.topics {
+		if topic.OrgID == orgID && topic.AppID == appID {
+			result = append(result, topic)
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeStorage) CountTopicsSubscribers(orgID string, appID string) (map[string]int, error) {
+	counts := map[string]int{}
+	for _, user := range s.topicUsers {
+		if user.OrgID == orgID && user.AppID == appID {
+			for _, topic := range user.Topics {
+				counts[topic]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+func (s *fakeStorage) GetTopicByName(orgID string, appID string, name string) (*model.Topic, error) {
+	for _, topic := range s.topics {
+		if topic.OrgID == orgID && topic.AppID == appID && topic.Name == name {
+			return &topic, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeStorage) FindTopicByAlias(orgID string, appID string, alias string) (*model.Topic, error) {
+	for _, topic := range s.topics {
+		for _, topicAlias := range topic.Aliases {
+			if topic.OrgID == orgID && topic.AppID == appID && topicAlias == alias {
+				return &topic, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeStorage) FindTopicsWithRetention() ([]model.Topic, error) {
+	result := []model.Topic{}
+	for _, topic := range s.topics {
+		if topic.RetentionDays != nil && *topic.RetentionDays > 0 {
+			result = append(result, topic)
+		}
+	}
+	return result, nil
+}
+
+// messageInTopic checks if the message is sent to the topic
+func messageInTopic(message model.Message, topic model.Topic) bool {
+	if message.OrgID != topic.OrgID || message.AppID != topic.AppID {
+		return false
+	}
+	for _, messageTopic := range model.MergeTopics(message.Topic, message.Topics) {
+		if messageTopic == topic.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fakeStorage) FindMessagesCreatedBefore(before time.Time, topic *model.Topic, excludedTopics []model.Topic) ([]model.Message, error) {
+	result := []model.Message{}
+	for _, message := range s.messages {
+		if message.DateCreated == nil || !message.DateCreated.Before(before) || (topic != nil && !messageInTopic(message, *topic)) {
+			continue
+		}
+		excluded := false
+		for _, excludedTopic := range excludedTopics {
+			excluded = excluded || messageInTopic(message, excludedTopic)
+		}
+		if !excluded {
+			result = append(result, message)
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeStorage) FindMessagesExpiredBefore(before time.Time) ([]model.Message, error) {
+	result := []model.Message{}
+	for _, message := range s.messages {
+		if message.ExpiresAt != nil && message.ExpiresAt.Before(before) {
+			result = append(result, message)
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeStorage) InsertTopicWithContext(ctx context.Context, topic model.Topic) error {
+	s.topics = append(s.topics, topic)
+	return nil
+}
+
+func (s *fakeStorage) DeleteTopicWithContext(ctx context.Context, orgID string, appID string, name string) error {
+	topics := []model.Topic{}
+	for _, topic := range s.topics {
+		if topic.OrgID != orgID || topic.AppID != appID || topic.Name != name {
+			topics = append(topics, topic)
+		}
+	}
+	s.topics = topics
+	return nil
+}
+
+func (s *fakeStorage) RenameUsersTopicWithContext(ctx context.Context, orgID string, appID string, oldName string, newName string) error {
+	for i, user := range s.topicUsers {
+		for j, topic := range user.Topics {
+			if user.OrgID == orgID && user.AppID == appID && topic == oldName {
+				s.topicUsers[i].Topics[j] = newName
+			}
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) RenameMessagesTopicWithContext(ctx context.Context, orgID string, appID string, oldName string, newName string) error {
+	for id, message := range s.messages {
+		if message.OrgID == orgID && message.AppID == appID && message.Topic != nil && *message.Topic == oldName {
+			topic := newName
+			message.Topic = &topic
+			s.messages[id] = message
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) FindUserByID(orgID string, appID string, userID string) (*model.User, error) {
+	for _, user := range s.users {
+		if user.OrgID == orgID && user.AppID == appID && user.UserID == userID {
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeStorage) InsertUser(orgID string, appID string, userID string) (*model.User, error) {
+	user := model.User{OrgID: orgID, AppID: appID, UserID: userID}
+	s.users = append(s.users, user)
+	return &user, nil
+}
+
+// updateUser applies the change to the user, it gives nil if there is no such user
+func (s *fakeStorage) updateUser(orgID string, appID string, userID string, change func(user *model.User)) *model.User {
+	for i, user := range s.users {
+		if user.OrgID == orgID && user.AppID == appID && user.UserID == userID {
+			change(&s.users[i])
+			updated := s.users[i]
+			return &updated
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) UpdateUserSettings(orgID string, appID string, userID string, settings model.UserSettings) (*model.User, error) {
+	return s.updateUser(orgID, appID, userID, func(user *model.User) {
+		user.NotificationsDisabled = !settings.NotificationsEnabled
+		user.PausedUntil = settings.PausedUntil
+		user.QuietHoursStart = settings.QuietHoursStart
+		user.QuietHoursEnd = settings.QuietHoursEnd
+		user.TimeZone = settings.TimeZone
+	}), nil
+}
+
+func (s *fakeStorage) UpdateUserMutedTopics(orgID string, appID string, userID string, mutedTopics []string) (*model.User, error) {
+	return s.updateUser(orgID, appID, userID, func(user *model.User) { user.MutedTopics = mutedTopics }), nil
+}
+
+func (s *fakeStorage) RemoveUserTopics(orgID string, appID string, userID string, topics []string) error {
+	s.updateUser(orgID, appID, userID, func(user *model.User) {
+		kept := []string{}
+		for _, topic := range user.Topics {
+			removed := false
+			for _, removedTopic := range topics {
+				removed = removed || topic == removedTopic
+			}
+			if !removed {
+				kept = append(kept, topic)
+			}
+		}
+		user.Topics = kept
+	})
+	return nil
+}
+
+func (s *fakeStorage) SubscribeToTopic(orgID string, appID string, token string, userID string, topic string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.updateUser(orgID, appID, userID, func(user *model.User) {
+		for _, userTopic := range user.Topics {
+			if userTopic == topic {
+				return
+			}
+		}
+		user.Topics = append(user.Topics, topic)
+	})
+	return nil
+}
+
+func (s *fakeStorage) UnsubscribeToTopic(orgID string, appID string, token string, userID string, topic string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.updateUser(orgID, appID, userID, func(user *model.User) {
+		topics := []string{}
+		for _, userTopic := range user.Topics {
+			if userTopic != topic {
+				topics = append(topics, userTopic)
+			}
+		}
+		user.Topics = topics
+	})
+	return nil
+}
+
+func (s *fakeStorage) IncrementDeviceTokenFailures(orgID string, appID string, userID string, token string) (int, error) {
+	failures := 0
+	s.updateUser(orgID, appID, userID, func(user *model.User) {
+		for i, deviceToken := range user.DeviceTokens {
+			if deviceToken.Token == token {
+				user.DeviceTokens[i].FailuresCount++
+				failures = user.DeviceTokens[i].FailuresCount
+			}
+		}
+	})
+	return failures, nil
+}
+
+func (s *fakeStorage) ResetDeviceTokenFailures(orgID string, appID string, userID string, token string) error {
+	s.updateUser(orgID, appID, userID, func(user *model.User) {
+		for i, deviceToken := range user.DeviceTokens {
+			if deviceToken.Token == token {
+				user.DeviceTokens[i].FailuresCount = 0
+			}
+		}
+	})
+	return nil
+}
+
+func (s *fakeStorage) RemoveDeviceToken(orgID string, appID string, userID string, token string) error {
+	s.updateUser(orgID, appID, userID, func(user *model.User) {
+		tokens := []model.DeviceToken{}
+		for _, deviceToken := range user.DeviceTokens {
+			if deviceToken.Token != token {
+				tokens = append(tokens, deviceToken)
+			}
+		}
+		user.DeviceTokens = tokens
+	})
+	return nil
+}
+
+func (s *fakeStorage) FindDeadDeviceTokens(orgID string, appID string, minFailures int) ([]model.DeadDeviceToken, error) {
+	result := []model.DeadDeviceToken{}
+	for _, user := range s.users {
+		if user.OrgID != orgID || user.AppID != appID {
+			continue
+		}
+		for _, deviceToken := range user.DeviceTokens {
+			if deviceToken.FailuresCount >= minFailures {
+				result = append(result, model.DeadDeviceToken{UserID: user.UserID, Token: deviceToken.Token, TokenType: deviceToken.TokenType,
+					FailuresCount: deviceToken.FailuresCount})
+			}
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].FailuresCount > result[j].FailuresCount })
+	return result, nil
+}
+
+func (s *fakeStorage) RemoveDeadDeviceTokens(orgID string, appID string, minFailures int) (int64, error) {
+	var usersCount int64
+	for i, user := range s.users {
+		if user.OrgID != orgID || user.AppID != appID {
+			continue
+		}
+		tokens := []model.DeviceToken{}
+		for _, deviceToken := range user.DeviceTokens {
+			if deviceToken.FailuresCount < minFailures {
+				tokens = append(tokens, deviceToken)
+			}
+		}
+		if len(tokens) < len(user.DeviceTokens) {
+			s.users[i].DeviceTokens = tokens
+			usersCount++
+		}
+	}
+	return usersCount, nil
+}
+
+func (s *fakeStorage) UpdateUserLastActive(orgID string, appID string, userID string, lastActive time.Time) error {
+	s.updateUser(orgID, appID, userID, func(user *model.User) { user.DateLastActive = &lastActive })
+	return nil
+}
+
+func (s *fakeStorage) FindUsersByIDs(orgID string, appID string, usersIDs []string) ([]model.User, error) {
+	result := []model.User{}
+	for _, user := range s.users {
+		for _, userID := range usersIDs {
+			if user.OrgID == orgID && user.AppID == appID && user.UserID == userID {
+				result = append(result, user)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeStorage) DeleteQueueDataForMessagesWithContext(ctx context.Context, messagesIDs []string) error {
+	return nil
+}
+
+func (s *fakeStorage) DeleteMessagesRecipientsForMessagesWithContext(ctx context.Context, messagesIDs []string) error {
+	return nil
+}
+
+func (s *fakeStorage) DeleteMessagesWithContext(ctx context.Context, orgID string, appID string, ids []string) error {
+	for _, id := range ids {
+		message, ok := s.messages[id]
+		if ok && message.OrgID == orgID && message.AppID == appID {
+			delete(s.messages, id)
+			s.deletedMessages = append(s.deletedMessages, id)
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) SoftDeleteMessageWithContext(ctx context.Context, orgID string, appID string, messageID string, dateDeleted time.Time) (bool, error) {
+	message, ok := s.messages[messageID]
+	if !ok || message.OrgID != orgID || message.AppID != appID || message.Deleted {
+		return false, nil
+	}
+	message.Deleted = true
+	message.DateDeleted = &dateDeleted
+	s.messages[messageID] = message
+	return true, nil
+}
+
+func (s *fakeStorage) RestoreMessageWithContext(ctx context.Context, orgID string, appID string, messageID string, dateRestored time.Time) (bool, error) {
+	message, ok := s.messages[messageID]
+	if !ok || message.OrgID != orgID || message.AppID != appID || !message.Deleted {
+		return false, nil
+	}
+	message.Deleted = false
+	message.DateDeleted = nil
+	message.DateUpdated = &dateRestored
+	s.messages[messageID] = message
+	return true, nil
+}
+
+func (s *fakeStorage) UpdateMessageRecipientsDeletedWithContext(ctx context.Context, messageID string, deleted bool) error {
+	for i := range s.recipients {
+		if s.recipients[i].MessageID == messageID {
+			s.recipients[i].Deleted = deleted
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) InsertMessagesWithContext(ctx context.Context, messages []model.Message) error {
+	s.insertedMessages = append(s.insertedMessages, messages...)
+	return nil
+}
+
+func (s *fakeStorage) InsertMessagesRecipientsWithContext(ctx context.Context, items []model.MessageRecipient) error {
+	s.insertedRecipients = append(s.insertedRecipients, items...)
+	return nil
+}
+
+func (s *fakeStorage) InsertQueueDataItemsWithContext(ctx context.Context, items []model.QueueItem) error {
+	s.insertedQueueItems = append(s.insertedQueueItems, items...)
+	return nil
+}
+
+func (s *fakeStorage) RemoveStaleDeviceTokens(before time.Time) (int64, int64, error) {
+	var removedTokens, deletedUsers int64
+	users := []model.User{}
+	for _, user := range s.users {
+		deviceTokens := []model.DeviceToken{}
+		for _, deviceToken := range user.DeviceTokens {
+			lastRegistered := deviceToken.DateCreated
+			if deviceToken.DateUpdated != nil {
+				lastRegistered = *deviceToken.DateUpdated
+			}
+			if lastRegistered.Before(before) {
+				removedTokens++
+			} else {
+				deviceTokens = append(deviceTokens, deviceToken)
+			}
+		}
+		if len(deviceTokens) < len(user.DeviceTokens) && len(deviceTokens) == 0 && len(user.Topics) == 0 {
+			deletedUsers++
+			continue
+		}
+		user.DeviceTokens = deviceTokens
+		users = append(users, user)
+	}
+	s.users = users
+	return removedTokens, deletedUsers, nil
+}
+
+func (s *fakeStorage) InsertPushRetry(retry model.PushRetry) error {
+	s.insertedRetries = append(s.insertedRetries, retry)
+	return nil
+}
+
+func (s *fakeStorage) CountPushRetriesForMessage(messageID string) (int64, error) {
+	var count int64
+	for _, retry := range s.insertedRetries {
+		if retry.MessageID == messageID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *fakeStorage) DeleteQueueData(ids []string) error {
+	s.deletedQueueItems = append(s.deletedQueueItems, ids...)
+	return nil
+}
+
+func (s *fakeStorage) UpdateQueueDataTime(ids []string, time time.Time) error {
+	s.deferredQueueItems = append(s.deferredQueueItems, ids...)
+	return nil
+}
+
+func (s *fakeStorage) UpdateMessageRecipientsDeliveryStatus(recipientsIDs []string, status string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, recipientID := range recipientsIDs {
+		s.deliveryStatuses[recipientID] = status
+	}
+	return nil
+}
+
+func (s *fakeStorage) LogDelivery(entry model.DeliveryLog) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.deliveryLogs = append(s.deliveryLogs, entry)
+	return nil
+}
+
+func (s *fakeStorage) CountQueueDataForMessage(messageID string) (int64, error) {
+	var count int64
+	for _, item := range s.insertedQueueItems {
+		if item.MessageID == messageID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *fakeStorage) LoadQueueWithContext(ctx context.Context) (*model.Queue, error) {
+	return nil, nil //the queue is not processed
+}
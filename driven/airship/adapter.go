@@ -23,7 +23,7 @@ func NewAirshipAdapter(host string, bearerToken string) *Adapter {
 }
 
 // SendNotificationToToken sends a notification to an Airship token
-func (a *Adapter) SendNotificationToToken(orgID string, appID string, deviceToken string, title string, body string, data map[string]string) error {
+func (a *Adapter) SendNotificationToToken(orgID string, appID string, deviceToken string, title string, body string, sound string, data map[string]string) error {
 	url := fmt.Sprintf("%s/api/push", a.host)
 
 	client := &http.Client{
@@ -42,6 +42,14 @@ func (a *Adapter) SendNotificationToToken(orgID string, appID string, deviceToke
 		"title": title,
 		"alert": body,
 	}
+	//without title and body it is a silent push, the data is given to the app as extras
+	if len(title) == 0 && len(body) == 0 {
+		ios = m{"content_available": true, "extra": data}
+		android = m{"extra": data}
+	}
+	if len(sound) > 0 {
+		ios["sound"] = sound
+	}
 
 	if val, ok := data["url"]; ok {
 		actions := m{
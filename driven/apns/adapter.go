@@ -0,0 +1,226 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apns
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"notifications/core/model"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHost = "https://api.push.apple.com"
+
+	//iOS plays no sound if the aps dictionary does not have one
+	defaultSound = "default"
+
+	//APNs rejects the provider tokens older than an hour and the ones refreshed more often than every 20 minutes
+	providerTokenTTL = 50 * time.Minute
+)
+
+type m map[string]interface{}
+
+// Adapter is the Apple Push Notification service adapter
+type Adapter struct {
+	host     string
+	keyID    string
+	teamID   string
+	bundleID string
+
+	key *ecdsa.PrivateKey
+
+	client *http.Client
+
+	tokenLock   sync.Mutex
+	token       string
+	tokenIssued time.Time
+}
+
+// NewAPNsAdapter creates a new APNs adapter instance
+func NewAPNsAdapter(host string, keyID string, teamID string, bundleID string) *Adapter {
+	if len(host) == 0 {
+		host = defaultHost
+	}
+	return &Adapter{host: host, keyID: keyID, teamID: teamID, bundleID: bundleID,
+		client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Start starts the adapter with the .p8 auth key
+func (a *Adapter) Start(authKey string) error {
+	block, _ := pem.Decode([]byte(authKey))
+	if block == nil {
+		return errors.New("error decoding the apns auth key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("error parsing the apns auth key - %s", err)
+	}
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return errors.New("the apns auth key is not an ecdsa key")
+	}
+	a.key = ecdsaKey
+	return nil
+}
+
+// SendNotificationToToken sends a notification to an APNs device token. The device keeps only the latest notification with the same non empty collapse key.
+// The default sound is played if the sound is empty and the app badge is left unchanged if the badge is nil.
+func (a *Adapter) SendNotificationToToken(orgID string, appID string, deviceToken string, title string, body string, sound string, badge *int, collapseKey string, data map[string]string) error {
+	if a.key == nil {
+		return errors.New("the apns adapter is not started")
+	}
+
+	providerToken, err := a.getProviderToken()
+	if err != nil {
+		return err
+	}
+
+	//the data fields are given next to the aps dictionary
+	payload := m{}
+	for key, value := range data {
+		payload[key] = value
+	}
+	//without title and body it is a silent push which wakes up the app in the background
+	silent := len(title) == 0 && len(body) == 0
+	aps := m{}
+	if silent {
+		aps["content-available"] = 1
+	} else {
+		aps["alert"] = m{
+			"title": title,
+			"body":  body,
+		}
+	}
+	if !silent {
+		if len(sound) == 0 {
+			sound = defaultSound
+		}
+		aps["sound"] = sound
+		if badge != nil {
+			aps["badge"] = *badge
+		}
+	}
+	payload["aps"] = aps
+	bodyBytes, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("error marshalling apns notification request - %s", err)
+		return err
+	}
+
+	url := fmt.Sprintf("%s/3/device/%s", a.host, deviceToken)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(bodyBytes))
+	if err != nil {
+		log.Printf("error creating apns notification request - %s", err)
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("bearer %s", providerToken))
+	req.Header.Set("apns-topic", a.bundleID)
+	if silent {
+		req.Header.Set("apns-push-type", "background")
+		req.Header.Set("apns-priority", "5") //the background pushes must have the low priority
+	} else {
+		req.Header.Set("apns-push-type", "alert")
+		req.Header.Set("apns-priority", "10")
+	}
+	if len(collapseKey) > 0 {
+		req.Header.Set("apns-collapse-id", collapseKey)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		log.Printf("error sending apns notification to token (%s) - %s", deviceToken, err)
+		return &model.DeliveryError{Code: model.DeliveryErrorInternal, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var respData struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&respData)
+
+	code := getDeliveryErrorCode(resp.StatusCode, respData.Reason)
+	if code == model.DeliveryErrorUnregistered || code == model.DeliveryErrorInvalidArgument {
+		//let the caller know that the token may need to be removed
+		err = fmt.Errorf("error while sending notification to token (%s): %w - %d %s", deviceToken, model.ErrInvalidDeviceToken, resp.StatusCode, respData.Reason)
+	} else {
+		err = fmt.Errorf("error while sending notification to token (%s): %d %s", deviceToken, resp.StatusCode, respData.Reason)
+	}
+	return &model.DeliveryError{Code: code, Err: err}
+}
+
+// getDeliveryErrorCode maps the APNs response to a delivery error code
+func getDeliveryErrorCode(status int, reason string) string {
+	switch {
+	case status == http.StatusGone || reason == "Unregistered":
+		return model.DeliveryErrorUnregistered
+	case reason == "BadDeviceToken" || reason == "DeviceTokenNotForTopic":
+		return model.DeliveryErrorInvalidArgument
+	case status == http.StatusTooManyRequests:
+		return model.DeliveryErrorQuotaExceeded
+	default:
+		return model.DeliveryErrorInternal
+	}
+}
+
+// getProviderToken gives the signed JWT for the APNs requests, it is reused until it is close to expire
+func (a *Adapter) getProviderToken() (string, error) {
+	a.tokenLock.Lock()
+	defer a.tokenLock.Unlock()
+
+	now := time.Now()
+	if len(a.token) > 0 && now.Sub(a.tokenIssued) < providerTokenTTL {
+		return a.token, nil
+	}
+
+	header, err := json.Marshal(m{"alg": "ES256", "kid": a.keyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(m{"iss": a.teamID, "iat": now.Unix()})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("error signing the apns provider token - %s", err)
+	}
+	//ES256 signature is r and s as 32 bytes each
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	a.token = unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+	a.tokenIssued = now
+	return a.token, nil
+}
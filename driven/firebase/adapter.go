@@ -19,7 +19,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"notifications/core/model"
+	"strconv"
+	"time"
 
 	firebase "firebase.google.com/go"
 	"firebase.google.com/go/messaging"
@@ -27,15 +30,56 @@ import (
 	"google.golang.org/api/option"
 )
 
+// maxMulticastTokens is the max number of tokens which FCM accepts in one multicast send
+const maxMulticastTokens = 500
+
+// defaultAPNsSound plays the iOS default sound, iOS plays no sound if the aps dictionary does not have one
+const defaultAPNsSound = "default"
+
+// Default message priority thresholds
+const (
+	defaultAndroidHighPriority = 1000 //the same as the quiet hours bypass
+	defaultAPNsHighPriority    = 1000 //the same as the Android one
+)
+
 // Adapter entity
 type Adapter struct {
 	//key is org-id_app-id construction
 	firebaseClients map[string]firebase.App
+
+	//a send which takes more than this is cancelled
+	sendTimeout time.Duration
+
+	//the messages with at least these priorities get the Android "high" priority and the APNs priority 10, otherwise "normal" and 5
+	androidHighPriority int
+	apnsHighPriority    int
+
+	//the Android notification channel of the messages which do not give one, Android 8+ does not show a notification without a channel
+	defaultAndroidChannel string
 }
 
 // NewFirebaseAdapter instance a new Firebase adapter
-func NewFirebaseAdapter() *Adapter {
-	return &Adapter{firebaseClients: make(map[string]firebase.App)}
+func NewFirebaseAdapter(sendTimeout string, androidHighPriority string, apnsHighPriority string, defaultAndroidChannel string) *Adapter {
+	timeout, err := strconv.Atoi(sendTimeout)
+	if err != nil {
+		log.Println("Set default firebase send timeout - 10000")
+		timeout = 10000
+	}
+	timeoutMS := time.Millisecond * time.Duration(timeout)
+
+	androidThreshold, err := strconv.Atoi(androidHighPriority)
+	if err != nil {
+		log.Printf("Set default firebase android high priority - %d", defaultAndroidHighPriority)
+		androidThreshold = defaultAndroidHighPriority
+	}
+	apnsThreshold, err := strconv.Atoi(apnsHighPriority)
+	if err != nil {
+		log.Printf("Set default firebase apns high priority - %d", defaultAPNsHighPriority)
+		apnsThreshold = defaultAPNsHighPriority
+	}
+
+	return &Adapter{firebaseClients: make(map[string]firebase.App), sendTimeout: timeoutMS,
+		androidHighPriority: androidThreshold, apnsHighPriority: apnsThreshold, defaultAndroidChannel: defaultAndroidChannel}
 }
 
 // Start starts the firebase adapter
@@ -48,6 +92,11 @@ func (fa *Adapter) UpdateFirebaseConfigurations(firebaseConfs []model.FirebaseCo
 	return fa.setFirebaseClients(firebaseConfs)
 }
 
+// IsReady tells if there is at least one firebase client
+func (fa *Adapter) IsReady() bool {
+	return len(fa.firebaseClients) > 0
+}
+
 func (fa *Adapter) setFirebaseClients(firebaseConfs []model.FirebaseConf) error {
 	//1. check if there are configs data
 	if len(firebaseConfs) == 0 {
@@ -91,32 +140,197 @@ func (fa *Adapter) getFirebaseClient(orgID string, appID string) firebase.App {
 	return fa.firebaseClients[key]
 }
 
-// SendNotificationToToken sends a notification to token
-func (fa *Adapter) SendNotificationToToken(orgID string, appID string, token string, title string, body string, data map[string]string) error {
-	ctx := context.Background()
+// androidConfig gives the Android delivery priority of the message priority and the collapse key if the message has one
+func (fa *Adapter) androidConfig(priority int, collapseKey string) *messaging.AndroidConfig {
+	androidPriority := "normal"
+	if priority >= fa.androidHighPriority {
+		androidPriority = "high"
+	}
+	return &messaging.AndroidConfig{Priority: androidPriority, CollapseKey: collapseKey}
+}
+
+// apnsConfig gives the APNs headers of the message priority and the collapse key if the message has one
+func (fa *Adapter) apnsConfig(priority int, collapseKey string) *messaging.APNSConfig {
+	apnsPriority := "5"
+	if priority >= fa.apnsHighPriority {
+		apnsPriority = "10"
+	}
+	headers := map[string]string{"apns-push-type": "alert", "apns-priority": apnsPriority}
+	if len(collapseKey) > 0 {
+		headers["apns-collapse-id"] = collapseKey
+	}
+	return &messaging.APNSConfig{Headers: headers}
+}
+
+// checkPayloadSize gives a not retryable delivery error before calling Firebase if the push payload is larger than it accepts
+func checkPayloadSize(title string, body string, data map[string]string) error {
+	err := model.ValidatePushPayloadSize(title, body, data)
+	if err != nil {
+		log.Printf("error while checking the push payload: %s", err)
+		return &model.DeliveryError{Code: model.DeliveryErrorPayloadTooLarge, Err: err, Retryable: false}
+	}
+	return nil
+}
+
+// silentAPNSConfig makes iOS deliver a data only message to the app in the background
+func silentAPNSConfig() *messaging.APNSConfig {
+	return &messaging.APNSConfig{
+		Headers: map[string]string{"apns-push-type": "background", "apns-priority": "5"},
+		Payload: &messaging.APNSPayload{Aps: &messaging.Aps{ContentAvailable: true}},
+	}
+}
+
+// SendNotificationToTokens sends a notification to up to 500 tokens with one multicast request.
+// The per token errors are given in the response in the tokens order. Without title and body it is a silent data only push.
+// The devices keep only the latest notification with the same non empty collapse key.
+func (fa *Adapter) SendNotificationToTokens(orgID string, appID string, tokens []string, title string, body string, sound string, badge *int, priority int, collapseKey string, imageURL string, androidChannelID string, data map[string]string) (*model.BatchResponse, error) {
+	if len(tokens) > maxMulticastTokens {
+		return nil, fmt.Errorf("too many tokens for a multicast send - %d, max %d", len(tokens), maxMulticastTokens)
+	}
+
+	err := checkPayloadSize(title, body, data)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fa.sendTimeout)
+	defer cancel()
 	firebase := fa.getFirebaseClient(orgID, appID)
 	client, err := firebase.Messaging(ctx)
-	if err == nil {
-		message := &messaging.Message{
-			Token: token,
-			Data:  data,
-			Notification: &messaging.Notification{
-				Title: title,
-				Body:  body,
-			},
+	if err != nil {
+		return nil, err
+	}
+
+	message := fa.multicastMessage(tokens, title, body, sound, badge, priority, collapseKey, imageURL, androidChannelID, data)
+	batchResponse, err := client.SendMulticast(ctx, message)
+	if err != nil {
+		log.Printf("error while sending multicast notification to %d tokens: %s", len(tokens), err)
+		code := fa.getDeliveryErrorCode(err)
+		return nil, &model.DeliveryError{Code: code, Err: fmt.Errorf("error while sending multicast notification: %s", err), Retryable: fa.isRetryableDeliveryErrorCode(code)}
+	}
+
+	result := model.BatchResponse{SuccessCount: batchResponse.SuccessCount, FailureCount: batchResponse.FailureCount, Errors: make([]error, len(tokens))}
+	for i, response := range batchResponse.Responses {
+		if i >= len(tokens) || response.Success {
+			continue
 		}
-		_, err = client.Send(ctx, message)
-		if err != nil {
-			log.Printf("error while sending notification to token (%s): %s", token, err)
-			err = fmt.Errorf("error while sending notification to token (%s): %s", token, err)
+		token := tokens[i]
+		log.Printf("error while sending notification to token (%s): %s", token, response.Error)
+		code := fa.getDeliveryErrorCode(response.Error)
+		var tokenErr error
+		if code == model.DeliveryErrorUnregistered || code == model.DeliveryErrorInvalidArgument {
+			//let the caller know that the token may need to be removed
+			tokenErr = fmt.Errorf("error while sending notification to token (%s): %w - %s", token, model.ErrInvalidDeviceToken, response.Error)
+		} else {
+			tokenErr = fmt.Errorf("error while sending notification to token (%s): %s", token, response.Error)
 		}
+		result.Errors[i] = &model.DeliveryError{Code: code, Err: tokenErr, Retryable: fa.isRetryableDeliveryErrorCode(code)}
 	}
-	return err
+	return &result, nil
+}
+
+// multicastMessage constructs the multicast message. The sound, the badge and the image are set only for a notification,
+// they are ignored for a silent data only push.
+func (fa *Adapter) multicastMessage(tokens []string, title string, body string, sound string, badge *int, priority int, collapseKey string, imageURL string,
+	androidChannelID string, data map[string]string) *messaging.MulticastMessage {
+	message := &messaging.MulticastMessage{
+		Tokens: tokens,
+		Data:   data,
+	}
+	message.Android = fa.androidConfig(priority, collapseKey)
+	if len(title) == 0 && len(body) == 0 {
+		message.APNS = silentAPNSConfig()
+		return message
+	}
+
+	message.Notification = &messaging.Notification{
+		Title:    title,
+		Body:     body,
+		ImageURL: imageURL,
+	}
+	message.Android.Notification = fa.androidNotification(sound, badge, androidChannelID)
+	message.APNS = fa.apnsConfig(priority, collapseKey)
+	message.APNS.Payload = &messaging.APNSPayload{Aps: notificationAps(sound, badge)}
+	setAPNSImage(message.APNS, imageURL)
+	return message
+}
+
+// androidNotification gives the Android notification channel, sound and count. The default channel is used if the channel is empty
+// and the default sound is played if the sound is empty.
+func (fa *Adapter) androidNotification(sound string, badge *int, channelID string) *messaging.AndroidNotification {
+	if len(channelID) == 0 {
+		channelID = fa.defaultAndroidChannel
+	}
+	notification := &messaging.AndroidNotification{ChannelID: channelID, NotificationCount: badge}
+	if len(sound) > 0 {
+		notification.Sound = sound
+	} else {
+		notification.DefaultSound = true
+	}
+	return notification
+}
+
+// notificationAps gives the aps dictionary of an alert notification. The default sound is played if the sound is empty
+// and the app badge is left unchanged if the badge is nil.
+func notificationAps(sound string, badge *int) *messaging.Aps {
+	if len(sound) == 0 {
+		sound = defaultAPNsSound
+	}
+	return &messaging.Aps{Sound: sound, Badge: badge}
+}
+
+// setAPNSImage makes iOS download the image with a notification service extension before the notification is shown
+func setAPNSImage(apns *messaging.APNSConfig, imageURL string) {
+	if len(imageURL) == 0 {
+		return
+	}
+	if apns.Payload == nil {
+		apns.Payload = &messaging.APNSPayload{Aps: &messaging.Aps{}}
+	}
+	apns.Payload.Aps.MutableContent = true
+	apns.FCMOptions = &messaging.APNSFCMOptions{ImageURL: imageURL}
+}
+
+// getDeliveryErrorCode maps the FCM error to a delivery error code
+func (fa *Adapter) getDeliveryErrorCode(err error) string {
+	switch {
+	case messaging.IsRegistrationTokenNotRegistered(err):
+		return model.DeliveryErrorUnregistered
+	case messaging.IsInvalidArgument(err):
+		return model.DeliveryErrorInvalidArgument
+	case messaging.IsMessageRateExceeded(err):
+		return model.DeliveryErrorQuotaExceeded
+	case messaging.IsServerUnavailable(err), messaging.IsInternal(err), isNetworkError(err):
+		return model.DeliveryErrorUnavailable
+	default:
+		return model.DeliveryErrorInternal
+	}
+}
+
+// isRetryableDeliveryErrorCode tells if the send failed with a transient error, so it may succeed later.
+// The invalid tokens and the invalid messages fail permanently.
+func (fa *Adapter) isRetryableDeliveryErrorCode(code string) bool {
+	return code == model.DeliveryErrorQuotaExceeded || code == model.DeliveryErrorUnavailable
+}
+
+// isNetworkError tells if the FCM server could not be reached or has not answered in time
+func isNetworkError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
 }
 
 // SendNotificationToTopic sends a notification to a topic
 func (fa *Adapter) SendNotificationToTopic(orgID string, appID string, topic string, title string, body string, data map[string]string) error {
-	ctx := context.Background()
+	err := checkPayloadSize(title, body, data)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fa.sendTimeout)
+	defer cancel()
 	firebase := fa.getFirebaseClient(orgID, appID)
 	client, err := firebase.Messaging(ctx)
 	if err == nil {
@@ -0,0 +1,265 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebase
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"notifications/core/model"
+	"strings"
+	"testing"
+	"time"
+
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/messaging"
+	"google.golang.org/api/option"
+)
+
+func TestConfigPriority(t *testing.T) {
+	fa := &Adapter{androidHighPriority: 1000, apnsHighPriority: 500}
+
+	tests := []struct {
+		name            string
+		priority        int
+		wantAndroid     string
+		wantAPNs        string
+		wantCollapseKey string
+	}{
+		{"low", 0, "normal", "5", ""},
+		{"below the apns threshold", 499, "normal", "5", ""},
+		{"apns threshold", 500, "normal", "10", ""},
+		{"below the android threshold", 999, "normal", "10", "group"},
+		{"android threshold", 1000, "high", "10", "group"},
+		{"above both", 5000, "high", "10", "group"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			android := fa.androidConfig(tt.priority, tt.wantCollapseKey)
+			if android.Priority != tt.wantAndroid || android.CollapseKey != tt.wantCollapseKey {
+				t.Errorf("androidConfig(%d) = %s %s, want %s %s", tt.priority, android.Priority, android.CollapseKey, tt.wantAndroid, tt.wantCollapseKey)
+			}
+
+			apns := fa.apnsConfig(tt.priority, tt.wantCollapseKey)
+			if apns.Headers["apns-priority"] != tt.wantAPNs || apns.Headers["apns-collapse-id"] != tt.wantCollapseKey {
+				t.Errorf("apnsConfig(%d) = %v, want priority %s and collapse id %s", tt.priority, apns.Headers, tt.wantAPNs, tt.wantCollapseKey)
+			}
+			if _, ok := apns.Headers["apns-collapse-id"]; ok != (len(tt.wantCollapseKey) > 0) {
+				t.Errorf("apnsConfig(%d) collapse id set = %t, want %t", tt.priority, ok, len(tt.wantCollapseKey) > 0)
+			}
+		})
+	}
+}
+
+func TestMulticastMessageImage(t *testing.T) {
+	fa := &Adapter{androidHighPriority: 1000, apnsHighPriority: 1000}
+
+	tests := []struct {
+		name     string
+		title    string
+		body     string
+		imageURL string
+	}{
+		{"image", "title", "body", "https://images.example.com/image.png"},
+		{"no image", "title", "body", ""},
+		{"silent push", "", "", "https://images.example.com/image.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := fa.multicastMessage([]string{"token"}, tt.title, tt.body, "", nil, 0, "", tt.imageURL, "", nil)
+
+			if len(tt.title) == 0 && len(tt.body) == 0 {
+				if message.Notification != nil || message.APNS.FCMOptions != nil || message.APNS.Payload.Aps.MutableContent {
+					t.Errorf("multicastMessage() has an image for a silent push")
+				}
+				return
+			}
+			if message.Notification.ImageURL != tt.imageURL {
+				t.Errorf("multicastMessage() notification image = %s, want %s", message.Notification.ImageURL, tt.imageURL)
+			}
+			hasImage := len(tt.imageURL) > 0
+			if message.APNS.Payload.Aps.MutableContent != hasImage {
+				t.Errorf("multicastMessage() mutable content = %t, want %t", message.APNS.Payload.Aps.MutableContent, hasImage)
+			}
+			if (message.APNS.FCMOptions != nil) != hasImage || (hasImage && message.APNS.FCMOptions.ImageURL != tt.imageURL) {
+				t.Errorf("multicastMessage() apns fcm options = %+v, want the image %s", message.APNS.FCMOptions, tt.imageURL)
+			}
+		})
+	}
+}
+
+func TestMulticastMessageBadgeSound(t *testing.T) {
+	fa := &Adapter{androidHighPriority: 1000, apnsHighPriority: 1000}
+	badge := 3
+
+	tests := []struct {
+		name             string
+		sound            string
+		badge            *int
+		wantAndroidSound string
+		wantDefaultSound bool
+		wantAPNsSound    string
+	}{
+		{"sound and badge", "chime.wav", &badge, "chime.wav", false, "chime.wav"},
+		{"no sound and no badge", "", nil, "", true, defaultAPNsSound},
+		{"only badge", "", &badge, "", true, defaultAPNsSound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := fa.multicastMessage([]string{"token"}, "title", "body", tt.sound, tt.badge, 0, "", "", "", nil)
+
+			android := message.Android.Notification
+			if android.Sound != tt.wantAndroidSound || android.DefaultSound != tt.wantDefaultSound {
+				t.Errorf("multicastMessage() android sound = %s default %t, want %s default %t", android.Sound, android.DefaultSound, tt.wantAndroidSound, tt.wantDefaultSound)
+			}
+			if android.NotificationCount != tt.badge {
+				t.Errorf("multicastMessage() android count = %v, want %v", android.NotificationCount, tt.badge)
+			}
+
+			aps := message.APNS.Payload.Aps
+			if aps.Sound != tt.wantAPNsSound {
+				t.Errorf("multicastMessage() aps sound = %v, want %s", aps.Sound, tt.wantAPNsSound)
+			}
+			if aps.Badge != tt.badge {
+				t.Errorf("multicastMessage() aps badge = %v, want %v", aps.Badge, tt.badge)
+			}
+		})
+	}
+}
+
+func TestMulticastMessageAndroidChannel(t *testing.T) {
+	fa := &Adapter{androidHighPriority: 1000, apnsHighPriority: 1000, defaultAndroidChannel: "general"}
+
+	tests := []struct {
+		name      string
+		channelID string
+		want      string
+	}{
+		{"message channel", "alerts", "alerts"},
+		{"default channel", "", "general"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := fa.multicastMessage([]string{"token"}, "title", "body", "", nil, 0, "", "", tt.channelID, nil)
+			if got := message.Android.Notification.ChannelID; got != tt.want {
+				t.Errorf("multicastMessage() android channel = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	//no default channel
+	fa.defaultAndroidChannel = ""
+	message := fa.multicastMessage([]string{"token"}, "title", "body", "", nil, 0, "", "", "", nil)
+	if got := message.Android.Notification.ChannelID; len(got) > 0 {
+		t.Errorf("multicastMessage() android channel = %s, want none", got)
+	}
+}
+
+// blockingTransport never answers, a request ends only when its context is done
+type blockingTransport struct{}
+
+func (blockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestSendTimeout(t *testing.T) {
+	app, err := firebase.NewApp(context.Background(), &firebase.Config{ProjectID: "test"},
+		option.WithHTTPClient(&http.Client{Transport: blockingTransport{}}))
+	if err != nil {
+		t.Fatalf("NewApp() error = %v", err)
+	}
+	fa := &Adapter{firebaseClients: map[string]firebase.App{"org_app": *app}, sendTimeout: 50 * time.Millisecond}
+
+	t.Run("tokens", func(t *testing.T) {
+		start := time.Now()
+		_, err := fa.SendNotificationToTokens("org", "app", []string{"token"}, "title", "body", "", nil, 0, "", "", "", nil)
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("SendNotificationToTokens() took %s, want it cancelled after the timeout", elapsed)
+		}
+		var deliveryErr *model.DeliveryError
+		if !errors.As(err, &deliveryErr) || deliveryErr.Code != model.DeliveryErrorUnavailable || !deliveryErr.Retryable {
+			t.Errorf("SendNotificationToTokens() error = %v, want a retryable %s delivery error", err, model.DeliveryErrorUnavailable)
+		}
+	})
+	t.Run("topic", func(t *testing.T) {
+		start := time.Now()
+		err := fa.SendNotificationToTopic("org", "app", "topic", "title", "body", nil)
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("SendNotificationToTopic() took %s, want it cancelled after the timeout", elapsed)
+		}
+		if err == nil {
+			t.Errorf("SendNotificationToTopic() error = nil, want the timeout error")
+		}
+	})
+}
+
+// fcmErrorTransport answers every request with the FCM error response
+type fcmErrorTransport struct {
+	status int
+	body   string
+}
+
+func (t fcmErrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: t.status, Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body: io.NopCloser(strings.NewReader(t.body)), Request: req}, nil
+}
+
+// fcmError gives the FCM v1 error response with the error code in its details
+func fcmError(status string, errorCode string) string {
+	return `{"error":{"status":"` + status + `","message":"error","details":[` +
+		`{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"` + errorCode + `"}]}}`
+}
+
+func TestGetDeliveryErrorCode(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		want          string
+		wantRetryable bool
+	}{
+		{"unregistered", http.StatusNotFound, fcmError("NOT_FOUND", "UNREGISTERED"), model.DeliveryErrorUnregistered, false},
+		{"not found without details", http.StatusNotFound, `{"error":{"status":"NOT_FOUND","message":"error"}}`, model.DeliveryErrorUnregistered, false},
+		{"invalid argument", http.StatusBadRequest, fcmError("INVALID_ARGUMENT", "INVALID_ARGUMENT"), model.DeliveryErrorInvalidArgument, false},
+		{"quota exceeded", http.StatusTooManyRequests, fcmError("RESOURCE_EXHAUSTED", "QUOTA_EXCEEDED"), model.DeliveryErrorQuotaExceeded, true},
+		{"sender id mismatch", http.StatusForbidden, fcmError("PERMISSION_DENIED", "SENDER_ID_MISMATCH"), model.DeliveryErrorInternal, false},
+		{"unknown", http.StatusBadRequest, `not json`, model.DeliveryErrorInternal, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := firebase.NewApp(context.Background(), &firebase.Config{ProjectID: "test"},
+				option.WithHTTPClient(&http.Client{Transport: fcmErrorTransport{status: tt.status, body: tt.body}}))
+			if err != nil {
+				t.Fatalf("NewApp() error = %v", err)
+			}
+			client, err := app.Messaging(context.Background())
+			if err != nil {
+				t.Fatalf("Messaging() error = %v", err)
+			}
+			_, sendErr := client.Send(context.Background(), &messaging.Message{Token: "token"})
+			if sendErr == nil {
+				t.Fatalf("Send() error = nil, want the FCM error")
+			}
+
+			fa := &Adapter{}
+			code := fa.getDeliveryErrorCode(sendErr)
+			if code != tt.want || fa.isRetryableDeliveryErrorCode(code) != tt.wantRetryable {
+				t.Errorf("getDeliveryErrorCode(%v) = %s, retryable %t, want %s, retryable %t", sendErr, code, fa.isRetryableDeliveryErrorCode(code), tt.want, tt.wantRetryable)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package moderation
+
+import (
+	"fmt"
+	"notifications/core/model"
+	"regexp"
+	"strings"
+)
+
+var (
+	emailRegex = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	phoneRegex = regexp.MustCompile(`\+?\d[\d\-. ()]{8,}\d`)
+)
+
+// Adapter implements the Moderator interface with words lists and personal information checks
+type Adapter struct {
+	blockedWords []string
+	flaggedWords []string
+}
+
+// Moderate checks the message subject and body
+func (a *Adapter) Moderate(subject string, body string) (*model.ModerationResult, error) {
+	content := strings.ToLower(subject + "\n" + body)
+	words := strings.FieldsFunc(content, func(r rune) bool {
+		return !(r == '\'' || r == '-' || ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') || r > 127)
+	})
+	wordsMap := make(map[string]bool, len(words))
+	for _, word := range words {
+		wordsMap[word] = true
+	}
+
+	for _, word := range a.blockedWords {
+		if wordsMap[word] {
+			return &model.ModerationResult{Decision: model.ModerationDecisionBlock, Reason: fmt.Sprintf("contains blocked word \"%s\"", word)}, nil
+		}
+	}
+	for _, word := range a.flaggedWords {
+		if wordsMap[word] {
+			return &model.ModerationResult{Decision: model.ModerationDecisionFlag, Reason: fmt.Sprintf("contains flagged word \"%s\"", word)}, nil
+		}
+	}
+
+	//personal information
+	if emailRegex.MatchString(content) {
+		return &model.ModerationResult{Decision: model.ModerationDecisionFlag, Reason: "contains an email address"}, nil
+	}
+	if phoneRegex.MatchString(content) {
+		return &model.ModerationResult{Decision: model.ModerationDecisionFlag, Reason: "contains a phone number"}, nil
+	}
+
+	return &model.ModerationResult{Decision: model.ModerationDecisionAllow}, nil
+}
+
+// NewModerationAdapter creates a new moderation adapter instance
+func NewModerationAdapter(blockedWords []string, flaggedWords []string) *Adapter {
+	return &Adapter{blockedWords: lowerAll(blockedWords), flaggedWords: lowerAll(flaggedWords)}
+}
+
+func lowerAll(items []string) []string {
+	result := make([]string, len(items))
+	for i, item := range items {
+		result[i] = strings.ToLower(item)
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sms
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxBodyLength is the max length of a Twilio message body
+const maxBodyLength = 1600
+
+// Adapter is the Twilio SMS adapter
+type Adapter struct {
+	sid   string
+	token string
+	from  string
+
+	client *http.Client
+}
+
+// NewSMSAdapter creates a new Twilio SMS adapter instance
+func NewSMSAdapter(sid string, token string, from string) *Adapter {
+	return &Adapter{sid: sid, token: token, from: from, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// SendSMS sends the body as SMS to the phone number
+func (a *Adapter) SendSMS(phone string, body string) error {
+	if len(a.sid) == 0 || len(a.token) == 0 || len(a.from) == 0 {
+		return errors.New("the sms adapter is not configured")
+	}
+	if len(phone) == 0 {
+		return errors.New("missing phone number")
+	}
+
+	bodyRunes := []rune(body)
+	if len(bodyRunes) > maxBodyLength {
+		log.Printf("warning: the sms body is truncated from %d to %d characters", len(bodyRunes), maxBodyLength)
+		body = string(bodyRunes[:maxBodyLength])
+	}
+
+	form := url.Values{}
+	form.Set("To", phone)
+	form.Set("From", a.from)
+	form.Set("Body", body)
+
+	requestURL := fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json", a.sid)
+	req, err := http.NewRequest("POST", requestURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		log.Printf("error creating sms request - %s", err)
+		return err
+	}
+	req.SetBasicAuth(a.sid, a.token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		log.Printf("error sending sms - %s", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		log.Printf("error with sms response code - %d", resp.StatusCode)
+		return fmt.Errorf("error with sms response code - %d", resp.StatusCode)
+	}
+	return nil
+}
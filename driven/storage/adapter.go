@@ -43,6 +43,8 @@ type Adapter struct {
 
 	cachedConfigs *syncmap.Map
 	configsLock   *sync.RWMutex
+
+	writeRetries int //how many times a transaction is performed again after a transient error
 }
 
 // Start starts the storage
@@ -61,34 +63,62 @@ func (sa *Adapter) Start() error {
 	return err
 }
 
+// Ping checks that the database is reachable
+func (sa Adapter) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), sa.db.operationTimeout())
+	defer cancel()
+
+	err := sa.db.dbClient.Ping(ctx, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionVerify, "database connection", nil, err)
+	}
+	return nil
+}
+
 // RegisterStorageListener registers a data change listener with the storage adapter
 func (sa *Adapter) RegisterStorageListener(storageListener Listener) {
 	sa.db.listeners = append(sa.db.listeners, storageListener)
 }
 
 // PerformTransaction performs a transaction
+// The whole transaction is performed again if it fails with a transient error.
 func (sa *Adapter) PerformTransaction(transaction func(context TransactionContext) error, timeoutMilliSeconds int64) error {
+	return sa.PerformTransactionWithContext(context.Background(), transaction, timeoutMilliSeconds)
+}
+
+// PerformTransactionWithContext performs a transaction which is aborted when the context is done
+// The whole transaction is performed again if it fails with a transient error while the context is not done.
+func (sa *Adapter) PerformTransactionWithContext(ctx context.Context, transaction func(context TransactionContext) error, timeoutMilliSeconds int64) error {
+	return sa.withRetry("transaction", func() error {
+		if ctx.Err() != nil {
+			return errors.WrapErrorAction(logutils.ActionStart, logutils.TypeTransaction, nil, ctx.Err())
+		}
+		return sa.performTransaction(ctx, transaction, timeoutMilliSeconds)
+	})
+}
+
+func (sa *Adapter) performTransaction(parentCtx context.Context, transaction func(context TransactionContext) error, timeoutMilliSeconds int64) error {
 	// transaction
 	timeout := time.Millisecond * time.Duration(timeoutMilliSeconds)
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(parentCtx, timeout)
 	defer cancel()
 	err := sa.db.dbClient.UseSession(ctx, func(sessionContext mongo.SessionContext) error {
 		err := sessionContext.StartTransaction()
 		if err != nil {
 			sa.abortTransaction(sessionContext)
-			return errors.WrapErrorAction(logutils.ActionStart, logutils.TypeTransaction, nil, err)
+			return markTransient(err, errors.WrapErrorAction(logutils.ActionStart, logutils.TypeTransaction, nil, err))
 		}
 
 		err = transaction(sessionContext)
 		if err != nil {
 			sa.abortTransaction(sessionContext)
-			return errors.WrapErrorAction("performing", logutils.TypeTransaction, nil, err)
+			return markTransient(err, errors.WrapErrorAction("performing", logutils.TypeTransaction, nil, err))
 		}
 
 		err = sessionContext.CommitTransaction(sessionContext)
 		if err != nil {
 			sa.abortTransaction(sessionContext)
-			return errors.WrapErrorAction(logutils.ActionCommit, logutils.TypeTransaction, nil, err)
+			return markTransient(err, errors.WrapErrorAction(logutils.ActionCommit, logutils.TypeTransaction, nil, err))
 		}
 		return nil
 	})
@@ -97,21 +127,45 @@ func (sa *Adapter) PerformTransaction(transaction func(context TransactionContex
 }
 
 // NewStorageAdapter creates a new storage adapter instance
-func NewStorageAdapter(mongoDBAuth string, mongoDBName string, mongoTimeout string,
-	multiTenancyOrgID string, multiTenancyAppID string, logger *logs.Logger) *Adapter {
-	timeout, err := strconv.Atoi(mongoTimeout)
-	if err != nil {
-		log.Println("Set default timeout - 2000")
-		timeout = 2000
+func NewStorageAdapter(mongoDBAuth string, mongoDBName string, mongoTimeout string, mongoOpTimeout string, mongoWriteRetries string,
+	mongoMaxPoolSize string, mongoMinPoolSize string, mongoMaxIdleTime string,
+	multiTenancyOrgID string, multiTenancyAppID string, tokenEncryptionKey string, devMode bool, logger *logs.Logger) *Adapter {
+	timeout, err := strconv.Atoi(strings.TrimSpace(mongoTimeout))
+	if err != nil || timeout <= 0 {
+		if len(mongoTimeout) > 0 {
+			log.Printf("Invalid mongo timeout %s", mongoTimeout)
+		}
+		log.Printf("Set default timeout - %d", defaultMongoTimeout)
+		timeout = defaultMongoTimeout
 	}
 	timeoutMS := time.Millisecond * time.Duration(timeout)
 
+	//the driver defaults are kept for the pool settings which are not set
+	pool := poolSettings{maxPoolSize: parsePoolSetting("max pool size", mongoMaxPoolSize),
+		minPoolSize: parsePoolSetting("min pool size", mongoMinPoolSize), maxIdleTime: parsePoolSetting("max idle time", mongoMaxIdleTime)}
+	if pool.maxPoolSize != nil && pool.minPoolSize != nil && *pool.minPoolSize > *pool.maxPoolSize {
+		log.Printf("The mongo min pool size %d is greater than the max pool size %d, the max pool size is used", *pool.minPoolSize, *pool.maxPoolSize)
+		pool.minPoolSize = pool.maxPoolSize
+	}
+
+	//the operations follow the mongo timeout if their own timeout is not set
+	opTimeout, err := strconv.Atoi(mongoOpTimeout)
+	if err != nil || opTimeout < 0 {
+		opTimeout = 0
+	}
+	opTimeoutMS := time.Millisecond * time.Duration(opTimeout)
+
+	writeRetries, err := strconv.Atoi(mongoWriteRetries)
+	if err != nil || writeRetries < 0 {
+		writeRetries = defaultWriteRetries
+	}
+
 	cachedConfigs := &syncmap.Map{}
 	configsLock := &sync.RWMutex{}
 
-	db := &database{mongoDBAuth: mongoDBAuth, mongoDBName: mongoDBName, mongoTimeout: timeoutMS,
-		multiTenancyOrgID: multiTenancyOrgID, multiTenancyAppID: multiTenancyAppID, logger: logger}
-	return &Adapter{db: db, cachedConfigs: cachedConfigs, configsLock: configsLock}
+	db := &database{mongoDBAuth: mongoDBAuth, mongoDBName: mongoDBName, mongoTimeout: timeoutMS, opTimeout: opTimeoutMS, pool: pool,
+		multiTenancyOrgID: multiTenancyOrgID, multiTenancyAppID: multiTenancyAppID, tokenEncryptionKey: tokenEncryptionKey, devMode: devMode, logger: logger}
+	return &Adapter{db: db, cachedConfigs: cachedConfigs, configsLock: configsLock, writeRetries: writeRetries}
 }
 
 // LoadFirebaseConfigurations loads all firebase configurations
@@ -126,8 +180,10 @@ func (sa Adapter) LoadFirebaseConfigurations() ([]model.FirebaseConf, error) {
 }
 
 // FindUsersByIDs finds users by ids
-func (sa Adapter) FindUsersByIDs(usersIDs []string) ([]model.User, error) {
+func (sa Adapter) FindUsersByIDs(orgID string, appID string, usersIDs []string) ([]model.User, error) {
 	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
 		primitive.E{Key: "user_id", Value: bson.M{"$in": usersIDs}},
 	}
 
@@ -152,7 +208,7 @@ func (sa Adapter) findUserByTokenWithContext(context context.Context, orgID stri
 		filter = bson.D{
 			primitive.E{Key: "org_id", Value: orgID},
 			primitive.E{Key: "app_id", Value: appID},
-			primitive.E{Key: "firebase_tokens", Value: bson.D{primitive.E{Key: "$elemMatch", Value: bson.D{primitive.E{Key: "token", Value: token}}}}},
+			primitive.E{Key: "firebase_tokens", Value: bson.D{primitive.E{Key: "$elemMatch", Value: bson.D{primitive.E{Key: "token", Value: sa.db.tokenCipher.matchValue(token)}}}}},
 		}
 	}
 
@@ -195,22 +251,18 @@ func (sa Adapter) findUserByIDWithContext(context context.Context, orgID string,
 
 // InsertUser inserts a new user document
 func (sa Adapter) InsertUser(orgID string, appID string, userID string) (*model.User, error) {
-	return sa.createUserWithContext(context.Background(), orgID, appID, userID, "", nil, nil, "")
+	return sa.createUserWithContext(context.Background(), orgID, appID, userID, nil)
 }
 
-func (sa Adapter) createUserWithContext(context context.Context, orgID string, appID string, userID string, token string, appPlatform *string, appVersion *string, tokenType string) (*model.User, error) {
+func (sa Adapter) createUserWithContext(context context.Context, orgID string, appID string, userID string, tokenInfo *model.TokenInfo) (*model.User, error) {
 
 	now := time.Now().UTC()
 
 	tokenList := []model.DeviceToken{}
-	if token != "" {
-		tokenList = append(tokenList, model.DeviceToken{
-			Token:       token,
-			TokenType:   tokenType,
-			AppVersion:  appVersion,
-			AppPlatform: appPlatform,
-			DateCreated: now,
-		})
+	token := ""
+	if tokenInfo != nil && tokenInfo.Token != "" {
+		token = tokenInfo.Token
+		tokenList = append(tokenList, tokenInfo.NewDeviceToken(now))
 	}
 	record := &model.User{
 		OrgID:        orgID,
@@ -231,7 +283,7 @@ func (sa Adapter) createUserWithContext(context context.Context, orgID string, a
 	return record, err
 }
 
-func (sa Adapter) addTokenToUserWithContext(ctx context.Context, orgID string, appID string, userID string, token string, appPlatform *string, appVersion *string, tokenType string) error {
+func (sa Adapter) addTokenToUserWithContext(ctx context.Context, orgID string, appID string, userID string, tokenInfo *model.TokenInfo) error {
 	// transaction
 	update := bson.D{}
 
@@ -241,39 +293,82 @@ func (sa Adapter) addTokenToUserWithContext(ctx context.Context, orgID string, a
 		primitive.E{Key: "user_id", Value: userID},
 	}
 
+	now := time.Now().UTC()
 	update = bson.D{
 		primitive.E{Key: "$set", Value: bson.D{
-			primitive.E{Key: "date_updated", Value: time.Now().UTC()},
+			primitive.E{Key: "date_updated", Value: now},
 		}},
-		primitive.E{Key: "$push", Value: bson.D{primitive.E{Key: "firebase_tokens", Value: model.DeviceToken{
-			Token:       token,
-			TokenType:   tokenType,
-			AppVersion:  appVersion,
-			AppPlatform: appPlatform,
-			DateCreated: time.Now().UTC(),
-		}}}},
+		primitive.E{Key: "$push", Value: bson.D{primitive.E{Key: "firebase_tokens", Value: tokenInfo.NewDeviceToken(now)}}},
 	}
 
 	_, err := sa.db.users.UpdateOneWithContext(ctx, filter, &update, nil)
 	if err != nil {
-		fmt.Printf("warning: error while adding token (%s) to user (%s) %s\n", token, userID, err)
+		fmt.Printf("warning: error while adding token (%s) to user (%s) %s\n", tokenInfo.Token, userID, err)
 		return err
 	}
 
 	sa.db.appVersions.InsertOne(map[string]string{
 		"org_id": orgID,
 		"app_id": appID,
-		"name":   *appVersion,
+		"name":   *tokenInfo.AppVersion,
 	})
 
 	sa.db.appPlatforms.InsertOne(map[string]string{
 		"org_id": orgID,
 		"app_id": appID,
-		"name":   *appPlatform,
+		"name":   *tokenInfo.AppPlatform,
 	})
 	return nil
 }
 
+// updateTokenInfoWithContext refreshes the device details of a token which the user already has
+func (sa Adapter) updateTokenInfoWithContext(ctx context.Context, orgID string, appID string, userID string, tokenInfo *model.TokenInfo) error {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "user_id", Value: userID},
+		primitive.E{Key: "firebase_tokens.token", Value: sa.db.tokenCipher.matchValue(tokenInfo.Token)},
+	}
+
+	now := time.Now().UTC()
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "firebase_tokens.$.app_platform", Value: tokenInfo.AppPlatform},
+			primitive.E{Key: "firebase_tokens.$.app_version", Value: tokenInfo.AppVersion},
+			primitive.E{Key: "firebase_tokens.$.device_id", Value: tokenInfo.DeviceID},
+			primitive.E{Key: "firebase_tokens.$.date_updated", Value: now},
+			primitive.E{Key: "date_updated", Value: now},
+		}},
+	}
+
+	_, err := sa.db.users.UpdateOneWithContext(ctx, filter, &update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "token", &logutils.FieldArgs{"user_id": userID}, err)
+	}
+	return nil
+}
+
+func (sa Adapter) updateUserLocaleWithContext(ctx context.Context, orgID string, appID string, userID string, locale string) error {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "user_id", Value: userID},
+	}
+
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "locale", Value: locale},
+			primitive.E{Key: "date_updated", Value: time.Now().UTC()},
+		}},
+	}
+
+	_, err := sa.db.users.UpdateOneWithContext(ctx, filter, &update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "user", &logutils.FieldArgs{"user_id": userID, "locale": locale}, err)
+	}
+	return nil
+}
+
 func (sa Adapter) removeTokenFromUserWithContext(ctx context.Context, orgID string, appID string, token string, userID string, tokenType string) error {
 	filter := bson.D{
 		primitive.E{Key: "org_id", Value: orgID},
@@ -286,7 +381,7 @@ func (sa Adapter) removeTokenFromUserWithContext(ctx context.Context, orgID stri
 		primitive.E{Key: "$set", Value: bson.D{
 			primitive.E{Key: "date_updated", Value: time.Now().UTC()},
 		}},
-		primitive.E{Key: "$pull", Value: bson.D{primitive.E{Key: "firebase_tokens", Value: bson.D{primitive.E{Key: "token", Value: token}}}}},
+		primitive.E{Key: "$pull", Value: bson.D{primitive.E{Key: "firebase_tokens", Value: bson.D{primitive.E{Key: "token", Value: sa.db.tokenCipher.matchValue(token)}}}}},
 	}
 
 	_, err := sa.db.users.UpdateOneWithContext(ctx, filter, &update, nil)
@@ -297,6 +392,267 @@ func (sa Adapter) removeTokenFromUserWithContext(ctx context.Context, orgID stri
 	return nil
 }
 
+// IncrementDeviceTokenFailures increments the failures count of the user token and gives the new count
+func (sa Adapter) IncrementDeviceTokenFailures(orgID string, appID string, userID string, token string) (int, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "user_id", Value: userID},
+		primitive.E{Key: "firebase_tokens.token", Value: sa.db.tokenCipher.matchValue(token)},
+	}
+	update := bson.D{
+		primitive.E{Key: "$inc", Value: bson.D{primitive.E{Key: "firebase_tokens.$.failures_count", Value: 1}}},
+	}
+	_, err := sa.db.users.UpdateOne(filter, update, nil)
+	if err != nil {
+		return 0, errors.WrapErrorAction(logutils.ActionUpdate, "device token", &logutils.FieldArgs{"user_id": userID, "failures_count": "+1"}, err)
+	}
+
+	user, err := sa.FindUserByID(orgID, appID, userID)
+	if err != nil {
+		return 0, err
+	}
+	if user != nil {
+		for _, deviceToken := range user.DeviceTokens {
+			if deviceToken.Token == token {
+				return deviceToken.FailuresCount, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+// ResetDeviceTokenFailures resets the failures count of the user token
+func (sa Adapter) ResetDeviceTokenFailures(orgID string, appID string, userID string, token string) error {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "user_id", Value: userID},
+		primitive.E{Key: "firebase_tokens", Value: bson.M{"$elemMatch": bson.M{"token": sa.db.tokenCipher.matchValue(token), "failures_count": bson.M{"$gt": 0}}}},
+	}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "firebase_tokens.$.failures_count", Value: 0}}},
+	}
+	_, err := sa.db.users.UpdateOne(filter, update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "device token", &logutils.FieldArgs{"user_id": userID, "failures_count": 0}, err)
+	}
+	return nil
+}
+
+// UpdateDeviceTokenNotificationsDisabled sets if the user receives the pushes on the device of the token. It gives false if the user does not have the token.
+func (sa Adapter) UpdateDeviceTokenNotificationsDisabled(orgID string, appID string, userID string, token string, notificationsDisabled bool) (bool, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "user_id", Value: userID},
+		primitive.E{Key: "firebase_tokens.token", Value: sa.db.tokenCipher.matchValue(token)},
+	}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "firebase_tokens.$.notifications_disabled", Value: notificationsDisabled},
+			primitive.E{Key: "firebase_tokens.$.date_updated", Value: time.Now().UTC()},
+		}},
+	}
+	res, err := sa.db.users.UpdateOne(filter, update, nil)
+	if err != nil {
+		return false, errors.WrapErrorAction(logutils.ActionUpdate, "device token", &logutils.FieldArgs{"user_id": userID, "notifications_disabled": notificationsDisabled}, err)
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// RemoveDeviceToken removes the token from the user
+func (sa Adapter) RemoveDeviceToken(orgID string, appID string, userID string, token string) error {
+	return sa.removeTokenFromUserWithContext(context.Background(), orgID, appID, token, userID, "")
+}
+
+// FindDeadDeviceTokens gives the device tokens which have at least minFailures invalid token failures, the most failed first
+func (sa Adapter) FindDeadDeviceTokens(orgID string, appID string, minFailures int) ([]model.DeadDeviceToken, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"org_id": orgID, "app_id": appID, "firebase_tokens.failures_count": bson.M{"$gte": minFailures}}},
+		{"$unwind": "$firebase_tokens"},
+		{"$match": bson.M{"firebase_tokens.failures_count": bson.M{"$gte": minFailures}}},
+		{"$project": bson.M{
+			"_id":            0,
+			"user_id":        1,
+			"token":          "$firebase_tokens.token",
+			"token_type":     "$firebase_tokens.token_type",
+			"app_platform":   "$firebase_tokens.app_platform",
+			"failures_count": "$firebase_tokens.failures_count",
+			"date_updated":   "$firebase_tokens.date_updated",
+		}},
+		{"$sort": bson.D{primitive.E{Key: "failures_count", Value: -1}, primitive.E{Key: "user_id", Value: 1}}},
+	}
+
+	var result []model.DeadDeviceToken
+	err := sa.db.users.Aggregate(pipeline, &result, nil)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "dead device tokens", &logutils.FieldArgs{"min_failures": minFailures}, err)
+	}
+	if sa.db.tokenCipher != nil {
+		for i := range result {
+			result[i].Token, err = sa.db.tokenCipher.decrypt(result[i].Token)
+			if err != nil {
+				return nil, errors.WrapErrorAction(logutils.ActionDecrypt, "dead device tokens", nil, err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// RemoveDeadDeviceTokens removes the device tokens which have at least minFailures invalid token failures and gives the number of updated users
+func (sa Adapter) RemoveDeadDeviceTokens(orgID string, appID string, minFailures int) (int64, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "firebase_tokens.failures_count", Value: bson.M{"$gte": minFailures}},
+	}
+	update := bson.D{
+		primitive.E{Key: "$pull", Value: bson.M{"firebase_tokens": bson.M{"failures_count": bson.M{"$gte": minFailures}}}},
+		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "date_updated", Value: time.Now().UTC()}}},
+	}
+	res, err := sa.db.users.UpdateMany(filter, update, nil)
+	if err != nil {
+		return 0, errors.WrapErrorAction(logutils.ActionDelete, "dead device tokens", &logutils.FieldArgs{"min_failures": minFailures}, err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// RemoveStaleDeviceTokens removes the device tokens of all apps which have not been registered since before and deletes the users which are left without tokens and topics.
+// It gives the number of the removed tokens and of the deleted users. A user which is updated while its tokens are removed is skipped until the next run.
+func (sa Adapter) RemoveStaleDeviceTokens(before time.Time) (int64, int64, error) {
+	//the tokens which have never been registered again have only the date created
+	stale := bson.M{"$or": []bson.M{
+		{"date_updated": bson.M{"$lt": before}},
+		{"date_updated": nil, "date_created": bson.M{"$lt": before}},
+	}}
+
+	const pageSize = 1000
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{primitive.E{Key: "_id", Value: 1}})
+	findOptions.SetLimit(pageSize)
+
+	var removedTokens, deletedUsers int64
+	afterID := ""
+	for {
+		filter := bson.D{
+			primitive.E{Key: "_id", Value: bson.M{"$gt": afterID}},
+			primitive.E{Key: "firebase_tokens", Value: bson.M{"$elemMatch": stale}},
+		}
+		var users []model.User
+		err := sa.db.users.Find(filter, &users, findOptions)
+		if err != nil {
+			return removedTokens, deletedUsers, errors.WrapErrorAction(logutils.ActionFind, "user", &logutils.FieldArgs{"after": afterID}, err)
+		}
+		if len(users) == 0 {
+			return removedTokens, deletedUsers, nil
+		}
+
+		updatedIDs := []string{}
+		for _, user := range users {
+			var staleCount int64
+			for _, deviceToken := range user.DeviceTokens {
+				if deviceTokenStale(deviceToken, before) {
+					staleCount++
+				}
+			}
+
+			//the user must not have changed since it was loaded so that the removed tokens are the counted ones
+			var dateUpdated interface{} = user.DateUpdated
+			if user.DateUpdated.IsZero() {
+				dateUpdated = nil
+			}
+			userFilter := bson.D{
+				primitive.E{Key: "_id", Value: user.ID},
+				primitive.E{Key: "date_updated", Value: dateUpdated},
+			}
+			update := bson.D{
+				primitive.E{Key: "$pull", Value: bson.M{"firebase_tokens": stale}},
+				primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "date_updated", Value: time.Now().UTC()}}},
+			}
+			res, err := sa.db.users.UpdateOne(userFilter, update, nil)
+			if err != nil {
+				return removedTokens, deletedUsers, errors.WrapErrorAction(logutils.ActionDelete, "stale device tokens", &logutils.FieldArgs{"user_id": user.UserID}, err)
+			}
+			if res.ModifiedCount > 0 {
+				removedTokens += staleCount
+				updatedIDs = append(updatedIDs, user.ID)
+			}
+		}
+
+		if len(updatedIDs) > 0 {
+			//only the users updated above, the users subscribed to topics are kept for their subscriptions
+			emptyFilter := bson.D{
+				primitive.E{Key: "_id", Value: bson.M{"$in": updatedIDs}},
+				primitive.E{Key: "firebase_tokens", Value: bson.M{"$size": 0}},
+				primitive.E{Key: "topics", Value: bson.M{"$in": bson.A{nil, bson.A{}}}},
+			}
+			res, err := sa.db.users.DeleteMany(emptyFilter, nil)
+			if err != nil {
+				return removedTokens, deletedUsers, errors.WrapErrorAction(logutils.ActionDelete, "user", &logutils.FieldArgs{"firebase_tokens": 0}, err)
+			}
+			deletedUsers += res.DeletedCount
+		}
+		afterID = users[len(users)-1].ID
+	}
+}
+
+// deviceTokenStale tells if the device token has not been registered since before, it matches the stale filter of RemoveStaleDeviceTokens
+func deviceTokenStale(deviceToken model.DeviceToken, before time.Time) bool {
+	if deviceToken.DateUpdated != nil {
+		return deviceToken.DateUpdated.Before(before)
+	}
+	return deviceToken.DateCreated.Before(before)
+}
+
+// EncryptDeviceTokens encrypts the device tokens which were stored before the encryption was enabled and gives the number of updated users.
+// A user which is updated while its tokens are encrypted is skipped, running it again encrypts its tokens.
+func (sa Adapter) EncryptDeviceTokens() (int64, error) {
+	if sa.db.tokenCipher == nil {
+		return 0, errors.ErrorData(logutils.StatusMissing, "token encryption key", nil)
+	}
+
+	const pageSize = 1000
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{primitive.E{Key: "_id", Value: 1}})
+	findOptions.SetLimit(pageSize)
+
+	var updated int64
+	afterID := ""
+	for {
+		filter := bson.D{
+			primitive.E{Key: "_id", Value: bson.M{"$gt": afterID}},
+			primitive.E{Key: "firebase_tokens", Value: bson.M{"$elemMatch": bson.M{
+				"token": bson.M{"$not": primitive.Regex{Pattern: "^" + encryptedTokenPrefix}, "$ne": ""}}}},
+		}
+		var users []model.User
+		err := sa.db.users.Find(filter, &users, findOptions)
+		if err != nil {
+			return updated, errors.WrapErrorAction(logutils.ActionFind, "user", &logutils.FieldArgs{"after": afterID}, err)
+		}
+		if len(users) == 0 {
+			return updated, nil
+		}
+
+		for _, user := range users {
+			//the tokens are encrypted when the loaded ones are stored back
+			userFilter := bson.D{
+				primitive.E{Key: "_id", Value: user.ID},
+				primitive.E{Key: "date_updated", Value: user.DateUpdated},
+			}
+			update := bson.D{
+				primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "firebase_tokens", Value: user.DeviceTokens}}},
+			}
+			res, err := sa.db.users.UpdateOne(userFilter, update, nil)
+			if err != nil {
+				return updated, errors.WrapErrorAction(logutils.ActionUpdate, "device token", &logutils.FieldArgs{"user_id": user.UserID}, err)
+			}
+			updated += res.ModifiedCount
+		}
+		afterID = users[len(users)-1].ID
+	}
+}
+
 // GetDeviceTokensByRecipients Gets all users mapped to the recipients input list
 func (sa Adapter) GetDeviceTokensByRecipients(orgID string, appID string, recipients []model.MessageRecipient, criteriaList []model.RecipientCriteria) ([]string, error) {
 	if len(recipients) > 0 {
@@ -322,7 +678,7 @@ func (sa Adapter) GetDeviceTokensByRecipients(orgID string, appID string, recipi
 		tokens := []string{}
 		for _, tokenMapping := range users {
 			if !tokenMapping.NotificationsDisabled {
-				for _, token := range tokenMapping.DeviceTokens {
+				for _, token := range tokenMapping.GetNotificationsTokens() {
 					if len(criteriaList) > 0 {
 						include := false
 						for _, criteria := range criteriaList {
@@ -347,71 +703,266 @@ func (sa Adapter) GetDeviceTokensByRecipients(orgID string, appID string, recipi
 	return nil, fmt.Errorf("empty recient information")
 }
 
-// GetUsersByTopicsWithContext Gets all users for topics
-func (sa Adapter) GetUsersByTopicsWithContext(ctx context.Context, orgID string, appID string, topics []string) ([]model.User, error) {
-	if len(topics) > 0 {
-		filter := bson.D{
-			primitive.E{Key: "org_id", Value: orgID},
-			primitive.E{Key: "app_id", Value: appID},
-			primitive.E{Key: "topics", Value: bson.M{"$in": topics}},
-		}
-
-		var tokenMappings []model.User
-		err := sa.db.users.FindWithContext(ctx, filter, &tokenMappings, nil)
-		if err != nil {
-			return nil, err
-		}
-
-		// TODO: was this necessary?
-		// result := []model.User{}
-		// for _, user := range tokenMappings {
-		// 	if user.HasTopic(topic) {
-		// 		result = append(result, user)
-		// 	}
-		// }
+// CountUsersByTopic counts the users subscribed to a topic
+func (sa Adapter) CountUsersByTopic(orgID string, appID string, topic string) (int64, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "topics", Value: topic},
+	}
 
-		return tokenMappings, nil
+	count, err := sa.db.users.CountDocuments(filter)
+	if err != nil {
+		return 0, errors.WrapErrorAction(logutils.ActionFind, "users count", &logutils.FieldArgs{"topic": topic}, err)
 	}
-	return nil, fmt.Errorf("no mapped recipients to %s topics", topics)
+	return count, nil
 }
 
-// GetUsersByRecipientCriteriasWithContext gets users list by list of criteria
-func (sa Adapter) GetUsersByRecipientCriteriasWithContext(ctx context.Context, orgID string, appID string, recipientCriterias []model.RecipientCriteria) ([]model.User, error) {
-	if len(recipientCriterias) > 0 {
-		var users []model.User
-		innerFilter := []interface{}{}
-
-		for _, criteria := range recipientCriterias {
-			if criteria.AppVersion != nil && len(*criteria.AppVersion) > 0 {
-				innerFilter = append(innerFilter, bson.D{primitive.E{Key: "firebase_tokens.app_version", Value: criteria.AppVersion}})
-			}
-			if criteria.AppPlatform != nil && len(*criteria.AppPlatform) > 0 {
-				innerFilter = append(innerFilter, bson.D{primitive.E{Key: "firebase_tokens.app_platform", Value: criteria.AppPlatform}})
-			}
-		}
+// CountMessagesByTopic counts the messages sent to the topic and created after the given time
+func (sa Adapter) CountMessagesByTopic(orgID string, appID string, topic string, createdAfter time.Time) (int64, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "$or", Value: bson.A{
+			bson.D{primitive.E{Key: "topic", Value: topic}},
+			bson.D{primitive.E{Key: "topics", Value: topic}},
+		}},
+		primitive.E{Key: "date_created", Value: bson.M{"$gt": createdAfter}},
+	}
 
-		if len(innerFilter) == 0 {
-			return nil, fmt.Errorf("no mapped recipients for the input criterias")
-		}
+	count, err := sa.db.messages.CountDocuments(filter)
+	if err != nil {
+		return 0, errors.WrapErrorAction(logutils.ActionCount, "message", &logutils.FieldArgs{"topic": topic}, err)
+	}
+	return count, nil
+}
 
-		filter := bson.D{
-			primitive.E{Key: "org_id", Value: orgID},
-			primitive.E{Key: "app_id", Value: appID},
-			primitive.E{Key: "$or", Value: innerFilter},
+// GetTopicStats aggregates the messages sent to the topic within the dates given as milliseconds epochs
+func (sa Adapter) GetTopicStats(orgID string, appID string, topic string, startDateEpoch *int64, endDateEpoch *int64) (*model.TopicStats, error) {
+	timeFilter := bson.M{"$lte": time.Now()} //sent
+	if startDateEpoch != nil {
+		timeFilter["$gte"] = time.Unix(*startDateEpoch/1000, 0)
+	}
+	if endDateEpoch != nil {
+		endTime := time.Unix(*endDateEpoch/1000, 0)
+		if endTime.Before(time.Now()) {
+			timeFilter["$lte"] = endTime
 		}
+	}
 
-		err := sa.db.users.FindWithContext(ctx, filter, &users, nil)
-		if err != nil {
-			return nil, err
-		}
+	pipeline := []bson.M{
+		{"$match": bson.M{"org_id": orgID, "app_id": appID,
+			"$or":             bson.A{bson.M{"topic": topic}, bson.M{"topics": topic}},
+			"time":            timeFilter,
+			"approval_status": bson.M{"$ne": model.ApprovalStatusPending}}},
+		{"$group": bson.M{"_id": nil,
+			"messages_count":     bson.M{"$sum": 1},
+			"last_sent":          bson.M{"$max": "$time"},
+			"average_recipients": bson.M{"$avg": "$calculated_recipients_count"}}},
+	}
+
+	var result []model.TopicStats
+	err := sa.db.messages.Aggregate(pipeline, &result, nil)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionCompute, "topic stats", &logutils.FieldArgs{"topic": topic}, err)
+	}
 
-		return users, nil
+	stats := model.TopicStats{Topic: topic}
+	if len(result) > 0 {
+		stats = result[0]
+		stats.Topic = topic
 	}
-	return nil, fmt.Errorf("no mapped recipients for the input criterias")
+	return &stats, nil
 }
 
-// UpdateUserByID Updates users notification enabled flag
-func (sa Adapter) UpdateUserByID(orgID string, appID string, userID string, notificationsDisabled bool) (*model.User, error) {
+// CountUsersByTopics counts the unique users subscribed to any of the topics or to all of them if all is true
+func (sa Adapter) CountUsersByTopics(orgID string, appID string, topics []string, all bool) (int64, error) {
+	pipeline := usersByTopicsCountPipeline(orgID, appID, topics, all)
+
+	var result []struct {
+		Count int64 `bson:"count"`
+	}
+	err := sa.db.users.Aggregate(pipeline, &result, nil)
+	if err != nil {
+		return 0, errors.WrapErrorAction(logutils.ActionFind, "users count", &logutils.FieldArgs{"topics": topics}, err)
+	}
+	if len(result) == 0 {
+		return 0, nil //no users
+	}
+	return result[0].Count, nil
+}
+
+// usersByTopicsCountPipeline gives the pipeline which counts the unique users subscribed to any of the topics or to all of them if all is true
+func usersByTopicsCountPipeline(orgID string, appID string, topics []string, all bool) []bson.M {
+	topicsOperator := "$in"
+	if all {
+		topicsOperator = "$all"
+	}
+
+	return []bson.M{
+		{"$match": bson.M{"org_id": orgID, "app_id": appID, "topics": bson.M{topicsOperator: topics}}},
+		{"$group": bson.M{"_id": "$user_id"}},
+		{"$count": "count"},
+	}
+}
+
+// CountUsersWithTokens counts the users which have at least one token and have not disabled the notifications
+func (sa Adapter) CountUsersWithTokens(orgID string, appID string) (int64, error) {
+	count, err := sa.db.users.CountDocuments(usersWithTokensFilter(orgID, appID, ""))
+	if err != nil {
+		return 0, errors.WrapErrorAction(logutils.ActionCount, "user", &logutils.FieldArgs{"org_id": orgID, "app_id": appID}, err)
+	}
+	return count, nil
+}
+
+// FindUsersWithTokens finds the users which have at least one token and have not disabled the notifications ordered by the user id.
+// It gives the users after the afterUserID one, from the first one if it is empty
+func (sa Adapter) FindUsersWithTokens(orgID string, appID string, afterUserID string, limit int64) ([]model.User, error) {
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{primitive.E{Key: "user_id", Value: 1}})
+	findOptions.SetLimit(limit)
+
+	var users []model.User
+	err := sa.db.users.Find(usersWithTokensFilter(orgID, appID, afterUserID), &users, findOptions)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "user", &logutils.FieldArgs{"org_id": orgID, "app_id": appID, "after": afterUserID}, err)
+	}
+	return users, nil
+}
+
+func usersWithTokensFilter(orgID string, appID string, afterUserID string) bson.D {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "notifications_disabled", Value: bson.M{"$ne": true}},
+		primitive.E{Key: "firebase_tokens.0", Value: bson.M{"$exists": true}},
+	}
+	if len(afterUserID) > 0 {
+		filter = append(filter, primitive.E{Key: "user_id", Value: bson.M{"$gt": afterUserID}})
+	}
+	return filter
+}
+
+// CountTopicsSubscribers counts the users subscribed to every topic of the app. It gives the counts by the topic names
+func (sa Adapter) CountTopicsSubscribers(orgID string, appID string) (map[string]int, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"org_id": orgID, "app_id": appID, "topics.0": bson.M{"$exists": true}}},
+		{"$unwind": "$topics"},
+		{"$group": bson.M{"_id": "$topics", "count": bson.M{"$sum": 1}}},
+	}
+
+	var result []struct {
+		Topic string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	err := sa.db.users.Aggregate(pipeline, &result, nil)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionCount, "topics subscribers", &logutils.FieldArgs{"org_id": orgID, "app_id": appID}, err)
+	}
+
+	counts := make(map[string]int, len(result))
+	for _, item := range result {
+		counts[item.Topic] = item.Count
+	}
+	return counts, nil
+}
+
+// FindTopicSubscribersIDs finds the ids of the users subscribed to the topic ordered by the user id
+func (sa Adapter) FindTopicSubscribersIDs(orgID string, appID string, topic string, offset *int64, limit *int64) ([]string, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "topics", Value: topic},
+	}
+
+	findOptions := options.Find()
+	findOptions.SetProjection(bson.D{primitive.E{Key: "user_id", Value: 1}})
+	findOptions.SetSort(bson.D{primitive.E{Key: "user_id", Value: 1}})
+	if offset != nil {
+		findOptions.SetSkip(*offset)
+	}
+	if limit != nil {
+		findOptions.SetLimit(*limit)
+	}
+
+	var users []model.User
+	err := sa.db.users.Find(filter, &users, findOptions)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "user", &logutils.FieldArgs{"topic": topic}, err)
+	}
+
+	ids := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.UserID
+	}
+	return ids, nil
+}
+
+// GetUsersByTopicsWithContext Gets all users for topics
+func (sa Adapter) GetUsersByTopicsWithContext(ctx context.Context, orgID string, appID string, topics []string) ([]model.User, error) {
+	if len(topics) > 0 {
+		filter := bson.D{
+			primitive.E{Key: "org_id", Value: orgID},
+			primitive.E{Key: "app_id", Value: appID},
+			primitive.E{Key: "topics", Value: bson.M{"$in": topics}},
+		}
+
+		var tokenMappings []model.User
+		err := sa.db.users.FindWithContext(ctx, filter, &tokenMappings, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// TODO: was this necessary?
+		// result := []model.User{}
+		// for _, user := range tokenMappings {
+		// 	if user.HasTopic(topic) {
+		// 		result = append(result, user)
+		// 	}
+		// }
+
+		return tokenMappings, nil
+	}
+	return nil, fmt.Errorf("no mapped recipients to %s topics", topics)
+}
+
+// GetUsersByRecipientCriteriasWithContext gets users list by list of criteria
+func (sa Adapter) GetUsersByRecipientCriteriasWithContext(ctx context.Context, orgID string, appID string, recipientCriterias []model.RecipientCriteria) ([]model.User, error) {
+	if len(recipientCriterias) > 0 {
+		var users []model.User
+		innerFilter := []interface{}{}
+
+		for _, criteria := range recipientCriterias {
+			if criteria.AppVersion != nil && len(*criteria.AppVersion) > 0 {
+				innerFilter = append(innerFilter, bson.D{primitive.E{Key: "firebase_tokens.app_version", Value: criteria.AppVersion}})
+			}
+			if criteria.AppPlatform != nil && len(*criteria.AppPlatform) > 0 {
+				innerFilter = append(innerFilter, bson.D{primitive.E{Key: "firebase_tokens.app_platform", Value: criteria.AppPlatform}})
+			}
+		}
+
+		if len(innerFilter) == 0 {
+			return nil, fmt.Errorf("no mapped recipients for the input criterias")
+		}
+
+		filter := bson.D{
+			primitive.E{Key: "org_id", Value: orgID},
+			primitive.E{Key: "app_id", Value: appID},
+			primitive.E{Key: "$or", Value: innerFilter},
+		}
+
+		err := sa.db.users.FindWithContext(ctx, filter, &users, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return users, nil
+	}
+	return nil, fmt.Errorf("no mapped recipients for the input criterias")
+}
+
+// UpdateUserByID Updates users notification enabled flag
+func (sa Adapter) UpdateUserByID(orgID string, appID string, userID string, notificationsDisabled bool) (*model.User, error) {
 	if userID != "" {
 		filter := bson.D{
 			primitive.E{Key: "org_id", Value: orgID},
@@ -439,6 +990,119 @@ func (sa Adapter) UpdateUserByID(orgID string, appID string, userID string, noti
 	return nil, nil
 }
 
+// UpdateUserMutedTopics sets the topics muted by the user
+func (sa Adapter) UpdateUserMutedTopics(orgID string, appID string, userID string, mutedTopics []string) (*model.User, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "user_id", Value: userID},
+	}
+
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "date_updated", Value: time.Now().UTC()},
+			primitive.E{Key: "muted_topics", Value: mutedTopics},
+		}},
+	}
+
+	_, err := sa.db.users.UpdateOneWithContext(context.Background(), filter, &update, nil)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionUpdate, "user", &logutils.FieldArgs{"user_id": userID, "muted_topics": mutedTopics}, err)
+	}
+
+	return sa.FindUserByID(orgID, appID, userID)
+}
+
+// UpdateUserSettings sets the user preferences for receiving the pushes, nil values clear the quiet hours and the pause
+func (sa Adapter) UpdateUserSettings(orgID string, appID string, userID string, settings model.UserSettings) (*model.User, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "user_id", Value: userID},
+	}
+
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "notifications_disabled", Value: !settings.NotificationsEnabled},
+			primitive.E{Key: "paused_until", Value: settings.PausedUntil},
+			primitive.E{Key: "quiet_hours_start", Value: settings.QuietHoursStart},
+			primitive.E{Key: "quiet_hours_end", Value: settings.QuietHoursEnd},
+			primitive.E{Key: "time_zone", Value: settings.TimeZone},
+			primitive.E{Key: "date_updated", Value: time.Now().UTC()},
+		}},
+	}
+
+	_, err := sa.db.users.UpdateOne(filter, update, nil)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionUpdate, "user", &logutils.FieldArgs{"user_id": userID, "settings": "set"}, err)
+	}
+
+	return sa.FindUserByID(orgID, appID, userID)
+}
+
+// MuteTopic adds the topic to the topics muted by the user
+func (sa Adapter) MuteTopic(orgID string, appID string, userID string, topic string) (*model.User, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "user_id", Value: userID},
+	}
+
+	update := bson.D{
+		primitive.E{Key: "$addToSet", Value: bson.D{primitive.E{Key: "muted_topics", Value: topic}}},
+		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "date_updated", Value: time.Now().UTC()}}},
+	}
+
+	_, err := sa.db.users.UpdateOne(filter, update, nil)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionUpdate, "user", &logutils.FieldArgs{"user_id": userID, "muted_topic": topic}, err)
+	}
+
+	return sa.FindUserByID(orgID, appID, userID)
+}
+
+// UnmuteTopic removes the topic from the topics muted by the user
+func (sa Adapter) UnmuteTopic(orgID string, appID string, userID string, topic string) (*model.User, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "user_id", Value: userID},
+	}
+
+	update := bson.D{
+		primitive.E{Key: "$pull", Value: bson.D{primitive.E{Key: "muted_topics", Value: topic}}},
+		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "date_updated", Value: time.Now().UTC()}}},
+	}
+
+	_, err := sa.db.users.UpdateOne(filter, update, nil)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionUpdate, "user", &logutils.FieldArgs{"user_id": userID, "unmuted_topic": topic}, err)
+	}
+
+	return sa.FindUserByID(orgID, appID, userID)
+}
+
+// UpdateUserLastActive sets the last time the user was active
+func (sa Adapter) UpdateUserLastActive(orgID string, appID string, userID string, lastActive time.Time) error {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "user_id", Value: userID},
+	}
+
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "date_last_active", Value: lastActive},
+		}},
+	}
+
+	_, err := sa.db.users.UpdateOne(filter, &update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "user", &logutils.FieldArgs{"user_id": userID, "date_last_active": lastActive}, err)
+	}
+	return nil
+}
+
 // DeleteUserWithID Deletes user with ID and all messages
 func (sa Adapter) DeleteUserWithID(orgID string, appID string, userID string) error {
 	if userID != "" {
@@ -451,7 +1115,7 @@ func (sa Adapter) DeleteUserWithID(orgID string, appID string, userID string) er
 				return err
 			}
 
-			messages, err := sa.FindMessagesRecipientsDeep(orgID, appID, &userID, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+			messages, err := sa.FindMessagesRecipientsDeep(orgID, appID, &userID, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 			if err != nil {
 				fmt.Printf("warning: unable to retrieve messages for user (%s): %s\n", userID, err)
 				abortTransaction(sessionContext)
@@ -466,7 +1130,7 @@ func (sa Adapter) DeleteUserWithID(orgID string, appID string, userID string) er
 
 					if *message.Message.CalculatedRecipientsCount == 1 {
 						//the message has had only one recipient, so we need to remove the message entity too
-						err = sa.DeleteMessagesWithContext(sessionContext, []string{message.ID})
+						err = sa.DeleteMessagesWithContext(sessionContext, orgID, appID, []string{message.ID})
 						if err != nil {
 							fmt.Printf("warning: unable to delete message(%s): %s\n", message.ID, err)
 						}
@@ -511,48 +1175,46 @@ func (sa Adapter) DeleteUserWithID(orgID string, appID string, userID string) er
 	return nil
 }
 
-// GetMessagesStats counts read/unread and muted/unmuted messages
-func (sa *Adapter) GetMessagesStats(userID string) (*model.MessagesStats, error) {
+// GetUserMessagesStats counts the read/unread and muted/unmuted messages of a user. The counts are computed by the database
+// so that the recipients documents are not loaded.
+func (sa *Adapter) GetUserMessagesStats(orgID string, appID string, userID string) (*model.MessagesStats, error) {
 	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
 		primitive.E{Key: "user_id", Value: userID},
+		primitive.E{Key: "pending_approval", Value: bson.M{"$ne": true}},
+		primitive.E{Key: "recalled", Value: bson.M{"$ne": true}},
+		primitive.E{Key: "deleted", Value: bson.M{"$ne": true}},
+	}
+	countIf := func(condition interface{}) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{condition, 1, 0}}}
+	}
+	isRead := bson.M{"$eq": bson.A{"$read", true}}
+	isMute := bson.M{"$eq": bson.A{"$mute", true}}
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$group": bson.M{
+			"_id":               nil,
+			"total_count":       bson.M{"$sum": 1},
+			"muted_count":       countIf(isMute),
+			"not_muted_count":   countIf(bson.M{"$not": bson.A{isMute}}),
+			"read_count":        countIf(isRead),
+			"not_read_count":    countIf(bson.M{"$not": bson.A{isRead}}),
+			"not_read_not_mute": countIf(bson.M{"$and": bson.A{bson.M{"$not": bson.A{isRead}}, bson.M{"$not": bson.A{isMute}}}}),
+		}},
 	}
 
-	var data []model.MessageRecipient
-	err := sa.db.messagesRecipients.Find(filter, &data, nil)
+	var data []model.MessagesStats
+	err := sa.db.messagesRecipients.Aggregate(pipeline, &data, nil)
 	if err != nil {
 		return nil, err
 	}
-	if data == nil {
-		data = make([]model.MessageRecipient, 0)
-	}
-
-	totalCount := int64(len(data))
-	muted := int64(0)
-	unmuted := int64(0)
-	read := int64(0)
-	unread := int64(0)
-	unreadUnmute := int64(0)
-
-	for _, messRec := range data {
-		if messRec.Read {
-			read++
-		} else {
-			unread++
-		}
-
-		if messRec.Mute {
-			muted++
-		} else {
-			unmuted++
-		}
-		if messRec.Read == false && messRec.Mute == false {
-			unreadUnmute++
-		}
+	if len(data) == 0 {
+		//no messages
+		zero := int64(0)
+		return &model.MessagesStats{TotalCount: &zero, Muted: &zero, Unmuted: &zero, Read: &zero, Unread: &zero, UnreadUnmute: &zero}, nil
 	}
-
-	stats := model.MessagesStats{TotalCount: &totalCount, Muted: &muted,
-		Unmuted: &unmuted, Read: &read, Unread: &unread, UnreadUnmute: &unreadUnmute}
-	return &stats, nil
+	return &data[0], nil
 }
 
 // SubscribeToTopic subscribes the token to a topic
@@ -613,6 +1275,26 @@ func (sa Adapter) UnsubscribeToTopic(orgID string, appID string, token string, u
 	return err
 }
 
+// RemoveUserTopics removes the given topics from the topics the user is subscribed to
+func (sa Adapter) RemoveUserTopics(orgID string, appID string, userID string, topics []string) error {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "user_id", Value: userID},
+	}
+
+	update := bson.D{
+		primitive.E{Key: "$pull", Value: bson.D{primitive.E{Key: "topics", Value: bson.M{"$in": topics}}}},
+		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "date_updated", Value: time.Now().UTC()}}},
+	}
+
+	_, err := sa.db.users.UpdateOne(filter, update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "user", &logutils.FieldArgs{"user_id": userID, "removed_topics": topics}, err)
+	}
+	return nil
+}
+
 // GetTopics gets all topics
 func (sa Adapter) GetTopics(orgID string, appID string) ([]model.Topic, error) {
 	filter := bson.D{
@@ -629,13 +1311,52 @@ func (sa Adapter) GetTopics(orgID string, appID string) ([]model.Topic, error) {
 	return result, nil
 }
 
+// GetTopicsPaged gets a page of the topics sorted by the name or the date created and the count of all topics
+func (sa Adapter) GetTopicsPaged(orgID string, appID string, offset *int64, limit *int64, sortBy string, order string) ([]model.Topic, int64, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+	}
+
+	total, err := sa.db.topics.CountDocuments(filter)
+	if err != nil {
+		return nil, 0, errors.WrapErrorAction(logutils.ActionCount, "topic", nil, err)
+	}
+
+	sortValue := 1
+	if order == "desc" {
+		sortValue = -1
+	}
+	sortFields := bson.D{primitive.E{Key: "name", Value: sortValue}}
+	if sortBy == model.TopicsSortByDateCreated {
+		sortFields = bson.D{primitive.E{Key: "date_created", Value: sortValue}, primitive.E{Key: "name", Value: sortValue}}
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(sortFields)
+	if offset != nil {
+		findOptions.SetSkip(*offset)
+	}
+	if limit != nil {
+		findOptions.SetLimit(*limit)
+	}
+
+	var result []model.Topic
+	err = sa.db.topics.Find(filter, &result, findOptions)
+	if err != nil {
+		return nil, 0, errors.WrapErrorAction(logutils.ActionFind, "topic", nil, err)
+	}
+
+	return result, total, nil
+}
+
 // GetTopicByName appends a new topic within the topics collection
 func (sa Adapter) GetTopicByName(orgID string, appID string, name string) (*model.Topic, error) {
 	if name != "" {
 		filter := bson.D{
 			primitive.E{Key: "org_id", Value: orgID},
 			primitive.E{Key: "app_id", Value: appID},
-			primitive.E{Key: "_id", Value: name},
+			primitive.E{Key: "name", Value: name},
 		}
 		var topic model.Topic
 		err := sa.db.topics.FindOne(filter, &topic, nil)
@@ -651,6 +1372,9 @@ func (sa Adapter) GetTopicByName(orgID string, appID string, name string) (*mode
 // InsertTopic appends a new topic within the topics collection
 func (sa Adapter) InsertTopic(topic *model.Topic) (*model.Topic, error) {
 	if topic.Name != "" {
+		if topic.ID == "" {
+			topic.ID = uuid.NewString()
+		}
 		now := time.Now().UTC()
 		topic.DateUpdated = now
 		topic.DateCreated = now
@@ -665,12 +1389,12 @@ func (sa Adapter) InsertTopic(topic *model.Topic) (*model.Topic, error) {
 	return topic, nil
 }
 
-// UpdateTopic updates a topic (for now only description is updatable)
+// UpdateTopic updates a topic (for now only description, delivery windows, retention and default sound are updatable)
 func (sa Adapter) UpdateTopic(topic *model.Topic) (*model.Topic, error) {
 	filter := bson.D{
 		primitive.E{Key: "org_id", Value: topic.OrgID},
 		primitive.E{Key: "app_id", Value: topic.AppID},
-		primitive.E{Key: "_id", Value: topic.Name},
+		primitive.E{Key: "name", Value: topic.Name},
 	}
 
 	now := time.Now().UTC()
@@ -679,6 +1403,10 @@ func (sa Adapter) UpdateTopic(topic *model.Topic) (*model.Topic, error) {
 	update := bson.D{
 		primitive.E{Key: "$set", Value: bson.D{
 			primitive.E{Key: "description", Value: topic.Description},
+			primitive.E{Key: "delivery_windows", Value: topic.DeliveryWindows},
+			primitive.E{Key: "time_zone", Value: topic.TimeZone},
+			primitive.E{Key: "retention_days", Value: topic.RetentionDays},
+			primitive.E{Key: "default_sound", Value: topic.DefaultSound},
 			primitive.E{Key: "date_updated", Value: topic.DateUpdated},
 		}},
 	}
@@ -692,6 +1420,171 @@ func (sa Adapter) UpdateTopic(topic *model.Topic) (*model.Topic, error) {
 	return topic, err
 }
 
+// FindTopicsWithRetention finds the topics which override the messages retention
+func (sa Adapter) FindTopicsWithRetention() ([]model.Topic, error) {
+	filter := bson.D{primitive.E{Key: "retention_days", Value: bson.M{"$gt": 0}}}
+	var result []model.Topic
+	err := sa.db.topics.Find(filter, &result, nil)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "topic", nil, err)
+	}
+	return result, nil
+}
+
+// FindTopicByAlias finds the topic which has the given name as alias
+func (sa Adapter) FindTopicByAlias(orgID string, appID string, alias string) (*model.Topic, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "aliases", Value: alias},
+	}
+	var result []model.Topic
+	err := sa.db.topics.Find(filter, &result, nil)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "topic", &logutils.FieldArgs{"alias": alias}, err)
+	}
+	if len(result) == 0 {
+		//not found
+		return nil, nil
+	}
+	return &result[0], nil
+}
+
+// InsertTopicWithContext inserts a topic
+func (sa Adapter) InsertTopicWithContext(ctx context.Context, topic model.Topic) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	_, err := sa.db.topics.InsertOneWithContext(ctx, topic)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionInsert, "topic", &logutils.FieldArgs{"name": topic.Name}, err)
+	}
+	return nil
+}
+
+// DeleteTopicWithContext deletes a topic
+func (sa Adapter) DeleteTopicWithContext(ctx context.Context, orgID string, appID string, name string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "name", Value: name},
+	}
+	_, err := sa.db.topics.DeleteOneWithContext(ctx, filter, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionDelete, "topic", &logutils.FieldArgs{"name": name}, err)
+	}
+	return nil
+}
+
+// RemoveUsersTopicWithContext removes the topic from the subscribed and the muted topics of all users. It gives the affected users count
+func (sa Adapter) RemoveUsersTopicWithContext(ctx context.Context, orgID string, appID string, topic string) (int64, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "$or", Value: bson.A{
+			bson.D{primitive.E{Key: "topics", Value: topic}},
+			bson.D{primitive.E{Key: "muted_topics", Value: topic}},
+		}},
+	}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "date_updated", Value: time.Now().UTC()},
+		}},
+		primitive.E{Key: "$pull", Value: bson.D{
+			primitive.E{Key: "topics", Value: topic},
+			primitive.E{Key: "muted_topics", Value: topic},
+		}},
+	}
+	res, err := sa.db.users.UpdateManyWithContext(ctx, filter, update, nil)
+	if err != nil {
+		return 0, errors.WrapErrorAction(logutils.ActionUpdate, "user", &logutils.FieldArgs{"topic": topic}, err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// RenameUsersTopicWithContext replaces the old topic with the new one for all subscribed users
+func (sa Adapter) RenameUsersTopicWithContext(ctx context.Context, orgID string, appID string, oldName string, newName string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "topics", Value: oldName},
+	}
+
+	//add the new name first so that the filter still matches the same users
+	addUpdate := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "date_updated", Value: time.Now().UTC()},
+		}},
+		primitive.E{Key: "$addToSet", Value: bson.D{primitive.E{Key: "topics", Value: newName}}},
+	}
+	_, err := sa.db.users.UpdateManyWithContext(ctx, filter, addUpdate, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "user", &logutils.FieldArgs{"topic": newName}, err)
+	}
+
+	pullUpdate := bson.D{
+		primitive.E{Key: "$pull", Value: bson.D{primitive.E{Key: "topics", Value: oldName}}},
+	}
+	_, err = sa.db.users.UpdateManyWithContext(ctx, filter, pullUpdate, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "user", &logutils.FieldArgs{"topic": oldName}, err)
+	}
+	return nil
+}
+
+// RenameMessagesTopicWithContext repoints the messages sent to the old topic to the new one
+func (sa Adapter) RenameMessagesTopicWithContext(ctx context.Context, orgID string, appID string, oldName string, newName string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	//single topic
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "topic", Value: oldName},
+	}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "topic", Value: newName},
+		}},
+	}
+	_, err := sa.db.messages.UpdateManyWithContext(ctx, filter, update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "message", &logutils.FieldArgs{"topic": oldName}, err)
+	}
+
+	//multiple topics
+	filter = bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "topics", Value: oldName},
+	}
+	update = bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "topics.$", Value: newName},
+		}},
+	}
+	_, err = sa.db.messages.UpdateManyWithContext(ctx, filter, update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "message", &logutils.FieldArgs{"topics": oldName}, err)
+	}
+	return nil
+}
+
 // FindMessagesRecipients finds messages recipients
 func (sa Adapter) FindMessagesRecipients(orgID string, appID string, messageID string, userID string) ([]model.MessageRecipient, error) {
 	filter := bson.D{
@@ -727,8 +1620,10 @@ func (sa Adapter) FindMessagesRecipientsByMessageAndUsers(messageID string, user
 }
 
 // FindMessagesRecipientsByMessages finds messages recipients by messages
-func (sa Adapter) FindMessagesRecipientsByMessages(messagesIDs []string) ([]model.MessageRecipient, error) {
+func (sa Adapter) FindMessagesRecipientsByMessages(orgID string, appID string, messagesIDs []string) ([]model.MessageRecipient, error) {
 	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
 		primitive.E{Key: "message_id", Value: bson.M{"$in": messagesIDs}},
 	}
 
@@ -741,11 +1636,103 @@ func (sa Adapter) FindMessagesRecipientsByMessages(messagesIDs []string) ([]mode
 	return data, nil
 }
 
-// FindMessagesRecipientsDeep finds messages recipients join with messages
+// FindFailedMessageRecipients finds the recipients of a message for which the delivery has failed
+func (sa Adapter) FindFailedMessageRecipients(orgID string, appID string, messageID string) ([]model.MessageRecipient, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "message_id", Value: messageID},
+		primitive.E{Key: "delivery_status", Value: model.DeliveryStatusFailed},
+	}
+
+	var data []model.MessageRecipient
+	err := sa.db.messagesRecipients.Find(filter, &data, nil)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "message recipient", &logutils.FieldArgs{"message_id": messageID}, err)
+	}
+	return data, nil
+}
+
+// FindMessagesRecipientsDeepWithCount finds messages recipients join with messages as FindMessagesRecipientsDeep does.
+// It gives also the count of all the recipients which match the filters regardless of the offset and the limit.
+func (sa Adapter) FindMessagesRecipientsDeepWithCount(ctx context.Context, orgID string, appID string, userID *string, read *bool, mute *bool,
+	messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, hasAttachment *bool,
+	priority *int64, groupID *string, offset *int64, limit *int64, order *string, orderBy *string, fields []string) ([]model.MessageRecipient, int64, error) {
+	items, err := sa.findMessagesRecipientsDeep(ctx, orgID, appID, userID, read, mute, messageIDs, startDateEpoch, endDateEpoch,
+		filterTopic, hasAttachment, priority, groupID, offset, limit, order, orderBy, fields)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pipeline := messagesRecipientsDeepFilters(orgID, appID, userID, read, mute, messageIDs, startDateEpoch, endDateEpoch, filterTopic, hasAttachment, priority, groupID)
+	pipeline = append(pipeline, bson.M{"$count": "count"})
+	var result []struct {
+		Count int64 `bson:"count"`
+	}
+	err = sa.db.messagesRecipients.AggregateWithContext(ctx, pipeline, &result, nil)
+	if err != nil {
+		return nil, 0, errors.WrapErrorAction(logutils.ActionCount, "message", nil, err)
+	}
+	if len(result) == 0 {
+		return items, 0, nil //no messages
+	}
+	return items, result[0].Count, nil
+}
+
+// FindMessagesRecipientsDeep finds messages recipients join with messages. If fields are given then only they are loaded from the messages.
 func (sa Adapter) FindMessagesRecipientsDeep(orgID string, appID string, userID *string, read *bool, mute *bool,
-	messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string,
-	offset *int64, limit *int64, order *string) ([]model.MessageRecipient, error) {
+	messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, hasAttachment *bool,
+	priority *int64, groupID *string, offset *int64, limit *int64, order *string, orderBy *string, fields []string) ([]model.MessageRecipient, error) {
+	return sa.findMessagesRecipientsDeep(context.Background(), orgID, appID, userID, read, mute, messageIDs, startDateEpoch, endDateEpoch,
+		filterTopic, hasAttachment, priority, groupID, offset, limit, order, orderBy, fields)
+}
+
+func (sa Adapter) findMessagesRecipientsDeep(ctx context.Context, orgID string, appID string, userID *string, read *bool, mute *bool,
+	messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, hasAttachment *bool,
+	priority *int64, groupID *string, offset *int64, limit *int64, order *string, orderBy *string, fields []string) ([]model.MessageRecipient, error) {
+	pipeline := messagesRecipientsDeepFilters(orgID, appID, userID, read, mute, messageIDs, startDateEpoch, endDateEpoch, filterTopic, hasAttachment, priority, groupID)
+
+	sortValue := -1
+	if order != nil && *order == "asc" {
+		sortValue = 1
+	}
+	if orderBy != nil {
+		pipeline = append(pipeline, bson.M{"$sort": messagesOrderBySort(*orderBy, sortValue)})
+	} else {
+		pipeline = append(pipeline, bson.M{"$sort": bson.M{"time": sortValue}})
+	}
+
+	if limit != nil {
+		//calculate real limit
+		offsetValue := utils.GetInt64Value(offset)
+		calculatedLimit := offsetValue + *limit
+		pipeline = append(pipeline, bson.M{"$limit": calculatedLimit})
+	}
+	if offset != nil {
+		pipeline = append(pipeline, bson.M{"$skip": *offset})
+	}
 
+	return sa.aggregateMessagesRecipientsDeep(ctx, pipeline, fields)
+}
+
+// GetMessagesAfter finds the user messages which come after the cursor message, the oldest first. The messages are ordered by
+// their time and then by their id, so none is skipped or given twice while the new messages arrive. If fields are given then only they are loaded.
+func (sa Adapter) GetMessagesAfter(ctx context.Context, orgID string, appID string, userID string, cursor model.Message, limit int64, fields []string) ([]model.MessageRecipient, error) {
+	pipeline := messagesRecipientsDeepFilters(orgID, appID, &userID, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	pipeline = append(pipeline,
+		bson.M{"$match": bson.M{"$or": bson.A{
+			bson.M{"time": bson.M{"$gt": cursor.Time}},
+			bson.M{"time": cursor.Time, "message_id": bson.M{"$gt": cursor.ID}},
+		}}},
+		bson.M{"$sort": bson.D{primitive.E{Key: "time", Value: 1}, primitive.E{Key: "message_id", Value: 1}}},
+		bson.M{"$limit": limit},
+	)
+
+	return sa.aggregateMessagesRecipientsDeep(ctx, pipeline, fields)
+}
+
+// aggregateMessagesRecipientsDeep runs the pipeline which joins the messages recipients with their messages
+func (sa Adapter) aggregateMessagesRecipientsDeep(ctx context.Context, pipeline []bson.M, fields []string) ([]model.MessageRecipient, error) {
 	type recipientJoinMessage struct {
 		//message
 		Priority                  int                       `bson:"priority"`
@@ -753,26 +1740,64 @@ func (sa Adapter) FindMessagesRecipientsDeep(orgID string, appID string, userID
 		Sender                    model.Sender              `bson:"sender"`
 		Body                      string                    `bson:"body"`
 		Data                      map[string]string         `bson:"data"`
+		Attachments               []model.Attachment        `bson:"attachments"`
 		Recipients                []model.MessageRecipient  `bson:"recipients"`
 		RecipientsCriteriaList    []model.RecipientCriteria `bson:"recipients_criteria_list"`
 		RecipientAccountCriteria  map[string]interface{}    `bson:"recipient_account_criteria"`
 		Topic                     *string                   `bson:"topic"`
 		Topics                    []string                  `bson:"topics"`
+		GroupID                   *string                   `bson:"group_id"`
 		CalculatedRecipientsCount *int                      `bson:"calculated_recipients_count"`
 		DateCreated               *time.Time                `bson:"date_created"`
 		DateUpdated               *time.Time                `bson:"date_updated"`
 		Time                      time.Time                 `bson:"time"`
 
 		//recipient
-		OrgID     string `bson:"org_id"`
-		AppID     string `bson:"app_id"`
-		ID        string `bson:"_id"`
-		UserID    string `bson:"user_id"`
-		MessageID string `bson:"message_id"`
-		Mute      bool   `bson:"mute"`
-		Read      bool   `bson:"read"`
+		OrgID        string  `bson:"org_id"`
+		AppID        string  `bson:"app_id"`
+		ID           string  `bson:"_id"`
+		UserID       string  `bson:"user_id"`
+		MessageID    string  `bson:"message_id"`
+		Mute         bool    `bson:"mute"`
+		Read         bool    `bson:"read"`
+		RenderedBody *string `bson:"rendered_body"`
+
+		RenderedSubject *string `bson:"rendered_subject"`
+	}
+
+	//partial messages
+	if len(fields) > 0 {
+		pipeline = append(pipeline, bson.M{"$project": messagesFieldsProjection(fields)})
 	}
 
+	var items []recipientJoinMessage
+	err := sa.db.messagesRecipients.AggregateWithContext(ctx, pipeline, &items, nil)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "message", nil, err)
+	}
+
+	result := make([]model.MessageRecipient, len(items))
+	for i, item := range items {
+
+		message := model.Message{OrgID: item.OrgID, AppID: item.AppID, ID: item.MessageID,
+			Priority: item.Priority, Subject: item.Subject,
+			Sender: item.Sender, Body: item.Body, Data: item.Data, Attachments: item.Attachments, Recipients: item.Recipients,
+			RecipientsCriteriaList: item.RecipientsCriteriaList, RecipientAccountCriteria: item.RecipientAccountCriteria,
+			Topic: item.Topic, Topics: item.Topics, GroupID: item.GroupID, CalculatedRecipientsCount: item.CalculatedRecipientsCount, DateCreated: item.DateCreated,
+			DateUpdated: item.DateUpdated, Time: item.Time}
+
+		recipient := model.MessageRecipient{OrgID: item.OrgID, AppID: item.AppID,
+			ID: item.ID, UserID: item.UserID, MessageID: item.MessageID, Mute: item.Mute,
+			Read: item.Read, RenderedBody: item.RenderedBody, RenderedSubject: item.RenderedSubject, Message: message}
+		result[i] = recipient
+	}
+
+	return result, nil
+}
+
+// messagesRecipientsDeepFilters gives the stages which join the messages recipients with their messages and filter them
+func messagesRecipientsDeepFilters(orgID string, appID string, userID *string, read *bool, mute *bool,
+	messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, hasAttachment *bool, priority *int64, groupID *string) []bson.M {
 	pipeline := []bson.M{
 		{"$lookup": bson.M{
 			"from":         "messages",
@@ -782,14 +1807,17 @@ func (sa Adapter) FindMessagesRecipientsDeep(orgID string, appID string, userID
 		}},
 		{"$unwind": "$message"},
 		{"$project": bson.M{"org_id": 1, "app_id": 1, "_id": 1,
-			"user_id": 1, "message_id": 1, "mute": 1, "read": 1, "time": "$message.time",
+			"user_id": 1, "message_id": 1, "mute": 1, "read": 1, "rendered_body": 1, "pending_approval": 1, "recalled": 1, "deleted": 1, "time": "$message.time",
 			"priority": "$message.priority", "subject": "$message.subject", "sender": "$message.sender",
-			"body": "$message.body", "data": "$message.data", "recipients": "$message.recipients",
+			"body": "$message.body", "data": "$message.data", "attachments": "$message.attachments", "recipients": "$message.recipients",
 			"recipients_criteria_list": "$message.recipients_criteria_list", "recipient_account_criteria": "$message.recipient_account_criteria",
 			"topic": "$message.topic", "topics": "$message.topics", "calculated_recipients_count": "$message.calculated_recipients_count",
-			"date_created": "$message.date_created", "date_updated": "$message.date_updated"}},
+			"group_id": "$message.group_id", "date_created": "$message.date_created", "date_updated": "$message.date_updated"}},
 		{"$match": bson.M{"org_id": orgID}},
 		{"$match": bson.M{"app_id": appID}},
+		{"$match": bson.M{"pending_approval": bson.M{"$ne": true}}}, //not approved yet
+		{"$match": bson.M{"recalled": bson.M{"$ne": true}}},
+		{"$match": bson.M{"deleted": bson.M{"$ne": true}}},
 	}
 
 	if userID != nil && len(*userID) > 0 {
@@ -809,7 +1837,20 @@ func (sa Adapter) FindMessagesRecipientsDeep(orgID string, appID string, userID
 	}
 
 	if filterTopic != nil {
-		pipeline = append(pipeline, bson.M{"$match": bson.M{"topic": *filterTopic}})
+		//the message is found by its single topic or by any of its topics
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"$or": bson.A{bson.M{"topic": *filterTopic}, bson.M{"topics": *filterTopic}}}})
+	}
+
+	if hasAttachment != nil {
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"attachments.0": bson.M{"$exists": *hasAttachment}}})
+	}
+
+	if priority != nil {
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"priority": *priority}})
+	}
+
+	if groupID != nil {
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"group_id": *groupID}})
 	}
 
 	pipeline = append(pipeline, bson.M{"$match": bson.M{"time": bson.M{"$lte": time.Now()}}})
@@ -825,45 +1866,7 @@ func (sa Adapter) FindMessagesRecipientsDeep(orgID string, appID string, userID
 		pipeline = append(pipeline, bson.M{"$match": bson.M{"time": bson.D{primitive.E{Key: "$lte", Value: &timeValue}}}})
 	}
 
-	if order != nil && *order == "asc" {
-		pipeline = append(pipeline, bson.M{"$sort": bson.M{"time": 1}})
-	} else {
-		pipeline = append(pipeline, bson.M{"$sort": bson.M{"time": -1}})
-	}
-
-	if limit != nil {
-		//calculate real limit
-		offsetValue := utils.GetInt64Value(offset)
-		calculatedLimit := offsetValue + *limit
-		pipeline = append(pipeline, bson.M{"$limit": calculatedLimit})
-	}
-	if offset != nil {
-		pipeline = append(pipeline, bson.M{"$skip": *offset})
-	}
-
-	var items []recipientJoinMessage
-	err := sa.db.messagesRecipients.Aggregate(pipeline, &items, nil)
-	if err != nil {
-		return nil, errors.WrapErrorAction(logutils.ActionFind, "message", nil, err)
-	}
-
-	result := make([]model.MessageRecipient, len(items))
-	for i, item := range items {
-
-		message := model.Message{OrgID: item.OrgID, AppID: item.AppID, ID: item.MessageID,
-			Priority: item.Priority, Subject: item.Subject,
-			Sender: item.Sender, Body: item.Body, Data: item.Data, Recipients: item.Recipients,
-			RecipientsCriteriaList: item.RecipientsCriteriaList, RecipientAccountCriteria: item.RecipientAccountCriteria,
-			Topic: item.Topic, Topics: item.Topics, CalculatedRecipientsCount: item.CalculatedRecipientsCount, DateCreated: item.DateCreated,
-			DateUpdated: item.DateUpdated, Time: item.Time}
-
-		recipient := model.MessageRecipient{OrgID: item.OrgID, AppID: item.AppID,
-			ID: item.ID, UserID: item.UserID, MessageID: item.MessageID, Mute: item.Mute,
-			Read: item.Read, Message: message}
-		result[i] = recipient
-	}
-
-	return result, nil
+	return pipeline
 }
 
 // InsertMessagesRecipientsWithContext inserts messages recipients
@@ -925,16 +1928,8 @@ func (sa Adapter) FindMessagesWithContext(ctx context.Context, ids []string) ([]
 }
 
 // FindMessagesByParams finds messages by params
-func (sa Adapter) FindMessagesByParams(orgID string, appID string, senderType string, senderAccountID *string, offset *int64, limit *int64, order *string) ([]model.Message, error) {
-	filter := bson.D{
-		primitive.E{Key: "org_id", Value: orgID},
-		primitive.E{Key: "app_id", Value: appID},
-		primitive.E{Key: "sender.type", Value: senderType},
-	}
-	//sender account id
-	if senderAccountID != nil {
-		filter = append(filter, primitive.E{Key: "sender.user.user_id", Value: *senderAccountID})
-	}
+func (sa Adapter) FindMessagesByParams(ctx context.Context, orgID string, appID string, senderType string, senderAccountID *string, sourceApp *string, includeDeleted bool, offset *int64, limit *int64, order *string, orderBy *string) ([]model.Message, error) {
+	filter := messagesByParamsFilter(orgID, appID, senderType, senderAccountID, sourceApp, includeDeleted)
 
 	findOptions := options.Find()
 	//limit
@@ -953,32 +1948,343 @@ func (sa Adapter) FindMessagesByParams(orgID string, appID string, senderType st
 	if order != nil && *order == "desc" {
 		sortValue = 1
 	}
-	findOptions.SetSort(bson.D{primitive.E{Key: "date_created", Value: sortValue}})
-
-	var messages []model.Message
-	err := sa.db.messages.Find(filter, &messages, findOptions)
+	orderByValue := model.MessagesOrderByDateCreated
+	if orderBy != nil {
+		orderByValue = *orderBy
+	}
+	findOptions.SetSort(messagesOrderBySort(orderByValue, sortValue))
+
+	var messages []model.Message
+	err := sa.db.messages.FindWithContext(ctx, filter, &messages, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// messagesByParamsFilter gives the filter of the messages by their sender and source app
+func messagesByParamsFilter(orgID string, appID string, senderType string, senderAccountID *string, sourceApp *string, includeDeleted bool) bson.D {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "sender.type", Value: senderType},
+	}
+	//the deleted messages are given only when requested
+	if !includeDeleted {
+		filter = append(filter, primitive.E{Key: "deleted", Value: bson.M{"$ne": true}})
+	}
+	//sender account id
+	if senderAccountID != nil {
+		filter = append(filter, primitive.E{Key: "sender.user.user_id", Value: *senderAccountID})
+	}
+	//source app
+	if sourceApp != nil {
+		filter = append(filter, primitive.E{Key: "source_app", Value: *sourceApp})
+	}
+	return filter
+}
+
+// messagesFieldsProjection gives the projection of the user messages fields. The recipient identity is always kept
+// and the rendered subject and body are loaded with the message ones.
+func messagesFieldsProjection(fields []string) bson.M {
+	projection := bson.M{"org_id": 1, "app_id": 1, "_id": 1, "user_id": 1, "message_id": 1}
+	for _, field := range fields {
+		switch field {
+		case "id":
+			//the message id is kept
+		case "subject":
+			projection["subject"] = 1
+			projection["rendered_subject"] = 1
+		case "body":
+			projection["body"] = 1
+			projection["rendered_body"] = 1
+		default:
+			projection[field] = 1
+		}
+	}
+	return projection
+}
+
+// messagesOrderBySort gives the sort by a messages order by field. The messages which have not been updated
+// do not have date_updated, so they are ordered by date_created.
+func messagesOrderBySort(orderBy string, sortValue int) bson.D {
+	if orderBy == model.MessagesOrderByDateUpdated {
+		return bson.D{primitive.E{Key: "date_updated", Value: sortValue}, primitive.E{Key: "date_created", Value: sortValue}}
+	}
+	return bson.D{primitive.E{Key: "date_created", Value: sortValue}}
+}
+
+// FindMessagesSendEndedAfter finds the messages whose last recipient has been sent after the time
+func (sa Adapter) FindMessagesSendEndedAfter(after time.Time) ([]model.Message, error) {
+	filter := bson.D{primitive.E{Key: "date_send_ended", Value: bson.M{"$gte": after}}}
+
+	var messages []model.Message
+	err := sa.db.messages.Find(filter, &messages, nil)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "messages", &logutils.FieldArgs{"date_send_ended": after}, err)
+	}
+	return messages, nil
+}
+
+// GetMessage gets a message by id
+func (sa Adapter) GetMessage(orgID string, appID string, ID string) (*model.Message, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "_id", Value: ID},
+	}
+
+	var message *model.Message
+	err := sa.db.messages.FindOne(filter, &message, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// GetMessageByIdempotencyKey finds the message which the sender has created with the key after the given time, nil if there is not such
+func (sa Adapter) GetMessageByIdempotencyKey(orgID string, appID string, sender model.Sender, key string, createdAfter time.Time) (*model.Message, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "idempotency_key", Value: key},
+		primitive.E{Key: "sender.type", Value: sender.Type},
+		primitive.E{Key: "date_created", Value: bson.M{"$gt": createdAfter}},
+	}
+	if sender.User != nil {
+		filter = append(filter, primitive.E{Key: "sender.user.user_id", Value: sender.User.UserID})
+	} else {
+		filter = append(filter, primitive.E{Key: "sender.user", Value: nil})
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{primitive.E{Key: "date_created", Value: -1}})
+	findOptions.SetLimit(1)
+
+	var messages []model.Message
+	err := sa.db.messages.Find(filter, &messages, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	return &messages[0], nil
+}
+
+// AddMessageReport adds a report to the message if the reporter has not reported it yet. It returns false if the report is a duplicate.
+func (sa Adapter) AddMessageReport(orgID string, appID string, messageID string, report model.MessageReport) (bool, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "_id", Value: messageID},
+		primitive.E{Key: "reports.reporter_id", Value: bson.M{"$ne": report.ReporterID}},
+	}
+	update := bson.D{
+		primitive.E{Key: "$push", Value: bson.D{primitive.E{Key: "reports", Value: report}}},
+		primitive.E{Key: "$inc", Value: bson.D{primitive.E{Key: "reports_count", Value: 1}}},
+	}
+	res, err := sa.db.messages.UpdateOne(filter, update, nil)
+	if err != nil {
+		return false, errors.WrapErrorAction(logutils.ActionInsert, "message report", &logutils.FieldArgs{"message_id": messageID}, err)
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// FlagMessage flags a message if it has reached the reports threshold and it is not flagged yet. It returns true if the message has been flagged by this call.
+func (sa Adapter) FlagMessage(orgID string, appID string, messageID string, reportsThreshold int) (bool, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "_id", Value: messageID},
+		primitive.E{Key: "reports_count", Value: bson.M{"$gte": reportsThreshold}},
+		primitive.E{Key: "flagged", Value: bson.M{"$ne": true}},
+	}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "flagged", Value: true}}},
+	}
+	res, err := sa.db.messages.UpdateOne(filter, update, nil)
+	if err != nil {
+		return false, errors.WrapErrorAction(logutils.ActionUpdate, "message", &logutils.FieldArgs{"message_id": messageID, "flagged": true}, err)
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// ApproveMessageWithContext approves a message which is pending approval. It returns false if the message does not wait for approval.
+func (sa Adapter) ApproveMessageWithContext(ctx context.Context, orgID string, appID string, messageID string, approvedBy model.CoreAccountRef, dateApproved time.Time) (bool, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "_id", Value: messageID},
+		primitive.E{Key: "approval_status", Value: model.ApprovalStatusPending},
+	}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "approval_status", Value: model.ApprovalStatusApproved},
+			primitive.E{Key: "approved_by", Value: approvedBy},
+			primitive.E{Key: "date_approved", Value: dateApproved},
+			primitive.E{Key: "date_updated", Value: dateApproved},
+		}},
+	}
+	res, err := sa.db.messages.UpdateOneWithContext(ctx, filter, update, nil)
+	if err != nil {
+		return false, errors.WrapErrorAction(logutils.ActionUpdate, "message", &logutils.FieldArgs{"message_id": messageID, "approval_status": model.ApprovalStatusApproved}, err)
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// ReleaseMessageRecipientsWithContext makes the recipients of an approved message see it
+func (sa Adapter) ReleaseMessageRecipientsWithContext(ctx context.Context, messageID string) error {
+	filter := bson.D{primitive.E{Key: "message_id", Value: messageID}}
+	update := bson.D{
+		primitive.E{Key: "$unset", Value: bson.D{primitive.E{Key: "pending_approval", Value: ""}}},
+	}
+	_, err := sa.db.messagesRecipients.UpdateManyWithContext(ctx, filter, update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "message recipients", &logutils.FieldArgs{"message_id": messageID}, err)
+	}
+	return nil
+}
+
+// RecallMessageWithContext marks the message as recalled. It gives false if the message has already been recalled
+func (sa Adapter) RecallMessageWithContext(ctx context.Context, orgID string, appID string, messageID string, dateRecalled time.Time) (bool, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "_id", Value: messageID},
+		primitive.E{Key: "recalled", Value: bson.M{"$ne": true}},
+	}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "recalled", Value: true},
+			primitive.E{Key: "date_recalled", Value: dateRecalled},
+			primitive.E{Key: "date_updated", Value: dateRecalled},
+		}},
+	}
+	res, err := sa.db.messages.UpdateOneWithContext(ctx, filter, update, nil)
+	if err != nil {
+		return false, errors.WrapErrorAction(logutils.ActionUpdate, "message", &logutils.FieldArgs{"message_id": messageID, "recalled": true}, err)
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// RecallMessageRecipientsWithContext hides the recalled message from its recipients
+func (sa Adapter) RecallMessageRecipientsWithContext(ctx context.Context, messageID string) error {
+	filter := bson.D{primitive.E{Key: "message_id", Value: messageID}}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "recalled", Value: true}}},
+	}
+	_, err := sa.db.messagesRecipients.UpdateManyWithContext(ctx, filter, update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "message recipients", &logutils.FieldArgs{"message_id": messageID, "recalled": true}, err)
+	}
+	return nil
+}
+
+// SoftDeleteMessageWithContext marks the message as deleted. It gives false if the message has already been deleted
+func (sa Adapter) SoftDeleteMessageWithContext(ctx context.Context, orgID string, appID string, messageID string, dateDeleted time.Time) (bool, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "_id", Value: messageID},
+		primitive.E{Key: "deleted", Value: bson.M{"$ne": true}},
+	}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "deleted", Value: true},
+			primitive.E{Key: "date_deleted", Value: dateDeleted},
+			primitive.E{Key: "date_updated", Value: dateDeleted},
+		}},
+	}
+	res, err := sa.db.messages.UpdateOneWithContext(ctx, filter, update, nil)
+	if err != nil {
+		return false, errors.WrapErrorAction(logutils.ActionUpdate, "message", &logutils.FieldArgs{"message_id": messageID, "deleted": true}, err)
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// RestoreMessageWithContext removes the deleted mark of the message. It gives false if the message has not been deleted
+func (sa Adapter) RestoreMessageWithContext(ctx context.Context, orgID string, appID string, messageID string, dateRestored time.Time) (bool, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "_id", Value: messageID},
+		primitive.E{Key: "deleted", Value: true},
+	}
+	update := bson.D{
+		primitive.E{Key: "$unset", Value: bson.D{
+			primitive.E{Key: "deleted", Value: ""},
+			primitive.E{Key: "date_deleted", Value: ""},
+		}},
+		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "date_updated", Value: dateRestored}}},
+	}
+	res, err := sa.db.messages.UpdateOneWithContext(ctx, filter, update, nil)
+	if err != nil {
+		return false, errors.WrapErrorAction(logutils.ActionUpdate, "message", &logutils.FieldArgs{"message_id": messageID, "deleted": false}, err)
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// UpdateMessageRecipientsDeletedWithContext hides the deleted message from its recipients or shows the restored message again
+func (sa Adapter) UpdateMessageRecipientsDeletedWithContext(ctx context.Context, messageID string, deleted bool) error {
+	filter := bson.D{primitive.E{Key: "message_id", Value: messageID}}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "deleted", Value: true}}},
+	}
+	if !deleted {
+		update = bson.D{
+			primitive.E{Key: "$unset", Value: bson.D{primitive.E{Key: "deleted", Value: ""}}},
+		}
+	}
+	_, err := sa.db.messagesRecipients.UpdateManyWithContext(ctx, filter, update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "message recipients", &logutils.FieldArgs{"message_id": messageID, "deleted": deleted}, err)
+	}
+	return nil
+}
+
+// FailQueuedMessage marks the message stored by an async create as failed if it is still queued
+func (sa Adapter) FailQueuedMessage(orgID string, appID string, messageID string, reason string) error {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "_id", Value: messageID},
+		primitive.E{Key: "create_status", Value: model.MessageStatusQueued},
+	}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "create_status", Value: model.MessageStatusFailed},
+			primitive.E{Key: "create_error", Value: reason},
+			primitive.E{Key: "date_updated", Value: time.Now().UTC()},
+		}},
+	}
+	_, err := sa.db.messages.UpdateOne(filter, update, nil)
 	if err != nil {
-		return nil, err
+		return errors.WrapErrorAction(logutils.ActionUpdate, "message", &logutils.FieldArgs{"message_id": messageID, "create_status": model.MessageStatusFailed}, err)
 	}
-
-	return messages, nil
+	return nil
 }
 
-// GetMessage gets a message by id
-func (sa Adapter) GetMessage(orgID string, appID string, ID string) (*model.Message, error) {
+// EndBroadcast marks the broadcast message as ended
+func (sa Adapter) EndBroadcast(orgID string, appID string, messageID string, ended time.Time) error {
 	filter := bson.D{
 		primitive.E{Key: "org_id", Value: orgID},
 		primitive.E{Key: "app_id", Value: appID},
-		primitive.E{Key: "_id", Value: ID},
+		primitive.E{Key: "_id", Value: messageID},
 	}
-
-	var message *model.Message
-	err := sa.db.messages.FindOne(filter, &message, nil)
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "date_broadcast_ended", Value: ended},
+			primitive.E{Key: "date_updated", Value: ended},
+		}},
+	}
+	_, err := sa.db.messages.UpdateOne(filter, update, nil)
 	if err != nil {
-		return nil, err
+		return errors.WrapErrorAction(logutils.ActionUpdate, "message", &logutils.FieldArgs{"message_id": messageID, "broadcast": true}, err)
 	}
-
-	return message, nil
+	return nil
 }
 
 // CreateMessageWithContext creates a new message.
@@ -1080,12 +2386,16 @@ func (sa Adapter) DeleteUserMessageWithContext(ctx context.Context, orgID string
 }
 
 // DeleteMessagesWithContext deletes messages by ids
-func (sa Adapter) DeleteMessagesWithContext(ctx context.Context, ids []string) error {
+func (sa Adapter) DeleteMessagesWithContext(ctx context.Context, orgID string, appID string, ids []string) error {
 	if ctx == nil {
 		ctx = context.Background()
 	}
 
-	filter := bson.D{primitive.E{Key: "_id", Value: bson.M{"$in": ids}}}
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "_id", Value: bson.M{"$in": ids}},
+	}
 	_, err := sa.db.messages.DeleteManyWithContext(ctx, filter, nil)
 	if err != nil {
 		fmt.Printf("warning: error while delete messages - %s", err)
@@ -1095,6 +2405,52 @@ func (sa Adapter) DeleteMessagesWithContext(ctx context.Context, ids []string) e
 	return nil
 }
 
+// FindMessagesCreatedBefore finds the messages created before the given time, only their ids, orgs and apps are loaded.
+// If topic is set then only the messages of this topic are matched. The messages which have any of the excluded topics are skipped.
+func (sa Adapter) FindMessagesCreatedBefore(before time.Time, topic *model.Topic, excludedTopics []model.Topic) ([]model.Message, error) {
+	filter := bson.D{primitive.E{Key: "date_created", Value: bson.M{"$lt": before}}}
+	if topic != nil {
+		filter = append(filter,
+			primitive.E{Key: "org_id", Value: topic.OrgID},
+			primitive.E{Key: "app_id", Value: topic.AppID},
+			primitive.E{Key: "$or", Value: []bson.M{{"topic": topic.Name}, {"topics": topic.Name}}})
+	}
+	if len(excludedTopics) > 0 {
+		excluded := []bson.M{}
+		for _, excludedTopic := range excludedTopics {
+			excluded = append(excluded,
+				bson.M{"org_id": excludedTopic.OrgID, "app_id": excludedTopic.AppID, "topic": excludedTopic.Name},
+				bson.M{"org_id": excludedTopic.OrgID, "app_id": excludedTopic.AppID, "topics": excludedTopic.Name})
+		}
+		filter = append(filter, primitive.E{Key: "$nor", Value: excluded})
+	}
+
+	findOptions := options.Find()
+	findOptions.SetProjection(bson.D{primitive.E{Key: "_id", Value: 1}, primitive.E{Key: "org_id", Value: 1}, primitive.E{Key: "app_id", Value: 1}})
+
+	var messages []model.Message
+	err := sa.db.messages.Find(filter, &messages, findOptions)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "messages", &logutils.FieldArgs{"date_created": before}, err)
+	}
+	return messages, nil
+}
+
+// FindMessagesExpiredBefore finds the messages which have expired before the given time, only their ids, orgs and apps are loaded
+func (sa Adapter) FindMessagesExpiredBefore(before time.Time) ([]model.Message, error) {
+	filter := bson.D{primitive.E{Key: "expires_at", Value: bson.M{"$lt": before}}}
+
+	findOptions := options.Find()
+	findOptions.SetProjection(bson.D{primitive.E{Key: "_id", Value: 1}, primitive.E{Key: "org_id", Value: 1}, primitive.E{Key: "app_id", Value: 1}})
+
+	var messages []model.Message
+	err := sa.db.messages.Find(filter, &messages, findOptions)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "messages", &logutils.FieldArgs{"expires_at": before}, err)
+	}
+	return messages, nil
+}
+
 // UpdateUnreadMessage updates a unread message in the recipients to read
 func (sa Adapter) UpdateUnreadMessage(ctx context.Context, orgID string, appID string, ID string, userID string) (*model.Message, error) {
 	read := true
@@ -1107,33 +2463,186 @@ func (sa Adapter) UpdateUnreadMessage(ctx context.Context, orgID string, appID s
 			primitive.E{Key: "read", Value: read},
 		}},
 	}
-	_, err := sa.db.messagesRecipients.UpdateOneWithContext(ctx, filter, update, nil)
+	res, err := sa.db.messagesRecipients.UpdateOneWithContext(ctx, filter, update, nil)
 	if err != nil {
 		fmt.Println("warning: error while updating massage", ID, userID, err)
 		return nil, err
 	}
+	if res.ModifiedCount > 0 {
+		err = sa.IncrementMessageDeliverySummaryWithContext(ctx, orgID, appID, ID, model.DeliverySummary{Read: 1})
+		if err != nil {
+			return nil, err
+		}
+	}
 	return nil, nil
 }
 
-// UpdateAllUserMessagesRead Update all user messages as read or as unread
-func (sa Adapter) UpdateAllUserMessagesRead(ctx context.Context, orgID string, appID string, userID string, read bool) error {
+// UpdateAllUserMessagesRead Update all user messages as read or as unread, only the given messages if messageIDs is not empty.
+// It gives the count of the updated messages.
+func (sa Adapter) UpdateAllUserMessagesRead(ctx context.Context, orgID string, appID string, userID string, messageIDs []string, read bool) (int64, error) {
+	//the recipients which will be changed are found first as the messages summaries need to be updated too
 	filter := bson.D{
 		primitive.E{Key: "app_id", Value: appID},
 		primitive.E{Key: "org_id", Value: orgID},
-		primitive.E{Key: "user_id", Value: userID}}
+		primitive.E{Key: "user_id", Value: userID},
+		primitive.E{Key: "read", Value: bson.M{"$ne": read}}}
+	if len(messageIDs) > 0 {
+		filter = append(filter, primitive.E{Key: "message_id", Value: bson.M{"$in": messageIDs}})
+	}
 	update := bson.D{
 		primitive.E{Key: "$set", Value: bson.D{
 			primitive.E{Key: "read", Value: read},
 		}},
 	}
-	_, err := sa.db.messagesRecipients.UpdateManyWithContext(ctx, filter, update, nil)
+	readDelta := 1
+	if !read {
+		readDelta = -1
+	}
+
+	//the recipients are updated together in a transaction so that a concurrent change is not counted twice
+	var updated int64
+	transaction := func(context TransactionContext) error {
+		updated = 0 //the transaction may be performed again
+		var recipients []model.MessageRecipient
+		err := sa.db.messagesRecipients.FindWithContext(context, filter, &recipients, nil)
+		if err != nil {
+			return err
+		}
+		if len(recipients) == 0 {
+			return nil
+		}
+
+		recipientsIDs := make([]string, len(recipients))
+		messagesCounts := map[string]int{}
+		messagesIDs := []string{}
+		for i, recipient := range recipients {
+			recipientsIDs[i] = recipient.ID
+			if _, ok := messagesCounts[recipient.MessageID]; !ok {
+				messagesIDs = append(messagesIDs, recipient.MessageID)
+			}
+			messagesCounts[recipient.MessageID]++
+		}
+		recipientsFilter := bson.D{
+			primitive.E{Key: "_id", Value: bson.M{"$in": recipientsIDs}},
+			primitive.E{Key: "read", Value: bson.M{"$ne": read}}}
+		res, err := sa.db.messagesRecipients.UpdateManyWithContext(context, recipientsFilter, update, nil)
+		if err != nil {
+			return err
+		}
+		updated = res.ModifiedCount
+
+		//one summary update for every message
+		for _, messageID := range messagesIDs {
+			err = sa.IncrementMessageDeliverySummaryWithContext(context, orgID, appID, messageID, model.DeliverySummary{Read: readDelta * messagesCounts[messageID]})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := sa.PerformTransactionWithContext(ctx, transaction, 2000)
 	if err != nil {
 		fmt.Println("warning: error while read/unread all user messages", userID, err)
-		return err
+		return 0, err
+	}
+	return updated, nil
+}
+
+// UpdateMessageRecipientDeliveryStatus sets the delivery status of a message recipient
+func (sa Adapter) UpdateMessageRecipientDeliveryStatus(orgID string, appID string, recipientID string, status string, errorCode *string) error {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "_id", Value: recipientID},
+	}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "delivery_status", Value: status},
+			primitive.E{Key: "delivery_error_code", Value: errorCode},
+		}},
+	}
+	_, err := sa.db.messagesRecipients.UpdateOne(filter, update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "message recipient", &logutils.FieldArgs{"id": recipientID}, err)
+	}
+	return nil
+}
+
+// UpdateMessageRecipientsDeliveryStatus sets the same delivery status to many message recipients at once
+func (sa Adapter) UpdateMessageRecipientsDeliveryStatus(recipientsIDs []string, status string) error {
+	filter := bson.D{primitive.E{Key: "_id", Value: bson.M{"$in": recipientsIDs}}}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "delivery_status", Value: status},
+		}},
+	}
+	_, err := sa.db.messagesRecipients.UpdateMany(filter, update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "message recipients", &logutils.FieldArgs{"status": status}, err)
+	}
+	return nil
+}
+
+// IncrementMessageDeliverySummaryWithContext atomically adds the delta counts to the message delivery summary
+func (sa Adapter) IncrementMessageDeliverySummaryWithContext(ctx context.Context, orgID string, appID string, messageID string, delta model.DeliverySummary) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	update := deliverySummaryUpdate(delta, time.Now().UTC())
+	if update == nil {
+		return nil
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "_id", Value: messageID},
+	}
+	_, err := sa.db.messages.UpdateOneWithContext(ctx, filter, update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "delivery summary", &logutils.FieldArgs{"message_id": messageID}, err)
 	}
 	return nil
 }
 
+// deliverySummaryUpdate gives the update which adds the delta to the delivery summary, nil if the delta is empty.
+// It only increments the counts, so the concurrent updates of a message do not overwrite each other.
+func deliverySummaryUpdate(delta model.DeliverySummary, now time.Time) bson.D {
+	inc := bson.D{}
+	if delta.Sent != 0 {
+		inc = append(inc, primitive.E{Key: "delivery_summary.sent", Value: delta.Sent})
+	}
+	if delta.Delivered != 0 {
+		inc = append(inc, primitive.E{Key: "delivery_summary.delivered", Value: delta.Delivered})
+	}
+	if delta.Read != 0 {
+		inc = append(inc, primitive.E{Key: "delivery_summary.read", Value: delta.Read})
+	}
+	if delta.Failed != 0 {
+		inc = append(inc, primitive.E{Key: "delivery_summary.failed", Value: delta.Failed})
+	}
+	if delta.Expired != 0 {
+		inc = append(inc, primitive.E{Key: "delivery_summary.expired", Value: delta.Expired})
+	}
+	for code, count := range delta.Errors {
+		inc = append(inc, primitive.E{Key: "delivery_summary.errors." + code, Value: count})
+	}
+	if len(inc) == 0 {
+		return nil
+	}
+
+	update := bson.D{primitive.E{Key: "$inc", Value: inc}}
+	if delta.Sent > 0 {
+		//the send duration is from the first to the last sent recipient
+		update = append(update,
+			primitive.E{Key: "$min", Value: bson.D{primitive.E{Key: "date_send_started", Value: now}}},
+			primitive.E{Key: "$max", Value: bson.D{primitive.E{Key: "date_send_ended", Value: now}}})
+	}
+	return update
+}
+
 // GetAllAppVersions gets all registered versions
 func (sa Adapter) GetAllAppVersions(orgID string, appID string) ([]model.AppVersion, error) {
 	filter := bson.D{
@@ -1241,7 +2750,8 @@ func (sa *Adapter) FindQueueData(time *time.Time, limit int) ([]model.QueueItem,
 	findOptions.SetLimit(int64(limit))
 
 	//set sort
-	findOptions.SetSort(bson.D{primitive.E{Key: "time", Value: 1}, primitive.E{Key: "priority", Value: 1}})
+	//the recently active users go first within the same time and priority
+	findOptions.SetSort(bson.D{primitive.E{Key: "time", Value: 1}, primitive.E{Key: "priority", Value: 1}, primitive.E{Key: "user_last_active", Value: -1}})
 
 	var result []model.QueueItem
 	err := sa.db.queueData.Find(filter, &result, findOptions)
@@ -1261,6 +2771,21 @@ func (sa *Adapter) DeleteQueueData(ids []string) error {
 	return nil
 }
 
+// UpdateQueueDataTime sets the time when the queue data items are processed
+func (sa *Adapter) UpdateQueueDataTime(ids []string, time time.Time) error {
+	filter := bson.D{primitive.E{Key: "_id", Value: bson.M{"$in": ids}}}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "time", Value: time},
+		}},
+	}
+	_, err := sa.db.queueData.UpdateMany(filter, update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "queue data", &logutils.FieldArgs{"ids": ids}, err)
+	}
+	return nil
+}
+
 // DeleteQueueDataForMessagesWithContext removes queue data items for messages
 func (sa *Adapter) DeleteQueueDataForMessagesWithContext(ctx context.Context, messagesIDs []string) error {
 	filter := bson.D{primitive.E{Key: "message_id", Value: bson.M{"$in": messagesIDs}}}
@@ -1272,6 +2797,17 @@ func (sa *Adapter) DeleteQueueDataForMessagesWithContext(ctx context.Context, me
 	return nil
 }
 
+// CountQueueDataForMessage counts the queue data items of a message
+func (sa *Adapter) CountQueueDataForMessage(messageID string) (int64, error) {
+	filter := bson.D{primitive.E{Key: "message_id", Value: messageID}}
+
+	count, err := sa.db.queueData.CountDocuments(filter)
+	if err != nil {
+		return 0, errors.WrapErrorAction(logutils.ActionFind, "queue data count", &logutils.FieldArgs{"message_id": messageID}, err)
+	}
+	return count, nil
+}
+
 // DeleteQueueDataForRecipientsWithContext removes queue data items for recepients
 func (sa *Adapter) DeleteQueueDataForRecipientsWithContext(ctx context.Context, recipientsIDs []string) error {
 	filter := bson.D{primitive.E{Key: "message_recipient_id", Value: bson.M{"$in": recipientsIDs}}}
@@ -1283,8 +2819,195 @@ func (sa *Adapter) DeleteQueueDataForRecipientsWithContext(ctx context.Context,
 	return nil
 }
 
+// InsertPushRetry inserts a push retry
+func (sa *Adapter) InsertPushRetry(retry model.PushRetry) error {
+	_, err := sa.db.pushRetries.InsertOne(retry)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionInsert, "push retry", &logutils.FieldArgs{"message_recipient_id": retry.MessageRecipientID}, err)
+	}
+	return nil
+}
+
+// FindPushRetriesDue finds the push retries whose next attempt is not after the given time, the earliest first
+func (sa *Adapter) FindPushRetriesDue(time time.Time, limit int) ([]model.PushRetry, error) {
+	filter := bson.D{primitive.E{Key: "next_attempt", Value: bson.M{"$lte": time}}}
+
+	findOptions := options.Find()
+	findOptions.SetLimit(int64(limit))
+	findOptions.SetSort(bson.D{primitive.E{Key: "next_attempt", Value: 1}})
+
+	var result []model.PushRetry
+	err := sa.db.pushRetries.Find(filter, &result, findOptions)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "push retry", nil, err)
+	}
+	return result, nil
+}
+
+// ClaimPushRetry moves the next attempt of a push retry to the lease end, so that no other service instance processes it meanwhile.
+// It gives false if the retry has been claimed by another instance or does not exist anymore.
+func (sa *Adapter) ClaimPushRetry(id string, nextAttempt time.Time, leaseEnd time.Time) (bool, error) {
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: id},
+		primitive.E{Key: "next_attempt", Value: nextAttempt},
+	}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "next_attempt", Value: leaseEnd},
+		}},
+	}
+	res, err := sa.db.pushRetries.UpdateOne(filter, update, nil)
+	if err != nil {
+		return false, errors.WrapErrorAction(logutils.ActionUpdate, "push retry", &logutils.FieldArgs{"_id": id}, err)
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// UpdatePushRetry sets the tokens, the attempts and the result recording of a push retry
+func (sa *Adapter) UpdatePushRetry(retry model.PushRetry) error {
+	filter := bson.D{primitive.E{Key: "_id", Value: retry.ID}}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "tokens", Value: retry.Tokens},
+			primitive.E{Key: "attempts", Value: retry.Attempts},
+			primitive.E{Key: "next_attempt", Value: retry.NextAttempt},
+			primitive.E{Key: "last_error_code", Value: retry.LastErrorCode},
+			primitive.E{Key: "record_result", Value: retry.RecordResult},
+		}},
+	}
+	_, err := sa.db.pushRetries.UpdateOne(filter, update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "push retry", &logutils.FieldArgs{"_id": retry.ID}, err)
+	}
+	return nil
+}
+
+// DeletePushRetry removes a push retry
+func (sa *Adapter) DeletePushRetry(id string) error {
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+	_, err := sa.db.pushRetries.DeleteOne(filter, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionDelete, "push retry", &logutils.FieldArgs{"_id": id}, err)
+	}
+	return nil
+}
+
+// CountPushRetriesForMessage counts the push retries of a message, the callback retries are not counted
+func (sa *Adapter) CountPushRetriesForMessage(messageID string) (int64, error) {
+	filter := bson.D{
+		primitive.E{Key: "message_id", Value: messageID},
+		primitive.E{Key: "kind", Value: bson.M{"$ne": model.PushRetryKindCallback}},
+	}
+
+	count, err := sa.db.pushRetries.CountDocuments(filter)
+	if err != nil {
+		return 0, errors.WrapErrorAction(logutils.ActionFind, "push retry count", &logutils.FieldArgs{"message_id": messageID}, err)
+	}
+	return count, nil
+}
+
+// LogDelivery appends a delivery attempt to the audit log, the entries are never updated
+func (sa *Adapter) LogDelivery(entry model.DeliveryLog) error {
+	_, err := sa.db.audit.InsertOne(entry)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionInsert, "delivery log", &logutils.FieldArgs{"message_recipient_id": entry.MessageRecipientID}, err)
+	}
+	return nil
+}
+
+// FindDeliveryLogs finds the delivery attempts of a message in the order they were made and counts all of them
+func (sa *Adapter) FindDeliveryLogs(orgID string, appID string, messageID string, offset *int64, limit *int64) ([]model.DeliveryLog, int64, error) {
+	filter := bson.D{
+		primitive.E{Key: "org_id", Value: orgID},
+		primitive.E{Key: "app_id", Value: appID},
+		primitive.E{Key: "message_id", Value: messageID},
+	}
+
+	total, err := sa.db.audit.CountDocuments(filter)
+	if err != nil {
+		return nil, 0, errors.WrapErrorAction(logutils.ActionCount, "delivery log", &logutils.FieldArgs{"message_id": messageID}, err)
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{primitive.E{Key: "date_created", Value: 1}, primitive.E{Key: "_id", Value: 1}})
+	if offset != nil {
+		findOptions.SetSkip(*offset)
+	}
+	if limit != nil {
+		findOptions.SetLimit(*limit)
+	}
+
+	var result []model.DeliveryLog
+	err = sa.db.audit.Find(filter, &result, findOptions)
+	if err != nil {
+		return nil, 0, errors.WrapErrorAction(logutils.ActionFind, "delivery log", &logutils.FieldArgs{"message_id": messageID}, err)
+	}
+	return result, total, nil
+}
+
+// FindMessagesPendingCallback finds the messages with a callback url whose delivery receipt has not been sent and whose time has come
+func (sa Adapter) FindMessagesPendingCallback(time time.Time, offset int, limit int) ([]model.Message, error) {
+	filter := bson.D{
+		primitive.E{Key: "callback_url", Value: bson.M{"$exists": true}},
+		primitive.E{Key: "date_callback_sent", Value: nil},
+		primitive.E{Key: "time", Value: bson.M{"$lte": time}},
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSkip(int64(offset))
+	findOptions.SetLimit(int64(limit))
+	findOptions.SetSort(bson.D{primitive.E{Key: "time", Value: 1}})
+
+	var result []model.Message
+	err := sa.db.messages.Find(filter, &result, findOptions)
+	if err != nil {
+		return nil, errors.WrapErrorAction(logutils.ActionFind, "message", nil, err)
+	}
+	return result, nil
+}
+
+// SetMessageCallbackDue sets the time after which the delivery receipt of a message is sent
+func (sa Adapter) SetMessageCallbackDue(messageID string, due time.Time) error {
+	filter := bson.D{primitive.E{Key: "_id", Value: messageID}}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "date_callback_due", Value: due},
+		}},
+	}
+	_, err := sa.db.messages.UpdateOne(filter, update, nil)
+	if err != nil {
+		return errors.WrapErrorAction(logutils.ActionUpdate, "message", &logutils.FieldArgs{"_id": messageID}, err)
+	}
+	return nil
+}
+
+// ClaimMessageCallback marks the delivery receipt of a message as sent, so that no other service instance sends it.
+// It gives false if another instance has claimed it already.
+func (sa Adapter) ClaimMessageCallback(messageID string, now time.Time) (bool, error) {
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: messageID},
+		primitive.E{Key: "date_callback_sent", Value: nil},
+	}
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "date_callback_sent", Value: now},
+		}},
+	}
+	res, err := sa.db.messages.UpdateOne(filter, update, nil)
+	if err != nil {
+		return false, errors.WrapErrorAction(logutils.ActionUpdate, "message", &logutils.FieldArgs{"_id": messageID}, err)
+	}
+	return res.ModifiedCount == 1, nil
+}
+
 // StoreDeviceToken stores device token
 func (sa Adapter) StoreDeviceToken(orgID string, appID string, tokenInfo *model.TokenInfo, userID string) error {
+	return sa.withRetry("storing device token", func() error {
+		return sa.storeDeviceToken(orgID, appID, tokenInfo, userID)
+	})
+}
+
+func (sa Adapter) storeDeviceToken(orgID string, appID string, tokenInfo *model.TokenInfo, userID string) error {
 	err := sa.db.dbClient.UseSession(context.Background(), func(sessionContext mongo.SessionContext) error {
 		err := sessionContext.StartTransaction()
 		if err != nil {
@@ -1296,9 +3019,9 @@ func (sa Adapter) StoreDeviceToken(orgID string, appID string, tokenInfo *model.
 		if userRecord == nil {
 			existingUser, _ := sa.findUserByIDWithContext(sessionContext, orgID, appID, userID)
 			if existingUser != nil {
-				err = sa.addTokenToUserWithContext(sessionContext, orgID, appID, userID, tokenInfo.Token, tokenInfo.AppPlatform, tokenInfo.AppVersion, tokenInfo.TokenType)
+				err = sa.addTokenToUserWithContext(sessionContext, orgID, appID, userID, tokenInfo)
 			} else {
-				_, err = sa.createUserWithContext(sessionContext, orgID, appID, userID, tokenInfo.Token, tokenInfo.AppPlatform, tokenInfo.AppVersion, tokenInfo.TokenType)
+				_, err = sa.createUserWithContext(sessionContext, orgID, appID, userID, tokenInfo)
 			}
 		} else if userRecord.UserID != userID {
 			err = sa.removeTokenFromUserWithContext(sessionContext, orgID, appID, tokenInfo.Token, userRecord.UserID, tokenInfo.TokenType)
@@ -1309,14 +3032,22 @@ func (sa Adapter) StoreDeviceToken(orgID string, appID string, tokenInfo *model.
 
 			existingUser, _ := sa.findUserByIDWithContext(sessionContext, orgID, appID, userID)
 			if existingUser != nil {
-				err = sa.addTokenToUserWithContext(sessionContext, orgID, appID, userID, tokenInfo.Token, tokenInfo.AppPlatform, tokenInfo.AppVersion, tokenInfo.TokenType)
+				err = sa.addTokenToUserWithContext(sessionContext, orgID, appID, userID, tokenInfo)
 			} else {
-				_, err = sa.createUserWithContext(sessionContext, orgID, appID, userID, tokenInfo.Token, tokenInfo.AppPlatform, tokenInfo.AppVersion, tokenInfo.TokenType)
+				_, err = sa.createUserWithContext(sessionContext, orgID, appID, userID, tokenInfo)
 			}
 			if err != nil {
 				fmt.Printf("error while linking token (%s) from user (%s)- %s\n", tokenInfo.Token, userID, err)
 				return err
 			}
+		} else {
+			//the same device registers again, so keep its details up to date
+			err = sa.updateTokenInfoWithContext(sessionContext, orgID, appID, userID, tokenInfo)
+		}
+
+		//the device locale is the user locale for the localized messages
+		if err == nil && tokenInfo.Locale != nil {
+			err = sa.updateUserLocaleWithContext(sessionContext, orgID, appID, userID, *tokenInfo.Locale)
 		}
 
 		if err != nil {
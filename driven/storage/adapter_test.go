@@ -0,0 +1,317 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"notifications/core/model"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMessagesRecipientsDeepFiltersNoPaging(t *testing.T) {
+	userID := "u1"
+	read := true
+	pipeline := messagesRecipientsDeepFilters("org", "app", &userID, &read, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	//the total count is given by the same stages, so they must not page the messages
+	readMatched := false
+	for _, stage := range pipeline {
+		for _, paging := range []string{"$skip", "$limit", "$sort"} {
+			if _, ok := stage[paging]; ok {
+				t.Errorf("messagesRecipientsDeepFilters() has a %s stage", paging)
+			}
+		}
+		if match, ok := stage["$match"].(bson.M); ok && match["read"] == true {
+			readMatched = true
+		}
+	}
+	if !readMatched {
+		t.Errorf("messagesRecipientsDeepFilters() does not match the read filter")
+	}
+}
+
+func TestPerformTransactionWithContextDone(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		wantErr string
+	}{
+		{"cancelled", cancelled, context.Canceled.Error()},
+		{"deadline exceeded", expired, context.DeadlineExceeded.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := Adapter{} //no database, the transaction must not be started
+			performed := false
+			err := sa.PerformTransactionWithContext(tt.ctx, func(context TransactionContext) error {
+				performed = true
+				return nil
+			}, 1000)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("PerformTransactionWithContext() error = %v, want %s", err, tt.wantErr)
+			}
+			if performed {
+				t.Errorf("PerformTransactionWithContext() performed the transaction")
+			}
+		})
+	}
+}
+
+func TestDeliverySummaryUpdate(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	sendDates := bson.D{
+		{Key: "$min", Value: bson.D{{Key: "date_send_started", Value: now}}},
+		{Key: "$max", Value: bson.D{{Key: "date_send_ended", Value: now}}},
+	}
+
+	tests := []struct {
+		name  string
+		delta model.DeliverySummary
+		want  bson.D
+	}{
+		{"delivered", model.DeliverySummary{Sent: 1, Delivered: 1},
+			append(bson.D{{Key: "$inc", Value: bson.D{{Key: "delivery_summary.sent", Value: 1}, {Key: "delivery_summary.delivered", Value: 1}}}}, sendDates...)},
+		{"failed", model.DeliverySummary{Sent: 1, Failed: 1, Errors: map[string]int{"unregistered": 1}},
+			append(bson.D{{Key: "$inc", Value: bson.D{{Key: "delivery_summary.sent", Value: 1}, {Key: "delivery_summary.failed", Value: 1},
+				{Key: "delivery_summary.errors.unregistered", Value: 1}}}}, sendDates...)},
+		{"unread", model.DeliverySummary{Read: -2}, bson.D{{Key: "$inc", Value: bson.D{{Key: "delivery_summary.read", Value: -2}}}}},
+		{"expired", model.DeliverySummary{Expired: 3}, bson.D{{Key: "$inc", Value: bson.D{{Key: "delivery_summary.expired", Value: 3}}}}},
+		{"empty", model.DeliverySummary{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deliverySummaryUpdate(tt.delta, now); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("deliverySummaryUpdate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessagesRecipientsDeepFiltersHasAttachment(t *testing.T) {
+	yes := true
+	no := false
+
+	tests := []struct {
+		name          string
+		hasAttachment *bool
+		want          interface{} //the attachments match, nil if there is none
+	}{
+		{"with attachment", &yes, bson.M{"$exists": true}},
+		{"without attachment", &no, bson.M{"$exists": false}},
+		{"no filter", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := messagesRecipientsDeepFilters("org", "app", nil, nil, nil, nil, nil, nil, nil, tt.hasAttachment, nil, nil)
+			var got interface{}
+			for _, stage := range pipeline {
+				if match, ok := stage["$match"].(bson.M); ok && match["attachments.0"] != nil {
+					got = match["attachments.0"]
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messagesRecipientsDeepFilters() attachments match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessagesRecipientsDeepFiltersPriority(t *testing.T) {
+	urgent := int64(10)
+
+	tests := []struct {
+		name     string
+		priority *int64
+		want     interface{} //the priority match, nil if there is none
+	}{
+		{"priority", &urgent, int64(10)},
+		{"no filter", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := messagesRecipientsDeepFilters("org", "app", nil, nil, nil, nil, nil, nil, nil, nil, tt.priority, nil)
+			var got interface{}
+			for _, stage := range pipeline {
+				if match, ok := stage["$match"].(bson.M); ok && match["priority"] != nil {
+					got = match["priority"]
+				}
+			}
+			if got != tt.want {
+				t.Errorf("messagesRecipientsDeepFilters() priority match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessagesByParamsFilterSourceApp(t *testing.T) {
+	sourceApp := "events"
+
+	tests := []struct {
+		name      string
+		sourceApp *string
+		want      interface{} //the source app match, nil if there is none
+	}{
+		{"source app", &sourceApp, "events"},
+		{"no filter", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got interface{}
+			for _, e := range messagesByParamsFilter("org", "app", "administrative", nil, tt.sourceApp, false) {
+				if e.Key == "source_app" {
+					got = e.Value
+				}
+			}
+			if got != tt.want {
+				t.Errorf("messagesByParamsFilter() source app match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessagesByParamsFilterDeleted(t *testing.T) {
+	tests := []struct {
+		name           string
+		includeDeleted bool
+		wantMatch      bool
+	}{
+		{"deleted skipped", false, true},
+		{"deleted included", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotMatch := false
+			for _, e := range messagesByParamsFilter("org", "app", "administrative", nil, nil, tt.includeDeleted) {
+				if e.Key == "deleted" {
+					gotMatch = true
+				}
+			}
+			if gotMatch != tt.wantMatch {
+				t.Errorf("messagesByParamsFilter() deleted match %t, want %t", gotMatch, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestUsersByTopicsCountPipeline(t *testing.T) {
+	topics := []string{"athletics", "news"}
+
+	tests := []struct {
+		name string
+		all  bool
+		want bson.M //the topics match
+	}{
+		{"union", false, bson.M{"$in": topics}},
+		{"intersection", true, bson.M{"$all": topics}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := usersByTopicsCountPipeline("org", "app", topics, tt.all)
+			match, _ := pipeline[0]["$match"].(bson.M)
+			if !reflect.DeepEqual(match["topics"], tt.want) || match["org_id"] != "org" || match["app_id"] != "app" {
+				t.Errorf("usersByTopicsCountPipeline() match = %v, want the app users with topics %v", match, tt.want)
+			}
+			//every user is counted once
+			if len(pipeline) != 3 || !reflect.DeepEqual(pipeline[1]["$group"], bson.M{"_id": "$user_id"}) || pipeline[2]["$count"] != "count" {
+				t.Errorf("usersByTopicsCountPipeline() = %v, want the users grouped and counted", pipeline)
+			}
+		})
+	}
+}
+
+func TestMessagesOrderBySort(t *testing.T) {
+	tests := []struct {
+		name      string
+		orderBy   string
+		sortValue int
+		want      bson.D
+	}{
+		{"date created", model.MessagesOrderByDateCreated, -1, bson.D{{Key: "date_created", Value: -1}}},
+		{"date updated", model.MessagesOrderByDateUpdated, -1, bson.D{{Key: "date_updated", Value: -1}, {Key: "date_created", Value: -1}}},
+		{"date updated ascending", model.MessagesOrderByDateUpdated, 1, bson.D{{Key: "date_updated", Value: 1}, {Key: "date_created", Value: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messagesOrderBySort(tt.orderBy, tt.sortValue); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messagesOrderBySort() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessagesFieldsProjection(t *testing.T) {
+	identity := bson.M{"org_id": 1, "app_id": 1, "_id": 1, "user_id": 1, "message_id": 1}
+	with := func(fields ...string) bson.M {
+		projection := bson.M{}
+		for key, value := range identity {
+			projection[key] = value
+		}
+		for _, field := range fields {
+			projection[field] = 1
+		}
+		return projection
+	}
+
+	tests := []struct {
+		name   string
+		fields []string
+		want   bson.M
+	}{
+		{"id only", []string{"id"}, identity},
+		{"rendered subject and body", []string{"id", "subject", "body"}, with("subject", "rendered_subject", "body", "rendered_body")},
+		{"message fields", []string{"date_created", "read"}, with("date_created", "read")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messagesFieldsProjection(tt.fields); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messagesFieldsProjection() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceTokenStale(t *testing.T) {
+	before := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	older := before.Add(-time.Hour)
+	newer := before.Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		deviceToken model.DeviceToken
+		want        bool
+	}{
+		{"created before, never updated", model.DeviceToken{DateCreated: older}, true},
+		{"created after, never updated", model.DeviceToken{DateCreated: newer}, false},
+		{"updated after", model.DeviceToken{DateCreated: older, DateUpdated: &newer}, false},
+		{"updated before", model.DeviceToken{DateCreated: older, DateUpdated: &older}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deviceTokenStale(tt.deviceToken, before); got != tt.want {
+				t.Errorf("deviceTokenStale() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -17,6 +17,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
@@ -35,7 +36,7 @@ func (collWrapper *collectionWrapper) Find(filter interface{}, result interface{
 }
 
 func (collWrapper *collectionWrapper) FindWithContext(ctx context.Context, filter interface{}, result interface{}, findOptions *options.FindOptions) error {
-	return collWrapper.FindWithContextTimeout(ctx, filter, result, findOptions, collWrapper.database.mongoTimeout)
+	return collWrapper.FindWithContextTimeout(ctx, filter, result, findOptions, collWrapper.database.operationTimeout())
 }
 
 func (collWrapper *collectionWrapper) FindWithContextTimeout(ctx context.Context, filter interface{},
@@ -62,7 +63,7 @@ func (collWrapper *collectionWrapper) Distinct(fieldName string, filter interfac
 }
 
 func (collWrapper *collectionWrapper) DistinctWithContext(ctx context.Context, fieldName string, filter interface{}, distinctOptions *options.DistinctOptions) ([]interface{}, error) {
-	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.mongoTimeout)
+	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.operationTimeout())
 	defer cancel()
 
 	if filter == nil {
@@ -78,7 +79,7 @@ func (collWrapper *collectionWrapper) FindOne(filter interface{}, result interfa
 }
 
 func (collWrapper *collectionWrapper) FindOneWithContext(ctx context.Context, filter interface{}, result interface{}, findOptions *options.FindOneOptions) error {
-	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.mongoTimeout)
+	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.operationTimeout())
 	defer cancel()
 
 	if findOptions == nil {
@@ -101,7 +102,7 @@ func (collWrapper *collectionWrapper) ReplaceOne(filter interface{}, replacement
 }
 
 func (collWrapper *collectionWrapper) ReplaceOneWithContext(ctx context.Context, filter interface{}, replacement interface{}, replaceOptions *options.ReplaceOptions) error {
-	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.mongoTimeout)
+	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.operationTimeout())
 	defer cancel()
 
 	if replacement == nil {
@@ -130,7 +131,7 @@ func (collWrapper *collectionWrapper) InsertOne(data interface{}) (interface{},
 }
 
 func (collWrapper *collectionWrapper) InsertOneWithContext(ctx context.Context, data interface{}) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.mongoTimeout)
+	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.operationTimeout())
 
 	ins, err := collWrapper.coll.InsertOne(ctx, data)
 	cancel()
@@ -149,7 +150,7 @@ func (collWrapper *collectionWrapper) InsertMany(documents []interface{}, opts *
 }
 
 func (collWrapper *collectionWrapper) InsertManyWithContext(ctx context.Context, documents []interface{}, opts *options.InsertManyOptions) (*mongo.InsertManyResult, error) {
-	return collWrapper.InsertManyWithContextTimeout(ctx, documents, opts, collWrapper.database.mongoTimeout)
+	return collWrapper.InsertManyWithContextTimeout(ctx, documents, opts, collWrapper.database.operationTimeout())
 }
 
 func (collWrapper *collectionWrapper) InsertManyWithContextTimeout(ctx context.Context, documents []interface{},
@@ -170,7 +171,7 @@ func (collWrapper *collectionWrapper) DeleteMany(filter interface{}, opts *optio
 }
 
 func (collWrapper *collectionWrapper) DeleteManyWithContext(ctx context.Context, filter interface{}, opts *options.DeleteOptions) (*mongo.DeleteResult, error) {
-	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.mongoTimeout)
+	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.operationTimeout())
 	defer cancel()
 
 	result, err := collWrapper.coll.DeleteMany(ctx, filter, opts)
@@ -186,7 +187,7 @@ func (collWrapper *collectionWrapper) DeleteOne(filter interface{}, opts *option
 }
 
 func (collWrapper *collectionWrapper) DeleteOneWithContext(ctx context.Context, filter interface{}, opts *options.DeleteOptions) (*mongo.DeleteResult, error) {
-	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.mongoTimeout)
+	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.operationTimeout())
 	defer cancel()
 
 	result, err := collWrapper.coll.DeleteOne(ctx, filter, opts)
@@ -202,7 +203,7 @@ func (collWrapper *collectionWrapper) UpdateOne(filter interface{}, update inter
 }
 
 func (collWrapper *collectionWrapper) UpdateOneWithContext(ctx context.Context, filter interface{}, update interface{}, opts *options.UpdateOptions) (*mongo.UpdateResult, error) {
-	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.mongoTimeout)
+	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.operationTimeout())
 	defer cancel()
 
 	updateResult, err := collWrapper.coll.UpdateOne(ctx, filter, update, opts)
@@ -218,7 +219,7 @@ func (collWrapper *collectionWrapper) UpdateMany(filter interface{}, update inte
 }
 
 func (collWrapper *collectionWrapper) UpdateManyWithContext(ctx context.Context, filter interface{}, update interface{}, opts *options.UpdateOptions) (*mongo.UpdateResult, error) {
-	return collWrapper.UpdateManyWithContextTimeout(ctx, filter, update, opts, collWrapper.database.mongoTimeout)
+	return collWrapper.UpdateManyWithContextTimeout(ctx, filter, update, opts, collWrapper.database.operationTimeout())
 }
 
 func (collWrapper *collectionWrapper) UpdateManyWithContextTimeout(ctx context.Context, filter interface{}, update interface{},
@@ -242,7 +243,7 @@ func (collWrapper *collectionWrapper) CountDocuments(filter interface{}) (int64,
 }
 
 func (collWrapper *collectionWrapper) CountDocumentsWithContext(ctx context.Context, filter interface{}) (int64, error) {
-	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.mongoTimeout)
+	ctx, cancel := context.WithTimeout(ctx, collWrapper.database.operationTimeout())
 	defer cancel()
 
 	if filter == nil {
@@ -325,6 +326,50 @@ func (collWrapper *collectionWrapper) AddIndex(keys interface{}, unique bool) er
 	return err
 }
 
+// EnsureIndex creates the index if the collection does not have it yet, so it can be called on every start.
+// An existing index with the same keys but a different uniqueness is rebuilt.
+func (collWrapper *collectionWrapper) EnsureIndex(keys bson.D, unique bool) error {
+	name := indexName(keys)
+
+	indexes, err := collWrapper.ListIndexes()
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if index["name"] != name {
+			continue
+		}
+		existingUnique, _ := index["unique"].(bool)
+		if existingUnique == unique {
+			return nil //already there
+		}
+		err = collWrapper.DropIndex(name)
+		if err != nil {
+			return err
+		}
+		break
+	}
+
+	err = collWrapper.AddIndex(keys, unique)
+	if err != nil {
+		return err
+	}
+	log.Printf("created index %s on %s", name, collWrapper.coll.Name())
+	return nil
+}
+
+// indexName gives the default name which MongoDB gives to an index with the keys - i.e. org_id_1_app_id_1
+func indexName(keys bson.D) string {
+	name := ""
+	for i, key := range keys {
+		if i > 0 {
+			name += "_"
+		}
+		name += fmt.Sprintf("%s_%v", key.Key, key.Value)
+	}
+	return name
+}
+
 func (collWrapper *collectionWrapper) AddIndexWithOptions(keys interface{}, opt *options.IndexOptions) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*15000)
 	defer cancel()
@@ -376,7 +421,7 @@ func (collWrapper *collectionWrapper) AggregateWithContext(ctx context.Context,
 }
 
 func (collWrapper *collectionWrapper) Drop() error {
-	ctx, cancel := context.WithTimeout(context.Background(), collWrapper.database.mongoTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), collWrapper.database.operationTimeout())
 	defer cancel()
 
 	err := collWrapper.coll.Drop(ctx)
@@ -0,0 +1,53 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestCollectionWrapperContextCancelled(t *testing.T) {
+	//the client connects lazily, no server is reached as the context is already done
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1").SetServerSelectionTimeout(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+	collWrapper := &collectionWrapper{database: &database{mongoTimeout: time.Minute}, coll: client.Database("notifications").Collection("messages")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	var result []bson.M
+	err = collWrapper.FindWithContext(ctx, bson.D{}, &result, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("FindWithContext() error = %v, want %v", err, context.Canceled)
+	}
+	_, err = collWrapper.UpdateManyWithContext(ctx, bson.D{}, bson.D{{Key: "$set", Value: bson.D{{Key: "read", Value: true}}}}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("UpdateManyWithContext() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("the operations took %s, want them aborted at once", elapsed)
+	}
+}
@@ -16,7 +16,10 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rokwire/logging-library-go/v2/logs"
@@ -28,10 +31,47 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// defaultMongoTimeout is the connect and operations timeout in milliseconds if it is not set or it is not valid
+const defaultMongoTimeout = 2000
+
+// poolSettings are the connection pool settings of the mongo client, the driver default is used for a nil setting
+type poolSettings struct {
+	maxPoolSize *uint64
+	minPoolSize *uint64
+	maxIdleTime *uint64 //in milliseconds
+}
+
+func (p poolSettings) String() string {
+	format := func(value *uint64) string {
+		if value == nil {
+			return "driver default"
+		}
+		return strconv.FormatUint(*value, 10)
+	}
+	return fmt.Sprintf("max pool size: %s, min pool size: %s, max idle time ms: %s", format(p.maxPoolSize), format(p.minPoolSize), format(p.maxIdleTime))
+}
+
+// parsePoolSetting gives the pool setting value, nil if it is not set or it is not a non-negative integer
+func parsePoolSetting(name string, value string) *uint64 {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return nil
+	}
+	parsed, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		log.Printf("Invalid mongo %s %s, the driver default is used", name, value)
+		return nil
+	}
+	return &parsed
+}
+
 type database struct {
 	mongoDBAuth  string
 	mongoDBName  string
 	mongoTimeout time.Duration
+	opTimeout    time.Duration //the timeout of a single operation, the mongo timeout is used if not set
+	pool         poolSettings
+	devMode      bool //the effective settings are logged on start
 
 	db       *mongo.Database
 	dbClient *mongo.Client
@@ -43,6 +83,8 @@ type database struct {
 	messagesRecipients *collectionWrapper
 	queue              *collectionWrapper
 	queueData          *collectionWrapper
+	pushRetries        *collectionWrapper
+	audit              *collectionWrapper
 	configs            *collectionWrapper
 
 	appVersions  *collectionWrapper
@@ -54,6 +96,17 @@ type database struct {
 
 	multiTenancyOrgID string
 	multiTenancyAppID string
+
+	tokenEncryptionKey string       //the device tokens are stored as plain text if not set
+	tokenCipher        *tokenCipher //set when the device tokens are encrypted
+}
+
+// operationTimeout gives the timeout of a single database operation
+func (m *database) operationTimeout() time.Duration {
+	if m.opTimeout > 0 {
+		return m.opTimeout
+	}
+	return m.mongoTimeout
 }
 
 func (m *database) start() error {
@@ -61,7 +114,28 @@ func (m *database) start() error {
 	log.Println("database -> start")
 
 	//connect to the database
-	clientOptions := options.Client().ApplyURI(m.mongoDBAuth)
+	clientOptions := options.Client().ApplyURI(m.mongoDBAuth).SetRetryWrites(true).SetRetryReads(true)
+	if m.pool.maxPoolSize != nil {
+		clientOptions.SetMaxPoolSize(*m.pool.maxPoolSize)
+	}
+	if m.pool.minPoolSize != nil {
+		clientOptions.SetMinPoolSize(*m.pool.minPoolSize)
+	}
+	if m.pool.maxIdleTime != nil {
+		clientOptions.SetMaxConnIdleTime(time.Millisecond * time.Duration(*m.pool.maxIdleTime))
+	}
+	if m.devMode {
+		log.Printf("mongo timeout: %s, %s", m.mongoTimeout, m.pool)
+	}
+	if len(m.tokenEncryptionKey) > 0 {
+		tokenCipher, err := newTokenCipher(m.tokenEncryptionKey)
+		if err != nil {
+			return err
+		}
+		m.tokenCipher = tokenCipher
+		clientOptions.SetRegistry(newTokenRegistry(tokenCipher))
+		log.Println("the device tokens are encrypted")
+	}
 	connectContext, cancel := context.WithTimeout(context.Background(), m.mongoTimeout)
 	client, err := mongo.Connect(connectContext, clientOptions)
 	cancel()
@@ -116,6 +190,18 @@ func (m *database) start() error {
 		return err
 	}
 
+	pushRetries := &collectionWrapper{database: m, coll: db.Collection("push_retries")}
+	err = m.applyPushRetriesChecks(pushRetries)
+	if err != nil {
+		return err
+	}
+
+	audit := &collectionWrapper{database: m, coll: db.Collection("audit")}
+	err = m.applyAuditChecks(audit)
+	if err != nil {
+		return err
+	}
+
 	appPlatforms := &collectionWrapper{database: m, coll: db.Collection("app_platforms")}
 	err = m.applyPlatformsChecks(appPlatforms)
 	if err != nil {
@@ -140,6 +226,11 @@ func (m *database) start() error {
 		return err
 	}
 
+	err = m.applyMultiTenancyDefaults(users, topics, messages, messagesRecipients)
+	if err != nil {
+		return err
+	}
+
 	//asign the db, db client and the collections
 	m.db = db
 	m.dbClient = client
@@ -150,6 +241,8 @@ func (m *database) start() error {
 	m.messagesRecipients = messagesRecipients
 	m.queue = queue
 	m.queueData = queueData
+	m.pushRetries = pushRetries
+	m.audit = audit
 	m.appPlatforms = appPlatforms
 	m.appVersions = appVersions
 	m.firebaseConfigurations = firebaseConfigurations
@@ -171,6 +264,12 @@ func (m *database) applyMessagesChecks(messages *collectionWrapper) error {
 		return err
 	}
 
+	//add priority index
+	err = messages.AddIndex(bson.D{primitive.E{Key: "priority", Value: 1}}, false)
+	if err != nil {
+		return err
+	}
+
 	indexes, _ := messages.ListIndexes()
 	indexMapping := map[string]interface{}{}
 	if indexes != nil {
@@ -180,19 +279,42 @@ func (m *database) applyMessagesChecks(messages *collectionWrapper) error {
 		}
 	}
 
-	if indexMapping["recipients.user_id_1"] == nil {
+	//the recipients, the topics and the creation date are the most used filters, they are checked on every start
+	err = messages.EnsureIndex(bson.D{primitive.E{Key: "recipients.user_id", Value: 1}}, false)
+	if err != nil {
+		return err
+	}
+
+	err = messages.EnsureIndex(bson.D{primitive.E{Key: "topic", Value: 1}}, false)
+	if err != nil {
+		return err
+	}
+
+	err = messages.EnsureIndex(bson.D{primitive.E{Key: "topics", Value: 1}}, false)
+	if err != nil {
+		return err
+	}
+
+	err = messages.EnsureIndex(bson.D{primitive.E{Key: "date_created", Value: 1}}, false)
+	if err != nil {
+		return err
+	}
+
+	if indexMapping["date_updated_1"] == nil {
 		err := messages.AddIndex(
 			bson.D{
-				primitive.E{Key: "recipients.user_id", Value: 1},
+				primitive.E{Key: "date_updated", Value: 1},
 			}, false)
 		if err != nil {
 			return err
 		}
 	}
 
-	if indexMapping["date_created_1"] == nil {
+	//the order by date_updated falls back to date_created for the messages which have not been updated
+	if indexMapping["date_updated_1_date_created_1"] == nil {
 		err := messages.AddIndex(
 			bson.D{
+				primitive.E{Key: "date_updated", Value: 1},
 				primitive.E{Key: "date_created", Value: 1},
 			}, false)
 		if err != nil {
@@ -200,10 +322,32 @@ func (m *database) applyMessagesChecks(messages *collectionWrapper) error {
 		}
 	}
 
-	if indexMapping["date_updated_1"] == nil {
+	if indexMapping["org_id_1_app_id_1_idempotency_key_1"] == nil {
 		err := messages.AddIndex(
 			bson.D{
-				primitive.E{Key: "date_updated", Value: 1},
+				primitive.E{Key: "org_id", Value: 1},
+				primitive.E{Key: "app_id", Value: 1},
+				primitive.E{Key: "idempotency_key", Value: 1},
+			}, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	if indexMapping["expires_at_1"] == nil {
+		err := messages.AddIndex(
+			bson.D{
+				primitive.E{Key: "expires_at", Value: 1},
+			}, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	if indexMapping["date_send_ended_1"] == nil {
+		err := messages.AddIndex(
+			bson.D{
+				primitive.E{Key: "date_send_ended", Value: 1},
 			}, false)
 		if err != nil {
 			return err
@@ -236,6 +380,18 @@ func (m *database) applyMessagesChecks(messages *collectionWrapper) error {
 		return err
 	}
 
+	//add source app index
+	err = messages.AddIndex(bson.D{primitive.E{Key: "source_app", Value: 1}}, false)
+	if err != nil {
+		return err
+	}
+
+	//add callback index for the pending delivery receipts
+	err = messages.AddIndex(bson.D{primitive.E{Key: "callback_url", Value: 1}, primitive.E{Key: "date_callback_sent", Value: 1}}, false)
+	if err != nil {
+		return err
+	}
+
 	log.Println("apply messages passed")
 	return nil
 }
@@ -299,10 +455,48 @@ func (m *database) applyQueueDataChecks(queueData *collectionWrapper) error {
 		return err
 	}
 
+	//add compound index for the processing order - time + priority + user_last_active
+	err = queueData.AddIndex(bson.D{primitive.E{Key: "time", Value: 1}, primitive.E{Key: "priority", Value: 1}, primitive.E{Key: "user_last_active", Value: -1}}, false)
+	if err != nil {
+		return err
+	}
+
 	log.Println("apply queue data passed")
 	return nil
 }
 
+func (m *database) applyPushRetriesChecks(pushRetries *collectionWrapper) error {
+	log.Println("apply push retries checks.....")
+
+	//add next attempt index
+	err := pushRetries.AddIndex(bson.D{primitive.E{Key: "next_attempt", Value: 1}}, false)
+	if err != nil {
+		return err
+	}
+
+	//add message index for the pending pushes of a message
+	err = pushRetries.AddIndex(bson.D{primitive.E{Key: "message_id", Value: 1}}, false)
+	if err != nil {
+		return err
+	}
+
+	log.Println("apply push retries passed")
+	return nil
+}
+
+func (m *database) applyAuditChecks(audit *collectionWrapper) error {
+	log.Println("apply audit checks.....")
+
+	//add message index for the delivery attempts of a message in order
+	err := audit.AddIndex(bson.D{primitive.E{Key: "message_id", Value: 1}, primitive.E{Key: "date_created", Value: 1}}, false)
+	if err != nil {
+		return err
+	}
+
+	log.Println("apply audit passed")
+	return nil
+}
+
 func (m *database) applyUsersChecks(users *collectionWrapper) error {
 	log.Println("apply users checks.....")
 
@@ -321,14 +515,9 @@ func (m *database) applyUsersChecks(users *collectionWrapper) error {
 		}
 	}
 
-	if indexMapping["user_id_1"] == nil {
-		err := users.AddIndex(
-			bson.D{
-				primitive.E{Key: "user_id", Value: 1},
-			}, true)
-		if err != nil {
-			return err
-		}
+	err = users.EnsureIndex(bson.D{primitive.E{Key: "user_id", Value: 1}}, true)
+	if err != nil {
+		return err
 	}
 
 	if indexMapping["firebase_tokens.token_1"] == nil {
@@ -341,22 +530,18 @@ func (m *database) applyUsersChecks(users *collectionWrapper) error {
 		}
 	}
 
-	if indexMapping["topics_1"] == nil {
-		err := users.AddIndex(
-			bson.D{
-				primitive.E{Key: "topics", Value: 1},
-			}, false)
-		if err != nil {
-			return err
-		}
-	} else {
-		err := users.DropIndex("topics_1") // rebuild nonunique index
-		if err != nil {
-			return err
-		}
+	//an old unique topics index is rebuilt as nonunique
+	err = users.EnsureIndex(bson.D{primitive.E{Key: "topics", Value: 1}}, false)
+	if err != nil {
+		return err
+	}
 
-		err = users.AddIndex(
+	//the topics subscribers counts and lists are per app
+	if indexMapping["org_id_1_app_id_1_topics_1"] == nil {
+		err := users.AddIndex(
 			bson.D{
+				primitive.E{Key: "org_id", Value: 1},
+				primitive.E{Key: "app_id", Value: 1},
 				primitive.E{Key: "topics", Value: 1},
 			}, false)
 		if err != nil {
@@ -384,6 +569,33 @@ func (m *database) applyUsersChecks(users *collectionWrapper) error {
 		}
 	}
 
+	//the old documents keep the tokens as plain strings, convert them to the token structure
+	legacyFilter := bson.D{primitive.E{Key: "firebase_tokens", Value: bson.D{primitive.E{Key: "$elemMatch", Value: bson.D{primitive.E{Key: "$type", Value: "string"}}}}}}
+	stringToToken := bson.D{primitive.E{Key: "$cond", Value: bson.A{
+		bson.D{primitive.E{Key: "$eq", Value: bson.A{bson.D{primitive.E{Key: "$type", Value: "$$t"}}, "string"}}},
+		bson.D{
+			primitive.E{Key: "token", Value: "$$t"},
+			primitive.E{Key: "token_type", Value: ""},
+			primitive.E{Key: "failures_count", Value: 0},
+			primitive.E{Key: "notifications_disabled", Value: false},
+			primitive.E{Key: "date_created", Value: "$date_created"},
+		},
+		"$$t",
+	}}}
+	tokensMap := bson.D{primitive.E{Key: "$map", Value: bson.D{
+		primitive.E{Key: "input", Value: "$firebase_tokens"},
+		primitive.E{Key: "as", Value: "t"},
+		primitive.E{Key: "in", Value: stringToToken},
+	}}}
+	legacyUpdate := bson.A{bson.D{primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "firebase_tokens", Value: tokensMap}}}}}
+	result, err := users.UpdateMany(legacyFilter, legacyUpdate, nil)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("converted the string tokens of %d users", result.ModifiedCount)
+	}
+
 	log.Println("apply users passed")
 	return nil
 }
@@ -397,10 +609,55 @@ func (m *database) applyTopicsChecks(topics *collectionWrapper) error {
 		return err
 	}
 
+	//add aliases index
+	err = topics.AddIndex(bson.D{primitive.E{Key: "aliases", Value: 1}}, false)
+	if err != nil {
+		return err
+	}
+
+	//the topics were identified by their names before they were per tenant, the name is copied out of the id of such topics
+	_, err = topics.UpdateMany(bson.D{primitive.E{Key: "name", Value: bson.M{"$exists": false}}},
+		bson.A{bson.M{"$set": bson.M{"name": "$_id"}}}, nil)
+	if err != nil {
+		return err
+	}
+
+	//add compound unique index - org_id + app_id + name
+	err = topics.EnsureIndex(bson.D{primitive.E{Key: "org_id", Value: 1}, primitive.E{Key: "app_id", Value: 1}, primitive.E{Key: "name", Value: 1}}, true)
+	if err != nil {
+		return err
+	}
+
 	log.Println("apply topics passed")
 	return nil
 }
 
+// applyMultiTenancyDefaults assigns the data stored before the multi-tenancy to the default org and app
+func (m *database) applyMultiTenancyDefaults(collections ...*collectionWrapper) error {
+	if m.multiTenancyOrgID == "" || m.multiTenancyAppID == "" {
+		return nil
+	}
+	log.Println("apply multi-tenancy defaults.....")
+
+	filter := bson.D{primitive.E{Key: "org_id", Value: bson.M{"$exists": false}}}
+	update := bson.D{primitive.E{Key: "$set", Value: bson.D{
+		primitive.E{Key: "org_id", Value: m.multiTenancyOrgID},
+		primitive.E{Key: "app_id", Value: m.multiTenancyAppID},
+	}}}
+	for _, collection := range collections {
+		result, err := collection.UpdateMany(filter, update, nil)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount > 0 {
+			log.Printf("assigned %d %s to the default org and app", result.ModifiedCount, collection.coll.Name())
+		}
+	}
+
+	log.Println("apply multi-tenancy defaults passed")
+	return nil
+}
+
 func (m *database) applyVersionsChecks(appVersions *collectionWrapper) error {
 	log.Println("apply app_versions checks.....")
 
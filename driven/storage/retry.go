@@ -0,0 +1,77 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultWriteRetries = 3
+	writeRetryDelay     = 100 * time.Millisecond //multiplied by the attempt
+)
+
+// transientError marks an error which was caused by a transient Mongo error, i.e. a primary stepdown
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// markTransient marks the wrapped error as transient if the cause is a transient Mongo error
+func markTransient(cause error, wrapped error) error {
+	if isTransientError(cause) {
+		return &transientError{err: wrapped}
+	}
+	return wrapped
+}
+
+// isTransientError checks if the operation may succeed if it is performed again
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var marked *transientError
+	if errors.As(err, &marked) {
+		return true
+	}
+	var labeled mongo.LabeledError
+	if errors.As(err, &labeled) && (labeled.HasErrorLabel("TransientTransactionError") ||
+		labeled.HasErrorLabel("UnknownTransactionCommitResult") || labeled.HasErrorLabel("RetryableWriteError")) {
+		return true
+	}
+	return mongo.IsNetworkError(err)
+}
+
+// withRetry performs the operation again while it fails with a transient error. The single writes are retried by the driver,
+// this is for the transactions which are aborted as a whole.
+func (sa *Adapter) withRetry(operation string, perform func() error) error {
+	err := perform()
+	for attempt := 1; attempt <= sa.writeRetries && isTransientError(err); attempt++ {
+		sa.db.logger.Warnf("retrying %s after a transient error (attempt %d) - %s", operation, attempt, err)
+		time.Sleep(time.Duration(attempt) * writeRetryDelay)
+		err = perform()
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestIsTransientError(t *testing.T) {
+	stepdown := mongo.CommandError{Code: 189, Name: "PrimarySteppedDown", Labels: []string{"RetryableWriteError"}}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"none", nil, false},
+		{"labeled", stepdown, true},
+		{"transaction", mongo.CommandError{Labels: []string{"TransientTransactionError"}}, true},
+		{"marked", markTransient(stepdown, errors.New("error inserting message")), true},
+		{"wrapped", fmt.Errorf("error performing transaction: %w", stepdown), true},
+		{"duplicate key", mongo.CommandError{Code: 11000, Name: "DuplicateKey"}, false},
+		{"not marked", markTransient(errors.New("invalid"), errors.New("error inserting message")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientError(tt.err); got != tt.want {
+				t.Errorf("isTransientError(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	transient := mongo.CommandError{Labels: []string{"TransientTransactionError"}}
+	invalid := errors.New("invalid")
+
+	tests := []struct {
+		name      string
+		errs      []error //the error of every attempt, the operation succeeds after them
+		wantErr   bool
+		wantCalls int
+	}{
+		{"succeeds", nil, false, 1},
+		{"transient then succeeds", []error{transient}, false, 2},
+		{"transient on every retry", []error{transient, transient, transient}, true, 3},
+		{"not transient", []error{invalid}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &Adapter{db: &database{logger: logs.NewLogger("notifications", nil)}, writeRetries: 2}
+			calls := 0
+			err := sa.withRetry("insert message", func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("withRetry() error = %v, want error %t", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("withRetry() performed %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"notifications/core/model"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+)
+
+// encryptedTokenPrefix marks the stored tokens which are encrypted, the tokens stored before the encryption was enabled do not have it
+const encryptedTokenPrefix = "enc:"
+
+// tokenCipher encrypts the device tokens with AES-GCM.
+// The nonce is derived from the token so the same token is always encrypted to the same value and the tokens can still be matched by the queries.
+type tokenCipher struct {
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+// newTokenCipher creates a cipher for the base64 encoded 32 bytes key
+func newTokenCipher(encodedKey string) (*tokenCipher, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+	if err != nil {
+		return nil, fmt.Errorf("the token encryption key is not base64 encoded: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("the token encryption key must be 32 bytes, it is %d", len(key))
+	}
+
+	//separate keys for the encryption and for the nonces
+	encryptionKey := deriveTokenKey(key, "encryption")
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenCipher{aead: aead, nonceKey: deriveTokenKey(key, "nonce")}, nil
+}
+
+func deriveTokenKey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// encrypt gives the stored value of the token
+func (c *tokenCipher) encrypt(token string) string {
+	if token == "" || strings.HasPrefix(token, encryptedTokenPrefix) {
+		return token
+	}
+
+	mac := hmac.New(sha256.New, c.nonceKey)
+	mac.Write([]byte(token))
+	nonce := mac.Sum(nil)[:c.aead.NonceSize()]
+
+	sealed := c.aead.Seal(nonce, nonce, []byte(token), nil)
+	return encryptedTokenPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// decrypt gives the token of the stored value, the values which are not encrypted are given as they are
+func (c *tokenCipher) decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedTokenPrefix) {
+		return value, nil
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, encryptedTokenPrefix))
+	if err != nil {
+		return "", fmt.Errorf("the encrypted token is not base64 encoded: %w", err)
+	}
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", fmt.Errorf("the encrypted token is too short")
+	}
+	token, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("the token cannot be decrypted, the encryption key may have changed: %w", err)
+	}
+	return string(token), nil
+}
+
+// matchValue gives the value which matches the token in the queries - both the encrypted and the not yet encrypted one
+func (c *tokenCipher) matchValue(token string) interface{} {
+	if c == nil {
+		return token
+	}
+	return bson.M{"$in": []string{c.encrypt(token), token}}
+}
+
+// deviceTokenDocument has the same fields as the device token so it is encoded and decoded by the default struct codec
+type deviceTokenDocument model.DeviceToken
+
+// deviceTokenCodec encrypts the token of the device tokens when they are stored and decrypts it when they are loaded
+type deviceTokenCodec struct {
+	cipher *tokenCipher
+}
+
+// EncodeValue encodes the device token with its token encrypted
+func (dc deviceTokenCodec) EncodeValue(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	deviceToken, ok := val.Interface().(model.DeviceToken)
+	if !ok {
+		return bsoncodec.ValueEncoderError{Name: "deviceTokenCodec.EncodeValue", Types: []reflect.Type{reflect.TypeOf(model.DeviceToken{})}, Received: val}
+	}
+	deviceToken.Token = dc.cipher.encrypt(deviceToken.Token)
+
+	document := deviceTokenDocument(deviceToken)
+	encoder, err := ec.LookupEncoder(reflect.TypeOf(document))
+	if err != nil {
+		return err
+	}
+	return encoder.EncodeValue(ec, vw, reflect.ValueOf(document))
+}
+
+// DecodeValue decodes the device token and decrypts its token
+func (dc deviceTokenCodec) DecodeValue(ctx bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if !val.CanSet() || val.Type() != reflect.TypeOf(model.DeviceToken{}) {
+		return bsoncodec.ValueDecoderError{Name: "deviceTokenCodec.DecodeValue", Types: []reflect.Type{reflect.TypeOf(model.DeviceToken{})}, Received: val}
+	}
+
+	var document deviceTokenDocument
+	decoder, err := ctx.LookupDecoder(reflect.TypeOf(document))
+	if err != nil {
+		return err
+	}
+	err = decoder.DecodeValue(ctx, vr, reflect.ValueOf(&document).Elem())
+	if err != nil {
+		return err
+	}
+
+	deviceToken := model.DeviceToken(document)
+	deviceToken.Token, err = dc.cipher.decrypt(deviceToken.Token)
+	if err != nil {
+		return err
+	}
+	val.Set(reflect.ValueOf(deviceToken))
+	return nil
+}
+
+// newTokenRegistry gives the registry which encrypts the device tokens
+func newTokenRegistry(cipher *tokenCipher) *bsoncodec.Registry {
+	registry := bson.NewRegistry()
+	codec := deviceTokenCodec{cipher: cipher}
+	registry.RegisterTypeEncoder(reflect.TypeOf(model.DeviceToken{}), codec)
+	registry.RegisterTypeDecoder(reflect.TypeOf(model.DeviceToken{}), codec)
+	return registry
+}
@@ -0,0 +1,195 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/base64"
+	"notifications/core/model"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func testTokenKey(fill byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(fill), 32)))
+}
+
+func TestNewTokenCipher(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"valid", testTokenKey('k'), false},
+		{"valid with spaces", " " + testTokenKey('k') + "\n", false},
+		{"not base64", "not a base64 key!", true},
+		{"short", base64.StdEncoding.EncodeToString([]byte("short")), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTokenCipher(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newTokenCipher() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenCipherEncryptDecrypt(t *testing.T) {
+	cipher, err := newTokenCipher(testTokenKey('k'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCipher, err := newTokenCipher(testTokenKey('o'))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"firebase token", "fcm-token:APA91bH-example_token"},
+		{"apns token", "740f4707bebcf74f9b7c25d48e3358945f6aa01da5ddb387462c7eaf61bb78ad"},
+		{"unicode", "token-ü-ß"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted := cipher.encrypt(tt.token)
+			if !strings.HasPrefix(encrypted, encryptedTokenPrefix) || strings.Contains(encrypted, tt.token) {
+				t.Errorf("encrypt() = %s, want an encrypted value", encrypted)
+			}
+			if again := cipher.encrypt(tt.token); again != encrypted {
+				t.Errorf("encrypt() = %s, want the same value %s", again, encrypted)
+			}
+			if twice := cipher.encrypt(encrypted); twice != encrypted {
+				t.Errorf("encrypt() of an encrypted value = %s, want it as it is", twice)
+			}
+
+			decrypted, err := cipher.decrypt(encrypted)
+			if err != nil || decrypted != tt.token {
+				t.Errorf("decrypt() = %s, %v, want %s", decrypted, err, tt.token)
+			}
+
+			if _, err := otherCipher.decrypt(encrypted); err == nil {
+				t.Errorf("decrypt() with another key succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestTokenCipherDecryptStoredValues(t *testing.T) {
+	cipher, err := newTokenCipher(testTokenKey('k'))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"legacy plaintext", "plain-legacy-token", "plain-legacy-token", false},
+		{"empty", "", "", false},
+		{"not base64", encryptedTokenPrefix + "not base64!", "", true},
+		{"too short", encryptedTokenPrefix + base64.RawStdEncoding.EncodeToString([]byte("short")), "", true},
+		{"tampered", encryptedTokenPrefix + base64.RawStdEncoding.EncodeToString([]byte(strings.Repeat("x", 40))), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cipher.decrypt(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("decrypt(%s) = %s, %v, want %s, error %t", tt.value, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenCipherMatchValue(t *testing.T) {
+	var noCipher *tokenCipher
+	if got := noCipher.matchValue("token"); got != "token" {
+		t.Errorf("matchValue() without encryption = %v, want the token", got)
+	}
+
+	cipher, err := newTokenCipher(testTokenKey('k'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	match, ok := cipher.matchValue("token").(bson.M)
+	if !ok {
+		t.Fatalf("matchValue() = %v, want an $in query", cipher.matchValue("token"))
+	}
+	values, _ := match["$in"].([]string)
+	if len(values) != 2 || values[0] != cipher.encrypt("token") || values[1] != "token" {
+		t.Errorf("matchValue() $in = %v, want the encrypted and the legacy plaintext token", values)
+	}
+}
+
+func TestDeviceTokenCodec(t *testing.T) {
+	cipher, err := newTokenCipher(testTokenKey('k'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := newTokenRegistry(cipher)
+
+	type userDocument struct {
+		Tokens []model.DeviceToken `bson:"firebase_tokens"`
+	}
+	user := userDocument{Tokens: []model.DeviceToken{{Token: "device-token", TokenType: "firebase"}}}
+	data, err := bson.MarshalWithRegistry(registry, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//stored encrypted
+	var raw struct {
+		Tokens []struct {
+			Token string `bson:"token"`
+		} `bson:"firebase_tokens"`
+	}
+	err = bson.Unmarshal(data, &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw.Tokens) != 1 || raw.Tokens[0].Token != cipher.encrypt("device-token") {
+		t.Errorf("stored tokens = %v, want the encrypted token", raw.Tokens)
+	}
+
+	//loaded decrypted
+	var loaded userDocument
+	err = bson.UnmarshalWithRegistry(registry, data, &loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Tokens) != 1 || loaded.Tokens[0].Token != "device-token" || loaded.Tokens[0].TokenType != "firebase" {
+		t.Errorf("loaded tokens = %v, want the decrypted token", loaded.Tokens)
+	}
+
+	//the legacy plaintext tokens are loaded as they are
+	legacy, err := bson.Marshal(bson.M{"firebase_tokens": bson.A{bson.M{"token": "legacy-token"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loadedLegacy userDocument
+	err = bson.UnmarshalWithRegistry(registry, legacy, &loadedLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loadedLegacy.Tokens) != 1 || loadedLegacy.Tokens[0].Token != "legacy-token" {
+		t.Errorf("loaded legacy tokens = %v, want the plaintext token", loadedLegacy.Tokens)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"notifications/core/model"
+	"syscall"
+	"time"
+)
+
+// signatureHeader carries the HMAC-SHA256 of the request body
+const signatureHeader = "X-Notifications-Signature"
+
+// Adapter posts the messages delivery receipts to their callback urls
+type Adapter struct {
+	secret string
+
+	client *http.Client
+}
+
+// NewWebhookAdapter creates a new webhook adapter instance
+func NewWebhookAdapter(secret string) *Adapter {
+	//the addresses are checked after the host is resolved, so that a public host name cannot point to a private address
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: checkPublicAddress}
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second}
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse //the redirects are not followed
+		},
+	}
+	return &Adapter{secret: secret, client: client}
+}
+
+// SendCallback posts the payload to the callback url signed with the secret
+func (a *Adapter) SendCallback(callbackURL string, payload []byte) error {
+	if len(a.secret) == 0 {
+		return errors.New("the webhook adapter is not configured")
+	}
+	err := model.ValidateCallbackURL(callbackURL)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", callbackURL, bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("error creating callback request - %s", err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureHeader, "sha256="+a.sign(payload))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		log.Printf("error sending callback - %s", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		log.Printf("error with callback response code - %d", resp.StatusCode)
+		return fmt.Errorf("error with callback response code - %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sign gives the hex encoded HMAC-SHA256 of the payload
+func (a *Adapter) sign(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(a.secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkPublicAddress rejects the connections to the non public addresses
+func checkPublicAddress(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !model.IsPublicIP(ip) {
+		return fmt.Errorf("%w: %s is not a public address", model.ErrInvalidCallbackURL, host)
+	}
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 	"notifications/core"
 	"notifications/core/model"
 	"os"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -54,6 +55,11 @@ type Adapter struct {
 
 	corsAllowedOrigins []string
 	corsAllowedHeaders []string
+	corsExcludeAdmin   bool
+
+	maxRequestBodyBytes int64
+
+	senderRateLimiter *senderRateLimiter
 
 	logger *logs.Logger
 }
@@ -70,6 +76,9 @@ func (we Adapter) Start() {
 	baseRouter.PathPrefix("/doc/ui").Handler(we.serveDocUI())
 	baseRouter.HandleFunc("/doc", we.serveDoc)
 	baseRouter.HandleFunc("/version", we.wrapFunc(we.apisHandler.Version, nil)).Methods("GET")
+	baseRouter.HandleFunc("/health", we.wrapFunc(we.apisHandler.Health, nil)).Methods("GET")
+	baseRouter.HandleFunc("/ready", we.wrapFunc(we.apisHandler.Ready, nil)).Methods("GET")
+	baseRouter.HandleFunc("/metrics", we.wrapFunc(we.internalApisHandler.GetMetrics, we.auth.internal)).Methods("GET")
 
 	mainRouter := baseRouter.PathPrefix("/api").Subrouter()
 
@@ -81,9 +90,9 @@ func (we Adapter) Start() {
 
 	// Internal APIs
 	// DEPRECATED - Use "bbs" APIs
-	mainRouter.HandleFunc("/int/message", we.wrapFunc(we.internalApisHandler.SendMessage, we.auth.internal)).Methods("POST")
-	mainRouter.HandleFunc("/int/messages", we.wrapFunc(we.internalApisHandler.SendMessages, we.auth.internal)).Methods("POST")
-	mainRouter.HandleFunc("/int/v2/message", we.wrapFunc(we.internalApisHandler.SendMessageV2, we.auth.internal)).Methods("POST")
+	mainRouter.HandleFunc("/int/message", we.wrapFunc(we.rateLimited(we.internalApisHandler.SendMessage), we.auth.internal)).Methods("POST")
+	mainRouter.HandleFunc("/int/messages", we.wrapFunc(we.rateLimited(we.internalApisHandler.SendMessages), we.auth.internal)).Methods("POST")
+	mainRouter.HandleFunc("/int/v2/message", we.wrapFunc(we.rateLimited(we.internalApisHandler.SendMessageV2), we.auth.internal)).Methods("POST")
 	mainRouter.HandleFunc("/int/mail", we.wrapFunc(we.internalApisHandler.SendMail, we.auth.internal)).Methods("POST")
 
 	// Client APIs
@@ -91,19 +100,31 @@ func (we Adapter) Start() {
 	mainRouter.HandleFunc("/user", we.wrapFunc(we.apisHandler.GetUser, we.auth.client.Standard)).Methods("GET")
 	mainRouter.HandleFunc("/user", we.wrapFunc(we.apisHandler.UpdateUser, we.auth.client.Standard)).Methods("PUT")
 	mainRouter.HandleFunc("/user", we.wrapFunc(we.apisHandler.DeleteUser, we.auth.client.Standard)).Methods("DELETE")
+	mainRouter.HandleFunc("/user/settings", we.wrapFunc(we.apisHandler.GetUserSettings, we.auth.client.Standard)).Methods("GET")
+	mainRouter.HandleFunc("/user/settings", we.wrapFunc(we.apisHandler.UpdateUserSettings, we.auth.client.Standard)).Methods("PUT")
+	mainRouter.HandleFunc("/heartbeat", we.wrapFunc(we.apisHandler.Heartbeat, we.auth.client.Standard)).Methods("POST")
+	mainRouter.HandleFunc("/preferences/muted-topics", we.wrapFunc(we.apisHandler.GetUserMutedTopics, we.auth.client.Standard)).Methods("GET")
+	mainRouter.HandleFunc("/preferences/muted-topics", we.wrapFunc(we.apisHandler.UpdateUserMutedTopics, we.auth.client.Standard)).Methods("PUT")
+	mainRouter.HandleFunc("/preferences/token", we.wrapFunc(we.apisHandler.UpdateTokenPreferences, we.auth.client.Standard)).Methods("PUT")
 	mainRouter.HandleFunc("/messages", we.wrapFunc(we.apisHandler.GetUserMessages, we.auth.client.Standard)).Methods("GET")
 	mainRouter.HandleFunc("/messages", we.wrapFunc(we.apisHandler.DeleteUserMessages, we.auth.client.Standard)).Methods("DELETE")
 	mainRouter.HandleFunc("/messages/read", we.wrapFunc(we.apisHandler.UpdateAllUserMessagesRead, we.auth.client.Standard)).Methods("PUT")
 	mainRouter.HandleFunc("/messages/stats", we.wrapFunc(we.apisHandler.GetUserMessagesStats, we.auth.client.Standard)).Methods("GET")
-	mainRouter.HandleFunc("/message", we.wrapFunc(we.apisHandler.CreateMessage, we.auth.client.Permissions)).Methods("POST")
+	mainRouter.HandleFunc("/message", we.wrapFunc(we.rateLimited(we.apisHandler.CreateMessage), we.auth.client.Permissions)).Methods("POST")
 	mainRouter.HandleFunc("/message/{id}", we.wrapFunc(we.apisHandler.GetUserMessage, we.auth.client.Standard)).Methods("GET")
 	mainRouter.HandleFunc("/message/{id}", we.wrapFunc(we.apisHandler.DeleteUserMessage, we.auth.client.Standard)).Methods("DELETE")
 	mainRouter.HandleFunc("/message/{id}/read", we.wrapFunc(we.apisHandler.UpdateReadMessage, we.auth.client.Standard)).Methods("PUT")
+	mainRouter.HandleFunc("/message/{id}/report", we.wrapFunc(we.apisHandler.ReportMessage, we.auth.client.Standard)).Methods("POST")
 	mainRouter.HandleFunc("/topics", we.wrapFunc(we.apisHandler.GetTopics, we.auth.client.Standard)).Methods("GET")
+	mainRouter.HandleFunc("/topics/subscriptions", we.wrapFunc(we.apisHandler.UpdateTopicSubscriptions, we.auth.client.Standard)).Methods("POST")
 	//not used and disabled because of the refactoring
 	//mainRouter.HandleFunc("/topic/{topic}/messages", we.wrapFunc(we.apisHandler.GetTopicMessages, we.auth.client.Standard)).Methods("GET")
+	mainRouter.HandleFunc("/topic/unsubscribe/all", we.wrapFunc(we.apisHandler.UnsubscribeFromAllTopics, we.auth.client.Standard)).Methods("POST")
+	mainRouter.HandleFunc("/topic/{name}/reach", we.wrapFunc(we.apisHandler.GetTopicReach, we.auth.client.Standard)).Methods("GET")
 	mainRouter.HandleFunc("/topic/{topic}/subscribe", we.wrapFunc(we.apisHandler.Subscribe, we.auth.client.Standard)).Methods("POST")
 	mainRouter.HandleFunc("/topic/{topic}/unsubscribe", we.wrapFunc(we.apisHandler.Unsubscribe, we.auth.client.Standard)).Methods("POST")
+	mainRouter.HandleFunc("/topic/{topic}/mute", we.wrapFunc(we.apisHandler.MuteTopic, we.auth.client.Standard)).Methods("POST")
+	mainRouter.HandleFunc("/topic/{topic}/unmute", we.wrapFunc(we.apisHandler.UnmuteTopic, we.auth.client.Standard)).Methods("POST")
 	mainRouter.HandleFunc("/push-subscription", we.wrapFunc(we.apisHandler.PushSubscription, we.auth.client.Standard)).Methods("POST")
 
 	// Admin APIs
@@ -111,14 +132,34 @@ func (we Adapter) Start() {
 	adminRouter.HandleFunc("/app-versions", we.wrapFunc(we.adminApisHandler.GetAllAppVersions, we.auth.admin.Permissions)).Methods("GET")
 	adminRouter.HandleFunc("/app-platforms", we.wrapFunc(we.adminApisHandler.GetAllAppPlatforms, we.auth.admin.Permissions)).Methods("GET")
 	adminRouter.HandleFunc("/topics", we.wrapFunc(we.adminApisHandler.GetTopics, we.auth.admin.Permissions)).Methods("GET")
+	adminRouter.HandleFunc("/topics/audience", we.wrapFunc(we.adminApisHandler.GetTopicsAudience, we.auth.admin.Permissions)).Methods("GET")
 	adminRouter.HandleFunc("/topic", we.wrapFunc(we.adminApisHandler.UpdateTopic, we.auth.admin.Permissions)).Methods("POST")
+	adminRouter.HandleFunc("/topic/{name}", we.wrapFunc(we.adminApisHandler.DeleteTopic, we.auth.admin.Permissions)).Methods("DELETE")
+	adminRouter.HandleFunc("/topic/{name}/subscribers", we.wrapFunc(we.adminApisHandler.GetTopicSubscribers, we.auth.admin.Permissions)).Methods("GET")
+	adminRouter.HandleFunc("/topic/{name}/stats", we.wrapFunc(we.adminApisHandler.GetTopicStats, we.auth.admin.Permissions)).Methods("GET")
+	adminRouter.HandleFunc("/topic/{name}/rename", we.wrapFunc(we.adminApisHandler.RenameTopic, we.auth.admin.Permissions)).Methods("POST")
 	//not used and disabled because of the refactoring
 	//adminRouter.HandleFunc("/messages", we.wrapFunc(we.adminApisHandler.GetMessages, we.auth.admin.Permissions)).Methods("GET")
-	adminRouter.HandleFunc("/message", we.wrapFunc(we.adminApisHandler.CreateMessage, we.auth.admin.Permissions)).Methods("POST")
+	adminRouter.HandleFunc("/message", we.wrapFunc(we.rateLimited(we.adminApisHandler.CreateMessage), we.auth.admin.Permissions)).Methods("POST")
 	adminRouter.HandleFunc("/message", we.wrapFunc(we.adminApisHandler.UpdateMessage, we.auth.admin.Permissions)).Methods("PUT")
+	adminRouter.HandleFunc("/messages/bulk", we.wrapFunc(we.rateLimited(we.adminApisHandler.CreateMessagesBulk), we.auth.admin.Permissions)).Methods("POST")
+	adminRouter.HandleFunc("/broadcast", we.wrapFunc(we.rateLimited(we.adminApisHandler.Broadcast), we.auth.admin.Permissions)).Methods("POST")
+	adminRouter.HandleFunc("/message/preview-for", we.wrapFunc(we.adminApisHandler.PreviewMessageFor, we.auth.admin.Permissions)).Methods("POST")
 	adminRouter.HandleFunc("/message/{id}", we.wrapFunc(we.adminApisHandler.GetMessage, we.auth.admin.Permissions)).Methods("GET")
 	adminRouter.HandleFunc("/message/{id}", we.wrapFunc(we.adminApisHandler.DeleteMessage, we.auth.admin.Permissions)).Methods("DELETE")
+	adminRouter.HandleFunc("/message/{id}/approve", we.wrapFunc(we.adminApisHandler.ApproveMessage, we.auth.admin.Permissions)).Methods("POST")
+	adminRouter.HandleFunc("/message/{id}/restore", we.wrapFunc(we.adminApisHandler.RestoreMessage, we.auth.admin.Permissions)).Methods("POST")
+	adminRouter.HandleFunc("/message/{id}/purge", we.wrapFunc(we.adminApisHandler.PurgeMessage, we.auth.admin.Permissions)).Methods("DELETE")
+	adminRouter.HandleFunc("/message/{id}/recall", we.wrapFunc(we.adminApisHandler.RecallMessage, we.auth.admin.Permissions)).Methods("POST")
+	adminRouter.HandleFunc("/message/{id}/resend", we.wrapFunc(we.adminApisHandler.ResendMessage, we.auth.admin.Permissions)).Methods("POST")
+	adminRouter.HandleFunc("/message/{id}/status", we.wrapFunc(we.adminApisHandler.GetMessageStatus, we.auth.admin.Permissions)).Methods("GET")
+	adminRouter.HandleFunc("/message/{id}/deliveries", we.wrapFunc(we.adminApisHandler.GetMessageDeliveries, we.auth.admin.Permissions)).Methods("GET")
 	adminRouter.HandleFunc("/messages/stats/source/{source}", we.wrapFunc(we.adminApisHandler.GetMessagesStats, we.auth.admin.Permissions)).Methods("GET")
+	adminRouter.HandleFunc("/tokens/dead", we.wrapFunc(we.adminApisHandler.GetDeadTokens, we.auth.admin.Permissions)).Methods("GET")
+	adminRouter.HandleFunc("/tokens/dead", we.wrapFunc(we.adminApisHandler.PurgeDeadTokens, we.auth.admin.Permissions)).Methods("DELETE")
+	adminRouter.HandleFunc("/user/{user_id}", we.wrapFunc(we.adminApisHandler.GetUser, we.auth.admin.Permissions)).Methods("GET")
+	adminRouter.HandleFunc("/user/{user_id}/token/{token}", we.wrapFunc(we.adminApisHandler.DeleteUserToken, we.auth.admin.Permissions)).Methods("DELETE")
+	adminRouter.HandleFunc("/user/{user_id}/unsubscribe-all", we.wrapFunc(we.adminApisHandler.UnsubscribeUserFromAllTopics, we.auth.admin.Permissions)).Methods("POST")
 	adminRouter.HandleFunc("/configs/{id}", we.wrapFunc(we.adminApisHandler.GetConfig, we.auth.admin.Permissions)).Methods("GET")
 	adminRouter.HandleFunc("/configs", we.wrapFunc(we.adminApisHandler.GetConfigs, we.auth.admin.Permissions)).Methods("GET")
 	adminRouter.HandleFunc("/configs", we.wrapFunc(we.adminApisHandler.CreateConfig, we.auth.admin.Permissions)).Methods("POST")
@@ -127,13 +168,13 @@ func (we Adapter) Start() {
 
 	// BB APIs
 	bbsRouter := mainRouter.PathPrefix("/bbs").Subrouter()
-	bbsRouter.HandleFunc("/messages", we.wrapFunc(we.bbsApisHandler.SendMessages, we.auth.bbs.Permissions)).Methods("POST")
+	bbsRouter.HandleFunc("/messages", we.wrapFunc(we.rateLimited(we.bbsApisHandler.SendMessages), we.auth.bbs.Permissions)).Methods("POST")
 	bbsRouter.HandleFunc("/messages", we.wrapFunc(we.bbsApisHandler.DeleteMessages, we.auth.bbs.Permissions)).Methods("DELETE")
 	bbsRouter.HandleFunc("/messages/{message-id}/recipients", we.wrapFunc(we.bbsApisHandler.AddRecipients, we.auth.bbs.Permissions)).Methods("POST")
 	bbsRouter.HandleFunc("/messages/{message-id}/recipients", we.wrapFunc(we.bbsApisHandler.DeleteRecipients, we.auth.bbs.Permissions)).Methods("DELETE")
 
 	//deprecated
-	bbsRouter.HandleFunc("/message", we.wrapFunc(we.bbsApisHandler.SendMessage, we.auth.bbs.Permissions)).Methods("POST")
+	bbsRouter.HandleFunc("/message", we.wrapFunc(we.rateLimited(we.bbsApisHandler.SendMessage), we.auth.bbs.Permissions)).Methods("POST")
 	bbsRouter.HandleFunc("/message/{id}", we.wrapFunc(we.bbsApisHandler.DeleteMessage, we.auth.bbs.Permissions)).Methods("DELETE")
 	//
 
@@ -141,11 +182,31 @@ func (we Adapter) Start() {
 
 	var handler http.Handler = router
 	if len(we.corsAllowedOrigins) > 0 {
-		handler = webauth.SetupCORS(we.corsAllowedOrigins, we.corsAllowedHeaders, router)
+		handler = we.corsHandler(router)
 	}
 	we.logger.Fatalf("Error serving: %v", http.ListenAndServe(":"+we.port, handler))
 }
 
+// corsHandler allows the CORS origins for the client and the admin APIs and answers their preflight requests. The internal
+// and the BBs APIs are called by the services only so they never allow them, the admin APIs do not allow them when excluded.
+func (we Adapter) corsHandler(router http.Handler) http.Handler {
+	excludedPrefixes := []string{"/notifications/api/int/", "/notifications/api/bbs/", "/notifications/metrics"}
+	if we.corsExcludeAdmin {
+		excludedPrefixes = append(excludedPrefixes, "/notifications/api/admin/")
+	}
+
+	corsRouter := webauth.SetupCORS(we.corsAllowedOrigins, we.corsAllowedHeaders, router)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range excludedPrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				router.ServeHTTP(w, r)
+				return
+			}
+		}
+		corsRouter.ServeHTTP(w, r)
+	})
+}
+
 func (we Adapter) serveDoc(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("access-control-allow-origin", "*")
 
@@ -197,6 +258,14 @@ func (we Adapter) wrapFunc(handler handlerFunc, authorization tokenauth.Handler)
 
 		logObj.RequestReceived()
 
+		//a body which is declared larger than the limit is not read at all
+		if req.ContentLength > we.maxRequestBodyBytes {
+			logObj.SendHTTPResponse(w, bodyTooLargeResponse(logObj, we.maxRequestBodyBytes))
+			return
+		}
+		body := newLimitedBody(w, req.Body, we.maxRequestBodyBytes)
+		req.Body = body
+
 		var response logs.HTTPResponse
 		if authorization != nil {
 			responseStatus, claims, err := authorization.Check(req)
@@ -214,6 +283,11 @@ func (we Adapter) wrapFunc(handler handlerFunc, authorization tokenauth.Handler)
 			response = handler(logObj, req, nil)
 		}
 
+		//the handler fails on its own when the body is larger, but it cannot tell it from an invalid body
+		if body.exceeded {
+			response = bodyTooLargeResponse(logObj, we.maxRequestBodyBytes)
+		}
+
 		logObj.SendHTTPResponse(w, response)
 		logObj.RequestComplete()
 	}
@@ -227,18 +301,30 @@ func NewWebAdapter(host string, port string, app *core.Application, config *mode
 		logger.Fatalf("error parsing docs yaml - %s", err.Error())
 	}
 
-	auth, err := NewAuth(app, config, serviceRegManager)
+	auth, err := NewAuth(app, config, serviceRegManager, logger)
 	if err != nil {
 		logger.Fatalf("error creating auth - %s", err.Error())
 	}
 
 	apisHandler := NewApisHandler(app)
 	adminApisHandler := NewAdminApisHandler(app)
-	internalApisHandler := NewInternalApisHandler(app)
+	internalApisHandler := NewInternalApisHandler(app, auth.internal)
 	bbsApisHandler := NewBBsAPIsHandler(app)
+
+	senderRateLimiter := newSenderRateLimiter(config.RateLimitAllowlist, config.SenderRatePerMin)
+
+	setPaginationLimits(config.DefaultPaginationLimit, config.MaxPaginationLimit)
+	defaultPerDevice = config.DefaultPerDevice
+
+	maxRequestBodyBytes := config.MaxRequestBodyBytes
+	if maxRequestBodyBytes <= 0 {
+		maxRequestBodyBytes = defaultMaxRequestBodyBytes
+	}
+
 	return Adapter{host: host, port: port, cachedYamlDoc: yamlDoc, auth: auth, apisHandler: apisHandler,
 		adminApisHandler: adminApisHandler, internalApisHandler: internalApisHandler, bbsApisHandler: bbsApisHandler,
-		app: app, corsAllowedOrigins: corsAllowedOrigins, corsAllowedHeaders: corsAllowedHeaders, logger: logger}
+		app: app, corsAllowedOrigins: corsAllowedOrigins, corsAllowedHeaders: corsAllowedHeaders, corsExcludeAdmin: config.CORSExcludeAdmin,
+		maxRequestBodyBytes: maxRequestBodyBytes, senderRateLimiter: senderRateLimiter, logger: logger}
 }
 
 // AppListener implements core.ApplicationListener interface
@@ -0,0 +1,63 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		excludeAdmin bool
+		method       string
+		path         string
+		wantCORS     bool
+		wantStatus   int
+	}{
+		{"client preflight", false, http.MethodOptions, "/notifications/api/messages", true, http.StatusNoContent},
+		{"client request", false, http.MethodGet, "/notifications/api/messages", true, http.StatusOK},
+		{"admin preflight", false, http.MethodOptions, "/notifications/api/admin/messages", true, http.StatusNoContent},
+		{"admin preflight excluded", true, http.MethodOptions, "/notifications/api/admin/messages", false, http.StatusOK},
+		{"internal request", false, http.MethodGet, "/notifications/api/int/message", false, http.StatusOK},
+		{"bbs request", false, http.MethodGet, "/notifications/api/bbs/message", false, http.StatusOK},
+		{"metrics request", false, http.MethodGet, "/notifications/metrics", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := Adapter{corsAllowedOrigins: []string{"https://web.example.com"}, corsExcludeAdmin: tt.excludeAdmin}
+			router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", "https://web.example.com")
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			rec := httptest.NewRecorder()
+			adapter.corsHandler(router).ServeHTTP(rec, req)
+
+			if gotCORS := len(rec.Header().Get("Access-Control-Allow-Origin")) > 0; gotCORS != tt.wantCORS {
+				t.Errorf("corsHandler() allow origin %t, want %t", gotCORS, tt.wantCORS)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("corsHandler() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -16,11 +16,11 @@ package web
 
 import (
 	"encoding/json"
-	"errors"
 	"net/http"
 	"notifications/core"
 	"notifications/core/model"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/rokwire/core-auth-library-go/v3/authutils"
@@ -44,23 +44,83 @@ func NewAdminApisHandler(app *core.Application) AdminApisHandler {
 }
 
 // GetTopics Gets all topics
-// @Description Gets all topics
+// @Description Gets all topics with their subscribers counts. The X-Total-Count header gives the count of all topics
 // @Tags Admin
 // @ID AdminGetTopics
+// @Param offset query string false "offset"
+// @Param limit query string false "limit - all topics are given when neither the offset nor the limit is set, otherwise 20 by default and the greater than 500 limits are clamped to 500"
+// @Param sort_by query string false "sort_by - Possible values: name, date_created, subscribers. Default: name"
+// @Param order query string false "order - Possible values: asc, desc. Default: asc"
 // @Success 200 {array} model.Topic
 // @Security AdminUserAuth
 // @Router /admin/topics [get]
 func (h AdminApisHandler) GetTopics(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
-	topics, err := h.app.Services.GetTopics(claims.OrgID, claims.AppID)
+	offset, limit, sortBy, order, ok := getTopicsPagingQueryParams(r)
+	if !ok {
+		return l.HTTPResponseErrorData(logutils.StatusInvalid, logutils.TypeQueryParam, logutils.StringArgs("sort_by"), nil, http.StatusBadRequest, false)
+	}
+
+	topics, total, err := h.app.Admin.AdminGetTopics(claims.OrgID, claims.AppID, offset, limit, sortBy, order)
 	if err != nil {
 		return l.HTTPResponseErrorAction(logutils.ActionGet, "topics", nil, err, http.StatusBadRequest, true)
 	}
+	if topics == nil {
+		topics = []model.Topic{}
+	}
 
 	data, err := json.Marshal(topics)
 	if err != nil {
 		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
 	}
 
+	response := l.HTTPResponseSuccessJSON(data)
+	setTotalCountHeader(&response, total)
+	return response
+}
+
+// GetTopicsAudience Gives how many unique users are subscribed to any or all of the topics
+// @Description Gives how many unique users are subscribed to any of the topics (union) or to all of them (intersection)
+// @Tags Admin
+// @ID AdminGetTopicsAudience
+// @Param topics query string true "topics - comma separated list of topics"
+// @Param mode query string false "mode - Possible values: union, intersection. Default: union"
+// @Success 200 {object} model.TopicsAudience
+// @Security AdminUserAuth
+// @Router /admin/topics/audience [get]
+func (h AdminApisHandler) GetTopicsAudience(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	topicsParam := getStringQueryParam(r, "topics")
+	if topicsParam == nil {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypeQueryParam, logutils.StringArgs("topics"), nil, http.StatusBadRequest, false)
+	}
+	topics := []string{}
+	for _, topic := range strings.Split(*topicsParam, ",") {
+		topic = strings.TrimSpace(topic)
+		if len(topic) > 0 {
+			topics = append(topics, topic)
+		}
+	}
+	if len(topics) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusInvalid, logutils.TypeQueryParam, logutils.StringArgs("topics"), nil, http.StatusBadRequest, false)
+	}
+
+	mode := model.TopicsAudienceModeUnion
+	if modeParam := getStringQueryParam(r, "mode"); modeParam != nil {
+		mode = *modeParam
+	}
+	if mode != model.TopicsAudienceModeUnion && mode != model.TopicsAudienceModeIntersection {
+		return l.HTTPResponseErrorData(logutils.StatusInvalid, logutils.TypeQueryParam, logutils.StringArgs("mode"), nil, http.StatusBadRequest, false)
+	}
+
+	audience, err := h.app.Admin.AdminGetTopicsAudience(claims.OrgID, claims.AppID, topics, mode)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "topics audience", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(audience)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
 	return l.HTTPResponseSuccessJSON(data)
 }
 
@@ -84,7 +144,7 @@ func (h AdminApisHandler) UpdateTopic(l *logs.Log, r *http.Request, claims *toke
 
 	_, err = h.app.Services.UpdateTopic(topic)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "topic", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "topic", nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(topic)
@@ -95,6 +155,153 @@ func (h AdminApisHandler) UpdateTopic(l *logs.Log, r *http.Request, claims *toke
 	return l.HTTPResponseSuccessJSON(data)
 }
 
+// renameTopicRequestBody rename topic request body
+type renameTopicRequestBody struct {
+	Name string `json:"name"`
+} // @name renameTopicRequestBody
+
+// RenameTopic Renames a topic
+// @Description Renames a topic. The subscribers and the messages are migrated to the new name and the old name is kept as an alias.
+// @Tags Admin
+// @ID RenameTopic
+// @Param name path string true "name"
+// @Param data body renameTopicRequestBody true "body json"
+// @Success 200 {object} model.Topic
+// @Security AdminUserAuth
+// @Router /admin/topic/{name}/rename [post]
+func (h AdminApisHandler) RenameTopic(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	name := params["name"]
+	if len(name) <= 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("name"), nil, http.StatusBadRequest, false)
+	}
+
+	var requestData renameTopicRequestBody
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDecode, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
+	}
+	if len(requestData.Name) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypeRequestBody, logutils.StringArgs("name"), nil, http.StatusBadRequest, false)
+	}
+
+	topic, err := h.app.Admin.AdminRenameTopic(l, claims.OrgID, claims.AppID, name, requestData.Name)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "topic", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(topic)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// GetTopicSubscribers Gets the ids of the users subscribed to a topic
+// @Description Gets the ids of the users subscribed to a topic ordered by the user id. The X-Total-Count header gives the count of all subscribers
+// @Tags Admin
+// @ID AdminGetTopicSubscribers
+// @Param name path string true "name"
+// @Param offset query string false "offset"
+// @Param limit query string false "limit - 20 by default, the greater than 500 limits are clamped to 500. Both are configurable"
+// @Success 200 {array} string
+// @Security AdminUserAuth
+// @Router /admin/topic/{name}/subscribers [get]
+func (h AdminApisHandler) GetTopicSubscribers(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	name := params["name"]
+	if len(name) <= 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("name"), nil, http.StatusBadRequest, false)
+	}
+
+	offset := getInt64QueryParam(r, "offset")
+	limit := getLimitQueryParam(r)
+
+	userIDs, total, err := h.app.Admin.AdminGetTopicSubscribers(claims.OrgID, claims.AppID, name, offset, limit)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "topic subscribers", nil, err, errorStatus(err), true)
+	}
+	if userIDs == nil {
+		userIDs = []string{}
+	}
+
+	data, err := json.Marshal(userIDs)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	response := l.HTTPResponseSuccessJSON(data)
+	setTotalCountHeader(&response, total)
+	return response
+}
+
+// GetTopicStats Gets the sending activity of a topic
+// @Description Gets the count of the messages sent to the topic, when the last one was sent and the average recipients per message
+// @Tags Admin
+// @ID AdminGetTopicStats
+// @Param name path string true "name"
+// @Param start_date query string false "start_date - Start date filter in milliseconds as an integer epoch value"
+// @Param end_date query string false "end_date - End date filter in milliseconds as an integer epoch value"
+// @Success 200 {object} model.TopicStats
+// @Security AdminUserAuth
+// @Router /admin/topic/{name}/stats [get]
+func (h AdminApisHandler) GetTopicStats(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	name := params["name"]
+	if len(name) <= 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("name"), nil, http.StatusBadRequest, false)
+	}
+
+	startDateFilter := getInt64QueryParam(r, "start_date")
+	endDateFilter := getInt64QueryParam(r, "end_date")
+
+	stats, err := h.app.Admin.AdminGetTopicStats(claims.OrgID, claims.AppID, name, startDateFilter, endDateFilter)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "topic stats", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// DeleteTopic Deletes a topic
+// @Description Deletes a topic and unsubscribes all users from it. A topic with messages from the last 7 days is deleted only with force=true. Gives the count of the affected users
+// @Tags Admin
+// @ID DeleteTopic
+// @Param name path string true "name"
+// @Param force query boolean false "force - delete the topic even if it has recent messages"
+// @Success 200 {object} model.TopicDeletion
+// @Security AdminUserAuth
+// @Router /admin/topic/{name} [delete]
+func (h AdminApisHandler) DeleteTopic(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	name := params["name"]
+	if len(name) <= 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("name"), nil, http.StatusBadRequest, false)
+	}
+
+	force := false
+	if forceParam := getBoolQueryParam(r, "force"); forceParam != nil {
+		force = *forceParam
+	}
+
+	deletion, err := h.app.Admin.AdminDeleteTopic(l, claims.OrgID, claims.AppID, name, force)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDelete, "topic", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(deletion)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
 // GetMessages Gets all messages. This api may be invoked with different filters in the query string
 // @Description Gets all messages
 // @Tags Admin
@@ -102,7 +309,7 @@ func (h AdminApisHandler) UpdateTopic(l *logs.Log, r *http.Request, claims *toke
 // @Param user query string false "user - filter by user"
 // @Param topic query string false "topic - filter by topic"
 // @Param offset query string false "offset"
-// @Param limit query string false "limit - limit the result"
+// @Param limit query string false "limit - 20 by default, the greater than 500 limits are clamped to 500. Both are configurable"
 // @Param order query string false "order - Possible values: asc, desc. Default: desc"
 // @Param start_date query string false "start_date - Start date filter in milliseconds as an integer epoch value"
 // @Param end_date query string false "end_date - End date filter in milliseconds as an integer epoch value"
@@ -115,7 +322,7 @@ func (h AdminApisHandler) GetMessages(l *logs.Log, r *http.Request, claims *toke
 	/*userIDFilter := getStringQueryParam(r, "user")
 	topicFilter := getStringQueryParam(r, "topic")
 	offsetFilter := getInt64QueryParam(r, "offset")
-	limitFilter := getInt64QueryParam(r, "limit")
+	limitFilter := getLimitQueryParam(r)
 	orderFilter := getStringQueryParam(r, "order")
 	startDateFilter := getInt64QueryParam(r, "start_date")
 	endDateFilter := getInt64QueryParam(r, "end_date")
@@ -124,7 +331,7 @@ func (h AdminApisHandler) GetMessages(l *logs.Log, r *http.Request, claims *toke
 
 	messages, err := h.app.Services.GetMessages(claims.OrgID, claims.AppID, userIDFilter, read, mute, nil, startDateFilter, endDateFilter, topicFilter, offsetFilter, limitFilter, orderFilter)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionGet, "messages", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "messages", nil, err, errorStatus(err), true)
 	}
 
 	if messages == nil {
@@ -146,7 +353,8 @@ func (h AdminApisHandler) CreateMessage(l *logs.Log, r *http.Request, claims *to
 	if err != nil {
 		return l.HTTPResponseErrorAction(logutils.ActionDecode, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
 	}
-	if len(inputData.Body) == 0 {
+	//the silent messages are data only
+	if len(inputData.Body) == 0 && (inputData.Silent == nil || !*inputData.Silent) {
 		return l.HTTPResponseErrorAction(logutils.ActionGet, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
 	}
 
@@ -158,13 +366,55 @@ func (h AdminApisHandler) CreateMessage(l *logs.Log, r *http.Request, claims *to
 	inputMessage.OrgID = orgID
 	inputMessage.AppID = appID
 	inputMessage.Sender = sender
+	inputMessage.IdempotencyKey = getIdempotencyKey(r)
 
-	message, err := h.app.Services.CreateMessage(inputMessage)
+	var message *model.Message
+	if isAsyncCreate(r) {
+		message, err = h.app.Services.CreateMessageAsync(r.Context(), inputMessage)
+	} else {
+		message, err = h.app.Services.CreateMessage(r.Context(), inputMessage)
+	}
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionCreate, "message", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionCreate, "message", nil, err, errorStatus(err), true)
 	}
 
-	data, err := json.Marshal(message)
+	return createMessageResponse(l, message)
+}
+
+// CreateMessagesBulk Creates many messages in one request
+// @Description Creates many distinct messages in one request. The valid messages are created even if some of the messages fail, every message gets its own result
+// @Tags Admin
+// @ID AdminCreateMessagesBulk
+// @Accept  json
+// @Success 200 {array} model.BulkMessageResult
+// @Security AdminUserAuth
+// @Router /admin/messages/bulk [post]
+func (h AdminApisHandler) CreateMessagesBulk(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	var requestData Def.AdminReqCreateMessagesBulk
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDecode, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
+	}
+	if len(requestData.Messages) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypeRequestBody, logutils.StringArgs("messages"), nil, http.StatusBadRequest, false)
+	}
+
+	sender := model.Sender{Type: "administrative", User: &model.CoreAccountRef{UserID: claims.Subject, Name: claims.Name}}
+	inputMessages := make([]model.InputMessage, len(requestData.Messages))
+	for i, m := range requestData.Messages {
+		inputMessage := getMessageData(m)
+		inputMessage.OrgID = claims.OrgID
+		inputMessage.AppID = claims.AppID
+		inputMessage.Sender = sender
+		inputMessages[i] = inputMessage
+	}
+
+	results, err := h.app.Admin.AdminCreateMessagesBulk(r.Context(), inputMessages)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionCreate, "messages", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(results)
 	if err != nil {
 		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
 	}
@@ -172,6 +422,67 @@ func (h AdminApisHandler) CreateMessage(l *logs.Log, r *http.Request, claims *to
 	return l.HTTPResponseSuccessJSON(data)
 }
 
+// Broadcast Sends a message to all the users with a token
+// @Description Sends a message to all the users with a token in the background. A dry run gives the audience size and the confirmation token which the broadcast requires
+// @Tags Admin
+// @ID AdminBroadcast
+// @Param dry_run query boolean false "dry_run - give the audience size and the confirmation token without sending the broadcast. Default: false"
+// @Accept  json
+// @Success 200 {object} model.BroadcastPreview
+// @Success 202 {object} model.Message
+// @Security AdminUserAuth
+// @Router /admin/broadcast [post]
+func (h AdminApisHandler) Broadcast(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	var requestData Def.AdminReqBroadcast
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDecode, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
+	}
+	if len(requestData.Body) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypeRequestBody, logutils.StringArgs("body"), nil, http.StatusBadRequest, false)
+	}
+
+	if dryRun := getBoolQueryParam(r, "dry_run"); dryRun != nil && *dryRun {
+		preview, err := h.app.Admin.AdminPreviewBroadcast(claims.OrgID, claims.AppID, requestData.Subject, requestData.Body)
+		if err != nil {
+			return l.HTTPResponseErrorAction(logutils.ActionGet, "broadcast audience", nil, err, errorStatus(err), true)
+		}
+
+		data, err := json.Marshal(preview)
+		if err != nil {
+			return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+		}
+		return l.HTTPResponseSuccessJSON(data)
+	}
+
+	if requestData.ConfirmationToken == nil || len(*requestData.ConfirmationToken) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypeRequestBody, logutils.StringArgs("confirmation_token"), nil, http.StatusBadRequest, false)
+	}
+
+	inputMessage := model.InputMessage{OrgID: claims.OrgID, AppID: claims.AppID, Subject: requestData.Subject, Body: requestData.Body,
+		Sender: model.Sender{Type: "administrative", User: &model.CoreAccountRef{UserID: claims.Subject, Name: claims.Name}}, PerDevice: defaultPerDevice}
+	if requestData.Data != nil {
+		inputMessage.Data = *requestData.Data
+	}
+	if requestData.Priority != nil {
+		inputMessage.Priority = *requestData.Priority
+	}
+
+	message, err := h.app.Admin.AdminBroadcastMessage(inputMessage, *requestData.ConfirmationToken)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionSend, "broadcast", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	response := l.HTTPResponseSuccessJSON(data)
+	response.ResponseCode = http.StatusAccepted
+	return response
+}
+
 // UpdateMessage Updates a message
 // @Description Updates a message
 // @Tags Admin
@@ -182,13 +493,13 @@ func (h AdminApisHandler) CreateMessage(l *logs.Log, r *http.Request, claims *to
 // @Security AdminUserAuth
 // @Router /admin/message [put]
 func (h AdminApisHandler) UpdateMessage(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
-	/*var message *model.Message
+	var message *model.Message
 	err := json.NewDecoder(r.Body).Decode(&message)
 	if err != nil {
 		return l.HTTPResponseErrorAction(logutils.ActionDecode, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
 	}
 
-	if message.ID == nil {
+	if message == nil || len(message.ID) == 0 {
 		return l.HTTPResponseErrorData(logutils.StatusMissing, "message id", nil, nil, http.StatusBadRequest, false)
 	}
 
@@ -197,7 +508,7 @@ func (h AdminApisHandler) UpdateMessage(l *logs.Log, r *http.Request, claims *to
 
 	message, err = h.app.Services.UpdateMessage(&claims.Subject, message)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "message", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "message", nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(message)
@@ -205,16 +516,15 @@ func (h AdminApisHandler) UpdateMessage(l *logs.Log, r *http.Request, claims *to
 		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
 	}
 
-	return l.HTTPResponseSuccessJSON(data) */
-
-	return l.HTTPResponseError("disabled api", errors.New("disabled api"), 500, true)
+	return l.HTTPResponseSuccessJSON(data)
 }
 
 // GetMessage Retrieves a message by id
-// @Description Retrieves a message by id
+// @Description Retrieves a message by id with its recipients delivery status
 // @Tags Admin
 // @ID GetMessage
 // @Param id path string true "id"
+// @Param include_deleted query boolean false "include_deleted - give the message even if it has been deleted. Default: false"
 // @Accept  json
 // @Produce plain
 // @Success 200 {object} model.Message
@@ -227,9 +537,11 @@ func (h AdminApisHandler) GetMessage(l *logs.Log, r *http.Request, claims *token
 		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("id"), nil, http.StatusBadRequest, false)
 	}
 
-	message, err := h.app.Services.GetMessage(claims.OrgID, claims.AppID, id)
+	includeDeleted := getBoolQueryParam(r, "include_deleted")
+
+	message, err := h.app.Admin.AdminGetMessage(claims.OrgID, claims.AppID, id, includeDeleted != nil && *includeDeleted)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionGet, "message", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "message", nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(message)
@@ -240,8 +552,220 @@ func (h AdminApisHandler) GetMessage(l *logs.Log, r *http.Request, claims *token
 	return l.HTTPResponseSuccessJSON(data)
 }
 
+// GetMessageStatus Retrieves the send state of a message
+// @Description Retrieves the send state of a message - pending, sending or complete, and its delivery summary
+// @Tags Admin
+// @ID GetMessageStatus
+// @Param id path string true "id"
+// @Accept  json
+// @Produce plain
+// @Success 200 {object} model.MessageStatus
+// @Security AdminUserAuth
+// @Router /admin/message/{id}/status [get]
+func (h AdminApisHandler) GetMessageStatus(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	id := params["id"]
+	if len(id) <= 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("id"), nil, http.StatusBadRequest, false)
+	}
+
+	status, err := h.app.Admin.AdminGetMessageStatus(claims.OrgID, claims.AppID, id)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "message status", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// GetMessageDeliveries Retrieves the delivery log of a message
+// @Description Retrieves the attempts to send a message to its recipients in the order they were made. The X-Total-Count header gives the count of all attempts
+// @Tags Admin
+// @ID GetMessageDeliveries
+// @Param id path string true "id"
+// @Param offset query string false "offset"
+// @Param limit query string false "limit - 20 by default, the greater than 500 limits are clamped to 500. Both are configurable"
+// @Accept  json
+// @Produce plain
+// @Success 200 {array} model.DeliveryLog
+// @Security AdminUserAuth
+// @Router /admin/message/{id}/deliveries [get]
+func (h AdminApisHandler) GetMessageDeliveries(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	id := params["id"]
+	if len(id) <= 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("id"), nil, http.StatusBadRequest, false)
+	}
+
+	offset := getInt64QueryParam(r, "offset")
+	limit := getLimitQueryParam(r)
+
+	deliveries, total, err := h.app.Admin.AdminGetMessageDeliveries(claims.OrgID, claims.AppID, id, offset, limit)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "message deliveries", nil, err, errorStatus(err), true)
+	}
+	if deliveries == nil {
+		deliveries = []model.DeliveryLog{}
+	}
+
+	data, err := json.Marshal(deliveries)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	response := l.HTTPResponseSuccessJSON(data)
+	setTotalCountHeader(&response, total)
+	return response
+}
+
+// ApproveMessage Approves a message which is pending approval so that it is sent
+// @Description Approves a message which is pending approval so that it is sent. The message sender cannot approve it
+// @Tags Admin
+// @ID ApproveMessage
+// @Param id path string true "id"
+// @Accept  json
+// @Produce plain
+// @Success 200 {object} model.Message
+// @Security AdminUserAuth
+// @Router /admin/message/{id}/approve [post]
+func (h AdminApisHandler) ApproveMessage(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	id := params["id"]
+	if len(id) <= 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("id"), nil, http.StatusBadRequest, false)
+	}
+
+	approver := model.CoreAccountRef{UserID: claims.Subject, Name: claims.Name}
+	message, err := h.app.Admin.AdminApproveMessage(claims.OrgID, claims.AppID, id, approver)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "message approval", nil, err, errorStatus(err), true)
+	}
+	if message == nil {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, "message", &logutils.FieldArgs{"id": id}, nil, http.StatusNotFound, false)
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// RecallMessage Recalls a message so that it is removed for all its recipients
+// @Description Recalls a message. It is removed from the recipients messages, its unsent pushes are dropped and the clients get a silent push with the recalled message id
+// @Tags Admin
+// @ID RecallMessage
+// @Param id path string true "id"
+// @Accept  json
+// @Produce plain
+// @Success 200 {object} model.Message
+// @Security AdminUserAuth
+// @Router /admin/message/{id}/recall [post]
+func (h AdminApisHandler) RecallMessage(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	id := params["id"]
+	if len(id) <= 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("id"), nil, http.StatusBadRequest, false)
+	}
+
+	message, err := h.app.Admin.AdminRecallMessage(claims.OrgID, claims.AppID, id)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "message recall", nil, err, errorStatus(err), true)
+	}
+	if message == nil {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, "message", &logutils.FieldArgs{"id": id}, nil, http.StatusNotFound, false)
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// ResendMessage Sends a message again to all its recipients or to the failed ones
+// @Description Sends a sent message again. The device tokens are found again and the recipients delivery statuses are replaced. The messages older than the resend window are not resent unless forced
+// @Tags Admin
+// @ID ResendMessage
+// @Param id path string true "id"
+// @Param only_failed query string false "only_failed - resend to the recipients for which the delivery has failed only. Default: false"
+// @Param force query string false "force - resend the message even if it is older than the resend window. Default: false"
+// @Accept  json
+// @Produce plain
+// @Success 200 {object} model.Message
+// @Security AdminUserAuth
+// @Router /admin/message/{id}/resend [post]
+func (h AdminApisHandler) ResendMessage(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	id := params["id"]
+	if len(id) <= 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("id"), nil, http.StatusBadRequest, false)
+	}
+
+	onlyFailed := getBoolQueryParam(r, "only_failed")
+	force := getBoolQueryParam(r, "force")
+
+	message, err := h.app.Admin.AdminResendMessage(claims.OrgID, claims.AppID, id, onlyFailed != nil && *onlyFailed, force != nil && *force)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionSend, "message", nil, err, errorStatus(err), true)
+	}
+	if message == nil {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, "message", &logutils.FieldArgs{"id": id}, nil, http.StatusNotFound, false)
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// PreviewMessageFor Gives how a draft message is rendered for a sample recipient
+// @Description Gives how a draft message is rendered for a sample recipient. The message is not created
+// @Tags Admin
+// @ID PreviewMessageFor
+// @Accept  json
+// @Produce plain
+// @Success 200 {object} model.MessagePreview
+// @Security AdminUserAuth
+// @Router /admin/message/preview-for [post]
+func (h AdminApisHandler) PreviewMessageFor(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	var requestData Def.AdminReqPreviewMessageFor
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDecode, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
+	}
+	if len(requestData.Recipient.UserId) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypeRequestBody, logutils.StringArgs("recipient.user_id"), nil, http.StatusBadRequest, false)
+	}
+
+	inputMessage := getMessageData(requestData.Message)
+	inputMessage.OrgID = claims.OrgID
+	inputMessage.AppID = claims.AppID
+	recipients := messagesRecipientsListFromDef([]Def.SharedReqCreateMessageInputMessageRecipient{requestData.Recipient})
+
+	preview, err := h.app.Admin.AdminPreviewMessageFor(inputMessage, recipients[0])
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "message preview", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(preview)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
 // DeleteMessage Deletes a message with id
-// @Description Deletes a message with id
+// @Description Deletes a message with id. The message is kept and hidden from its recipients and the admin queries until it is restored
 // @Tags Admin
 // @ID DeleteMessage
 // @Param id path string true "id"
@@ -257,9 +781,64 @@ func (h AdminApisHandler) DeleteMessage(l *logs.Log, r *http.Request, claims *to
 		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("id"), nil, http.StatusBadRequest, false)
 	}
 
-	err := h.app.Services.DeleteMessage(claims.OrgID, claims.AppID, id)
+	err := h.app.Admin.AdminDeleteMessage(claims.OrgID, claims.AppID, id)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionDelete, "message", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionDelete, "message", nil, err, errorStatus(err), true)
+	}
+
+	return l.HTTPResponseSuccess()
+}
+
+// RestoreMessage Restores a deleted message
+// @Description Restores a deleted message so that it is shown to its recipients again
+// @Tags Admin
+// @ID RestoreMessage
+// @Param id path string true "id"
+// @Accept  json
+// @Produce plain
+// @Success 200 {object} model.Message
+// @Security AdminUserAuth
+// @Router /admin/message/{id}/restore [post]
+func (h AdminApisHandler) RestoreMessage(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	id := params["id"]
+	if len(id) <= 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("id"), nil, http.StatusBadRequest, false)
+	}
+
+	message, err := h.app.Admin.AdminRestoreMessage(claims.OrgID, claims.AppID, id)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "message restore", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// PurgeMessage Deletes a message permanently
+// @Description Deletes a message permanently with its recipients and the pushes which have not been sent yet, whether it has been deleted or not
+// @Tags Admin
+// @ID PurgeMessage
+// @Param id path string true "id"
+// @Accept  json
+// @Produce plain
+// @Success 200
+// @Security AdminUserAuth
+// @Router /admin/message/{id}/purge [delete]
+func (h AdminApisHandler) PurgeMessage(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	id := params["id"]
+	if len(id) <= 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("id"), nil, http.StatusBadRequest, false)
+	}
+
+	err := h.app.Admin.AdminPurgeMessage(claims.OrgID, claims.AppID, id)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDelete, "message", nil, err, errorStatus(err), true)
 	}
 
 	return l.HTTPResponseSuccess()
@@ -276,7 +855,7 @@ func (h AdminApisHandler) DeleteMessage(l *logs.Log, r *http.Request, claims *to
 func (h AdminApisHandler) GetAllAppVersions(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
 	appVersions, err := h.app.Services.GetAllAppVersions(claims.OrgID, claims.AppID)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionGet, "app versions", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "app versions", nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(appVersions)
@@ -298,7 +877,7 @@ func (h AdminApisHandler) GetAllAppVersions(l *logs.Log, r *http.Request, claims
 func (h AdminApisHandler) GetAllAppPlatforms(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
 	appPlatforms, err := h.app.Services.GetAllAppPlatforms(claims.OrgID, claims.AppID)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionGet, "app platforms", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "app platforms", nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(appPlatforms)
@@ -321,14 +900,22 @@ func (h AdminApisHandler) GetMessagesStats(l *logs.Log, r *http.Request, claims
 		return l.HTTPResponseErrorData(logutils.MessageDataStatus(logutils.StatusError), logutils.TypePathParam, logutils.StringArgs("source"), nil, http.StatusBadRequest, false)
 	}
 
-	//offset, limit and order
+	//source app filter
+	sourceApp := getStringQueryParam(r, "source_app")
+	includeDeleted := getBoolQueryParam(r, "include_deleted")
+
+	//offset, limit, order and order by
 	offset := getInt64QueryParam(r, "offset")
-	limit := getInt64QueryParam(r, "limit")
+	limit := getLimitQueryParam(r)
 	order := getStringQueryParam(r, "order")
+	orderBy, ok := getOrderByQueryParam(r)
+	if !ok {
+		return l.HTTPResponseErrorData(logutils.StatusInvalid, logutils.TypeQueryParam, logutils.StringArgs("order_by"), nil, http.StatusBadRequest, false)
+	}
 
-	messagesStatsData, err := h.app.Admin.AdminGetMessagesStats(claims.OrgID, claims.AppID, claims.Subject, source, offset, limit, order)
+	messagesStatsData, err := h.app.Admin.AdminGetMessagesStats(r.Context(), claims.OrgID, claims.AppID, claims.Subject, source, sourceApp, includeDeleted != nil && *includeDeleted, offset, limit, order, orderBy)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionGet, "messages stats", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "messages stats", nil, err, errorStatus(err), true)
 	}
 
 	//prepare the result
@@ -348,13 +935,17 @@ func (h AdminApisHandler) GetMessagesStats(l *logs.Log, r *http.Request, claims
 
 		//create response item
 		messageID := message.ID
-		dateCreated := message.DateCreated.UTC().Format(time.RFC3339Nano)
+		dateCreated := ""
+		if message.DateCreated != nil {
+			dateCreated = message.DateCreated.UTC().Format(time.RFC3339Nano)
+		}
 		time := message.Time.UTC().Format(time.RFC3339Nano)
 
-		sender := message.Sender.User
-		sentByItem := Def.AdminResGetMessagesStatsSentByItem{
-			AccountId: sender.UserID,
-			Name:      &sender.Name,
+		//the system messages and the older records do not have a sender user
+		sentByItem := Def.AdminResGetMessagesStatsSentByItem{}
+		if sender := message.Sender.User; sender != nil {
+			sentByItem.AccountId = sender.UserID
+			sentByItem.Name = &sender.Name
 		}
 		title := message.Subject
 		body := message.Body
@@ -384,6 +975,180 @@ func (h AdminApisHandler) GetMessagesStats(l *logs.Log, r *http.Request, claims
 	return l.HTTPResponseSuccessJSON(data)
 }
 
+// GetDeadTokens Gives the device tokens reported as invalid by the push providers
+// @Description Gives the device tokens reported as invalid by the push providers which are pending removal, the most failed first
+// @Tags Admin
+// @ID AdminGetDeadTokens
+// @Param min_failures query integer false "min_failures - the minimum invalid token failures. Default: 1"
+// @Success 200 {array} model.DeadDeviceToken
+// @Security AdminUserAuth
+// @Router /admin/tokens/dead [get]
+func (h AdminApisHandler) GetDeadTokens(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	minFailures, ok := getMinFailuresQueryParam(r)
+	if !ok {
+		return l.HTTPResponseErrorData(logutils.StatusInvalid, logutils.TypeQueryParam, logutils.StringArgs("min_failures"), nil, http.StatusBadRequest, false)
+	}
+
+	deadTokens, err := h.app.Admin.AdminGetDeadTokens(claims.OrgID, claims.AppID, minFailures)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "dead device tokens", nil, err, errorStatus(err), true)
+	}
+	if deadTokens == nil {
+		deadTokens = []model.DeadDeviceToken{}
+	}
+
+	data, err := json.Marshal(deadTokens)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// PurgeDeadTokens Removes the device tokens reported as invalid by the push providers
+// @Description Removes the device tokens reported as invalid by the push providers without waiting for the failures limit. Gives the removed tokens
+// @Tags Admin
+// @ID AdminPurgeDeadTokens
+// @Param min_failures query integer false "min_failures - the minimum invalid token failures. Default: 1"
+// @Success 200 {array} model.DeadDeviceToken
+// @Security AdminUserAuth
+// @Router /admin/tokens/dead [delete]
+func (h AdminApisHandler) PurgeDeadTokens(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	minFailures, ok := getMinFailuresQueryParam(r)
+	if !ok {
+		return l.HTTPResponseErrorData(logutils.StatusInvalid, logutils.TypeQueryParam, logutils.StringArgs("min_failures"), nil, http.StatusBadRequest, false)
+	}
+
+	deadTokens, err := h.app.Admin.AdminPurgeDeadTokens(l, claims.OrgID, claims.AppID, minFailures)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDelete, "dead device tokens", nil, err, errorStatus(err), true)
+	}
+	if deadTokens == nil {
+		deadTokens = []model.DeadDeviceToken{}
+	}
+
+	data, err := json.Marshal(deadTokens)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// GetUser Gives a user with its device tokens and topics
+// @Description Gives a user with its device tokens and topics in order to find out why the user does not receive the pushes. The tokens are masked to their last 6 characters unless reveal is true
+// @Tags Admin
+// @ID AdminGetUser
+// @Param user_id path string true "user_id"
+// @Param reveal query boolean false "reveal - give the full device tokens"
+// @Success 200 {object} model.User
+// @Security AdminUserAuth
+// @Router /admin/user/{user_id} [get]
+func (h AdminApisHandler) GetUser(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	userID := params["user_id"]
+	if len(userID) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("user_id"), nil, http.StatusBadRequest, false)
+	}
+
+	user, err := h.app.Admin.AdminGetUser(claims.OrgID, claims.AppID, userID)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "user", nil, err, errorStatus(err), true)
+	}
+	if user == nil {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, "user", &logutils.FieldArgs{"user_id": userID}, nil, http.StatusNotFound, false)
+	}
+
+	result := *user
+	reveal := getBoolQueryParam(r, "reveal")
+	if reveal == nil || !*reveal {
+		result = user.WithMaskedTokens()
+	} else {
+		l.Infof("admin %s revealed the device tokens of user %s", claims.Subject, userID)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// DeleteUserToken Removes a stale device token of a user
+// @Description Removes a stale device token of a user. The full token must be given
+// @Tags Admin
+// @ID AdminDeleteUserToken
+// @Param user_id path string true "user_id"
+// @Param token path string true "token"
+// @Success 200
+// @Security AdminUserAuth
+// @Router /admin/user/{user_id}/token/{token} [delete]
+func (h AdminApisHandler) DeleteUserToken(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	userID := params["user_id"]
+	if len(userID) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("user_id"), nil, http.StatusBadRequest, false)
+	}
+	token := params["token"]
+	if len(token) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("token"), nil, http.StatusBadRequest, false)
+	}
+
+	removed, err := h.app.Admin.AdminRemoveUserToken(l, claims.OrgID, claims.AppID, userID, token)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDelete, "device token", nil, err, errorStatus(err), true)
+	}
+	if !removed {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, "device token", &logutils.FieldArgs{"user_id": userID}, nil, http.StatusNotFound, false)
+	}
+
+	return l.HTTPResponseSuccess()
+}
+
+// UnsubscribeUserFromAllTopics Unsubscribes a user from all topics
+// @Description Unsubscribes a user from all topics. Gives the removed topics
+// @Tags Admin
+// @ID AdminUnsubscribeUserFromAllTopics
+// @Param user_id path string true "user_id"
+// @Success 200 {array} string
+// @Security AdminUserAuth
+// @Router /admin/user/{user_id}/unsubscribe-all [post]
+func (h AdminApisHandler) UnsubscribeUserFromAllTopics(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	userID := params["user_id"]
+	if len(userID) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("user_id"), nil, http.StatusBadRequest, false)
+	}
+
+	topics, err := h.app.Admin.AdminUnsubscribeUserFromAllTopics(l, claims.OrgID, claims.AppID, userID)
+	if err != nil {
+		return l.HTTPResponseErrorAction("unsubscribing", "topics", nil, err, errorStatus(err), true)
+	}
+	if topics == nil {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, "user", &logutils.FieldArgs{"user_id": userID}, nil, http.StatusNotFound, false)
+	}
+
+	data, err := json.Marshal(topics)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// getMinFailuresQueryParam gives the min_failures query param, 1 when it is not set
+func getMinFailuresQueryParam(r *http.Request) (int, bool) {
+	minFailures := getInt64QueryParam(r, "min_failures")
+	if minFailures == nil {
+		return 1, true
+	}
+	if *minFailures < 1 {
+		return 0, false
+	}
+	return int(*minFailures), true
+}
+
 // GetConfig retrieves a config document
 func (h AdminApisHandler) GetConfig(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
 	params := mux.Vars(r)
@@ -394,7 +1159,7 @@ func (h AdminApisHandler) GetConfig(l *logs.Log, r *http.Request, claims *tokena
 
 	config, err := h.app.Services.GetConfig(id, claims)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionGet, model.TypeConfig, nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionGet, model.TypeConfig, nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(config)
@@ -415,7 +1180,7 @@ func (h AdminApisHandler) GetConfigs(l *logs.Log, r *http.Request, claims *token
 
 	configs, err := h.app.Services.GetConfigs(configType, claims)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionGet, model.TypeConfig, nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionGet, model.TypeConfig, nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(configs)
@@ -454,7 +1219,7 @@ func (h AdminApisHandler) CreateConfig(l *logs.Log, r *http.Request, claims *tok
 
 	newConfig, err := h.app.Services.CreateConfig(config, claims)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionCreate, model.TypeConfig, nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionCreate, model.TypeConfig, nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(newConfig)
@@ -491,7 +1256,7 @@ func (h AdminApisHandler) UpdateConfig(l *logs.Log, r *http.Request, claims *tok
 
 	err = h.app.Services.UpdateConfig(config, claims)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionUpdate, model.TypeConfig, nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, model.TypeConfig, nil, err, errorStatus(err), true)
 	}
 
 	return l.HTTPResponseSuccess()
@@ -507,7 +1272,7 @@ func (h AdminApisHandler) DeleteConfig(l *logs.Log, r *http.Request, claims *tok
 
 	err := h.app.Services.DeleteConfig(id, claims)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionDelete, model.TypeConfig, nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionDelete, model.TypeConfig, nil, err, errorStatus(err), true)
 	}
 
 	return l.HTTPResponseSuccess()
@@ -50,8 +50,10 @@ type bbsSendMessageRequestBody struct {
 // @Tags BBs
 // @ID BBsSendMessage
 // @Param data body sendMessageRequestBody true "body json"
+// @Param async query bool false "return 202 with the queued message and create it in the background"
 // @Produce plain
 // @Success 200 {object} model.Message
+// @Success 202 {object} model.Message
 // @Security BBsAuth
 // @Router /bbs/message [post]
 func (h BBsAPIsHandler) SendMessage(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
@@ -79,12 +81,21 @@ func (h BBsAPIsHandler) SendMessage(l *logs.Log, r *http.Request, claims *tokena
 	inputMessage.OrgID = orgID
 	inputMessage.AppID = appID
 	inputMessage.Sender = sender
+	inputMessage.SourceApp = getSourceApp(r, inputMessage.SourceApp)
+
+	if isAsyncCreate(r) {
+		message, err := h.app.Services.CreateMessageAsync(r.Context(), inputMessage)
+		if err != nil {
+			return l.HTTPResponseErrorAction(logutils.ActionSend, "message", nil, err, errorStatus(err), true)
+		}
+		return createMessageResponse(l, message)
+	}
 
 	inputMessages := []model.InputMessage{inputMessage} //only one message
 
-	messages, err := h.app.BBs.BBsCreateMessages(inputMessages, false)
+	messages, err := h.app.BBs.BBsCreateMessages(r.Context(), inputMessages, false)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionSend, "message", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionSend, "message", nil, err, errorStatus(err), true)
 	}
 	if len(messages) == 0 {
 		return l.HTTPResponseErrorData(logutils.MessageDataStatus(logutils.StatusError), "message", nil, nil, http.StatusInternalServerError, false)
@@ -132,13 +143,14 @@ func (h BBsAPIsHandler) SendMessages(l *logs.Log, r *http.Request, claims *token
 		inputMessage.OrgID = m.OrgId
 		inputMessage.AppID = m.AppId
 		inputMessage.Sender = model.Sender{Type: "system", User: &model.CoreAccountRef{UserID: claims.Subject, Name: claims.Name}}
+		inputMessage.SourceApp = getSourceApp(r, inputMessage.SourceApp)
 
 		inputMessages = append(inputMessages, inputMessage)
 	}
 
-	createdMessages, err := h.app.BBs.BBsCreateMessages(inputMessages, isBatch)
+	createdMessages, err := h.app.BBs.BBsCreateMessages(r.Context(), inputMessages, isBatch)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionSend, "message", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionSend, "message", nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(createdMessages)
@@ -160,7 +172,7 @@ func (h BBsAPIsHandler) DeleteMessage(l *logs.Log, r *http.Request, claims *toke
 	messagesIDs := []string{id} // only one
 	err := h.app.BBs.BBsDeleteMessages(l, claims.Subject, messagesIDs)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionDelete, "message", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionDelete, "message", nil, err, errorStatus(err), true)
 	}
 
 	return l.HTTPResponseSuccess()
@@ -179,7 +191,7 @@ func (h BBsAPIsHandler) DeleteMessages(l *logs.Log, r *http.Request, claims *tok
 
 	err := h.app.BBs.BBsDeleteMessages(l, claims.Subject, ids)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionDelete, "message", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionDelete, "message", nil, err, errorStatus(err), true)
 	}
 
 	return l.HTTPResponseSuccess()
@@ -210,7 +222,7 @@ func (h BBsAPIsHandler) SendMail(l *logs.Log, r *http.Request, claims *tokenauth
 
 	err = h.app.BBs.BBsSendMail(mailRequest.ToMail, mailRequest.Subject, mailRequest.Body)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionSend, "email", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionSend, "email", nil, err, errorStatus(err), true)
 	}
 
 	return l.HTTPResponseSuccess()
@@ -243,7 +255,7 @@ func (h BBsAPIsHandler) AddRecipients(l *logs.Log, r *http.Request, claims *toke
 
 	recipientResult, err := h.app.BBs.BBsAddRecipients(l, claims.Subject, messageID, recipients)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionSend, "recipients", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionSend, "recipients", nil, err, errorStatus(err), true)
 	}
 	data, err := json.Marshal(recipientResult)
 	if err != nil {
@@ -273,7 +285,7 @@ func (h BBsAPIsHandler) DeleteRecipients(l *logs.Log, r *http.Request, claims *t
 
 	err = h.app.BBs.BBsDeleteRecipients(l, claims.Subject, messageID, usersIDs)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionSend, "recipients", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionSend, "recipients", nil, err, errorStatus(err), true)
 	}
 	return l.HTTPResponseSuccess()
 }
@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"notifications/core"
@@ -63,6 +64,33 @@ func (h ApisHandler) Version(l *logs.Log, r *http.Request, claims *tokenauth.Cla
 	return l.HTTPResponseSuccessMessage(h.app.Services.GetVersion())
 }
 
+// Health gives the service liveness
+// @Description Gives 200 while the service process is up.
+// @Tags Client
+// @ID Health
+// @Produce plain
+// @Success 200
+// @Router /health [get]
+func (h ApisHandler) Health(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	return l.HTTPResponseSuccessMessage("ok")
+}
+
+// Ready gives the service readiness
+// @Description Gives 200 when the database is reachable and the firebase adapter is initialized, 503 otherwise.
+// @Tags Client
+// @ID Ready
+// @Produce plain
+// @Success 200
+// @Failure 503
+// @Router /ready [get]
+func (h ApisHandler) Ready(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	err := h.app.Services.CheckReadiness()
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionValidate, "readiness", nil, err, http.StatusServiceUnavailable, false)
+	}
+	return l.HTTPResponseSuccessMessage("ok")
+}
+
 // StoreToken Sends a message to a user, list of users or a topic
 // @Description Stores a token and maps it to a idToken if presents
 // @Tags Client
@@ -93,7 +121,7 @@ func (h ApisHandler) StoreToken(l *logs.Log, r *http.Request, claims *tokenauth.
 
 	err = h.app.Services.StoreToken(claims.OrgID, claims.AppID, &tokenInfo, claims.Subject)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionSave, "token", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionSave, "token", nil, err, errorStatus(err), true)
 	}
 
 	return l.HTTPResponseSuccess()
@@ -109,7 +137,7 @@ func (h ApisHandler) StoreToken(l *logs.Log, r *http.Request, claims *tokenauth.
 func (h ApisHandler) GetUser(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
 	userMapping, err := h.app.Services.FindUserByID(claims.OrgID, claims.AppID, claims.Subject, l)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionFind, "user", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionFind, "user", nil, err, errorStatus(err), true)
 	}
 
 	if userMapping == nil {
@@ -146,7 +174,7 @@ func (h ApisHandler) UpdateUser(l *logs.Log, r *http.Request, claims *tokenauth.
 
 	userMapping, err := h.app.Services.UpdateUserByID(claims.OrgID, claims.AppID, claims.Subject, bodyData.NotificationsDisabled)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "user", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "user", nil, err, errorStatus(err), true)
 	}
 
 	if userMapping == nil {
@@ -161,6 +189,79 @@ func (h ApisHandler) UpdateUser(l *logs.Log, r *http.Request, claims *tokenauth.
 	return l.HTTPResponseSuccessJSON(responseData)
 }
 
+// userSettingsRequestBody user settings request body
+type userSettingsRequestBody struct {
+	NotificationsEnabled *bool  `json:"notifications_enabled"`
+	PausedUntil          *int64 `json:"paused_until"` // unix seconds
+
+	QuietHoursStart *int    `json:"quiet_hours_start"`
+	QuietHoursEnd   *int    `json:"quiet_hours_end"`
+	TimeZone        *string `json:"time_zone"`
+} // @name userSettingsRequestBody
+
+// GetUserSettings Gets the current user settings
+// @Description Gets the current user preferences for receiving the pushes
+// @Tags Client
+// @ID GetUserSettings
+// @Success 200 {object} model.UserSettings
+// @Security RokwireAuth UserAuth
+// @Router /user/settings [get]
+func (h ApisHandler) GetUserSettings(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	settings, err := h.app.Services.GetUserSettings(claims.OrgID, claims.AppID, claims.Subject, l)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "user settings", nil, err, errorStatus(err), true)
+	}
+	if settings == nil {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, "user", nil, nil, http.StatusNotFound, false)
+	}
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// UpdateUserSettings Sets the current user settings
+// @Description Sets the current user preferences for receiving the pushes. With disabled notifications or until the pause ends no pushes are sent, the messages are still listed. The notifications stay as they are if not given, empty values clear the pause and the quiet hours. The quiet hours are minutes of the day in the time zone. The pushes within the quiet hours are deferred until they end, except for the messages with priority of 1000 or more
+// @Tags Client
+// @ID UpdateUserSettings
+// @Param data body userSettingsRequestBody true "body json"
+// @Accept  json
+// @Success 200 {object} model.User
+// @Security RokwireAuth UserAuth
+// @Router /user/settings [put]
+func (h ApisHandler) UpdateUserSettings(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	var bodyData userSettingsRequestBody
+	err := json.NewDecoder(r.Body).Decode(&bodyData)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDecode, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
+	}
+
+	var pausedUntil *time.Time
+	if bodyData.PausedUntil != nil {
+		value := time.Unix(*bodyData.PausedUntil, 0).UTC()
+		pausedUntil = &value
+	}
+
+	user, err := h.app.Services.UpdateUserSettings(claims.OrgID, claims.AppID, claims.Subject, bodyData.QuietHoursStart, bodyData.QuietHoursEnd, bodyData.TimeZone,
+		bodyData.NotificationsEnabled, pausedUntil, l)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "user settings", nil, err, errorStatus(err), true)
+	}
+	if user == nil {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, "user", nil, nil, http.StatusNotFound, false)
+	}
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
 // DeleteUser Deletes user record and unlink all messages
 // @Description Deletes user record and unlink all messages
 // @Tags Client
@@ -172,8 +273,162 @@ func (h ApisHandler) UpdateUser(l *logs.Log, r *http.Request, claims *tokenauth.
 func (h ApisHandler) DeleteUser(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
 	err := h.app.Services.DeleteUserWithID(claims.OrgID, claims.AppID, claims.Subject)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionDelete, "user", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionDelete, "user", nil, err, errorStatus(err), true)
+	}
+	return l.HTTPResponseSuccess()
+}
+
+// Heartbeat Marks the current user as active
+// @Description Marks the current user as active. The messages which are sent only to the active users use it.
+// @Tags Client
+// @ID Heartbeat
+// @Success 200
+// @Security RokwireAuth UserAuth
+// @Router /heartbeat [post]
+func (h ApisHandler) Heartbeat(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	err := h.app.Services.Heartbeat(claims.OrgID, claims.AppID, claims.Subject, l)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "user", nil, err, errorStatus(err), true)
+	}
+	return l.HTTPResponseSuccess()
+}
+
+// GetUserMutedTopics Gets the topics muted by the current user
+// @Description Gets the topics muted by the current user
+// @Tags Client
+// @ID GetUserMutedTopics
+// @Success 200 {array} string
+// @Security RokwireAuth UserAuth
+// @Router /preferences/muted-topics [get]
+func (h ApisHandler) GetUserMutedTopics(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	mutedTopics, err := h.app.Services.GetUserMutedTopics(claims.OrgID, claims.AppID, claims.Subject, l)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "muted topics", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(mutedTopics)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// UpdateUserMutedTopics Sets the topics muted by the current user
+// @Description Sets the topics muted by the current user. The messages sent to these topics are received without a push notification.
+// @Tags Client
+// @ID UpdateUserMutedTopics
+// @Param data body []string true "body json"
+// @Accept  json
+// @Success 200 {array} string
+// @Security RokwireAuth UserAuth
+// @Router /preferences/muted-topics [put]
+func (h ApisHandler) UpdateUserMutedTopics(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	var mutedTopics []string
+	err := json.NewDecoder(r.Body).Decode(&mutedTopics)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDecode, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
+	}
+
+	mutedTopics, err = h.app.Services.UpdateUserMutedTopics(claims.OrgID, claims.AppID, claims.Subject, mutedTopics, l)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "muted topics", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(mutedTopics)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// MuteTopic Mutes a topic for the current user
+// @Description Mutes a topic for the current user without unsubscribing. The messages sent to the topic are still received and listed but without a push notification.
+// @Tags Client
+// @ID MuteTopic
+// @Param topic path string true "topic"
+// @Success 200 {array} string
+// @Security RokwireAuth UserAuth
+// @Router /topic/{topic}/mute [post]
+func (h ApisHandler) MuteTopic(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	topic := params["topic"]
+	if len(topic) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("topic"), nil, http.StatusBadRequest, false)
+	}
+
+	mutedTopics, err := h.app.Services.MuteTopic(claims.OrgID, claims.AppID, claims.Subject, topic, l)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "muted topics", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(mutedTopics)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// UnmuteTopic Unmutes a topic for the current user
+// @Description Unmutes a topic for the current user so that the messages sent to the topic are received with a push notification again
+// @Tags Client
+// @ID UnmuteTopic
+// @Param topic path string true "topic"
+// @Success 200 {array} string
+// @Security RokwireAuth UserAuth
+// @Router /topic/{topic}/unmute [post]
+func (h ApisHandler) UnmuteTopic(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	topic := params["topic"]
+	if len(topic) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("topic"), nil, http.StatusBadRequest, false)
+	}
+
+	mutedTopics, err := h.app.Services.UnmuteTopic(claims.OrgID, claims.AppID, claims.Subject, topic, l)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "muted topics", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(mutedTopics)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// tokenPreferencesRequestBody token preferences request body
+type tokenPreferencesRequestBody struct {
+	Token                 string `json:"token"`
+	NotificationsDisabled bool   `json:"notifications_disabled"`
+} // @name tokenPreferencesRequestBody
+
+// UpdateTokenPreferences Sets if the current user receives the pushes on the device of the token
+// @Description Sets if the current user receives the pushes on the device of the token. The user still receives the messages when the pushes are disabled on some devices.
+// @Tags Client
+// @ID UpdateTokenPreferences
+// @Param data body tokenPreferencesRequestBody true "body json"
+// @Accept  json
+// @Success 200
+// @Security RokwireAuth UserAuth
+// @Router /preferences/token [put]
+func (h ApisHandler) UpdateTokenPreferences(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	var body tokenPreferencesRequestBody
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDecode, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
+	}
+	if len(body.Token) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypeRequestBody, logutils.StringArgs("token"), nil, http.StatusBadRequest, false)
+	}
+
+	err = h.app.Services.UpdateTokenPreferences(claims.OrgID, claims.AppID, claims.Subject, body.Token, body.NotificationsDisabled)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "token preferences", nil, err, errorStatus(err), true)
 	}
+
 	return l.HTTPResponseSuccess()
 }
 
@@ -206,7 +461,7 @@ func (h ApisHandler) Subscribe(l *logs.Log, r *http.Request, claims *tokenauth.C
 
 	err = h.app.Services.SubscribeToTopic(claims.OrgID, claims.AppID, token, claims.Subject, claims.Anonymous, topic)
 	if err != nil {
-		return l.HTTPResponseErrorAction("subscribing", "topic", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction("subscribing", "topic", nil, err, errorStatus(err), true)
 	}
 
 	return l.HTTPResponseSuccess()
@@ -240,12 +495,119 @@ func (h ApisHandler) Unsubscribe(l *logs.Log, r *http.Request, claims *tokenauth
 
 	err = h.app.Services.UnsubscribeToTopic(claims.OrgID, claims.AppID, token, claims.Subject, claims.Anonymous, topic)
 	if err != nil {
-		return l.HTTPResponseErrorAction("unsubscribing", "topic", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction("unsubscribing", "topic", nil, err, errorStatus(err), true)
 	}
 
 	return l.HTTPResponseSuccess()
 }
 
+// UnsubscribeFromAllTopics Unsubscribes the current user from all topics
+// @Description Unsubscribes the current user from all topics. Gives the removed topics
+// @Tags Client
+// @ID UnsubscribeFromAllTopics
+// @Success 200 {array} string
+// @Security RokwireAuth UserAuth
+// @Router /topic/unsubscribe/all [post]
+func (h ApisHandler) UnsubscribeFromAllTopics(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	topics, err := h.app.Services.UnsubscribeFromAllTopics(l, claims.OrgID, claims.AppID, claims.Subject, claims.Anonymous)
+	if err != nil {
+		return l.HTTPResponseErrorAction("unsubscribing", "topics", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(topics)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// GetTopicReach Gives how many users a message to the topic would reach
+// @Description Gives how many users a message to the topic would reach. Only the count is given, not the users.
+// @Tags Client
+// @ID GetTopicReach
+// @Param name path string true "name"
+// @Success 200 {object} model.TopicReach
+// @Security RokwireAuth UserAuth
+// @Router /topic/{name}/reach [get]
+func (h ApisHandler) GetTopicReach(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	name := params["name"]
+	if len(name) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("name"), nil, http.StatusBadRequest, false)
+	}
+
+	reach, err := h.app.Services.GetTopicReach(claims.OrgID, claims.AppID, name)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "topic reach", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(reach)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
+// topicSubscriptionsRequestBody bulk topics subscription request body
+type topicSubscriptionsRequestBody struct {
+	Token       *string  `json:"token"`
+	Subscribe   []string `json:"subscribe"`
+	Unsubscribe []string `json:"unsubscribe"`
+} // @name topicSubscriptionsRequestBody
+
+// UpdateTopicSubscriptions Subscribes and unsubscribes the current user to/from a list of topics
+// @Description Subscribes and unsubscribes the current user to/from a list of topics. Gives the user topics after the update and the topics which failed.
+// @Tags Client
+// @ID UpdateTopicSubscriptions
+// @Param data body topicSubscriptionsRequestBody true "body json"
+// @Accept  json
+// @Success 200 {object} model.TopicSubscriptions
+// @Security RokwireAuth UserAuth
+// @Router /topics/subscriptions [post]
+func (h ApisHandler) UpdateTopicSubscriptions(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	var body topicSubscriptionsRequestBody
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDecode, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
+	}
+
+	//a topic cannot be subscribed and unsubscribed at the same time
+	for _, topic := range body.Subscribe {
+		if len(topic) == 0 {
+			return l.HTTPResponseErrorData(logutils.StatusInvalid, "topic", logutils.StringArgs("empty"), nil, http.StatusBadRequest, false)
+		}
+		for _, unsubscribeTopic := range body.Unsubscribe {
+			if topic == unsubscribeTopic {
+				return l.HTTPResponseErrorData(logutils.StatusInvalid, "topic", logutils.StringArgs(topic), nil, http.StatusBadRequest, false)
+			}
+		}
+	}
+	for _, topic := range body.Unsubscribe {
+		if len(topic) == 0 {
+			return l.HTTPResponseErrorData(logutils.StatusInvalid, "topic", logutils.StringArgs("empty"), nil, http.StatusBadRequest, false)
+		}
+	}
+
+	token := ""
+	if body.Token != nil {
+		token = *body.Token
+	}
+
+	subscriptions, err := h.app.Services.UpdateTopicSubscriptions(l, claims.OrgID, claims.AppID, token, claims.Subject, claims.Anonymous, body.Subscribe, body.Unsubscribe)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "topic subscriptions", nil, err, errorStatus(err), true)
+	}
+
+	data, err := json.Marshal(subscriptions)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	return l.HTTPResponseSuccessJSON(data)
+}
+
 // TODO - for now all fields but almost all of them will be removed!
 type getUserMessageResponse struct {
 	OrgID                     string                    `json:"org_id"`
@@ -256,10 +618,12 @@ type getUserMessageResponse struct {
 	Sender                    model.Sender              `json:"sender"`
 	Body                      string                    `json:"body"`
 	Data                      map[string]string         `json:"data"`
+	Attachments               []model.Attachment        `json:"attachments,omitempty"`
 	Recipients                []model.MessageRecipient  `json:"recipients"`
 	RecipientsCriteriaList    []model.RecipientCriteria `json:"recipients_criteria_list"`
 	RecipientAccountCriteria  map[string]interface{}    `json:"recipient_account_criteria"`
 	Topic                     *string                   `json:"topic"`
+	GroupID                   *string                   `json:"group_id,omitempty"`
 	CalculatedRecipientsCount *int                      `json:"calculated_recipients_count"`
 	DateCreated               *time.Time                `json:"date_created"`
 	DateUpdated               *time.Time                `json:"date_updated"`
@@ -269,15 +633,32 @@ type getUserMessageResponse struct {
 	Read bool `json:"read"`
 }
 
+// userMessagesCursorConflicts are the query params which cannot be combined with the since_id cursor paging
+var userMessagesCursorConflicts = []string{"offset", "order", "order_by", "start_date", "end_date", "read", "mute", "has_attachment", "group_id", "priority"}
+
 // GetUserMessages Gets all messages for the user
 func (h ApisHandler) GetUserMessages(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
 	offsetFilter := getInt64QueryParam(r, "offset")
-	limitFilter := getInt64QueryParam(r, "limit")
+	limitFilter := getLimitQueryParam(r)
 	orderFilter := getStringQueryParam(r, "order")
+	orderByFilter, ok := getOrderByQueryParam(r)
+	if !ok {
+		return l.HTTPResponseErrorData(logutils.StatusInvalid, logutils.TypeQueryParam, logutils.StringArgs("order_by"), nil, http.StatusBadRequest, false)
+	}
 	startDateFilter := getInt64QueryParam(r, "start_date")
 	endDateFilter := getInt64QueryParam(r, "end_date")
 	read := getBoolQueryParam(r, "read")
 	mute := getBoolQueryParam(r, "mute")
+	hasAttachment := getBoolQueryParam(r, "has_attachment")
+	groupID := getStringQueryParam(r, "group_id")
+	priority := getInt64QueryParam(r, "priority")
+	if (priority == nil && getStringQueryParam(r, "priority") != nil) || (priority != nil && *priority < 0) {
+		return l.HTTPResponseErrorData(logutils.StatusInvalid, logutils.TypeQueryParam, logutils.StringArgs("priority"), nil, http.StatusBadRequest, false)
+	}
+	fields, ok := getFieldsQueryParam(r, model.UserMessageFields)
+	if !ok {
+		return l.HTTPResponseErrorData(logutils.StatusInvalid, logutils.TypeQueryParam, logutils.StringArgs("fields"), nil, http.StatusBadRequest, false)
+	}
 
 	var messageIDs []string
 	var body getMessagesRequestBody
@@ -286,37 +667,96 @@ func (h ApisHandler) GetUserMessages(l *logs.Log, r *http.Request, claims *token
 		messageIDs = body.IDs
 	}
 
-	recipientsMessages, err := h.app.Services.GetMessagesRecipientsDeep(claims.OrgID, claims.AppID, &claims.Subject, read, mute, messageIDs, startDateFilter, endDateFilter, nil, offsetFilter, limitFilter, orderFilter)
+	//the messages after the since_id message are given the oldest first, the client continues from the next cursor
+	sinceID := getStringQueryParam(r, "since_id")
+	var recipientsMessages []model.MessageRecipient
+	var totalCount int64
+	var nextCursor string
+	if sinceID != nil {
+		for _, param := range userMessagesCursorConflicts {
+			if getStringQueryParam(r, param) != nil {
+				return l.HTTPResponseErrorData(logutils.StatusInvalid, logutils.TypeQueryParam, logutils.StringArgs(param), nil, http.StatusBadRequest, false)
+			}
+		}
+		recipientsMessages, nextCursor, err = h.app.Services.GetUserMessagesSince(r.Context(), claims.OrgID, claims.AppID, claims.Subject, *sinceID, *limitFilter, fields)
+	} else {
+		recipientsMessages, totalCount, err = h.app.Services.GetMessagesRecipientsDeep(r.Context(), claims.OrgID, claims.AppID, &claims.Subject, read, mute, messageIDs, startDateFilter, endDateFilter, nil, hasAttachment, priority, groupID, offsetFilter, limitFilter, orderFilter, orderByFilter, fields)
+	}
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionGet, "messages", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "messages", nil, err, errorStatus(err), true)
 	}
 	result := make([]getUserMessageResponse, len(recipientsMessages))
 	for i, item := range recipientsMessages {
 		message := item.Message
+		subject := message.Subject
+		if item.RenderedSubject != nil {
+			subject = *item.RenderedSubject
+		}
+		body := message.Body
+		if item.RenderedBody != nil {
+			body = *item.RenderedBody
+		}
 
 		respItem := getUserMessageResponse{OrgID: message.OrgID, AppID: message.AppID,
-			ID: message.ID, Priority: message.Priority, Subject: message.Subject,
-			Sender: message.Sender, Body: message.Body, Data: message.Data, Recipients: message.Recipients,
+			ID: message.ID, Priority: message.Priority, Subject: subject,
+			Sender: message.Sender, Body: body, Data: message.Data, Attachments: message.Attachments, Recipients: message.Recipients,
 			RecipientsCriteriaList: message.RecipientsCriteriaList, RecipientAccountCriteria: message.RecipientAccountCriteria,
-			Topic: message.Topic, CalculatedRecipientsCount: message.CalculatedRecipientsCount,
+			Topic: message.Topic, GroupID: message.GroupID, CalculatedRecipientsCount: message.CalculatedRecipientsCount,
 			DateCreated: message.DateCreated, DateUpdated: message.DateUpdated,
 			Mute: item.Mute, Read: item.Read, Time: message.Time}
 		result[i] = respItem
 	}
-	data, err := json.Marshal(result)
+
+	var data []byte
+	if len(fields) > 0 {
+		data, err = marshalUserMessagesFields(result, fields)
+	} else {
+		data, err = json.Marshal(result)
+	}
 	if err != nil {
 		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
 	}
 
-	return l.HTTPResponseSuccessJSON(data)
+	response := l.HTTPResponseSuccessJSON(data)
+	if sinceID != nil {
+		setNextCursorHeader(&response, nextCursor)
+	} else {
+		setTotalCountHeader(&response, totalCount)
+	}
+	return response
+}
+
+// marshalUserMessagesFields gives the user messages json with only the selected fields
+func marshalUserMessagesFields(messages []getUserMessageResponse, fields []string) ([]byte, error) {
+	fullData, err := json.Marshal(messages)
+	if err != nil {
+		return nil, err
+	}
+	var fullMessages []map[string]json.RawMessage
+	err = json.Unmarshal(fullData, &fullMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]map[string]json.RawMessage, len(fullMessages))
+	for i, fullMessage := range fullMessages {
+		message := make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			if value, ok := fullMessage[field]; ok {
+				message[field] = value
+			}
+		}
+		result[i] = message
+	}
+	return json.Marshal(result)
 }
 
 // GetUserMessagesStats Count the messages stats
-// @Description Count the messages stats.
+// @Description Count the total and the unread messages of the user.
 // @Tags Client
 // @ID GetUserMessagesStats
 // @Accept  json
-// @Success 200
+// @Success 200 {object} model.MessagesStats
 // @Security UserAuth
 // @Router /messages/stats[get]
 func (h ApisHandler) GetUserMessagesStats(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
@@ -324,7 +764,7 @@ func (h ApisHandler) GetUserMessagesStats(l *logs.Log, r *http.Request, claims *
 	var unreadMessages *model.MessagesStats
 	unreadMessages, err = h.app.Services.GetMessagesStats(claims.OrgID, claims.AppID, claims.Subject)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionGet, "message stats", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "message stats", nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(unreadMessages)
@@ -336,16 +776,28 @@ func (h ApisHandler) GetUserMessagesStats(l *logs.Log, r *http.Request, claims *
 }
 
 // GetTopics Gets all topics
-// @Description Gets all topics
+// @Description Gets all topics. The X-Total-Count header gives the count of all topics
 // @Tags Client
 // @ID GetTopics
+// @Param offset query string false "offset"
+// @Param limit query string false "limit - all topics are given when neither the offset nor the limit is set, otherwise 20 by default and the greater than 500 limits are clamped to 500"
+// @Param sort_by query string false "sort_by - Possible values: name, date_created, subscribers. Default: name"
+// @Param order query string false "order - Possible values: asc, desc. Default: asc"
 // @Success 200 {array} model.Topic
 // @Security RokwireAuth
 // @Router /topics [get]
 func (h ApisHandler) GetTopics(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
-	topics, err := h.app.Services.GetTopics(claims.OrgID, claims.AppID)
+	offset, limit, sortBy, order, ok := getTopicsPagingQueryParams(r)
+	if !ok {
+		return l.HTTPResponseErrorData(logutils.StatusInvalid, logutils.TypeQueryParam, logutils.StringArgs("sort_by"), nil, http.StatusBadRequest, false)
+	}
+
+	topics, total, err := h.app.Services.GetTopics(claims.OrgID, claims.AppID, offset, limit, sortBy, order)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionGet, "topics", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "topics", nil, err, errorStatus(err), true)
+	}
+	if topics == nil {
+		topics = []model.Topic{}
 	}
 
 	data, err := json.Marshal(topics)
@@ -353,7 +805,9 @@ func (h ApisHandler) GetTopics(l *logs.Log, r *http.Request, claims *tokenauth.C
 		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
 	}
 
-	return l.HTTPResponseSuccessJSON(data)
+	response := l.HTTPResponseSuccessJSON(data)
+	setTotalCountHeader(&response, total)
+	return response
 }
 
 // GetTopicMessages Gets all messages for topic
@@ -362,7 +816,7 @@ func (h ApisHandler) GetTopics(l *logs.Log, r *http.Request, claims *tokenauth.C
 // @ID GetTopicMessages
 // @Param topic path string true "topic"
 // @Param offset query string false "offset"
-// @Param limit query string false "limit - limit the result"
+// @Param limit query string false "limit - 20 by default, the greater than 500 limits are clamped to 500. Both are configurable"
 // @Param order query string false "order - Possible values: asc, desc. Default: desc"
 // @Param start_date query string false "start_date - Start date filter in milliseconds as an integer epoch value"
 // @Param end_date query string false "end_date - End date filter in milliseconds as an integer epoch value"// @Produce plain
@@ -372,7 +826,7 @@ func (h ApisHandler) GetTopics(l *logs.Log, r *http.Request, claims *tokenauth.C
 func (h ApisHandler) GetTopicMessages(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
 	return l.HTTPResponseSuccess()
 	/*offsetFilter := getInt64QueryParam(r, "offset")
-	limitFilter := getInt64QueryParam(r, "limit")
+	limitFilter := getLimitQueryParam(r)
 	orderFilter := getStringQueryParam(r, "order")
 	startDateFilter := getInt64QueryParam(r, "start_date")
 	endDateFilter := getInt64QueryParam(r, "end_date")
@@ -385,7 +839,7 @@ func (h ApisHandler) GetTopicMessages(l *logs.Log, r *http.Request, claims *toke
 
 	messages, err := h.app.Services.GetMessages(claims.OrgID, claims.AppID, nil, nil, nil, nil, startDateFilter, endDateFilter, &topic, offsetFilter, limitFilter, orderFilter)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionGet, "messages", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "messages", nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(messages)
@@ -415,7 +869,7 @@ func (h ApisHandler) GetUserMessage(l *logs.Log, r *http.Request, claims *tokena
 
 	message, err := h.app.Services.GetUserMessage(claims.OrgID, claims.AppID, id, claims.Subject)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionGet, "message", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "message", nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(message)
@@ -436,6 +890,10 @@ func (h ApisHandler) GetUserMessage(l *logs.Log, r *http.Request, claims *tokena
 // @Security UserAuth
 // @Router /messages [delete]
 func (h ApisHandler) DeleteUserMessages(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	if claims == nil || len(claims.Subject) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypeClaim, logutils.StringArgs("sub"), nil, http.StatusBadRequest, false)
+	}
+
 	var messageIDs []string
 	var body getMessagesRequestBody
 	err := json.NewDecoder(r.Body).Decode(&body)
@@ -468,7 +926,9 @@ func (h ApisHandler) DeleteUserMessages(l *logs.Log, r *http.Request, claims *to
 // @ID createMessage
 // @Accept  json
 // @Param data body model.Message true "body json"
+// @Param async query bool false "return 202 with the queued message and create it in the background"
 // @Success 200 {object} model.Message
+// @Success 202 {object} model.Message
 // @Security UserAuth
 // @Router /message [post]
 func (h ApisHandler) CreateMessage(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
@@ -488,17 +948,22 @@ func (h ApisHandler) CreateMessage(l *logs.Log, r *http.Request, claims *tokenau
 	inputMessage.AppID = appID
 	inputMessage.Sender = sender
 
-	message, err := h.app.Services.CreateMessage(inputMessage)
-	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionCreate, "message", nil, err, http.StatusInternalServerError, true)
+	//the users resolved by topics or criteria are not known by the client sender so they must not be exposed
+	if len(inputMessage.Topics) > 0 || len(inputMessage.RecipientsCriteriaList) > 0 || len(inputMessage.RecipientAccountCriteria) > 0 {
+		inputMessage.IncludeFailedRecipients = false
 	}
 
-	data, err := json.Marshal(message)
+	var message *model.Message
+	if isAsyncCreate(r) {
+		message, err = h.app.Services.CreateMessageAsync(r.Context(), inputMessage)
+	} else {
+		message, err = h.app.Services.CreateMessage(r.Context(), inputMessage)
+	}
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionCreate, "message", nil, err, errorStatus(err), true)
 	}
 
-	return l.HTTPResponseSuccessJSON(data)
+	return createMessageResponse(l, message)
 }
 
 // DeleteUserMessage Removes the current user from the recipient list of the message
@@ -511,6 +976,10 @@ func (h ApisHandler) CreateMessage(l *logs.Log, r *http.Request, claims *tokenau
 // @Security UserAuth
 // @Router /message/{id} [delete]
 func (h ApisHandler) DeleteUserMessage(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	if claims == nil || len(claims.Subject) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypeClaim, logutils.StringArgs("sub"), nil, http.StatusBadRequest, false)
+	}
+
 	params := mux.Vars(r)
 	id := params["id"]
 	if len(id) == 0 {
@@ -519,7 +988,7 @@ func (h ApisHandler) DeleteUserMessage(l *logs.Log, r *http.Request, claims *tok
 
 	err := h.app.Services.DeleteUserMessage(claims.OrgID, claims.AppID, claims.Subject, id)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionDelete, "message", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionDelete, "message", nil, err, errorStatus(err), true)
 	}
 
 	return l.HTTPResponseSuccess()
@@ -543,7 +1012,7 @@ func (h ApisHandler) UpdateReadMessage(l *logs.Log, r *http.Request, claims *tok
 
 	message, err := h.app.Services.UpdateReadMessage(claims.OrgID, claims.AppID, id, claims.Subject)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "message read", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "message read", nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(message)
@@ -554,33 +1023,83 @@ func (h ApisHandler) UpdateReadMessage(l *logs.Log, r *http.Request, claims *tok
 	return l.HTTPResponseSuccessJSON(data)
 }
 
+// reportMessageRequestBody report message request body
+type reportMessageRequestBody struct {
+	Reason string `json:"reason"`
+} // @name reportMessageRequestBody
+
+// ReportMessage reports a message as abusive
+// @Description Reports a message as abusive. Only the recipients of the message can report it.
+// @Tags Client
+// @ID ReportMessage
+// @Param id path string true "id"
+// @Param data body reportMessageRequestBody true "body json"
+// @Accept  json
+// @Success 200
+// @Security UserAuth
+// @Router /message/{id}/report [post]
+func (h ApisHandler) ReportMessage(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	params := mux.Vars(r)
+	id := params["id"]
+	if len(id) == 0 {
+		return l.HTTPResponseErrorData(logutils.StatusMissing, logutils.TypePathParam, logutils.StringArgs("id"), nil, http.StatusBadRequest, false)
+	}
+
+	var body reportMessageRequestBody
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDecode, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
+	}
+
+	err = h.app.Services.ReportMessage(l, claims.OrgID, claims.AppID, id, claims.Subject, body.Reason)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionCreate, "message report", nil, err, errorStatus(err), true)
+	}
+
+	return l.HTTPResponseSuccess()
+}
+
 // updateAllUserMessagesReadRequest Wrapper for update user read flag
 type updateAllUserMessagesReadRequest struct {
-	Read bool `json:"read"`
+	Read *bool    `json:"read"` // true if not set
+	IDs  []string `json:"ids"`  // all the user messages if empty
 } // @name updateAllUserMessagesReadRequest
 
+// updateAllUserMessagesReadResponse gives the count of the updated messages
+type updateAllUserMessagesReadResponse struct {
+	Updated int64 `json:"updated"`
+} // @name updateAllUserMessagesReadResponse
+
 // UpdateAllUserMessagesRead marking as "unread" or as "read all user messages
-// @Description marking as "unread" or as "read all user messages
+// @Description marking as "unread" or as "read all user messages, or only the given messages. Without a body all the user messages are marked as read.
 // @Tags Client
 // @ID UpdateAllUserMessagesRead
-// @Param data body updateAllUserMessagesReadRequest true "body json"
+// @Param data body updateAllUserMessagesReadRequest false "body json"
 // @Accept  json
-// @Success 200
+// @Success 200 {object} updateAllUserMessagesReadResponse
 // @Security UserAuth
 // @Router messages/read [put]
 func (h ApisHandler) UpdateAllUserMessagesRead(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
 	var body updateAllUserMessagesReadRequest
 	err := json.NewDecoder(r.Body).Decode(&body)
-	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "all messages read", nil, err, http.StatusInternalServerError, true)
+	if err != nil && err != io.EOF {
+		return l.HTTPResponseErrorAction(logutils.ActionDecode, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
+	}
+	read := true
+	if body.Read != nil {
+		read = *body.Read
 	}
 
-	err = h.app.Services.UpdateAllUserMessagesRead(claims.OrgID, claims.AppID, claims.Subject, body.Read)
+	updated, err := h.app.Services.UpdateAllUserMessagesRead(claims.OrgID, claims.AppID, claims.Subject, body.IDs, read)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "all messages read", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionUpdate, "all messages read", nil, err, errorStatus(err), true)
 	}
 
-	return l.HTTPResponseSuccess()
+	data, err := json.Marshal(updateAllUserMessagesReadResponse{Updated: updated})
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+	return l.HTTPResponseSuccessJSON(data)
 }
 
 // PushSubscription Subscribes the current user
@@ -613,7 +1132,7 @@ func (h ApisHandler) PushSubscription(l *logs.Log, r *http.Request, claims *toke
 
 	err = h.app.Services.PushSubscription(claims.OrgID, claims.AppID)
 	if err != nil {
-		return l.HTTPResponseErrorAction("subscribing", "topic", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction("subscribing", "topic", nil, err, errorStatus(err), true)
 	}
 
 	return l.HTTPResponseSuccess()
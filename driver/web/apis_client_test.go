@@ -0,0 +1,417 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"notifications/core"
+	"notifications/core/model"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rokwire/core-auth-library-go/v3/tokenauth"
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+// fakeServices keeps the user messages in memory. The services which it does not implement panic.
+type fakeServices struct {
+	core.Services
+
+	messages        []model.MessageRecipient
+	deletedMessages []string
+	topicsReach     map[string]int64
+}
+
+func (s *fakeServices) GetMessagesRecipientsDeep(ctx context.Context, orgID string, appID string, userID *string, read *bool, mute *bool, messageIDs []string, startDateEpoch *int64, endDateEpoch *int64, filterTopic *string, hasAttachment *bool, priority *int64, groupID *string, offset *int64, limit *int64, order *string, orderBy *string, fields []string) ([]model.MessageRecipient, int64, error) {
+	filtered := []model.MessageRecipient{}
+	for _, message := range s.messages {
+		if message.OrgID == orgID && message.AppID == appID && message.UserID == *userID && (read == nil || message.Read == *read) &&
+			(hasAttachment == nil || (len(message.Message.Attachments) > 0) == *hasAttachment) &&
+			(priority == nil || int64(message.Message.Priority) == *priority) {
+			filtered = append(filtered, message)
+		}
+	}
+
+	page := filtered
+	if offset != nil {
+		if *offset >= int64(len(page)) {
+			page = nil
+		} else {
+			page = page[*offset:]
+		}
+	}
+	if limit != nil && *limit < int64(len(page)) {
+		page = page[:*limit]
+	}
+	return page, int64(len(filtered)), nil
+}
+
+func (s *fakeServices) GetUserMessagesSince(ctx context.Context, orgID string, appID string, userID string, sinceID string, limit int64, fields []string) ([]model.MessageRecipient, string, error) {
+	result := []model.MessageRecipient{}
+	found := false
+	for _, message := range s.messages {
+		if found && message.UserID == userID && int64(len(result)) < limit {
+			result = append(result, message)
+		}
+		found = found || message.MessageID == sinceID
+	}
+	if !found {
+		return nil, "", fmt.Errorf("%w: %s", model.ErrInvalidCursor, sinceID)
+	}
+	if len(result) == 0 {
+		return result, sinceID, nil
+	}
+	return result, result[len(result)-1].MessageID, nil
+}
+
+func (s *fakeServices) DeleteUserMessage(orgID string, appID string, userID string, messageID string) error {
+	s.deletedMessages = append(s.deletedMessages, userID+"/"+messageID)
+	return nil
+}
+
+func (s *fakeServices) GetTopicReach(orgID string, appID string, topic string) (*model.TopicReach, error) {
+	return &model.TopicReach{Topic: topic, Count: s.topicsReach[topic]}, nil
+}
+
+func newTestApisHandler(services core.Services) ApisHandler {
+	return NewApisHandler(&core.Application{Services: services})
+}
+
+func TestGetUserMessagesTotalCount(t *testing.T) {
+	services := &fakeServices{}
+	for i := 0; i < 30; i++ {
+		id := fmt.Sprintf("m%d", i)
+		services.messages = append(services.messages, model.MessageRecipient{OrgID: "org", AppID: "app", UserID: "u1", MessageID: id, Read: i%3 == 0,
+			Message: model.Message{OrgID: "org", AppID: "app", ID: id}})
+	}
+	services.messages = append(services.messages, model.MessageRecipient{OrgID: "org", AppID: "app", UserID: "u2", MessageID: "other"})
+	h := newTestApisHandler(services)
+	claims := &tokenauth.Claims{OrgID: "org", AppID: "app"}
+	claims.Subject = "u1"
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCount string
+		wantLen   int
+	}{
+		{"default limit", "", "30", int(defaultPaginationLimit)},
+		{"page", "?offset=10&limit=5", "30", 5},
+		{"last page", "?offset=28&limit=5", "30", 2},
+		{"past the last page", "?offset=40&limit=5", "30", 0},
+		{"read", "?read=true", "10", 10},
+		{"read page", "?read=true&offset=5&limit=2", "10", 2},
+		{"unread", "?read=false&limit=3", "20", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/messages"+tt.query, nil)
+			l := logs.NewLogger("notifications", nil).NewRequestLog(req)
+
+			response := h.GetUserMessages(l, req, claims)
+			if response.ResponseCode != http.StatusOK {
+				t.Fatalf("GetUserMessages() status = %d, want %d", response.ResponseCode, http.StatusOK)
+			}
+			if got := response.Headers[totalCountHeader]; len(got) != 1 || got[0] != tt.wantCount {
+				t.Errorf("GetUserMessages() %s = %v, want %s", totalCountHeader, got, tt.wantCount)
+			}
+			var messages []map[string]interface{}
+			err := json.Unmarshal(response.Body, &messages)
+			if err != nil || len(messages) != tt.wantLen {
+				t.Errorf("GetUserMessages() gives %d messages, want %d - %v", len(messages), tt.wantLen, err)
+			}
+		})
+	}
+}
+
+func TestDeleteUserMessageClaims(t *testing.T) {
+	anonymous := &tokenauth.Claims{OrgID: "org", AppID: "app", Anonymous: true}
+	user := &tokenauth.Claims{OrgID: "org", AppID: "app", Email: "user@example.com"}
+	user.Subject = "u1"
+
+	tests := []struct {
+		name        string
+		claims      *tokenauth.Claims
+		wantStatus  int
+		wantDeleted bool
+	}{
+		{"no email and no subject", anonymous, http.StatusBadRequest, false},
+		{"no claims", nil, http.StatusBadRequest, false},
+		{"user", user, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := &fakeServices{}
+			h := newTestApisHandler(services)
+			logger := logs.NewLogger("notifications", nil)
+			endpoints := []struct {
+				path    string
+				handler handlerFunc
+			}{
+				{"/api/message/m1", h.DeleteUserMessage},
+				{"/api/messages", h.DeleteUserMessages},
+			}
+
+			for _, endpoint := range endpoints {
+				server := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					l := logger.NewRequestLog(r)
+					l.SendHTTPResponse(w, endpoint.handler(l, r, tt.claims))
+				})
+				req := httptest.NewRequest(http.MethodDelete, endpoint.path, strings.NewReader(`{"ids":["m1"]}`))
+				req = mux.SetURLVars(req, map[string]string{"id": "m1"})
+				w := httptest.NewRecorder()
+
+				server.ServeHTTP(w, req)
+
+				if w.Code != tt.wantStatus {
+					t.Errorf("DELETE %s status = %d, want %d", endpoint.path, w.Code, tt.wantStatus)
+				}
+			}
+			if (len(services.deletedMessages) > 0) != tt.wantDeleted {
+				t.Errorf("deleted messages = %v, want deleted %t", services.deletedMessages, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestGetUserMessagesHasAttachment(t *testing.T) {
+	services := &fakeServices{messages: []model.MessageRecipient{
+		{OrgID: "org", AppID: "app", UserID: "u1", MessageID: "image", Message: model.Message{ID: "image", Attachments: []model.Attachment{{URL: "https://example.com/a.png"}}}},
+		{OrgID: "org", AppID: "app", UserID: "u1", MessageID: "text", Message: model.Message{ID: "text"}},
+	}}
+	h := newTestApisHandler(services)
+	claims := &tokenauth.Claims{OrgID: "org", AppID: "app"}
+	claims.Subject = "u1"
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"with attachment", "?has_attachment=true", []string{"image"}},
+		{"without attachment", "?has_attachment=false", []string{"text"}},
+		{"no filter", "", []string{"image", "text"}},
+		{"invalid value", "?has_attachment=maybe", []string{"image", "text"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/messages"+tt.query, nil)
+			l := logs.NewLogger("notifications", nil).NewRequestLog(req)
+
+			response := h.GetUserMessages(l, req, claims)
+			if response.ResponseCode != http.StatusOK {
+				t.Fatalf("GetUserMessages() status = %d, want %d", response.ResponseCode, http.StatusOK)
+			}
+			if got := responseMessagesIDs(t, response); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetUserMessages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetUserMessagesPriority(t *testing.T) {
+	services := &fakeServices{messages: []model.MessageRecipient{
+		{OrgID: "org", AppID: "app", UserID: "u1", MessageID: "urgent", Message: model.Message{ID: "urgent", Priority: 10}},
+		{OrgID: "org", AppID: "app", UserID: "u1", MessageID: "normal", Message: model.Message{ID: "normal", Priority: 0}},
+	}}
+	h := newTestApisHandler(services)
+	claims := &tokenauth.Claims{OrgID: "org", AppID: "app"}
+	claims.Subject = "u1"
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       []string
+	}{
+		{"urgent", "?priority=10", http.StatusOK, []string{"urgent"}},
+		{"normal", "?priority=0", http.StatusOK, []string{"normal"}},
+		{"no match", "?priority=5", http.StatusOK, []string{}},
+		{"no filter", "", http.StatusOK, []string{"urgent", "normal"}},
+		{"negative", "?priority=-1", http.StatusBadRequest, nil},
+		{"not a number", "?priority=high", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/messages"+tt.query, nil)
+			l := logs.NewLogger("notifications", nil).NewRequestLog(req)
+
+			response := h.GetUserMessages(l, req, claims)
+			if response.ResponseCode != tt.wantStatus {
+				t.Fatalf("GetUserMessages() status = %d, want %d", response.ResponseCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := responseMessagesIDs(t, response); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetUserMessages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// responseMessagesIDs gives the ids of the messages in a user messages response
+func responseMessagesIDs(t *testing.T, response logs.HTTPResponse) []string {
+	var messages []struct {
+		ID string `json:"id"`
+	}
+	err := json.Unmarshal(response.Body, &messages)
+	if err != nil {
+		t.Fatalf("invalid messages response - %v", err)
+	}
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	return ids
+}
+
+func TestGetTopicReachCountOnly(t *testing.T) {
+	h := newTestApisHandler(&fakeServices{topicsReach: map[string]int64{"athletics": 1200}})
+	claims := &tokenauth.Claims{OrgID: "org", AppID: "app"}
+	claims.Subject = "u1"
+
+	req := httptest.NewRequest(http.MethodGet, "/api/topic/athletics/reach", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "athletics"})
+	l := logs.NewLogger("notifications", nil).NewRequestLog(req)
+
+	response := h.GetTopicReach(l, req, claims)
+	if response.ResponseCode != http.StatusOK {
+		t.Fatalf("GetTopicReach() status = %d, want %d", response.ResponseCode, http.StatusOK)
+	}
+	var reach map[string]interface{}
+	err := json.Unmarshal(response.Body, &reach)
+	want := map[string]interface{}{"topic": "athletics", "count": float64(1200)}
+	if err != nil || !reflect.DeepEqual(reach, want) {
+		t.Errorf("GetTopicReach() = %s, want only the topic and its count", response.Body)
+	}
+}
+
+func TestGetUserMessagesFields(t *testing.T) {
+	services := &fakeServices{messages: []model.MessageRecipient{
+		{OrgID: "org", AppID: "app", UserID: "u1", MessageID: "m1", Read: true,
+			Message: model.Message{OrgID: "org", AppID: "app", ID: "m1", Subject: "subject", Body: "body", Data: map[string]string{"key": "value"}}},
+	}}
+	h := newTestApisHandler(services)
+	claims := &tokenauth.Claims{OrgID: "org", AppID: "app"}
+	claims.Subject = "u1"
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantFields []string
+	}{
+		{"selected", "?fields=id,subject,read", http.StatusOK, []string{"id", "read", "subject"}},
+		{"id only", "?fields=id", http.StatusOK, []string{"id"}},
+		{"not allowed", "?fields=id,recipient_secret", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/messages"+tt.query, nil)
+			l := logs.NewLogger("notifications", nil).NewRequestLog(req)
+
+			response := h.GetUserMessages(l, req, claims)
+			if response.ResponseCode != tt.wantStatus {
+				t.Fatalf("GetUserMessages() status = %d, want %d", response.ResponseCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var messages []map[string]interface{}
+			err := json.Unmarshal(response.Body, &messages)
+			if err != nil || len(messages) != 1 {
+				t.Fatalf("GetUserMessages() gives %d messages, want 1 - %v", len(messages), err)
+			}
+			fields := []string{}
+			for field := range messages[0] {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("GetUserMessages() fields = %v, want %v", fields, tt.wantFields)
+			}
+			if messages[0]["id"] != "m1" {
+				t.Errorf("GetUserMessages() id = %v, want m1", messages[0]["id"])
+			}
+		})
+	}
+}
+
+func TestGetUserMessagesSinceID(t *testing.T) {
+	services := &fakeServices{}
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("m%d", i)
+		services.messages = append(services.messages, model.MessageRecipient{OrgID: "org", AppID: "app", UserID: "u1", MessageID: id,
+			Message: model.Message{OrgID: "org", AppID: "app", ID: id}})
+	}
+	h := newTestApisHandler(services)
+	claims := &tokenauth.Claims{OrgID: "org", AppID: "app"}
+	claims.Subject = "u1"
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []string
+		wantCursor string
+	}{
+		{"page", "?since_id=m0&limit=2", http.StatusOK, []string{"m1", "m2"}, "m2"},
+		{"last page", "?since_id=m2&limit=5", http.StatusOK, []string{"m3", "m4"}, "m4"},
+		{"no new messages", "?since_id=m4", http.StatusOK, []string{}, "m4"},
+		{"unknown cursor", "?since_id=other", http.StatusBadRequest, nil, ""},
+		{"with offset", "?since_id=m0&offset=2", http.StatusBadRequest, nil, ""},
+		{"with a filter", "?since_id=m0&read=false", http.StatusBadRequest, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/messages"+tt.query, nil)
+			l := logs.NewLogger("notifications", nil).NewRequestLog(req)
+
+			response := h.GetUserMessages(l, req, claims)
+			if response.ResponseCode != tt.wantStatus {
+				t.Fatalf("GetUserMessages() status = %d, want %d", response.ResponseCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var messages []getUserMessageResponse
+			err := json.Unmarshal(response.Body, &messages)
+			if err != nil {
+				t.Fatalf("GetUserMessages() body error = %v", err)
+			}
+			ids := []string{}
+			for _, message := range messages {
+				ids = append(ids, message.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("GetUserMessages() = %v, want %v", ids, tt.wantIDs)
+			}
+			if got := response.Headers[nextCursorHeader]; len(got) != 1 || got[0] != tt.wantCursor {
+				t.Errorf("GetUserMessages() %s = %v, want %s", nextCursorHeader, got, tt.wantCursor)
+			}
+			if _, ok := response.Headers[totalCountHeader]; ok {
+				t.Errorf("GetUserMessages() gives %s with the cursor paging", totalCountHeader)
+			}
+		})
+	}
+}
@@ -16,11 +16,13 @@ package web
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"notifications/core"
 	"notifications/core/model"
 	Def "notifications/driver/web/docs/gen"
 	"strconv"
+	"strings"
 
 	"github.com/rokwire/core-auth-library-go/v3/tokenauth"
 	"github.com/rokwire/logging-library-go/v2/logs"
@@ -29,12 +31,13 @@ import (
 
 // InternalApisHandler handles the rest Admin APIs implementation
 type InternalApisHandler struct {
-	app *core.Application
+	app          *core.Application
+	internalAuth InternalAuth
 }
 
 // NewInternalApisHandler creates new rest Handler instance
-func NewInternalApisHandler(app *core.Application) InternalApisHandler {
-	return InternalApisHandler{app: app}
+func NewInternalApisHandler(app *core.Application, internalAuth InternalAuth) InternalApisHandler {
+	return InternalApisHandler{app: app, internalAuth: internalAuth}
 }
 
 // SendMessage Sends a message to a user, list of users or a topic
@@ -42,8 +45,10 @@ func NewInternalApisHandler(app *core.Application) InternalApisHandler {
 // @Tags Internal
 // @ID InternalSendMessage
 // @Param data body model.Message true "body json"
+// @Param async query bool false "return 202 with the queued message and create it in the background"
 // @Produce plain
 // @Success 200 {object} model.Message
+// @Success 202 {object} model.Message
 // @Security InternalAuth
 // @Router /int/message [post]
 // @Deprecated
@@ -94,13 +99,14 @@ func (h InternalApisHandler) SendMessages(l *logs.Log, r *http.Request, claims *
 		inputMessage.OrgID = m.OrgId
 		inputMessage.AppID = m.AppId
 		inputMessage.Sender = model.Sender{Type: "system"}
+		inputMessage.SourceApp = getSourceApp(r, inputMessage.SourceApp)
 
 		inputMessages = append(inputMessages, inputMessage)
 	}
 
-	createdMessages, err := h.app.Services.CreateMessages(inputMessages, isBatch)
+	createdMessages, err := h.app.Services.CreateMessages(r.Context(), inputMessages, isBatch)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionSend, "message", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionSend, "message", nil, err, errorStatus(err), true)
 	}
 
 	data, err := json.Marshal(createdMessages)
@@ -122,8 +128,10 @@ type sendMessageRequestBody struct {
 // @Tags Internal
 // @ID InternalSendMessageV2
 // @Param data body sendMessageRequestBody true "body json"
+// @Param async query bool false "return 202 with the queued message and create it in the background"
 // @Produce plain
 // @Success 200 {object} model.Message
+// @Success 202 {object} model.Message
 // @Security InternalAuth
 // @Router /int/v2/message [post]
 func (h InternalApisHandler) SendMessageV2(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
@@ -154,18 +162,21 @@ func (h InternalApisHandler) processSendMessage(l *logs.Log,
 
 	sender := model.Sender{Type: "system"}
 	inputMessage.Sender = sender
-
-	message, err := h.app.Services.CreateMessage(inputMessage)
-	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionSend, "message", nil, err, http.StatusInternalServerError, true)
+	inputMessage.SourceApp = getSourceApp(r, inputMessage.SourceApp)
+	inputMessage.IdempotencyKey = getIdempotencyKey(r)
+
+	var message *model.Message
+	var err error
+	if isAsyncCreate(r) {
+		message, err = h.app.Services.CreateMessageAsync(r.Context(), inputMessage)
+	} else {
+		message, err = h.app.Services.CreateMessage(r.Context(), inputMessage)
 	}
-
-	data, err := json.Marshal(message)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponse, nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionSend, "message", nil, err, errorStatus(err), true)
 	}
 
-	return l.HTTPResponseSuccessJSON(data)
+	return createMessageResponse(l, message)
 }
 
 // sendMailRequestBody mail request body
@@ -175,6 +186,44 @@ type sendMailRequestBody struct {
 	Body    string `json:"body"`
 } // @name sendMailRequestBody
 
+// GetMetrics gives the send metrics of the messages whose send has ended within the last hour and the stale tokens removed and the internal API keys usage since the start in the Prometheus text format
+// @Description Gives the send metrics of the messages whose send has ended within the last hour and the stale tokens removed and the internal API keys usage since the start in the Prometheus text format
+// @Tags Internal
+// @ID GetMetrics
+// @Produce plain
+// @Success 200
+// @Security InternalAuth
+// @Router /metrics [get]
+func (h InternalApisHandler) GetMetrics(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	metrics, err := h.app.Services.GetSendMetrics()
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionGet, "send metrics", nil, err, errorStatus(err), true)
+	}
+
+	window := metrics.Window.String()
+	var result strings.Builder
+	writeMetric := func(name string, help string, value float64) {
+		fmt.Fprintf(&result, "# HELP %s %s\n# TYPE %s gauge\n%s{window=%q} %g\n", name, help, name, name, window, value)
+	}
+	writeMetric("notifications_send_messages", "Messages whose send has ended within the window", float64(metrics.Messages))
+	writeMetric("notifications_send_recipients", "Recipients sent by these messages", float64(metrics.Sent))
+	writeMetric("notifications_send_duration_seconds_avg", "Average send duration of these messages", metrics.DurationSecondsAvg)
+	writeMetric("notifications_send_duration_seconds_max", "Longest send duration of these messages", metrics.DurationSecondsMax)
+	writeMetric("notifications_send_throughput_avg", "Average sent recipients per second of these messages", metrics.ThroughputAvg)
+
+	//not a windowed one, it counts since the start
+	prunedTokens := "notifications_stale_tokens_pruned_total"
+	fmt.Fprintf(&result, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", prunedTokens, "Device tokens removed because they have not been registered for TOKEN_STALE_DAYS", prunedTokens, prunedTokens, h.app.Services.GetPrunedTokensCount())
+
+	keyRequests := "notifications_internal_api_key_requests_total"
+	fmt.Fprintf(&result, "# HELP %s %s\n# TYPE %s counter\n", keyRequests, "Requests authorized by each internal API key since the start", keyRequests)
+	for _, usage := range h.internalAuth.usage() {
+		fmt.Fprintf(&result, "%s{key=%q,current=\"%t\"} %d\n", keyRequests, usage.maskedKey, usage.current, usage.count)
+	}
+
+	return l.HTTPResponseSuccessMessage(result.String())
+}
+
 // SendMail Sends an email
 // @Description Sends an email
 // @Tags Internal
@@ -193,7 +242,7 @@ func (h InternalApisHandler) SendMail(l *logs.Log, r *http.Request, claims *toke
 
 	err = h.app.Services.SendMail(mailRequest.ToMail, mailRequest.Subject, mailRequest.Body)
 	if err != nil {
-		return l.HTTPResponseErrorAction(logutils.ActionSend, "email", nil, err, http.StatusInternalServerError, true)
+		return l.HTTPResponseErrorAction(logutils.ActionSend, "email", nil, err, errorStatus(err), true)
 	}
 
 	return l.HTTPResponseSuccess()
@@ -18,9 +18,12 @@ import (
 	"net/http"
 	"notifications/core"
 	"notifications/core/model"
+	"sync/atomic"
+	"time"
 
 	"github.com/rokwire/core-auth-library-go/v3/authorization"
 	"github.com/rokwire/logging-library-go/v2/errors"
+	"github.com/rokwire/logging-library-go/v2/logs"
 	"github.com/rokwire/logging-library-go/v2/logutils"
 
 	"github.com/rokwire/core-auth-library-go/v3/authservice"
@@ -36,7 +39,7 @@ type Auth struct {
 }
 
 // NewAuth creates new auth handler
-func NewAuth(app *core.Application, config *model.Config, serviceRegManager *authservice.ServiceRegManager) (*Auth, error) {
+func NewAuth(app *core.Application, config *model.Config, serviceRegManager *authservice.ServiceRegManager, logger *logs.Logger) (*Auth, error) {
 	client, err := newClientAuth(serviceRegManager)
 	if err != nil {
 		return nil, errors.WrapErrorAction(logutils.ActionCreate, "client auth", nil, err)
@@ -55,7 +58,7 @@ func NewAuth(app *core.Application, config *model.Config, serviceRegManager *aut
 	}
 	bbsHandlers := tokenauth.NewHandlers(bbs)
 
-	internal := newInternalAuth(config.InternalAPIKey)
+	internal := newInternalAuth(config.InternalAPIKey, config.PreviousInternalAPIKeys, config.InternalAPIKeysRotationEnd, logger)
 
 	auth := Auth{
 		client:   clientHandlers,
@@ -71,10 +74,23 @@ func NewAuth(app *core.Application, config *model.Config, serviceRegManager *aut
 // InternalAuth handling the internal calls fromother BBs
 type InternalAuth struct {
 	internalAPIKey string
+
+	//the previous keys are still accepted until the end of the rotation window (forever if not set)
+	previousAPIKeys []string
+	rotationEnd     *time.Time
+
+	keysUsage map[string]*uint64 //key -> requests count
+	logger    *logs.Logger
 }
 
-func newInternalAuth(internalAPIKey string) InternalAuth {
-	return InternalAuth{internalAPIKey: internalAPIKey}
+func newInternalAuth(internalAPIKey string, previousAPIKeys []string, rotationEnd *time.Time, logger *logs.Logger) InternalAuth {
+	keysUsage := make(map[string]*uint64, len(previousAPIKeys)+1)
+	keysUsage[internalAPIKey] = new(uint64)
+	for _, key := range previousAPIKeys {
+		keysUsage[key] = new(uint64)
+	}
+	return InternalAuth{internalAPIKey: internalAPIKey, previousAPIKeys: previousAPIKeys, rotationEnd: rotationEnd,
+		keysUsage: keysUsage, logger: logger}
 }
 
 // Check verifies the internal API key
@@ -87,14 +103,73 @@ func (auth InternalAuth) Check(req *http.Request) (int, *tokenauth.Claims, error
 		return http.StatusBadRequest, nil, errors.New("Bad Request")
 	}
 
-	if auth.internalAPIKey != apiKey {
+	if !auth.isValidKey(apiKey) {
+		if auth.logger != nil {
+			auth.logger.Warnf("invalid internal api key %s", maskAPIKey(apiKey))
+		}
 		//not exist, so return 401
 		return http.StatusUnauthorized, nil, errors.New("Unauthorized")
 	}
 
+	//record the key usage so that the stale keys can be identified before removing them - the counts are exposed by /metrics
+	count := atomic.AddUint64(auth.keysUsage[apiKey], 1)
+	if auth.logger != nil {
+		current := apiKey == auth.internalAPIKey
+		if !current && count == 1 {
+			auth.logger.Infof("previous internal api key %s still in use", maskAPIKey(apiKey))
+		} else {
+			auth.logger.Debugf("internal api key %s used - current %t, requests count %d", maskAPIKey(apiKey), current, count)
+		}
+	}
+
 	return http.StatusOK, nil, nil
 }
 
+// isValidKey checks if the key is the current one or a previous one within the rotation window
+func (auth InternalAuth) isValidKey(apiKey string) bool {
+	if apiKey == auth.internalAPIKey {
+		return true
+	}
+	if auth.rotationEnd != nil && time.Now().After(*auth.rotationEnd) {
+		return false //the rotation window has ended
+	}
+	for _, key := range auth.previousAPIKeys {
+		if apiKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+// internalKeyUsage is the requests count of an internal API key
+type internalKeyUsage struct {
+	maskedKey string
+	current   bool
+	count     uint64
+}
+
+// usage gives the requests count of the current key and the previous keys
+func (auth InternalAuth) usage() []internalKeyUsage {
+	result := make([]internalKeyUsage, 0, len(auth.previousAPIKeys)+1)
+	result = append(result, internalKeyUsage{maskedKey: maskAPIKey(auth.internalAPIKey), current: true,
+		count: atomic.LoadUint64(auth.keysUsage[auth.internalAPIKey])})
+	for _, key := range auth.previousAPIKeys {
+		if key == auth.internalAPIKey {
+			continue
+		}
+		result = append(result, internalKeyUsage{maskedKey: maskAPIKey(key), count: atomic.LoadUint64(auth.keysUsage[key])})
+	}
+	return result
+}
+
+// maskAPIKey gives a key identifier which is safe for logging
+func maskAPIKey(apiKey string) string {
+	if len(apiKey) <= 8 {
+		return "****"
+	}
+	return "****" + apiKey[len(apiKey)-4:]
+}
+
 // GetTokenAuth returns nil
 func (auth InternalAuth) GetTokenAuth() *tokenauth.TokenAuth {
 	return nil
@@ -0,0 +1,111 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+// newCapturedLogger creates a debug logger which writes to a file instead of stderr
+func newCapturedLogger(t *testing.T) (*logs.Logger, *os.File) {
+	out, err := os.CreateTemp(t.TempDir(), "log")
+	if err != nil {
+		t.Fatalf("CreateTemp() error = %v", err)
+	}
+	stderr := os.Stderr
+	os.Stderr = out //the logger writes to the stderr given on its creation
+	logger := logs.NewLogger("notifications", nil)
+	os.Stderr = stderr
+	logger.SetLevel(logs.Debug)
+	return logger, out
+}
+
+func TestInternalAuthCheckKeyUsage(t *testing.T) {
+	current := "current-key-1111"
+	previous := "previous-key-2222"
+	ended := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name        string
+		rotationEnd *time.Time
+		apiKey      string
+		wantStatus  int
+		wantUsage   []internalKeyUsage
+		wantLogged  string
+	}{
+		{"current key", nil, current, http.StatusOK,
+			[]internalKeyUsage{{maskedKey: "****1111", current: true, count: 1}, {maskedKey: "****2222"}}, "internal api key ****1111 used"},
+		{"previous key", nil, previous, http.StatusOK,
+			[]internalKeyUsage{{maskedKey: "****1111", current: true}, {maskedKey: "****2222", count: 1}}, "previous internal api key ****2222 still in use"},
+		{"previous key after the rotation", &ended, previous, http.StatusUnauthorized,
+			[]internalKeyUsage{{maskedKey: "****1111", current: true}, {maskedKey: "****2222"}}, "invalid internal api key ****2222"},
+		{"unknown key", nil, "unknown-key-3333", http.StatusUnauthorized,
+			[]internalKeyUsage{{maskedKey: "****1111", current: true}, {maskedKey: "****2222"}}, "invalid internal api key ****3333"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, out := newCapturedLogger(t)
+			auth := newInternalAuth(current, []string{previous}, tt.rotationEnd, logger)
+
+			req := httptest.NewRequest(http.MethodGet, "/int/metrics", nil)
+			req.Header.Set("INTERNAL-API-KEY", tt.apiKey)
+			status, _, _ := auth.Check(req)
+			if status != tt.wantStatus {
+				t.Errorf("Check() status = %d, want %d", status, tt.wantStatus)
+			}
+			if got := auth.usage(); !reflect.DeepEqual(got, tt.wantUsage) {
+				t.Errorf("usage() = %+v, want %+v", got, tt.wantUsage)
+			}
+
+			logged, err := os.ReadFile(out.Name())
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			if !strings.Contains(string(logged), tt.wantLogged) {
+				t.Errorf("Check() logged %q, want %q", logged, tt.wantLogged)
+			}
+			if strings.Contains(string(logged), tt.apiKey) {
+				t.Errorf("Check() logged the unmasked key %q", logged)
+			}
+		})
+	}
+}
+
+func TestMaskAPIKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		apiKey string
+		want   string
+	}{
+		{"long key", "0123456789abcdef", "****cdef"},
+		{"short key", "01234567", "****"},
+		{"empty key", "", "****"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maskAPIKey(tt.apiKey); got != tt.want {
+				t.Errorf("maskAPIKey(%s) = %s, want %s", tt.apiKey, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+	"github.com/rokwire/logging-library-go/v2/logutils"
+)
+
+// defaultMaxRequestBodyBytes is the max size of the requests bodies when it is not configured
+const defaultMaxRequestBodyBytes int64 = 1 << 20
+
+// limitedBody is a request body which fails the reads past the max size
+//
+// It records that the max size has been exceeded so that the request is responded with 413
+// whatever error the handler gives for the failed read.
+type limitedBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+func newLimitedBody(w http.ResponseWriter, body io.ReadCloser, maxBytes int64) *limitedBody {
+	return &limitedBody{ReadCloser: http.MaxBytesReader(w, body, maxBytes)}
+}
+
+func bodyTooLargeResponse(l *logs.Log, maxBytes int64) logs.HTTPResponse {
+	return l.HTTPResponseErrorData(logutils.StatusInvalid, logutils.TypeRequestBody, &logutils.FieldArgs{"max_bytes": maxBytes}, nil, http.StatusRequestEntityTooLarge, false)
+}
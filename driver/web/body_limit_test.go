@@ -0,0 +1,81 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rokwire/core-auth-library-go/v3/tokenauth"
+	"github.com/rokwire/logging-library-go/v2/logs"
+	"github.com/rokwire/logging-library-go/v2/logutils"
+)
+
+// decodeBodyHandler gives 400 for a body which cannot be decoded, as the handlers do
+func decodeBodyHandler(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+	var body map[string]string
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionDecode, logutils.TypeRequestBody, nil, err, http.StatusBadRequest, true)
+	}
+	return l.HTTPResponseSuccess()
+}
+
+// streamedBody hides the size of the body so that the request has no content length
+type streamedBody struct {
+	io.Reader
+}
+
+func TestWrapFuncBodyLimit(t *testing.T) {
+	we := Adapter{maxRequestBodyBytes: 32, logger: logs.NewLogger("notifications", nil)}
+	small := `{"subject":"subject"}`
+	large := `{"subject":"` + strings.Repeat("a", 64) + `"}`
+
+	tests := []struct {
+		name       string
+		body       string
+		streamed   bool
+		wantStatus int
+	}{
+		{"small", small, false, http.StatusOK},
+		{"small streamed", small, true, http.StatusOK},
+		{"large content length", large, false, http.StatusRequestEntityTooLarge},
+		{"large streamed", large, true, http.StatusRequestEntityTooLarge},
+		{"invalid", `{"subject":`, false, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = strings.NewReader(tt.body)
+			if tt.streamed {
+				body = streamedBody{body}
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/messages", body)
+			if tt.streamed && req.ContentLength != -1 {
+				t.Fatalf("the streamed request content length = %d, want -1", req.ContentLength)
+			}
+			w := httptest.NewRecorder()
+
+			we.wrapFunc(decodeBodyHandler, nil)(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("wrapFunc() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -15,14 +15,32 @@
 package web
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"notifications/core/model"
 	Def "notifications/driver/web/docs/gen"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/rokwire/logging-library-go/v2/logs"
+	"github.com/rokwire/logging-library-go/v2/logutils"
 )
 
+// sourceAppHeader is the header by which the building blocks give the message source app
+const sourceAppHeader = "X-Source-App"
+
+// idempotencyKeyHeader is the header by which the senders make the message creation safe to retry
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// totalCountHeader is the header by which the list APIs give the count of all the items which match the filters
+const totalCountHeader = "X-Total-Count"
+
+// nextCursorHeader is the header by which the cursor paged list APIs give the cursor of the next page
+const nextCursorHeader = "X-Next-Cursor"
+
 func getStringQueryParam(r *http.Request, paramName string) *string {
 	params, ok := r.URL.Query()[paramName]
 	if ok && len(params[0]) > 0 {
@@ -43,6 +61,89 @@ func getInt64QueryParam(r *http.Request, paramName string) *int64 {
 	return nil
 }
 
+// the pagination limits used by getLimitQueryParam, set on creating the web adapter
+var (
+	defaultPaginationLimit int64 = 20
+	maxPaginationLimit     int64 = 500
+)
+
+// defaultPerDevice is the per_device value of the messages which do not give one, set on creating the web adapter
+var defaultPerDevice = true
+
+// setPaginationLimits sets the configured pagination limits, the not positive values keep the defaults
+func setPaginationLimits(defaultLimit int64, maxLimit int64) {
+	if maxLimit > 0 {
+		maxPaginationLimit = maxLimit
+	}
+	if defaultLimit > 0 {
+		defaultPaginationLimit = defaultLimit
+	}
+	if defaultPaginationLimit > maxPaginationLimit {
+		defaultPaginationLimit = maxPaginationLimit
+	}
+}
+
+// getLimitQueryParam gives the limit query param clamped to the max limit, the default limit is used when it is missing, invalid or not positive
+func getLimitQueryParam(r *http.Request) *int64 {
+	limit := getInt64QueryParam(r, "limit")
+	if limit == nil || *limit <= 0 {
+		value := defaultPaginationLimit
+		return &value
+	}
+	if *limit > maxPaginationLimit {
+		value := maxPaginationLimit
+		return &value
+	}
+	return limit
+}
+
+// getOrderByQueryParam gives the order_by query param, ok is false when it is not a supported field
+func getOrderByQueryParam(r *http.Request) (orderBy *string, ok bool) {
+	orderBy = getStringQueryParam(r, "order_by")
+	if orderBy != nil && *orderBy != model.MessagesOrderByDateCreated && *orderBy != model.MessagesOrderByDateUpdated {
+		return nil, false
+	}
+	return orderBy, true
+}
+
+// getTopicsPagingQueryParams gives the topics offset, limit, sort_by and order query params, ok is false when sort_by is not a supported field.
+// The limit is nil when neither the offset nor the limit is given, so that all topics are listed.
+func getTopicsPagingQueryParams(r *http.Request) (offset *int64, limit *int64, sortBy *string, order *string, ok bool) {
+	offset = getInt64QueryParam(r, "offset")
+	if offset != nil || getStringQueryParam(r, "limit") != nil {
+		limit = getLimitQueryParam(r)
+	}
+	sortBy = getStringQueryParam(r, "sort_by")
+	if sortBy != nil && *sortBy != model.TopicsSortByName && *sortBy != model.TopicsSortByDateCreated && *sortBy != model.TopicsSortBySubscribers {
+		return nil, nil, nil, nil, false
+	}
+	order = getStringQueryParam(r, "order")
+	return offset, limit, sortBy, order, true
+}
+
+// getFieldsQueryParam gives the comma separated fields query param, ok is false when any of the fields is not within the allowed ones
+func getFieldsQueryParam(r *http.Request, allowed []string) (fields []string, ok bool) {
+	value := getStringQueryParam(r, "fields")
+	if value == nil {
+		return nil, true
+	}
+	allowedFields := make(map[string]bool, len(allowed))
+	for _, field := range allowed {
+		allowedFields[field] = true
+	}
+	for _, field := range strings.Split(*value, ",") {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			continue
+		}
+		if !allowedFields[field] {
+			return nil, false
+		}
+		fields = append(fields, field)
+	}
+	return fields, true
+}
+
 func getBoolQueryParam(r *http.Request, paramName string) *bool {
 	readFromQuery, ok := r.URL.Query()[paramName]
 	if ok && len(readFromQuery[0]) > 0 {
@@ -54,6 +155,30 @@ func getBoolQueryParam(r *http.Request, paramName string) *bool {
 	return nil
 }
 
+// errorStatus gives the response status for the kind of an error given by the core, 500 if it is not of a known kind
+func errorStatus(err error) int {
+	for err != nil {
+		switch {
+		case errors.Is(err, model.ErrNotFound):
+			return http.StatusNotFound
+		case errors.Is(err, model.ErrValidation):
+			return http.StatusBadRequest
+		case errors.Is(err, model.ErrConflict):
+			return http.StatusConflict
+		case errors.Is(err, model.ErrUnauthorized):
+			return http.StatusForbidden
+		}
+
+		//the logging library errors do not unwrap, the error which they wrap is checked
+		var wrapper interface{ Internal() error }
+		if !errors.As(err, &wrapper) {
+			break
+		}
+		err = wrapper.Internal()
+	}
+	return http.StatusInternalServerError
+}
+
 func getMessageData(inputMessage Def.SharedReqCreateMessage) model.InputMessage {
 	mTime := time.Now()
 	if inputMessage.Time != nil {
@@ -68,20 +193,114 @@ func getMessageData(inputMessage Def.SharedReqCreateMessage) model.InputMessage
 		inputData[key] = fmt.Sprintf("%v", value)
 	}
 
-	//TODO add topic to topics array
+	//the topic is folded into the topics on handling the message
 	topics := inputMessage.Topics
-	if inputMessage.Topic != nil {
-		if topics != nil {
-			topics = append(topics, *inputMessage.Topic)
-		} else {
-			topics = []string{*inputMessage.Topic}
-		}
-	}
 	inputRecipients := messagesRecipientsListFromDef(inputMessage.Recipients)
+	excludeRecipients := messagesRecipientsListFromDef(inputMessage.ExcludeRecipients)
 	recipientsCriteria := recipientsCriteriaListFromDef(inputMessage.RecipientsCriteriaList)
 	recipientsAccountCriteria := inputMessage.RecipientAccountCriteria
+	attachments := attachmentsListFromDef(inputMessage.Attachments)
+	sourceApp := ""
+	if inputMessage.SourceApp != nil {
+		sourceApp = *inputMessage.SourceApp
+	}
+	deferInactive := false
+	if inputMessage.DeferInactive != nil {
+		deferInactive = *inputMessage.DeferInactive
+	}
+	var eventTime *time.Time
+	if inputMessage.EventTime != nil {
+		eventTimeValue := time.Unix(*inputMessage.EventTime, 0)
+		eventTime = &eventTimeValue
+	}
+	var offsetBefore time.Duration
+	if inputMessage.OffsetBefore != nil {
+		offsetBefore = time.Duration(*inputMessage.OffsetBefore) * time.Second
+	}
+	skipIfPast := false
+	if inputMessage.SkipIfPast != nil {
+		skipIfPast = *inputMessage.SkipIfPast
+	}
+	includeFailedRecipients := false
+	if inputMessage.IncludeFailedRecipients != nil {
+		includeFailedRecipients = *inputMessage.IncludeFailedRecipients
+	}
+	var expiresAt *time.Time
+	if inputMessage.ExpiresAt != nil {
+		expiresAtValue := time.Unix(*inputMessage.ExpiresAt, 0)
+		expiresAt = &expiresAtValue
+	}
+	silent := false
+	if inputMessage.Silent != nil {
+		silent = *inputMessage.Silent
+	}
+	perDevice := defaultPerDevice
+	if inputMessage.PerDevice != nil {
+		perDevice = *inputMessage.PerDevice
+	}
+	requiresApproval := false
+	if inputMessage.RequiresApproval != nil {
+		requiresApproval = *inputMessage.RequiresApproval
+	}
 
 	return model.InputMessage{ID: inputMessage.Id, Time: mTime, Priority: priority, Subject: subject,
-		Body: body, Data: inputData, Topic: inputMessage.Topic, Topics: topics, InputRecipients: inputRecipients,
-		RecipientsCriteriaList: recipientsCriteria, RecipientAccountCriteria: recipientsAccountCriteria}
+		Body: body, Data: inputData, LocalizedSubjects: inputMessage.LocalizedSubjects, LocalizedBodies: inputMessage.LocalizedBodies, Topic: inputMessage.Topic, Topics: topics, InputRecipients: inputRecipients,
+		ExcludeRecipients: excludeRecipients, RecipientsCriteriaList: recipientsCriteria, RecipientAccountCriteria: recipientsAccountCriteria,
+		Attachments: attachments, SourceApp: sourceApp, ActiveWithinMinutes: inputMessage.ActiveWithinMinutes,
+		DeferInactive: deferInactive, IncludeFailedRecipients: includeFailedRecipients, EventTime: eventTime,
+		OffsetBefore: offsetBefore, SkipIfPast: skipIfPast, DeliveryChannels: inputMessage.DeliveryChannels,
+		RequiresApproval: requiresApproval, Sound: inputMessage.Sound, Badge: inputMessage.Badge, Silent: silent, PerDevice: perDevice, CallbackURL: inputMessage.CallbackUrl, GroupID: inputMessage.GroupId, CollapseKey: inputMessage.CollapseKey, ImageURL: inputMessage.ImageUrl, AndroidChannelID: inputMessage.AndroidChannelId, ExpiresAt: expiresAt}
+}
+
+// isAsyncCreate checks if the request asks to return before the message has been created
+func isAsyncCreate(r *http.Request) bool {
+	async := getBoolQueryParam(r, "async")
+	return async != nil && *async
+}
+
+// createMessageResponse gives the created message, or the queued message with the accepted status if it is created in the background
+func createMessageResponse(l *logs.Log, message *model.Message) logs.HTTPResponse {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return l.HTTPResponseErrorAction(logutils.ActionMarshal, logutils.TypeResponseBody, nil, err, http.StatusInternalServerError, true)
+	}
+
+	response := l.HTTPResponseSuccessJSON(data)
+	if message.IsQueued() {
+		response.ResponseCode = http.StatusAccepted
+	}
+	return response
+}
+
+// getIdempotencyKey gives the idempotency key header, nil if it is not set
+func getIdempotencyKey(r *http.Request) *string {
+	key := strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+	if len(key) == 0 {
+		return nil
+	}
+	return &key
+}
+
+// setTotalCountHeader sets the total count header of a list response
+func setTotalCountHeader(response *logs.HTTPResponse, totalCount int64) {
+	if response.Headers == nil {
+		response.Headers = map[string][]string{}
+	}
+	response.Headers[totalCountHeader] = []string{strconv.FormatInt(totalCount, 10)}
+}
+
+// setNextCursorHeader sets the next page cursor header of a cursor paged list response
+func setNextCursorHeader(response *logs.HTTPResponse, nextCursor string) {
+	if response.Headers == nil {
+		response.Headers = map[string][]string{}
+	}
+	response.Headers[nextCursorHeader] = []string{nextCursor}
+}
+
+// getSourceApp gives the message source app from the request body or the source app header
+func getSourceApp(r *http.Request, bodySourceApp string) string {
+	if len(bodySourceApp) > 0 {
+		return bodySourceApp
+	}
+	return r.Header.Get(sourceAppHeader)
 }
@@ -0,0 +1,188 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"notifications/core/model"
+	"reflect"
+	"testing"
+
+	"github.com/rokwire/logging-library-go/v2/errors"
+	"github.com/rokwire/logging-library-go/v2/logutils"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"message not editable", fmt.Errorf("%w: message", model.ErrMessageNotEditable), http.StatusConflict},
+		{"message not editable wrapped", errors.WrapErrorAction(logutils.ActionUpdate, "message", nil, fmt.Errorf("%w: message", model.ErrMessageNotEditable)), http.StatusConflict},
+		{"not the sender", fmt.Errorf("%w: only creator can update the original message", model.ErrUnauthorized), http.StatusForbidden},
+		{"message blocked", errors.WrapErrorAction(logutils.ActionCreate, "message", nil, fmt.Errorf("%w: blocked word", model.ErrMessageBlocked)), http.StatusBadRequest},
+		{"not found", errors.WrapErrorData(logutils.StatusMissing, "message", &logutils.FieldArgs{"id": "m1"}, model.ErrNotFound), http.StatusNotFound},
+		{"validation", errors.WrapErrorData(logutils.StatusInvalid, "mode", nil, model.ErrValidation), http.StatusBadRequest},
+		{"validation kind", errors.WrapErrorAction(logutils.ActionUpdate, "user", nil, model.ErrInvalidQuietHours), http.StatusBadRequest},
+		{"conflict kind", fmt.Errorf("%w: topic", model.ErrTopicHasRecentMessages), http.StatusConflict},
+		{"unauthorized", model.ErrUnauthorized, http.StatusForbidden},
+		{"no error kind", errors.New("storage error"), http.StatusInternalServerError},
+		{"no error kind wrapped", errors.WrapErrorAction(logutils.ActionFind, "message", nil, errors.New("storage error")), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorStatus(tt.err); got != tt.want {
+				t.Errorf("errorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetSourceApp(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        string
+		bodySourceApp string
+		want          string
+	}{
+		{"body", "", "events", "events"},
+		{"header", "groups", "", "groups"},
+		{"body over header", "groups", "events", "events"},
+		{"none", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/bbs/message", nil)
+			if len(tt.header) > 0 {
+				req.Header.Set(sourceAppHeader, tt.header)
+			}
+			if got := getSourceApp(req, tt.bodySourceApp); got != tt.want {
+				t.Errorf("getSourceApp() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetLimitQueryParam(t *testing.T) {
+	tests := []struct {
+		name         string
+		defaultLimit int64
+		maxLimit     int64
+		query        string
+		want         int64
+	}{
+		{"default", 20, 500, "", 20},
+		{"valid", 20, 500, "?limit=100", 100},
+		{"max", 20, 500, "?limit=500", 500},
+		{"clamped", 20, 500, "?limit=1000000", 500},
+		{"zero", 20, 500, "?limit=0", 20},
+		{"negative", 20, 500, "?limit=-5", 20},
+		{"invalid", 20, 500, "?limit=all", 20},
+		{"configured", 50, 100, "?limit=200", 100},
+		{"default over the max", 200, 100, "", 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultLimit, maxLimit := defaultPaginationLimit, maxPaginationLimit
+			defer func() {
+				defaultPaginationLimit, maxPaginationLimit = defaultLimit, maxLimit
+			}()
+			setPaginationLimits(tt.defaultLimit, tt.maxLimit)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/messages"+tt.query, nil)
+			if got := getLimitQueryParam(req); got == nil || *got != tt.want {
+				t.Errorf("getLimitQueryParam() = %v, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetOrderByQueryParam(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		want   string
+		wantOk bool
+	}{
+		{"not given", "", "", true},
+		{"date created", "?order_by=date_created", model.MessagesOrderByDateCreated, true},
+		{"date updated", "?order_by=date_updated", model.MessagesOrderByDateUpdated, true},
+		{"unsupported", "?order_by=subject", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/messages"+tt.query, nil)
+			got, ok := getOrderByQueryParam(req)
+			if ok != tt.wantOk || (got == nil) != (len(tt.want) == 0) || (got != nil && *got != tt.want) {
+				t.Errorf("getOrderByQueryParam() = %v, %t, want %q, %t", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestGetFieldsQueryParam(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		want   []string
+		wantOk bool
+	}{
+		{"not given", "", nil, true},
+		{"selected", "?fields=id,subject,date_created", []string{"id", "subject", "date_created"}, true},
+		{"spaces and empty", "?fields=id,%20subject,,", []string{"id", "subject"}, true},
+		{"not allowed", "?fields=id,recipients_secret", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/messages"+tt.query, nil)
+			got, ok := getFieldsQueryParam(req, model.UserMessageFields)
+			if ok != tt.wantOk || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getFieldsQueryParam() = %v, %t, want %v, %t", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestGetTopicsPagingQueryParams(t *testing.T) {
+	int64Ptr := func(value int64) *int64 { return &value }
+
+	tests := []struct {
+		name       string
+		query      string
+		wantOffset *int64
+		wantLimit  *int64
+		wantSortBy string
+		wantOk     bool
+	}{
+		{"all topics", "", nil, nil, "", true},
+		{"page", "?offset=20&limit=10&sort_by=subscribers&order=desc", int64Ptr(20), int64Ptr(10), model.TopicsSortBySubscribers, true},
+		{"offset only", "?offset=20", int64Ptr(20), int64Ptr(defaultPaginationLimit), "", true},
+		{"sort only", "?sort_by=date_created", nil, nil, model.TopicsSortByDateCreated, true},
+		{"unsupported sort", "?sort_by=alias", nil, nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/topics"+tt.query, nil)
+			offset, limit, sortBy, _, ok := getTopicsPagingQueryParams(req)
+			if ok != tt.wantOk || !reflect.DeepEqual(offset, tt.wantOffset) || !reflect.DeepEqual(limit, tt.wantLimit) ||
+				(sortBy == nil) != (len(tt.wantSortBy) == 0) || (sortBy != nil && *sortBy != tt.wantSortBy) {
+				t.Errorf("getTopicsPagingQueryParams() = %v, %v, %v, %t, want %v, %v, %q, %t", offset, limit, sortBy, ok, tt.wantOffset, tt.wantLimit, tt.wantSortBy, tt.wantOk)
+			}
+		})
+	}
+}
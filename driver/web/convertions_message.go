@@ -1,6 +1,7 @@
 package web
 
 import (
+	"fmt"
 	"notifications/core/model"
 	Def "notifications/driver/web/docs/gen"
 )
@@ -18,7 +19,26 @@ func recipientsCriteriaListFromDef(items []Def.SharedReqCreateMessageInputRecipi
 func messagesRecipientsListFromDef(items []Def.SharedReqCreateMessageInputMessageRecipient) []model.MessageRecipient {
 	result := make([]model.MessageRecipient, len(items))
 	for i, item := range items {
-		result[i] = model.MessageRecipient{UserID: item.UserId, Mute: item.Mute}
+		var data map[string]string
+		if len(item.Data) > 0 {
+			data = make(map[string]string, len(item.Data))
+			for key, value := range item.Data {
+				data[key] = fmt.Sprintf("%v", value)
+			}
+		}
+		result[i] = model.MessageRecipient{UserID: item.UserId, Mute: item.Mute, Data: data}
+	}
+	return result
+}
+
+// Attachment Type
+func attachmentsListFromDef(items []Def.SharedReqCreateMessageInputAttachment) []model.Attachment {
+	if len(items) == 0 {
+		return nil
+	}
+	result := make([]model.Attachment, len(items))
+	for i, item := range items {
+		result[i] = model.Attachment{URL: item.Url, Type: item.Type, Name: item.Name}
 	}
 	return result
 }
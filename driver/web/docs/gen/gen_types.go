@@ -3,53 +3,305 @@
 // Code generated by github.com/deepmap/oapi-codegen/v2 version v2.0.0 DO NOT EDIT.
 package Def
 
+import (
+	"time"
+)
+
 const (
 	BearerAuthScopes = "bearerAuth.Scopes"
 )
 
+// Attachment defines model for Attachment.
+type Attachment struct {
+	Name *string `json:"name,omitempty"`
+	Type *string `json:"type,omitempty"`
+	Url  *string `json:"url,omitempty"`
+}
+
+// BroadcastPreview defines model for BroadcastPreview.
+type BroadcastPreview struct {
+	// AudienceCount the users with at least one token which have not disabled the notifications
+	AudienceCount *int64 `json:"audience_count,omitempty"`
+
+	// ConfirmationExpires the confirmation token cannot be used after it
+	ConfirmationExpires *time.Time `json:"confirmation_expires,omitempty"`
+
+	// ConfirmationToken sends the broadcast of the same subject and body to up to the audience count
+	ConfirmationToken *string `json:"confirmation_token,omitempty"`
+}
+
+// BulkMessageResult defines model for BulkMessageResult.
+type BulkMessageResult struct {
+	// Error the reason for which the message is not created
+	Error *string `json:"error,omitempty"`
+
+	// Index the position of the message in the request
+	Index   *int     `json:"index,omitempty"`
+	Message *Message `json:"message,omitempty"`
+}
+
 // CoreAccountRef defines model for CoreAccountRef.
 type CoreAccountRef struct {
 	Name   *string `json:"name,omitempty"`
 	UserId *string `json:"user_id,omitempty"`
 }
 
+// DeadDeviceToken defines model for DeadDeviceToken.
+type DeadDeviceToken struct {
+	AppPlatform *string `json:"app_platform,omitempty"`
+	DateUpdated *string `json:"date_updated,omitempty"`
+
+	// FailuresCount consecutive sends failed because of an invalid token
+	FailuresCount *int    `json:"failures_count,omitempty"`
+	Token         *string `json:"token,omitempty"`
+
+	// TokenType empty for Firebase, airship or apns
+	TokenType *string `json:"token_type,omitempty"`
+	UserId    *string `json:"user_id,omitempty"`
+}
+
+// DeliveryLog defines model for DeliveryLog.
+type DeliveryLog struct {
+	AppId *string `json:"app_id,omitempty"`
+
+	// Channel push, email or sms
+	Channel     *string `json:"channel,omitempty"`
+	DateCreated *string `json:"date_created,omitempty"`
+
+	// ErrorCode set if the attempt has failed - unregistered, invalid_argument, quota_exceeded, unavailable, payload_too_large or internal
+	ErrorCode          *string `json:"error_code,omitempty"`
+	Id                 *string `json:"id,omitempty"`
+	MessageId          *string `json:"message_id,omitempty"`
+	MessageRecipientId *string `json:"message_recipient_id,omitempty"`
+	OrgId              *string `json:"org_id,omitempty"`
+
+	// Status delivered, emailed, texted or failed
+	Status *string `json:"status,omitempty"`
+	UserId *string `json:"user_id,omitempty"`
+}
+
+// DeliveryReceipt posted to the message callback url when the message has been dispatched, signed with the X-Notifications-Signature header
+type DeliveryReceipt struct {
+	DateSent        *string                    `json:"date_sent,omitempty"`
+	DeliverySummary *DeliverySummary           `json:"delivery_summary,omitempty"`
+	MessageId       *string                    `json:"message_id,omitempty"`
+	Recipients      *[]RecipientDeliveryStatus `json:"recipients,omitempty"`
+}
+
+// DeliverySummary defines model for DeliverySummary.
+type DeliverySummary struct {
+	Delivered *int `json:"delivered,omitempty"`
+
+	// Errors failed recipients count by error code - unregistered, invalid_argument, quota_exceeded, unavailable, payload_too_large, no_email, no_phone or internal
+	Errors *map[string]int `json:"errors,omitempty"`
+
+	// Expired recipients which were not sent as the message had expired
+	Expired *int `json:"expired,omitempty"`
+	Failed  *int `json:"failed,omitempty"`
+	Read    *int `json:"read,omitempty"`
+	Sent    *int `json:"sent,omitempty"`
+}
+
 // DeviceToken defines model for DeviceToken.
 type DeviceToken struct {
 	AppPlatform *string `json:"app_platform,omitempty"`
 	AppVersion  *string `json:"app_version,omitempty"`
 	DateCreated *string `json:"date_created,omitempty"`
 	DateUpdated *string `json:"date_updated,omitempty"`
-	Token       *string `json:"token,omitempty"`
-	TokenType   *string `json:"token_type,omitempty"`
+	DeviceId    *string `json:"device_id,omitempty"`
+
+	// FailuresCount consecutive sends failed because of an invalid token
+	FailuresCount *int `json:"failures_count,omitempty"`
+
+	// NotificationsDisabled the user does not receive the pushes on this device
+	NotificationsDisabled *bool   `json:"notifications_disabled,omitempty"`
+	Token                 *string `json:"token,omitempty"`
+	TokenType             *string `json:"token_type,omitempty"`
+}
+
+// FailedRecipient defines model for FailedRecipient.
+type FailedRecipient struct {
+	// Reason no_device_tokens, notifications_disabled or a delivery error code - unregistered, invalid_argument, quota_exceeded, unavailable, payload_too_large, no_email, no_phone or internal
+	Reason *string `json:"reason,omitempty"`
+	UserId *string `json:"user_id,omitempty"`
 }
 
 // Message defines model for Message.
 type Message struct {
-	Id                       *string                 `json:"_id,omitempty"`
-	AppId                    *string                 `json:"app_id,omitempty"`
-	Body                     *string                 `json:"body,omitempty"`
-	Data                     *[]string               `json:"data,omitempty"`
-	DateCreated              *string                 `json:"date_created,omitempty"`
-	DateUpdated              *string                 `json:"date_updated,omitempty"`
-	OrgId                    *string                 `json:"org_id,omitempty"`
-	Priority                 *string                 `json:"priority,omitempty"`
+	Id *string `json:"_id,omitempty"`
+
+	// ActiveWithinMinutes the push is sent only to the users active within these minutes
+	ActiveWithinMinutes *int `json:"active_within_minutes,omitempty"`
+
+	// AndroidChannelId the Android notification channel, the service default channel is used if not set
+	AndroidChannelId *string `json:"android_channel_id,omitempty"`
+	AppId            *string `json:"app_id,omitempty"`
+
+	// ApprovalStatus pending_approval or approved, not set if the message does not require approval
+	ApprovalStatus *string         `json:"approval_status,omitempty"`
+	ApprovedBy     *CoreAccountRef `json:"approved_by,omitempty"`
+	Attachments    *[]Attachment   `json:"attachments,omitempty"`
+
+	// Badge the app badge count, the badge is left unchanged if not set
+	Badge *int    `json:"badge,omitempty"`
+	Body  *string `json:"body,omitempty"`
+
+	// Broadcast the message is sent to all the users with a token and it has no recipients
+	Broadcast *bool `json:"broadcast,omitempty"`
+
+	// CallbackUrl the delivery receipt is posted to it when the message has been dispatched
+	CallbackUrl *string `json:"callback_url,omitempty"`
+
+	// CollapseKey the devices keep only the latest notification with the same collapse key
+	CollapseKey *string `json:"collapse_key,omitempty"`
+
+	// CreateError why the async create of the message has failed
+	CreateError *string `json:"create_error,omitempty"`
+
+	// CreateStatus queued or failed while an async create has not created the message, not set once it has been created
+	CreateStatus *string   `json:"create_status,omitempty"`
+	Data         *[]string `json:"data,omitempty"`
+	DateApproved *string   `json:"date_approved,omitempty"`
+
+	// DateBroadcastEnded when the broadcast has been sent to all the users
+	DateBroadcastEnded *string `json:"date_broadcast_ended,omitempty"`
+
+	// DateCallbackSent when the delivery receipt was posted
+	DateCallbackSent *string `json:"date_callback_sent,omitempty"`
+	DateCreated      *string `json:"date_created,omitempty"`
+	DateDeleted      *string `json:"date_deleted,omitempty"`
+	DateRecalled     *string `json:"date_recalled,omitempty"`
+
+	// DateSendEnded when the last recipient has been sent
+	DateSendEnded *string `json:"date_send_ended,omitempty"`
+
+	// DateSendStarted when the first recipient has been sent
+	DateSendStarted *string          `json:"date_send_started,omitempty"`
+	DateUpdated     *string          `json:"date_updated,omitempty"`
+	DeliverySummary *DeliverySummary `json:"delivery_summary,omitempty"`
+
+	// DeferInactive the push is deferred for the inactive users instead of dropped
+	DeferInactive *bool `json:"defer_inactive,omitempty"`
+
+	// Deleted the message has been deleted and it is hidden until it is restored
+	Deleted *bool `json:"deleted,omitempty"`
+
+	// DeliveryChannels push, email and/or sms, push if not set
+	DeliveryChannels *[]string `json:"delivery_channels,omitempty"`
+
+	// EventTime the event relative to which the message time is computed
+	EventTime *string `json:"event_time,omitempty"`
+
+	// ExcludedRecipients the ids of the users which were excluded from the resolved recipients
+	ExcludedRecipients *[]string `json:"excluded_recipients,omitempty"`
+
+	// ExpiresAt the recipients which have not been sent until this time are skipped
+	ExpiresAt *string `json:"expires_at,omitempty"`
+
+	// FailedRecipients the recipients to which the push cannot be sent, given only on creating when requested
+	FailedRecipients *[]FailedRecipient `json:"failed_recipients,omitempty"`
+
+	// Flagged true when the reports count has reached the threshold or the moderation has flagged it
+	Flagged *bool `json:"flagged,omitempty"`
+
+	// GroupId the thread of the message, the messages of a thread share it
+	GroupId *string `json:"group_id,omitempty"`
+
+	// IdempotencyKey the Idempotency-Key with which the message has been created
+	IdempotencyKey *string `json:"idempotency_key,omitempty"`
+
+	// ImageUrl the image shown in the rich notification
+	ImageUrl *string `json:"image_url,omitempty"`
+
+	// LocalizedBodies the body by locale (i.e. fr or en-US), the recipients without a matching locale get the default body
+	LocalizedBodies *map[string]string `json:"localized_bodies,omitempty"`
+
+	// LocalizedSubjects the subject by locale (i.e. fr or en-US), the recipients without a matching locale get the default subject
+	LocalizedSubjects *map[string]string `json:"localized_subjects,omitempty"`
+
+	// ModerationReason the reason for which the moderation has flagged the message for admin review
+	ModerationReason *string `json:"moderation_reason,omitempty"`
+
+	// OffsetBefore seconds before the event time at which the message is sent
+	OffsetBefore *int64  `json:"offset_before,omitempty"`
+	OrgId        *string `json:"org_id,omitempty"`
+	Priority     *string `json:"priority,omitempty"`
+
+	// Recalled the message has been recalled and removed for all its recipients
+	Recalled                 *bool                   `json:"recalled,omitempty"`
 	RecipientAccountCriteria *map[string]interface{} `json:"recipient_account_criteria,omitempty"`
 	Recipients               *Recipient              `json:"recipients,omitempty"`
 	RecipientsCriteriaList   *RecipientCriteria      `json:"recipients_criteria_list,omitempty"`
-	Sender                   *Sender                 `json:"sender,omitempty"`
-	Subject                  *string                 `json:"subject,omitempty"`
-	Topic                    *string                 `json:"topic,omitempty"`
+	Reports                  *[]struct {
+		DateCreated *string `json:"date_created,omitempty"`
+		Reason      *string `json:"reason,omitempty"`
+		ReporterId  *string `json:"reporter_id,omitempty"`
+	} `json:"reports,omitempty"`
+	ReportsCount *int                `json:"reports_count,omitempty"`
+	SendMetrics  *MessageSendMetrics `json:"send_metrics,omitempty"`
+	Sender       *Sender             `json:"sender,omitempty"`
+
+	// PerDevice the push is sent to every device of a recipient, only to the most recently registered one otherwise
+	PerDevice *bool `json:"per_device,omitempty"`
+
+	// Silent the push is data only
+	Silent *bool `json:"silent,omitempty"`
+
+	// Sound the push sound, the device default if not set
+	Sound *string `json:"sound,omitempty"`
+
+	// SourceApp the building block or application which sent the message
+	SourceApp *string `json:"source_app,omitempty"`
+	Subject   *string `json:"subject,omitempty"`
+	Topic     *string `json:"topic,omitempty"`
 }
 
 // MessageRecipient defines model for MessageRecipient.
 type MessageRecipient struct {
-	AppId     *string `json:"app_id,omitempty"`
-	Id        *string `json:"id,omitempty"`
-	MessageId *string `json:"message_id,omitempty"`
-	Mute      *bool   `json:"mute,omitempty"`
-	OrgId     *string `json:"org_id,omitempty"`
-	Read      *bool   `json:"read,omitempty"`
-	UserId    *string `json:"user_id,omitempty"`
+	AppId *string `json:"app_id,omitempty"`
+
+	// DeliveryErrorCode the error code if the delivery has failed - unregistered, invalid_argument, quota_exceeded, unavailable, payload_too_large, no_email, no_phone or internal
+	DeliveryErrorCode *string `json:"delivery_error_code,omitempty"`
+
+	// DeliveryStatus delivered, emailed, texted, failed, no_token, notifications_disabled or expired, not set until the push is sent
+	DeliveryStatus *string `json:"delivery_status,omitempty"`
+	Id             *string `json:"id,omitempty"`
+	MessageId      *string `json:"message_id,omitempty"`
+	Mute           *bool   `json:"mute,omitempty"`
+	OrgId          *string `json:"org_id,omitempty"`
+	Read           *bool   `json:"read,omitempty"`
+	UserId         *string `json:"user_id,omitempty"`
+}
+
+// MessagePreview defines model for MessagePreview.
+type MessagePreview struct {
+	// Body the body rendered with the recipient attributes
+	Body    *string            `json:"body,omitempty"`
+	Data    *map[string]string `json:"data,omitempty"`
+	Subject *string            `json:"subject,omitempty"`
+	UserId  *string            `json:"user_id,omitempty"`
+}
+
+// MessageSendMetrics defines model for MessageSendMetrics.
+type MessageSendMetrics struct {
+	DateEnded       *string  `json:"date_ended,omitempty"`
+	DateStarted     *string  `json:"date_started,omitempty"`
+	DurationSeconds *float32 `json:"duration_seconds,omitempty"`
+
+	// Throughput sent recipients per second
+	Throughput *float32 `json:"throughput,omitempty"`
+}
+
+// MessagesStats defines model for MessagesStats.
+type MessagesStats struct {
+	MutedCount    *int64 `json:"muted_count,omitempty"`
+	NotMutedCount *int64 `json:"not_muted_count,omitempty"`
+	NotReadCount  *int64 `json:"not_read_count,omitempty"`
+
+	// NotReadNotMute the unread messages which are not muted
+	NotReadNotMute *int64 `json:"not_read_not_mute,omitempty"`
+	ReadCount      *int64 `json:"read_count,omitempty"`
+	TotalCount     *int64 `json:"total_count,omitempty"`
 }
 
 // Recipient defines model for Recipient.
@@ -67,6 +319,16 @@ type RecipientCriteria struct {
 	AppVersion  *string `json:"app_version,omitempty"`
 }
 
+// RecipientDeliveryStatus defines model for RecipientDeliveryStatus.
+type RecipientDeliveryStatus struct {
+	// DeliveryStatus delivered, emailed, texted, failed, no_token, notifications_disabled or expired, null if nothing was sent to the recipient
+	DeliveryStatus *string `json:"delivery_status"`
+
+	// ErrorCode the error code if the delivery has failed
+	ErrorCode *string `json:"error_code,omitempty"`
+	UserId    *string `json:"user_id,omitempty"`
+}
+
 // Sender defines model for Sender.
 type Sender struct {
 	Type *string         `json:"type,omitempty"`
@@ -75,12 +337,52 @@ type Sender struct {
 
 // Topic defines model for Topic.
 type Topic struct {
-	AppId       *string `json:"app_id,omitempty"`
-	DateCreated *string `json:"date_created,omitempty"`
-	DateUpdated *string `json:"date_updated,omitempty"`
+	Aliases     *[]string `json:"aliases,omitempty"`
+	AppId       *string   `json:"app_id,omitempty"`
+	DateCreated *string   `json:"date_created,omitempty"`
+	DateUpdated *string   `json:"date_updated,omitempty"`
+
+	// DefaultSound the push sound for the topic messages which do not give their own sound
+	DefaultSound    *string `json:"default_sound,omitempty"`
+	DeliveryWindows *[]struct {
+		// End 15:04 format, before start means the next day
+		End *string `json:"end,omitempty"`
+
+		// Start 15:04 format
+		Start *string `json:"start,omitempty"`
+	} `json:"delivery_windows,omitempty"`
 	Description *string `json:"description,omitempty"`
 	Name        *string `json:"name,omitempty"`
 	OrgId       *string `json:"org_id,omitempty"`
+
+	// RetentionDays days after which the topic messages are deleted, overrides the global retention
+	RetentionDays *int `json:"retention_days,omitempty"`
+
+	// SubscribersCount the users subscribed to the topic, given only by the admin APIs
+	SubscribersCount *int `json:"subscribers_count,omitempty"`
+
+	// TimeZone IANA time zone of the delivery windows, UTC if not set
+	TimeZone *string `json:"time_zone,omitempty"`
+}
+
+// TopicDeletion defines model for TopicDeletion.
+type TopicDeletion struct {
+	// AffectedUsers the users unsubscribed from the topic
+	AffectedUsers *int64  `json:"affected_users,omitempty"`
+	Topic         *string `json:"topic,omitempty"`
+}
+
+// TopicStats defines model for TopicStats.
+type TopicStats struct {
+	// AverageRecipients per message
+	AverageRecipients *float32 `json:"average_recipients,omitempty"`
+
+	// LastSent null if no message has been sent
+	LastSent *string `json:"last_sent"`
+
+	// MessagesCount the messages sent to the topic
+	MessagesCount *int64  `json:"messages_count,omitempty"`
+	Topic         *string `json:"topic,omitempty"`
 }
 
 // User defines model for User.
@@ -89,11 +391,51 @@ type User struct {
 	DateCreated           *string        `json:"date_created,omitempty"`
 	DateUpdated           *string        `json:"date_updated,omitempty"`
 	FirebaseTokens        *DeviceToken   `json:"firebase_tokens,omitempty"`
+	Locale                *string        `json:"locale"`
+	MutedTopics           *[]string      `json:"muted_topics,omitempty"`
 	NotificationsDisabled *string        `json:"notifications_disabled,omitempty"`
+	PausedUntil           *string        `json:"paused_until"`
+	QuietHoursEnd         *int           `json:"quiet_hours_end"`
+	QuietHoursStart       *int           `json:"quiet_hours_start"`
+	TimeZone              *string        `json:"time_zone"`
 	Topics                *[]interface{} `json:"topics,omitempty"`
 	UserId                *string        `json:"user_id,omitempty"`
 }
 
+// UserSettings defines model for UserSettings.
+type UserSettings struct {
+	// NotificationsEnabled the user receives the pushes, the messages are listed either way
+	NotificationsEnabled *bool `json:"notifications_enabled,omitempty"`
+
+	// PausedUntil no pushes are sent until then
+	PausedUntil     *string `json:"paused_until"`
+	QuietHoursEnd   *int    `json:"quiet_hours_end"`
+	QuietHoursStart *int    `json:"quiet_hours_start"`
+	TimeZone        *string `json:"time_zone"`
+}
+
+// AdminReqBroadcast defines model for _admin_req_Broadcast.
+type AdminReqBroadcast struct {
+	Body string `json:"body"`
+
+	// ConfirmationToken the token given by the dry run, required unless it is a dry run
+	ConfirmationToken *string            `json:"confirmation_token,omitempty"`
+	Data              *map[string]string `json:"data,omitempty"`
+	Priority          *int               `json:"priority,omitempty"`
+	Subject           string             `json:"subject"`
+}
+
+// AdminReqCreateMessagesBulk defines model for _admin_req_CreateMessagesBulk.
+type AdminReqCreateMessagesBulk struct {
+	Messages []SharedReqCreateMessage `json:"messages"`
+}
+
+// AdminReqPreviewMessageFor defines model for _admin_req_PreviewMessageFor.
+type AdminReqPreviewMessageFor struct {
+	Message   SharedReqCreateMessage                      `json:"message"`
+	Recipient SharedReqCreateMessageInputMessageRecipient `json:"recipient"`
+}
+
 // AdminResGetMessagesStatsItem defines model for _admin_res_GetMessagesStatsItem.
 type AdminResGetMessagesStatsItem struct {
 	DateCreated     string                             `json:"date_created"`
@@ -142,10 +484,22 @@ type ClientReqMessageV2 struct {
 	Message *SharedReqCreateMessage `json:"message,omitempty"`
 }
 
+// ClientReqMessagesRead defines model for _client_req_messages_read.
+type ClientReqMessagesRead struct {
+	// Ids the ids of the messages to update, all the user messages if not set
+	Ids *[]string `json:"ids,omitempty"`
+
+	// Read true if not set
+	Read *bool `json:"read,omitempty"`
+}
+
 // ClientReqToken defines model for _client_req_token.
 type ClientReqToken struct {
-	AppPlatform   *string `json:"app_platform,omitempty"`
-	AppVersion    *string `json:"app_version,omitempty"`
+	AppPlatform *string `json:"app_platform,omitempty"`
+	AppVersion  *string `json:"app_version,omitempty"`
+
+	// DeviceId the device identifier, for debugging the delivery
+	DeviceId      *string `json:"device_id,omitempty"`
 	PreviousToken *string `json:"previous_token,omitempty"`
 	Token         string  `json:"token"`
 	TokenType     *string `json:"token_type,omitempty"`
@@ -156,29 +510,131 @@ type ClientReqUser struct {
 	NotificationsDisabled bool `json:"notifications_disabled"`
 }
 
+// ClientReqUserSettings defines model for _client_req_user_settings.
+type ClientReqUserSettings struct {
+	// NotificationsEnabled The user receives the pushes, the messages are listed either way. It stays as it is if not set
+	NotificationsEnabled *bool `json:"notifications_enabled"`
+
+	// PausedUntil No pushes are sent until this time as unix seconds, it must be in the future. Not set clears the pause
+	PausedUntil *int64 `json:"paused_until"`
+
+	// QuietHoursEnd The quiet hours end as minutes of the day (0-1439). It may be before the start for quiet hours wrapping past midnight
+	QuietHoursEnd *int `json:"quiet_hours_end"`
+
+	// QuietHoursStart The quiet hours start as minutes of the day (0-1439)
+	QuietHoursStart *int `json:"quiet_hours_start"`
+
+	// TimeZone IANA time zone of the quiet hours, UTC if not set
+	TimeZone *string `json:"time_zone"`
+}
+
+// ClientResMessagesRead defines model for _client_res_messages_read.
+type ClientResMessagesRead struct {
+	// Updated count of the messages which were updated
+	Updated *int `json:"updated,omitempty"`
+}
+
 // SharedReqCreateMessage defines model for _shared_req_CreateMessage.
 type SharedReqCreateMessage struct {
-	AppId string                 `json:"app_id"`
-	Body  string                 `json:"body"`
-	Data  map[string]interface{} `json:"data"`
+	// ActiveWithinMinutes the push is sent only to the users active within these minutes
+	ActiveWithinMinutes *int `json:"active_within_minutes,omitempty"`
+
+	// AndroidChannelId the Android notification channel which the app has created, the service default channel is used if not set
+	AndroidChannelId *string                                 `json:"android_channel_id,omitempty"`
+	AppId            string                                  `json:"app_id"`
+	Attachments      []SharedReqCreateMessageInputAttachment `json:"attachments,omitempty"`
+
+	// Badge the app badge count, the badge is left unchanged if not set
+	Badge *int   `json:"badge,omitempty"`
+	Body  string `json:"body"`
+
+	// CallbackUrl the https url to which the delivery receipt is posted when the message has been dispatched. It must not point to a private or a loopback address
+	CallbackUrl *string `json:"callback_url,omitempty"`
+
+	// CollapseKey a later push with the same collapse key replaces the notification on the device, up to 64 bytes
+	CollapseKey *string `json:"collapse_key,omitempty"`
+
+	// Data the push data, with the subject and the body up to 4096 bytes
+	Data map[string]interface{} `json:"data"`
+
+	// DeferInactive defer the push for the inactive users for up to 24 hours instead of dropping it
+	DeferInactive *bool `json:"defer_inactive,omitempty"`
+
+	// DeliveryChannels push, email and/or sms, push if not set. The email and the sms are tried in the given order for the recipients without device tokens
+	DeliveryChannels []string `json:"delivery_channels,omitempty"`
+
+	// EventTime the event time as unix seconds, if set the time is computed as the event time minus offset_before
+	EventTime *int64 `json:"event_time,omitempty"`
+
+	// ExcludeRecipients users which are removed from the recipients resolved by the topics and criteria
+	ExcludeRecipients []SharedReqCreateMessageInputMessageRecipient `json:"exclude_recipients,omitempty"`
+
+	// ExpiresAt the expiration time as unix seconds. The recipients which have not been sent until then are skipped. It must be after the message time - the time, or the event time minus offset_before, deferred to the topics delivery windows, otherwise the message is rejected
+	ExpiresAt *int64 `json:"expires_at,omitempty"`
+
+	// GroupId the thread of the message, the clients get the thread with the group_id messages filter
+	GroupId *string `json:"group_id,omitempty"`
 
 	// Id optional
-	Id                       *string                                        `json:"id,omitempty"`
+	Id *string `json:"id,omitempty"`
+
+	// ImageUrl the http(s) url of the image shown in the rich notification, iOS shows it only if the app has a notification service extension
+	ImageUrl *string `json:"image_url,omitempty"`
+
+	// IncludeFailedRecipients give the recipients to which the push cannot be sent in the response
+	IncludeFailedRecipients *bool `json:"include_failed_recipients,omitempty"`
+
+	// LocalizedBodies the body by locale (i.e. fr or en-US), the recipients without a matching locale get the default body
+	LocalizedBodies map[string]string `json:"localized_bodies,omitempty"`
+
+	// LocalizedSubjects the subject by locale (i.e. fr or en-US), the recipients without a matching locale get the default subject
+	LocalizedSubjects        map[string]string                              `json:"localized_subjects,omitempty"`
 	OrgId                    string                                         `json:"org_id"`
 	Priority                 int                                            `json:"priority"`
 	RecipientAccountCriteria map[string]interface{}                         `json:"recipient_account_criteria"`
 	Recipients               []SharedReqCreateMessageInputMessageRecipient  `json:"recipients"`
 	RecipientsCriteriaList   []SharedReqCreateMessageInputRecipientCriteria `json:"recipients_criteria_list"`
-	Subject                  string                                         `json:"subject"`
-	Time                     *int64                                         `json:"time,omitempty"`
-	Topic                    *string                                        `json:"topic,omitempty"`
-	Topics                   []string                                       `json:"topics,omitempty"`
+
+	// OffsetBefore seconds before the event time at which the message is sent
+	OffsetBefore *int64 `json:"offset_before,omitempty"`
+
+	// PerDevice send the push to every device of a recipient if true, only to the most recently registered device if false. NOTIFICATIONS_DEFAULT_PER_DEVICE if not set
+	PerDevice *bool `json:"per_device,omitempty"`
+
+	// RequiresApproval the message is not sent until a different admin approves it
+	RequiresApproval *bool `json:"requires_approval,omitempty"`
+
+	// Silent send a data only push without an alert, the subject and the body are optional then
+	Silent *bool `json:"silent,omitempty"`
+
+	// SkipIfPast do not send the message if its time relative to the event has passed, it is sent immediately otherwise
+	SkipIfPast *bool `json:"skip_if_past,omitempty"`
+
+	// Sound the push sound, the default sound of the message topics is used if not set and the device default sound if none of them has one
+	Sound *string `json:"sound,omitempty"`
+
+	// SourceApp the building block or application which sends the message, the X-Source-App header is used if not set
+	SourceApp *string  `json:"source_app,omitempty"`
+	Subject   string   `json:"subject"`
+	Time      *int64   `json:"time,omitempty"`
+	Topic     *string  `json:"topic,omitempty"`
+	Topics    []string `json:"topics,omitempty"`
+}
+
+// SharedReqCreateMessageInputAttachment defines model for _shared_req_CreateMessage_InputAttachment.
+type SharedReqCreateMessageInputAttachment struct {
+	Name *string `json:"name,omitempty"`
+
+	// Type MIME type of the attachment, i.e. image/png
+	Type *string `json:"type,omitempty"`
+	Url  string  `json:"url"`
 }
 
 // SharedReqCreateMessageInputMessageRecipient defines model for _shared_req_CreateMessage_InputMessageRecipient.
 type SharedReqCreateMessageInputMessageRecipient struct {
-	Mute   bool   `json:"mute"`
-	UserId string `json:"user_id"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+	Mute   bool                   `json:"mute"`
+	UserId string                 `json:"user_id"`
 }
 
 // SharedReqCreateMessageInputRecipientCriteria defines model for _shared_req_CreateMessage_InputRecipientCriteria.
@@ -190,6 +646,45 @@ type SharedReqCreateMessageInputRecipientCriteria struct {
 // SharedReqCreateMessages defines model for _shared_req_CreateMessages.
 type SharedReqCreateMessages = []SharedReqCreateMessage
 
+// GetApiAdminTopicsParams defines parameters for GetApiAdminTopics.
+type GetApiAdminTopicsParams struct {
+	// Offset offset
+	Offset *string `json:"offset,omitempty"`
+
+	// Limit limit - all topics are given when neither the offset nor the limit is set, otherwise 20 by default and the greater than 500 limits are clamped to 500
+	Limit *string `json:"limit,omitempty"`
+
+	// SortBy sort_by - Possible values: name, date_created, subscribers. Default: name
+	SortBy *string `json:"sort_by,omitempty"`
+
+	// Order order - Possible values: asc, desc. Default: asc
+	Order *string `json:"order,omitempty"`
+}
+
+// DeleteApiAdminTopicNameParams defines parameters for DeleteApiAdminTopicName.
+type DeleteApiAdminTopicNameParams struct {
+	// Force delete the topic even if it has recent messages
+	Force *bool `json:"force,omitempty"`
+}
+
+// GetApiAdminTopicNameSubscribersParams defines parameters for GetApiAdminTopicNameSubscribers.
+type GetApiAdminTopicNameSubscribersParams struct {
+	// Offset offset
+	Offset *string `json:"offset,omitempty"`
+
+	// Limit limit - 20 by default, the greater than 500 limits are clamped to 500. Both are configurable
+	Limit *string `json:"limit,omitempty"`
+}
+
+// PostApiAdminMessageParams defines parameters for PostApiAdminMessage.
+type PostApiAdminMessageParams struct {
+	// Async async - return 202 with the queued message at once and create the message in the background, the admins follow its progress by /admin/message/{id}/status. Default: false
+	Async *bool `json:"async,omitempty"`
+
+	// IdempotencyKey the message is created only once for the same key of the same sender within 24 hours, a retry gives the existing message
+	IdempotencyKey *string `json:"Idempotency-Key,omitempty"`
+}
+
 // GetApiAdminMessagesParams defines parameters for GetApiAdminMessages.
 type GetApiAdminMessagesParams struct {
 	// Offset offset
@@ -208,6 +703,36 @@ type GetApiAdminMessagesParams struct {
 	EndDate string `json:"end_date"`
 }
 
+// GetApiAdminMessagesIdParams defines parameters for GetApiAdminMessagesId.
+type GetApiAdminMessagesIdParams struct {
+	// IncludeDeleted include_deleted - give the message even if it has been deleted. Default: false
+	IncludeDeleted *bool `json:"include_deleted,omitempty"`
+}
+
+// PostApiAdminMessageIdResendParams defines parameters for PostApiAdminMessageIdResend.
+type PostApiAdminMessageIdResendParams struct {
+	// OnlyFailed only_failed - resend to the recipients for which the delivery has failed only. Default: false
+	OnlyFailed *bool `json:"only_failed,omitempty"`
+
+	// Force force - resend the message even if it is older than the resend window. Default: false
+	Force *bool `json:"force,omitempty"`
+}
+
+// GetApiAdminMessageIdDeliveriesParams defines parameters for GetApiAdminMessageIdDeliveries.
+type GetApiAdminMessageIdDeliveriesParams struct {
+	// Offset offset
+	Offset *string `json:"offset,omitempty"`
+
+	// Limit limit - 20 by default, the greater than 500 limits are clamped to 500. Both are configurable
+	Limit *string `json:"limit,omitempty"`
+}
+
+// PostApiAdminBroadcastParams defines parameters for PostApiAdminBroadcast.
+type PostApiAdminBroadcastParams struct {
+	// DryRun dry_run - give the audience size and the confirmation token without sending the broadcast. Default: false
+	DryRun *bool `json:"dry_run,omitempty"`
+}
+
 // GetApiAdminMessagesStatsSourceSourceParams defines parameters for GetApiAdminMessagesStatsSourceSource.
 type GetApiAdminMessagesStatsSourceSourceParams struct {
 	// Offset offset
@@ -218,6 +743,36 @@ type GetApiAdminMessagesStatsSourceSourceParams struct {
 
 	// Order order - Possible values: asc, desc. Default: asc
 	Order *string `json:"order,omitempty"`
+
+	// SourceApp source_app - filter by the messages source app
+	SourceApp *string `json:"source_app,omitempty"`
+
+	// IncludeDeleted include_deleted - give the deleted messages too. Default: false
+	IncludeDeleted *bool `json:"include_deleted,omitempty"`
+}
+
+// GetApiAdminTokensDeadParams defines parameters for GetApiAdminTokensDead.
+type GetApiAdminTokensDeadParams struct {
+	// MinFailures the minimum invalid token failures, 1 if not set
+	MinFailures *int `json:"min_failures,omitempty"`
+}
+
+// DeleteApiAdminTokensDeadParams defines parameters for DeleteApiAdminTokensDead.
+type DeleteApiAdminTokensDeadParams struct {
+	// MinFailures the minimum invalid token failures, 1 if not set
+	MinFailures *int `json:"min_failures,omitempty"`
+}
+
+// GetApiAdminUserUserIdParams defines parameters for GetApiAdminUserUserId.
+type GetApiAdminUserUserIdParams struct {
+	// Reveal give the full device tokens
+	Reveal *bool `json:"reveal,omitempty"`
+}
+
+// PostApiBbsMessageParams defines parameters for PostApiBbsMessage.
+type PostApiBbsMessageParams struct {
+	// Async async - return 202 with the queued message at once and create the message in the background, the admins follow its progress by /admin/message/{id}/status. Default: false
+	Async *bool `json:"async,omitempty"`
 }
 
 // DeleteApiBbsMessagesParams defines parameters for DeleteApiBbsMessages.
@@ -226,6 +781,30 @@ type DeleteApiBbsMessagesParams struct {
 	Ids string `json:"ids"`
 }
 
+// PostApiIntMessageParams defines parameters for PostApiIntMessage.
+type PostApiIntMessageParams struct {
+	// Async async - return 202 with the queued message at once and create the message in the background, the admins follow its progress by /admin/message/{id}/status. Default: false
+	Async *bool `json:"async,omitempty"`
+
+	// IdempotencyKey the message is created only once for the same key of the same sender within 24 hours, a retry gives the existing message
+	IdempotencyKey *string `json:"Idempotency-Key,omitempty"`
+}
+
+// PostApiIntV2MessageParams defines parameters for PostApiIntV2Message.
+type PostApiIntV2MessageParams struct {
+	// Async async - return 202 with the queued message at once and create the message in the background, the admins follow its progress by /admin/message/{id}/status. Default: false
+	Async *bool `json:"async,omitempty"`
+
+	// IdempotencyKey the message is created only once for the same key of the same sender within 24 hours, a retry gives the existing message
+	IdempotencyKey *string `json:"Idempotency-Key,omitempty"`
+}
+
+// PostApiMessageParams defines parameters for PostApiMessage.
+type PostApiMessageParams struct {
+	// Async async - return 202 with the queued message at once and create the message in the background, the admins follow its progress by /admin/message/{id}/status. Default: false
+	Async *bool `json:"async,omitempty"`
+}
+
 // GetApiMessagesParams defines parameters for GetApiMessages.
 type GetApiMessagesParams struct {
 	// Read read
@@ -234,6 +813,15 @@ type GetApiMessagesParams struct {
 	// Mute mute
 	Mute *bool `json:"mute,omitempty"`
 
+	// HasAttachment has_attachment - filter the messages with or without attachments
+	HasAttachment *bool `json:"has_attachment,omitempty"`
+
+	// GroupId group_id - filter the messages of a thread
+	GroupId *string `json:"group_id,omitempty"`
+
+	// Priority priority - filter the messages by priority
+	Priority *int `json:"priority,omitempty"`
+
 	// Offset offset
 	Offset string `json:"offset"`
 
@@ -243,11 +831,35 @@ type GetApiMessagesParams struct {
 	// Order order - Possible values: asc, desc. Default: desc
 	Order string `json:"order"`
 
+	// OrderBy order_by - Possible values: date_created, date_updated. Default: the message time
+	OrderBy *string `json:"order_by,omitempty"`
+
 	// StartDate start_date - Start date filter in milliseconds as an integer epoch value
 	StartDate string `json:"start_date"`
 
 	// EndDate end_date - End date filter in milliseconds as an integer epoch value
 	EndDate string `json:"end_date"`
+
+	// SinceId since_id - the id of the last message the client has, the messages after it are given the oldest first and the X-Next-Cursor header gives the since_id of the next page. It cannot be combined with the offset, the order, the dates or the filters
+	SinceId *string `json:"since_id,omitempty"`
+
+	// Fields fields - comma separated list of the message fields to give, all the fields if not set. Possible values: id, org_id, app_id, priority, subject, sender, body, data, attachments, recipients, recipients_criteria_list, recipient_account_criteria, topic, calculated_recipients_count, date_created, date_updated, time, mute, read
+	Fields *string `json:"fields,omitempty"`
+}
+
+// GetApiTopicsParams defines parameters for GetApiTopics.
+type GetApiTopicsParams struct {
+	// Offset offset
+	Offset *string `json:"offset,omitempty"`
+
+	// Limit limit - all topics are given when neither the offset nor the limit is set, otherwise 20 by default and the greater than 500 limits are clamped to 500
+	Limit *string `json:"limit,omitempty"`
+
+	// SortBy sort_by - Possible values: name, date_created, subscribers. Default: name
+	SortBy *string `json:"sort_by,omitempty"`
+
+	// Order order - Possible values: asc, desc. Default: asc
+	Order *string `json:"order,omitempty"`
 }
 
 // GetApiTopicTopicMessagesParams defines parameters for GetApiTopicTopicMessages.
@@ -268,6 +880,9 @@ type GetApiTopicTopicMessagesParams struct {
 	EndDate string `json:"end_date"`
 }
 
+// PostApiAdminBroadcastJSONRequestBody defines body for PostApiAdminBroadcast for application/json ContentType.
+type PostApiAdminBroadcastJSONRequestBody = AdminReqBroadcast
+
 // PostApiAdminMessageJSONRequestBody defines body for PostApiAdminMessage for application/json ContentType.
 type PostApiAdminMessageJSONRequestBody = SharedReqCreateMessage
 
@@ -277,6 +892,9 @@ type PutApiAdminMessageJSONRequestBody = SharedReqCreateMessage
 // GetApiAdminMessagesJSONRequestBody defines body for GetApiAdminMessages for application/json ContentType.
 type GetApiAdminMessagesJSONRequestBody = ClientReqMessage
 
+// PostApiAdminMessagesBulkJSONRequestBody defines body for PostApiAdminMessagesBulk for application/json ContentType.
+type PostApiAdminMessagesBulkJSONRequestBody = AdminReqCreateMessagesBulk
+
 // PutApiAdminTopicJSONRequestBody defines body for PutApiAdminTopic for application/json ContentType.
 type PutApiAdminTopicJSONRequestBody = Topic
 
@@ -327,3 +945,6 @@ type DeleteApiUserJSONRequestBody = ClientReqUser
 
 // PutApiUserJSONRequestBody defines body for PutApiUser for application/json ContentType.
 type PutApiUserJSONRequestBody = ClientReqUser
+
+// PutApiUserSettingsJSONRequestBody defines body for PutApiUserSettings for application/json ContentType.
+type PutApiUserSettingsJSONRequestBody = ClientReqUserSettings
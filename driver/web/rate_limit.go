@@ -0,0 +1,164 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rokwire/core-auth-library-go/v3/tokenauth"
+	"github.com/rokwire/logging-library-go/v2/logs"
+	"github.com/rokwire/logging-library-go/v2/logutils"
+)
+
+// senderRateLimiter limits the messages which a sender can create
+//
+// It keeps a token bucket per sender in memory, so every service instance limits the senders on its own
+// and a sender may create up to the rate times the instances count messages per minute in total.
+// The buckets which have not been used for a refill window are full, so they are removed to keep the memory bounded.
+type senderRateLimiter struct {
+	allowlist map[string]bool
+
+	ratePerMin int //0 means no limit
+	buckets    map[string]*senderBucket
+	dateSwept  time.Time
+	lock       sync.Mutex
+}
+
+// bucketRefillWindow is the time for which an empty bucket is refilled to its capacity
+const bucketRefillWindow = time.Minute
+
+// senderBucket is the token bucket of a sender
+type senderBucket struct {
+	tokens      float64
+	dateUpdated time.Time
+}
+
+// isExempt checks if the sender is in the allowlist
+func (rl *senderRateLimiter) isExempt(senderID string) bool {
+	return rl.allowlist[senderID]
+}
+
+// allow checks if the sender is allowed to send now, if not it gives the wait until the sender is allowed again
+func (rl *senderRateLimiter) allow(senderID string) (bool, time.Duration) {
+	return rl.allowAt(senderID, time.Now())
+}
+
+// allowAt checks if the sender is allowed to send at the given time
+func (rl *senderRateLimiter) allowAt(senderID string, now time.Time) (bool, time.Duration) {
+	//the allowlisted senders are never limited
+	if rl.isExempt(senderID) || rl.ratePerMin <= 0 {
+		return true, 0
+	}
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	rl.sweep(now)
+
+	capacity := float64(rl.ratePerMin)
+	bucket := rl.buckets[senderID]
+	if bucket == nil {
+		bucket = &senderBucket{tokens: capacity, dateUpdated: now}
+		rl.buckets[senderID] = bucket
+	} else {
+		//refill the tokens for the elapsed time
+		refill := float64(now.Sub(bucket.dateUpdated)) / float64(bucketRefillWindow) * capacity
+		bucket.tokens = math.Min(capacity, bucket.tokens+refill)
+		bucket.dateUpdated = now
+	}
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / capacity * float64(bucketRefillWindow))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep removes the buckets which have not been used for a refill window, it runs at most once per window.
+// Such a bucket is full, so removing it does not change the sender limit. The lock must be held.
+func (rl *senderRateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.dateSwept) < bucketRefillWindow {
+		return
+	}
+	for senderID, bucket := range rl.buckets {
+		if now.Sub(bucket.dateUpdated) >= bucketRefillWindow {
+			delete(rl.buckets, senderID)
+		}
+	}
+	rl.dateSwept = now
+}
+
+// newSenderRateLimiter creates new sender rate limiter
+func newSenderRateLimiter(allowlist []string, ratePerMin int) *senderRateLimiter {
+	allowlistMap := make(map[string]bool, len(allowlist))
+	for _, senderID := range allowlist {
+		allowlistMap[senderID] = true
+	}
+	return &senderRateLimiter{allowlist: allowlistMap, ratePerMin: ratePerMin, buckets: map[string]*senderBucket{}}
+}
+
+// rateLimitSender gives the sender of a request - the token subject or the internal API key id for the internal calls
+func rateLimitSender(r *http.Request, claims *tokenauth.Claims) string {
+	if claims != nil {
+		return claims.Subject
+	}
+	apiKey := r.Header.Get("INTERNAL-API-KEY")
+	if len(apiKey) == 0 {
+		return ""
+	}
+	return internalSenderID(apiKey)
+}
+
+// internalSenderID gives the sender id of an internal API key - "internal:" and the hex SHA-256 of the key.
+// It is not secret, so it can be logged and put in the allowlist instead of the key.
+func internalSenderID(apiKey string) string {
+	hash := sha256.Sum256([]byte(apiKey))
+	return "internal:" + hex.EncodeToString(hash[:])
+}
+
+// rateLimited wraps a handler so that it is invoked only if the sender is allowed to send
+func (we Adapter) rateLimited(handler handlerFunc) handlerFunc {
+	return func(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+		senderID := rateLimitSender(r, claims)
+		if len(senderID) > 0 {
+			allowed, retryAfter := we.senderRateLimiter.allow(senderID)
+			if !allowed {
+				response := l.HTTPResponseErrorData(logutils.StatusInvalid, "rate limit", &logutils.FieldArgs{"sender": senderID}, nil, http.StatusTooManyRequests, false)
+				setRetryAfterHeader(&response, retryAfter)
+				return response
+			}
+		}
+		return handler(l, r, claims)
+	}
+}
+
+// setRetryAfterHeader sets the retry after header of a rate limited response in whole seconds
+func setRetryAfterHeader(response *logs.HTTPResponse, retryAfter time.Duration) {
+	if response.Headers == nil {
+		response.Headers = map[string][]string{}
+	}
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	response.Headers["Retry-After"] = []string{strconv.FormatInt(seconds, 10)}
+}
@@ -0,0 +1,147 @@
+// Copyright 2022 Board of Trustees of the University of Illinois.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rokwire/core-auth-library-go/v3/tokenauth"
+	"github.com/rokwire/logging-library-go/v2/logs"
+)
+
+func TestSenderRateLimiterAllow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	type request struct {
+		after   time.Duration //since the start
+		allowed bool
+		wait    time.Duration
+	}
+	tests := []struct {
+		name       string
+		ratePerMin int
+		allowlist  []string
+		requests   []request
+	}{
+		{"no limit", 0, nil, []request{{0, true, 0}, {0, true, 0}, {0, true, 0}}},
+		{"burst up to the rate", 2, nil, []request{{0, true, 0}, {0, true, 0}, {0, false, 30 * time.Second}}},
+		{"partial refill", 2, nil, []request{{0, true, 0}, {0, true, 0}, {15 * time.Second, false, 15 * time.Second},
+			{30 * time.Second, true, 0}, {30 * time.Second, false, 30 * time.Second}}},
+		{"refill up to the capacity", 2, nil, []request{{0, true, 0}, {0, true, 0}, {10 * time.Minute, true, 0},
+			{10 * time.Minute, true, 0}, {10 * time.Minute, false, 30 * time.Second}}},
+		{"allowlisted", 1, []string{"sender"}, []request{{0, true, 0}, {0, true, 0}, {0, true, 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := newSenderRateLimiter(tt.allowlist, tt.ratePerMin)
+			for i, req := range tt.requests {
+				allowed, wait := rl.allowAt("sender", start.Add(req.after))
+				if allowed != req.allowed || wait != req.wait {
+					t.Errorf("request %d: allowAt() = %t, %s, want %t, %s", i, allowed, wait, req.allowed, req.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestSenderRateLimiterSweep(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	rl := newSenderRateLimiter(nil, 10)
+
+	rl.allowAt("idle", start)
+	rl.allowAt("active", start.Add(30*time.Second))
+	rl.allowAt("active", start.Add(bucketRefillWindow))
+
+	if _, ok := rl.buckets["idle"]; ok {
+		t.Errorf("the idle bucket is not removed")
+	}
+	if _, ok := rl.buckets["active"]; !ok {
+		t.Errorf("the active bucket is removed")
+	}
+
+	//the sweep runs at most once per refill window
+	rl.allowAt("other", start.Add(bucketRefillWindow+bucketRefillWindow/2))
+	if _, ok := rl.buckets["active"]; !ok {
+		t.Errorf("the active bucket is removed before the next sweep")
+	}
+	rl.allowAt("other", start.Add(3*bucketRefillWindow))
+	if len(rl.buckets) != 1 {
+		t.Errorf("buckets count = %d, want 1", len(rl.buckets))
+	}
+}
+
+func TestRateLimitSender(t *testing.T) {
+	apiKey := "secret-internal-api-key"
+	req, _ := http.NewRequest(http.MethodPost, "/notifications/api/int/message", nil)
+	req.Header.Set("INTERNAL-API-KEY", apiKey)
+
+	senderID := rateLimitSender(req, nil)
+	if senderID != internalSenderID(apiKey) {
+		t.Errorf("rateLimitSender() = %s, want %s", senderID, internalSenderID(apiKey))
+	}
+	if !strings.HasPrefix(senderID, "internal:") || strings.Contains(senderID, apiKey) {
+		t.Errorf("rateLimitSender() = %s, want the key hash", senderID)
+	}
+
+	noKeyReq, _ := http.NewRequest(http.MethodPost, "/notifications/api/int/message", nil)
+	if senderID := rateLimitSender(noKeyReq, nil); len(senderID) > 0 {
+		t.Errorf("rateLimitSender() = %s, want empty", senderID)
+	}
+}
+
+func TestRateLimitedAllowlist(t *testing.T) {
+	apiKey := "system-internal-api-key"
+	we := Adapter{senderRateLimiter: newSenderRateLimiter([]string{"system", internalSenderID(apiKey)}, 1)}
+	handler := we.rateLimited(func(l *logs.Log, r *http.Request, claims *tokenauth.Claims) logs.HTTPResponse {
+		return logs.HTTPResponse{ResponseCode: http.StatusOK}
+	})
+	l := logs.NewLogger("notifications", nil).NewLog("test", logs.RequestContext{})
+	system := &tokenauth.Claims{}
+	system.Subject = "system"
+	user := &tokenauth.Claims{}
+	user.Subject = "user"
+
+	tests := []struct {
+		name   string
+		claims *tokenauth.Claims
+		apiKey string
+		want   []int
+	}{
+		{"allowlisted user", system, "", []int{http.StatusOK, http.StatusOK, http.StatusOK}},
+		{"allowlisted internal key", nil, apiKey, []int{http.StatusOK, http.StatusOK, http.StatusOK}},
+		{"other user", user, "", []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}},
+		{"other internal key", nil, "other-internal-api-key", []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, want := range tt.want {
+				req, _ := http.NewRequest(http.MethodPost, "/notifications/api/message", nil)
+				if len(tt.apiKey) > 0 {
+					req.Header.Set("INTERNAL-API-KEY", tt.apiKey)
+				}
+				response := handler(l, req, tt.claims)
+				if response.ResponseCode != want {
+					t.Errorf("request %d: rateLimited() = %d, want %d", i, response.ResponseCode, want)
+				}
+				if want == http.StatusTooManyRequests && len(response.Headers["Retry-After"]) == 0 {
+					t.Errorf("request %d: rateLimited() has no Retry-After header", i)
+				}
+			}
+		})
+	}
+}
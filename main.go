@@ -19,13 +19,19 @@ import (
 	"notifications/core"
 	"notifications/core/model"
 	"notifications/driven/airship"
+	"notifications/driven/apns"
 	corebb "notifications/driven/core"
 	"notifications/driven/firebase"
 	"notifications/driven/mailer"
+	"notifications/driven/moderation"
+	"notifications/driven/sms"
 	storage "notifications/driven/storage"
+	"notifications/driven/webhook"
 	driver "notifications/driver/web"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rokwire/core-auth-library-go/v3/authservice"
 	"github.com/rokwire/core-auth-library-go/v3/authutils"
@@ -65,21 +71,43 @@ func main() {
 	mongoDBAuth := envLoader.GetAndLogEnvVar("MONGO_AUTH", true, true)
 	mongoDBName := envLoader.GetAndLogEnvVar("MONGO_DATABASE", true, false)
 	mongoTimeout := envLoader.GetAndLogEnvVar("MONGO_TIMEOUT", false, false)
+	mongoOpTimeout := envLoader.GetAndLogEnvVar("MONGO_OP_TIMEOUT", false, false)
+	mongoWriteRetries := envLoader.GetAndLogEnvVar("MONGO_WRITE_RETRIES", false, false)
+	mongoMaxPoolSize := envLoader.GetAndLogEnvVar("MONGO_MAX_POOL_SIZE", false, false)
+	mongoMinPoolSize := envLoader.GetAndLogEnvVar("MONGO_MIN_POOL_SIZE", false, false)
+	mongoMaxIdleTime := envLoader.GetAndLogEnvVar("MONGO_MAX_IDLE_TIME", false, false)
 	mtOrgID := envLoader.GetAndLogEnvVar("NOTIFICATIONS_MULTI_TENANCY_ORG_ID", true, false)
 	mtAppID := envLoader.GetAndLogEnvVar("NOTIFICATIONS_MULTI_TENANCY_APP_ID", true, false)
-	storageAdapter := storage.NewStorageAdapter(mongoDBAuth, mongoDBName, mongoTimeout, mtOrgID, mtAppID, logger)
+	tokenEncryptionKey := envLoader.GetAndLogEnvVar("TOKEN_ENCRYPTION_KEY", false, true)
+	storageAdapter := storage.NewStorageAdapter(mongoDBAuth, mongoDBName, mongoTimeout, mongoOpTimeout, mongoWriteRetries,
+		mongoMaxPoolSize, mongoMinPoolSize, mongoMaxIdleTime, mtOrgID, mtAppID, tokenEncryptionKey, Version == "dev", logger)
 	err := storageAdapter.Start()
 	if err != nil {
 		logger.Fatal("Cannot start the mongoDB adapter - " + err.Error())
 	}
 
+	//one-time migration - encrypt the device tokens stored before the encryption was enabled and exit
+	if len(os.Args) > 1 && os.Args[1] == "encrypt-tokens" {
+		updated, err := storageAdapter.EncryptDeviceTokens()
+		if err != nil {
+			logger.Fatalf("Error encrypting the device tokens after %d users: %v", updated, err)
+		}
+		logger.Infof("Encrypted the device tokens of %d users", updated)
+		return
+	}
+
 	// firebase adapter
 	firebaseConfs, err := storageAdapter.LoadFirebaseConfigurations()
 	_, err = storageAdapter.LoadFirebaseConfigurations()
 	if err != nil {
 		logger.Fatal("Error loading the firebase configurations from the storage - " + err.Error())
 	}
-	firebaseAdapter := firebase.NewFirebaseAdapter()
+	firebaseSendTimeout := envLoader.GetAndLogEnvVar("FIREBASE_SEND_TIMEOUT", false, false)
+	firebaseAndroidHighPriority := envLoader.GetAndLogEnvVar("FIREBASE_ANDROID_HIGH_PRIORITY", false, false)
+	firebaseAPNsHighPriority := envLoader.GetAndLogEnvVar("FIREBASE_APNS_HIGH_PRIORITY", false, false)
+	firebaseDefaultAndroidChannel := envLoader.GetAndLogEnvVar("DEFAULT_ANDROID_CHANNEL", false, false)
+	firebaseSendConcurrency, _ := strconv.Atoi(envLoader.GetAndLogEnvVar("FIREBASE_SEND_CONCURRENCY", false, false))
+	firebaseAdapter := firebase.NewFirebaseAdapter(firebaseSendTimeout, firebaseAndroidHighPriority, firebaseAPNsHighPriority, firebaseDefaultAndroidChannel)
 	err = firebaseAdapter.Start(firebaseConfs)
 	if err != nil {
 		logger.Warn("Cannot start the Firebase adapter - " + err.Error())
@@ -90,6 +118,37 @@ func main() {
 	airshipBearerToken := envLoader.GetAndLogEnvVar("NOTIFICATIONS_AIRSHIP_BEARER_TOKEN", false, true)
 	airshipAdapter := airship.NewAirshipAdapter(airshipHost, airshipBearerToken)
 
+	//apns adapter
+	apnsHost := envLoader.GetAndLogEnvVar("APNS_HOST", false, false)
+	apnsKey := envLoader.GetAndLogEnvVar("APNS_KEY", false, true)
+	apnsKeyID := envLoader.GetAndLogEnvVar("APNS_KEY_ID", false, false)
+	apnsTeamID := envLoader.GetAndLogEnvVar("APNS_TEAM_ID", false, false)
+	apnsBundleID := envLoader.GetAndLogEnvVar("APNS_BUNDLE_ID", false, false)
+	apnsAdapter := apns.NewAPNsAdapter(apnsHost, apnsKeyID, apnsTeamID, apnsBundleID)
+	if apnsKey != "" {
+		err = apnsAdapter.Start(strings.ReplaceAll(apnsKey, "\\n", "\n"))
+		if err != nil {
+			logger.Warn("Cannot start the APNs adapter - " + err.Error())
+		}
+	}
+
+	//sms adapter
+	twilioSID := envLoader.GetAndLogEnvVar("TWILIO_SID", false, false)
+	twilioToken := envLoader.GetAndLogEnvVar("TWILIO_TOKEN", false, true)
+	twilioFrom := envLoader.GetAndLogEnvVar("TWILIO_FROM", false, false)
+	smsAdapter := sms.NewSMSAdapter(twilioSID, twilioToken, twilioFrom)
+
+	//webhook adapter
+	callbackSecret := envLoader.GetAndLogEnvVar("NOTIFICATIONS_CALLBACK_SECRET", false, true)
+	webhookAdapter := webhook.NewWebhookAdapter(callbackSecret)
+	subscriptionWebhookURL := envLoader.GetAndLogEnvVar("SUBSCRIPTION_WEBHOOK_URL", false, false)
+	if len(subscriptionWebhookURL) > 0 {
+		err := model.ValidateCallbackURL(subscriptionWebhookURL)
+		if err != nil {
+			logger.Fatalf("Invalid subscription webhook url: %v", err)
+		}
+	}
+
 	smtpHost := envLoader.GetAndLogEnvVar("SMTP_HOST", false, false)
 	smtpPort := envLoader.GetAndLogEnvVar("SMTP_PORT", false, false)
 	smtpUser := envLoader.GetAndLogEnvVar("SMTP_USER", false, false)
@@ -98,11 +157,52 @@ func main() {
 	smtpPortNum, _ := strconv.Atoi(smtpPort)
 	mailAdapter := mailer.NewMailerAdapter(smtpHost, smtpPortNum, smtpUser, smtpPassword, smtpFrom)
 
+	//moderation adapter
+	var moderator core.Moderator
+	moderationEnabled, _ := strconv.ParseBool(envLoader.GetAndLogEnvVar("NOTIFICATIONS_MODERATION_ENABLED", false, false))
+	if moderationEnabled {
+		moderationBlockedWords := envLoader.GetAndLogEnvVar("NOTIFICATIONS_MODERATION_BLOCKED_WORDS", false, true)
+		moderationFlaggedWords := envLoader.GetAndLogEnvVar("NOTIFICATIONS_MODERATION_FLAGGED_WORDS", false, true)
+		moderator = moderation.NewModerationAdapter(parseList(moderationBlockedWords), parseList(moderationFlaggedWords))
+	}
+
 	// web adapter
 	host := envLoader.GetAndLogEnvVar("HOST", true, false)
 	internalAPIKey := envLoader.GetAndLogEnvVar("INTERNAL_API_KEY", true, true)
+	previousInternalAPIKeys := envLoader.GetAndLogEnvVar("INTERNAL_API_KEY_PREVIOUS", false, true)
+	internalAPIKeysRotationEnd, err := parseTime(envLoader.GetAndLogEnvVar("INTERNAL_API_KEY_ROTATION_END", false, false))
+	if err != nil {
+		logger.Fatalf("Error parsing the internal api key rotation end: %v", err)
+	}
 	coreBBHost := envLoader.GetAndLogEnvVar("CORE_BB_HOST", true, false)
+	envCORSAllowedOrigins := envLoader.GetAndLogEnvVar("CORS_ALLOWED_ORIGINS", false, false)
+	envCORSAllowedHeaders := envLoader.GetAndLogEnvVar("CORS_ALLOWED_HEADERS", false, false)
+	corsExcludeAdmin, _ := strconv.ParseBool(envLoader.GetAndLogEnvVar("CORS_EXCLUDE_ADMIN", false, false))
+	maxRequestBodyBytes, _ := strconv.ParseInt(envLoader.GetAndLogEnvVar("MAX_REQUEST_BODY_BYTES", false, false), 10, 64)
 	notificationsServiceURL := envLoader.GetAndLogEnvVar("NOTIFICATIONS_SERVICE_URL", true, false)
+	defaultMessageData := envLoader.GetAndLogEnvVar("NOTIFICATIONS_DEFAULT_MESSAGE_DATA", false, false)
+	rateLimitAllowlist := envLoader.GetAndLogEnvVar("NOTIFICATIONS_RATE_LIMIT_ALLOWLIST", false, false)
+	senderRatePerMin, _ := strconv.Atoi(envLoader.GetAndLogEnvVar("SENDER_RATE_PER_MIN", false, false))
+	reportsThreshold, _ := strconv.Atoi(envLoader.GetAndLogEnvVar("NOTIFICATIONS_REPORTS_THRESHOLD", false, false))
+	reportsAdminEmail := envLoader.GetAndLogEnvVar("NOTIFICATIONS_REPORTS_ADMIN_EMAIL", false, false)
+	tokenFailuresLimit, _ := strconv.Atoi(envLoader.GetAndLogEnvVar("NOTIFICATIONS_TOKEN_FAILURES_LIMIT", false, false))
+	topicsResyncConcurrency, _ := strconv.Atoi(envLoader.GetAndLogEnvVar("NOTIFICATIONS_TOPICS_RESYNC_CONCURRENCY", false, false))
+	topicNameMaxLength, _ := strconv.Atoi(envLoader.GetAndLogEnvVar("NOTIFICATIONS_TOPIC_NAME_MAX_LENGTH", false, false))
+	topicReservedPrefixes := envLoader.GetAndLogEnvVar("NOTIFICATIONS_TOPIC_RESERVED_PREFIXES", false, false)
+	defaultPaginationLimit, _ := strconv.ParseInt(envLoader.GetAndLogEnvVar("NOTIFICATIONS_DEFAULT_PAGINATION_LIMIT", false, false), 10, 64)
+	maxPaginationLimit, _ := strconv.ParseInt(envLoader.GetAndLogEnvVar("NOTIFICATIONS_MAX_PAGINATION_LIMIT", false, false), 10, 64)
+	sourceApps := envLoader.GetAndLogEnvVar("NOTIFICATIONS_SOURCE_APPS", false, false)
+	messagesRetentionDays, _ := strconv.Atoi(envLoader.GetAndLogEnvVar("NOTIFICATIONS_MESSAGES_RETENTION_DAYS", false, false))
+	messageTTLDays, _ := strconv.Atoi(envLoader.GetAndLogEnvVar("MESSAGE_TTL_DAYS", false, false))
+	tokenStaleDays, _ := strconv.Atoi(envLoader.GetAndLogEnvVar("TOKEN_STALE_DAYS", false, false))
+	bulkMessagesLimit, _ := strconv.Atoi(envLoader.GetAndLogEnvVar("NOTIFICATIONS_BULK_MESSAGES_LIMIT", false, false))
+	defaultPerDevice, err := strconv.ParseBool(envLoader.GetAndLogEnvVar("NOTIFICATIONS_DEFAULT_PER_DEVICE", false, false))
+	if err != nil {
+		defaultPerDevice = true //every device if not set
+	}
+	pushRetryBaseSeconds, _ := strconv.Atoi(envLoader.GetAndLogEnvVar("NOTIFICATIONS_PUSH_RETRY_BASE_SECONDS", false, false))
+	pushRetryMaxAttempts, _ := strconv.Atoi(envLoader.GetAndLogEnvVar("NOTIFICATIONS_PUSH_RETRY_MAX_ATTEMPTS", false, false))
+	messageResendWindowHours, _ := strconv.Atoi(envLoader.GetAndLogEnvVar("NOTIFICATIONS_MESSAGE_RESEND_WINDOW_HOURS", false, false))
 
 	authService := authservice.AuthService{
 		ServiceID:   serviceID,
@@ -150,13 +250,39 @@ func main() {
 	coreAdapter := corebb.NewCoreAdapter(coreBBHost, serviceAccountManager)
 
 	config := &model.Config{
-		InternalAPIKey:          internalAPIKey,
-		CoreBBHost:              coreBBHost,
-		NotificationsServiceURL: notificationsServiceURL,
+		InternalAPIKey:             internalAPIKey,
+		PreviousInternalAPIKeys:    parseList(previousInternalAPIKeys),
+		InternalAPIKeysRotationEnd: internalAPIKeysRotationEnd,
+		CoreBBHost:                 coreBBHost,
+		NotificationsServiceURL:    notificationsServiceURL,
+		DefaultMessageData:         parseKeyValueList(defaultMessageData),
+		RateLimitAllowlist:         parseList(rateLimitAllowlist),
+		SenderRatePerMin:           senderRatePerMin,
+		ReportsThreshold:           reportsThreshold,
+		ReportsAdminEmail:          reportsAdminEmail,
+		TokenFailuresLimit:         tokenFailuresLimit,
+		MessagesRetentionDays:      messagesRetentionDays,
+		MessageTTLDays:             messageTTLDays,
+		TokenStaleDays:             tokenStaleDays,
+		SourceApps:                 parseList(sourceApps),
+		TopicsResyncConcurrency:    topicsResyncConcurrency,
+		TopicNameMaxLength:         topicNameMaxLength,
+		TopicReservedPrefixes:      parseList(topicReservedPrefixes),
+		DefaultPaginationLimit:     defaultPaginationLimit,
+		MaxPaginationLimit:         maxPaginationLimit,
+		BulkMessagesLimit:          bulkMessagesLimit,
+		PushRetryBaseSeconds:       pushRetryBaseSeconds,
+		PushRetryMaxAttempts:       pushRetryMaxAttempts,
+		DefaultPerDevice:           defaultPerDevice,
+		SubscriptionWebhookURL:     subscriptionWebhookURL,
+		MessageResendWindowHours:   messageResendWindowHours,
+		FirebaseSendConcurrency:    firebaseSendConcurrency,
+		CORSExcludeAdmin:           corsExcludeAdmin,
+		MaxRequestBodyBytes:        maxRequestBodyBytes,
 	}
 
 	// application
-	application := core.NewApplication(Version, Build, storageAdapter, firebaseAdapter, mailAdapter, logger, coreAdapter, airshipAdapter)
+	application := core.NewApplication(Version, Build, storageAdapter, firebaseAdapter, mailAdapter, logger, coreAdapter, airshipAdapter, apnsAdapter, smsAdapter, webhookAdapter, moderator, config)
 	application.Start()
 
 	// read CORS parameters from stored env config
@@ -175,8 +301,51 @@ func main() {
 		corsAllowedHeaders = envData.CORSAllowedHeaders
 		corsAllowedOrigins = envData.CORSAllowedOrigins
 	}
+	//the env variables take precedence over the stored env config
+	if len(parseList(envCORSAllowedOrigins)) > 0 {
+		corsAllowedOrigins = parseList(envCORSAllowedOrigins)
+		corsAllowedHeaders = parseList(envCORSAllowedHeaders)
+	}
 
 	webAdapter := driver.NewWebAdapter(host, port, application, config, serviceRegManager, corsAllowedOrigins, corsAllowedHeaders, logger)
 
 	webAdapter.Start()
 }
+
+// parseKeyValueList parses a comma separated list of key=value pairs
+func parseKeyValueList(value string) map[string]string {
+	result := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		key, val, found := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !found || len(key) == 0 {
+			continue
+		}
+		result[key] = strings.TrimSpace(val)
+	}
+	return result
+}
+
+// parseList parses a comma separated list
+func parseList(value string) []string {
+	result := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// parseTime parses a RFC3339 time, nil if the value is empty
+func parseTime(value string) (*time.Time, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	result, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}